@@ -73,7 +73,7 @@ function App() {
 
   const startCrawl = useCallback((startingURL) => {
     axios
-      .post(baseURL + "/crawl", {
+      .post(baseURL + "/v1/crawl", {
         url: "http://" + startingURL,
       })
       .then((res) => {
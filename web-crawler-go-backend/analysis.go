@@ -0,0 +1,374 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+)
+
+const (
+	analysisStateRunning = "running"
+	analysisStateDone    = "done"
+	analysisStateFailed  = "failed"
+	// Longest an analysis may run. Past it, another one can be started even if
+	// the server running it died without saying so
+	analysisTimeout = 10 * time.Minute
+	// PageRank's damping factor, and when PageRank and HITS stop iterating
+	pageRankDamping     = 0.85
+	maxAnalysisRounds   = 100
+	analysisConvergence = 1e-6
+	// Scores returned by default, and at most
+	defaultAnalysisTop = 100
+	maxAnalysisTop     = 10000
+)
+
+type (
+	URLScores struct {
+		URL       string  `json:"url"`
+		PageRank  float64 `json:"pageRank"`
+		Hub       float64 `json:"hub"`
+		Authority float64 `json:"authority"`
+	}
+	AnalysisResponse struct {
+		State      string     `json:"state"`
+		StartedAt  time.Time  `json:"startedAt"`
+		FinishedAt *time.Time `json:"finishedAt,omitempty"`
+		Error      string     `json:"error,omitempty"`
+		// Urls scored, fetched or only linked to
+		URLs int `json:"urls"`
+		// Where the scores can be read
+		ResultsURL string `json:"resultsURL,omitempty"`
+		// Highest first, only once the analysis is done
+		Scores []URLScores `json:"scores,omitempty"`
+	}
+)
+
+// Helper function to build the redis key holding the state of a crawl's analysis
+func analysisKey(uniqueID string) string {
+	return fmt.Sprintf("go-crawler-analysis-%s", uniqueID)
+}
+
+// Helper function to build the redis key held while a crawl's analysis runs
+func analysisLockKey(uniqueID string) string {
+	return fmt.Sprintf("go-crawler-analysis-lock-%s", uniqueID)
+}
+
+// Helper function to build the redis key holding the scores of a crawl's urls
+func urlScoresKey(uniqueID string) string {
+	return fmt.Sprintf("go-crawler-scores-%s", uniqueID)
+}
+
+// linkGraph is a crawl's results as links between numbered urls
+type linkGraph struct {
+	urls []string
+	// Links out of and into every url, without duplicates
+	out, in [][]int
+}
+
+func newLinkGraph(nodes []graphNode) *linkGraph {
+	graph := &linkGraph{}
+	index := make(map[string]int)
+	id := func(url string) int {
+		if i, ok := index[url]; ok {
+			return i
+		}
+		index[url] = len(graph.urls)
+		graph.urls = append(graph.urls, url)
+		graph.out = append(graph.out, nil)
+		graph.in = append(graph.in, nil)
+		return len(graph.urls) - 1
+	}
+	linked := make(map[[2]int]bool)
+	for _, node := range nodes {
+		from := id(node.Parent)
+		for _, child := range node.Children {
+			to := id(child)
+			if from == to || linked[[2]int{from, to}] {
+				continue
+			}
+			linked[[2]int{from, to}] = true
+			graph.out[from] = append(graph.out[from], to)
+			graph.in[to] = append(graph.in[to], from)
+		}
+	}
+	return graph
+}
+
+// pageRank scores every url by the chance a random surfer ends up on it. Urls
+// without links out, like the ones that were never fetched, share their rank
+// with every url
+func (graph *linkGraph) pageRank() []float64 {
+	n := float64(len(graph.urls))
+	rank := make([]float64, len(graph.urls))
+	for i := range rank {
+		rank[i] = 1 / n
+	}
+	for round := 0; round < maxAnalysisRounds; round++ {
+		dangling := 0.0
+		for i, links := range graph.out {
+			if len(links) == 0 {
+				dangling += rank[i]
+			}
+		}
+		next := make([]float64, len(rank))
+		for i := range next {
+			next[i] = (1-pageRankDamping)/n + pageRankDamping*dangling/n
+		}
+		for i, links := range graph.out {
+			for _, to := range links {
+				next[to] += pageRankDamping * rank[i] / float64(len(links))
+			}
+		}
+		delta := 0.0
+		for i := range rank {
+			delta += math.Abs(next[i] - rank[i])
+		}
+		rank = next
+		if delta < analysisConvergence {
+			break
+		}
+	}
+	return rank
+}
+
+// hits scores every url as a hub, linking to good authorities, and as an
+// authority, linked to by good hubs
+func (graph *linkGraph) hits() (hubs, authorities []float64) {
+	hubs = make([]float64, len(graph.urls))
+	authorities = make([]float64, len(graph.urls))
+	for i := range hubs {
+		hubs[i] = 1
+	}
+	normalize := func(scores []float64) {
+		norm := 0.0
+		for _, score := range scores {
+			norm += score * score
+		}
+		if norm = math.Sqrt(norm); norm > 0 {
+			for i := range scores {
+				scores[i] /= norm
+			}
+		}
+	}
+	for round := 0; round < maxAnalysisRounds; round++ {
+		nextAuthorities := make([]float64, len(authorities))
+		for i, links := range graph.in {
+			for _, from := range links {
+				nextAuthorities[i] += hubs[from]
+			}
+		}
+		normalize(nextAuthorities)
+		nextHubs := make([]float64, len(hubs))
+		for i, links := range graph.out {
+			for _, to := range links {
+				nextHubs[i] += nextAuthorities[to]
+			}
+		}
+		normalize(nextHubs)
+		delta := 0.0
+		for i := range hubs {
+			delta += math.Abs(nextHubs[i]-hubs[i]) + math.Abs(nextAuthorities[i]-authorities[i])
+		}
+		hubs, authorities = nextHubs, nextAuthorities
+		if delta < analysisConvergence {
+			break
+		}
+	}
+	return hubs, authorities
+}
+
+// analyzeCrawl scores the urls of a finished crawl and stores the scores,
+// recording how it went in the crawl's analysis state
+func analyzeCrawl(rdb *redis.Client, crawlID string, nodes []graphNode) {
+	defer rdb.Del(ctx, analysisLockKey(crawlID))
+	crawlLog := crawlLogger(crawlID)
+	start := time.Now()
+
+	graph := newLinkGraph(nodes)
+	ranks := graph.pageRank()
+	hubs, authorities := graph.hits()
+	scores := make(map[string]interface{}, len(graph.urls))
+	for i, url := range graph.urls {
+		encoded, _ := json.Marshal(URLScores{URL: url, PageRank: ranks[i], Hub: hubs[i], Authority: authorities[i]})
+		scores[url] = encoded
+	}
+
+	pipe := rdb.TxPipeline()
+	pipe.Del(ctx, urlScoresKey(crawlID))
+	if len(scores) > 0 {
+		pipe.HSet(ctx, urlScoresKey(crawlID), scores)
+	}
+	pipe.HSet(ctx, analysisKey(crawlID), "state", analysisStateDone, "finishedAt", time.Now().Format(time.RFC3339Nano), "urls", len(graph.urls))
+	// Scores live exactly as long as the crawl they describe
+	ttl := crawlDataTTL(rdb, crawlID)
+	pipe.PExpire(ctx, urlScoresKey(crawlID), ttl)
+	pipe.PExpire(ctx, analysisKey(crawlID), ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		withError(crawlLog.Error(), err).Msg("Failed to store analysis")
+		rdb.HSet(ctx, analysisKey(crawlID), "state", analysisStateFailed, "finishedAt", time.Now().Format(time.RFC3339Nano), "error", err.Error())
+		return
+	}
+	crawlLog.Info().Int("urls", len(graph.urls)).Dur("duration", time.Since(start)).Msg("Analyzed crawl")
+}
+
+// Helper function to read the state of a crawl's analysis, nil if there's none
+func loadAnalysis(rdb *redis.Client, crawlID string) (*AnalysisResponse, error) {
+	fields, err := rdb.HGetAll(ctx, analysisKey(crawlID)).Result()
+	if err != nil || len(fields) == 0 {
+		return nil, err
+	}
+	analysis := &AnalysisResponse{State: fields["state"], Error: fields["error"]}
+	analysis.StartedAt, _ = time.Parse(time.RFC3339Nano, fields["startedAt"])
+	if finishedAt, err := time.Parse(time.RFC3339Nano, fields["finishedAt"]); err == nil {
+		analysis.FinishedAt = &finishedAt
+	}
+	analysis.URLs, _ = strconv.Atoi(fields["urls"])
+	if analysis.State == analysisStateRunning && time.Since(analysis.StartedAt) > analysisTimeout {
+		analysis.State = analysisStateFailed
+		analysis.Error = "analysis didn't finish in time"
+	}
+	return analysis, nil
+}
+
+// Analyze crawl handler - POST /crawl/{crawl_ID}/analyze
+func analyzeCrawlHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
+	crawlID := mux.Vars(r)["crawl_ID"]
+
+	nodes, done, err := resultStore.Range(crawlID, 0, -1)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to get results")
+		return
+	}
+	if len(nodes) == 0 && !done {
+		exists, err := crawlExists(rdb, crawlID)
+		if err != nil {
+			sendErrorResponse(w, http.StatusInternalServerError, "Failed to look up crawl")
+			return
+		}
+		if !exists {
+			sendErrorResponse(w, http.StatusNotFound, "Crawl not found")
+			return
+		}
+	}
+	if !done {
+		sendErrorResponse(w, http.StatusConflict, "Crawl is still running")
+		return
+	}
+	locked, err := rdb.SetNX(ctx, analysisLockKey(crawlID), time.Now().Format(time.RFC3339Nano), analysisTimeout).Result()
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to start analysis")
+		return
+	}
+	if !locked {
+		sendErrorResponse(w, http.StatusConflict, "Crawl is already being analyzed")
+		return
+	}
+
+	response := AnalysisResponse{State: analysisStateRunning, StartedAt: time.Now(), ResultsURL: fmt.Sprintf("%s://%s%s", apiScheme(), r.Host, versionedPath("/crawl/"+crawlID+"/analysis"))}
+	pipe := rdb.TxPipeline()
+	pipe.Del(ctx, analysisKey(crawlID))
+	pipe.HSet(ctx, analysisKey(crawlID), "state", response.State, "startedAt", response.StartedAt.Format(time.RFC3339Nano))
+	pipe.Expire(ctx, analysisKey(crawlID), analysisTimeout)
+	if _, err := pipe.Exec(ctx); err != nil {
+		rdb.Del(ctx, analysisLockKey(crawlID))
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to start analysis")
+		return
+	}
+	go analyzeCrawl(rdb, crawlID, nodes)
+	sendJSONResponse(w, http.StatusAccepted, response)
+}
+
+// Crawl analysis handler - GET /crawl/{crawl_ID}/analysis
+func crawlAnalysisHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
+	crawlID := mux.Vars(r)["crawl_ID"]
+	query := r.URL.Query()
+	top := defaultAnalysisTop
+	if rawTop := query.Get("top"); rawTop != "" {
+		var err error
+		top, err = strconv.Atoi(rawTop)
+		if err != nil || top < 1 || top > maxAnalysisTop {
+			sendErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("top must be between 1 and %d", maxAnalysisTop))
+			return
+		}
+	}
+	sortBy := query.Get("sort")
+	switch sortBy {
+	case "":
+		sortBy = "pageRank"
+	case "pageRank", "hub", "authority":
+	default:
+		sendErrorResponse(w, http.StatusBadRequest, "sort must be pageRank, hub or authority")
+		return
+	}
+
+	analysis, err := loadAnalysis(rdb, crawlID)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to get analysis")
+		return
+	}
+	if analysis == nil {
+		sendErrorResponse(w, http.StatusNotFound, "Crawl hasn't been analyzed, start with POST /crawl/{id}/analyze")
+		return
+	}
+	if analysis.State != analysisStateDone {
+		sendJSONResponse(w, http.StatusOK, analysis)
+		return
+	}
+
+	// A single url's scores, or the top ones
+	if url := query.Get("url"); url != "" {
+		raw, err := rdb.HGet(ctx, urlScoresKey(crawlID), url).Result()
+		if err == redis.Nil {
+			sendErrorResponse(w, http.StatusNotFound, "URL not in crawl")
+			return
+		}
+		if err != nil {
+			sendErrorResponse(w, http.StatusInternalServerError, "Failed to get scores")
+			return
+		}
+		var scores URLScores
+		json.Unmarshal([]byte(raw), &scores)
+		analysis.Scores = []URLScores{scores}
+		sendJSONResponse(w, http.StatusOK, analysis)
+		return
+	}
+	raw, err := rdb.HVals(ctx, urlScoresKey(crawlID)).Result()
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to get scores")
+		return
+	}
+	analysis.Scores = make([]URLScores, 0, len(raw))
+	for _, encoded := range raw {
+		var scores URLScores
+		if json.Unmarshal([]byte(encoded), &scores) == nil {
+			analysis.Scores = append(analysis.Scores, scores)
+		}
+	}
+	score := func(scores URLScores) float64 {
+		switch sortBy {
+		case "hub":
+			return scores.Hub
+		case "authority":
+			return scores.Authority
+		}
+		return scores.PageRank
+	}
+	sort.Slice(analysis.Scores, func(i, j int) bool {
+		a, b := score(analysis.Scores[i]), score(analysis.Scores[j])
+		if a != b {
+			return a > b
+		}
+		return analysis.Scores[i].URL < analysis.Scores[j].URL
+	})
+	if len(analysis.Scores) > top {
+		analysis.Scores = analysis.Scores[:top]
+	}
+	sendJSONResponse(w, http.StatusOK, analysis)
+}
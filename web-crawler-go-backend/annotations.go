@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+)
+
+type (
+	// Annotation is a reviewer's note on a node (URL) or an edge (Parent -> Child) of a crawl
+	Annotation struct {
+		ID              string    `json:"id"`
+		URL             string    `json:"url,omitempty"`
+		Parent          string    `json:"parent,omitempty"`
+		Child           string    `json:"child,omitempty"`
+		Labels          []string  `json:"labels,omitempty"`
+		Note            string    `json:"note,omitempty"`
+		IgnoreInReports bool      `json:"ignoreInReports"`
+		Author          string    `json:"author,omitempty"`
+		CreatedAt       time.Time `json:"createdAt"`
+	}
+	AnnotationsResponse struct {
+		Annotations []Annotation `json:"annotations"`
+	}
+	// ignoreSet holds the nodes and edges reports should leave out
+	ignoreSet struct {
+		nodes map[string]bool
+		edges map[[2]string]bool
+	}
+)
+
+// Helper function to build the redis key holding a crawl's annotations
+func annotationsKey(uniqueID string) string {
+	return fmt.Sprintf("go-crawler-annotations-%s", uniqueID)
+}
+
+func loadAnnotations(rdb *redis.Client, crawlID string) ([]Annotation, error) {
+	raw, err := rdb.HGetAll(ctx, annotationsKey(crawlID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	annotations := make([]Annotation, 0, len(raw))
+	for _, rawAnnotation := range raw {
+		var annotation Annotation
+		if err := json.Unmarshal([]byte(rawAnnotation), &annotation); err != nil {
+			continue
+		}
+		annotations = append(annotations, annotation)
+	}
+	sort.Slice(annotations, func(i, j int) bool {
+		return annotations[i].CreatedAt.Before(annotations[j].CreatedAt)
+	})
+	return annotations, nil
+}
+
+func loadIgnoreSet(rdb *redis.Client, crawlID string) (ignoreSet, error) {
+	ignored := ignoreSet{nodes: map[string]bool{}, edges: map[[2]string]bool{}}
+	annotations, err := loadAnnotations(rdb, crawlID)
+	if err != nil {
+		return ignored, err
+	}
+	for _, annotation := range annotations {
+		if !annotation.IgnoreInReports {
+			continue
+		}
+		if annotation.URL != "" {
+			ignored.nodes[annotation.URL] = true
+		} else {
+			ignored.edges[[2]string{annotation.Parent, annotation.Child}] = true
+		}
+	}
+	return ignored, nil
+}
+
+// filter drops ignored nodes and edges, for use by anything reporting on a crawl
+func (ignored ignoreSet) filter(nodes []graphNode) []graphNode {
+	if len(ignored.nodes) == 0 && len(ignored.edges) == 0 {
+		return nodes
+	}
+	filtered := make([]graphNode, 0, len(nodes))
+	for _, node := range nodes {
+		if ignored.nodes[node.Parent] {
+			continue
+		}
+		children := make([]string, 0, len(node.Children))
+		for _, child := range node.Children {
+			if !ignored.nodes[child] && !ignored.edges[[2]string{node.Parent, child}] {
+				children = append(children, child)
+			}
+		}
+		node.Children = children
+		filtered = append(filtered, node)
+	}
+	return filtered
+}
+
+// Helper function to check that an annotation points at something the crawl actually found
+func annotationTargetExists(nodes []graphNode, annotation Annotation) bool {
+	for _, node := range nodes {
+		if annotation.URL != "" && node.Parent == annotation.URL {
+			return true
+		}
+		for _, child := range node.Children {
+			if annotation.URL != "" && child == annotation.URL {
+				return true
+			}
+			if node.Parent == annotation.Parent && child == annotation.Child {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Create annotation handler - POST /crawl/{crawl_ID}/annotations
+func createAnnotationHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
+	crawlID := mux.Vars(r)["crawl_ID"]
+
+	var annotation Annotation
+	if err := json.NewDecoder(r.Body).Decode(&annotation); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	isNode := annotation.URL != ""
+	isEdge := annotation.Parent != "" || annotation.Child != ""
+	if isNode == isEdge || (isEdge && (annotation.Parent == "" || annotation.Child == "")) {
+		sendErrorResponse(w, http.StatusBadRequest, "Annotation must target either a url or a parent and child")
+		return
+	}
+
+	nodes, _, err := resultStore.Range(crawlID, 0, -1)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to get results")
+		return
+	}
+	if !annotationTargetExists(nodes, annotation) {
+		sendErrorResponse(w, http.StatusNotFound, "Annotation target not found in crawl")
+		return
+	}
+
+	annotation.ID = fmt.Sprintf("%d", time.Now().UnixNano())
+	annotation.CreatedAt = time.Now()
+	marshalled, _ := json.Marshal(annotation)
+	if err := rdb.HSet(ctx, annotationsKey(crawlID), annotation.ID, marshalled).Err(); err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to save annotation")
+		return
+	}
+	// Annotations live exactly as long as the graph they describe
+	rdb.PExpire(ctx, annotationsKey(crawlID), crawlDataTTL(rdb, crawlID))
+
+	sendJSONResponse(w, http.StatusCreated, annotation)
+}
+
+// List annotations handler - GET /crawl/{crawl_ID}/annotations
+func listAnnotationsHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
+	annotations, err := loadAnnotations(rdb, mux.Vars(r)["crawl_ID"])
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to get annotations")
+		return
+	}
+	sendJSONResponse(w, http.StatusOK, AnnotationsResponse{Annotations: annotations})
+}
+
+// Delete annotation handler - DELETE /crawl/{crawl_ID}/annotations/{annotation_ID}
+func deleteAnnotationHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
+	vars := mux.Vars(r)
+	deleted, err := rdb.HDel(ctx, annotationsKey(vars["crawl_ID"]), vars["annotation_ID"]).Result()
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to delete annotation")
+		return
+	}
+	if deleted == 0 {
+		sendErrorResponse(w, http.StatusNotFound, "Annotation not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
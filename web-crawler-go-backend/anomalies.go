@@ -0,0 +1,288 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// Runs kept per schedule, each new run is compared with their average
+	scheduleHistoryRuns = 6
+	// Fewer earlier runs than this make too shaky a baseline to compare against
+	minBaselineRuns = 3
+	// External hosts remembered per run, to tell which ones are new
+	maxRunDomains = 5000
+
+	defaultPageDropPercent      = 30
+	defaultNotFoundSpikePercent = 50
+	defaultNewDomains           = 5
+	// A spike also needs this many more 404s than usual, or a handful would be one
+	minNotFoundSpike = 5
+
+	anomalyPageDrop      = "page_drop"
+	anomalyNotFoundSpike = "not_found_spike"
+	anomalyNewDomains    = "new_external_domains"
+)
+
+var errAnomalyThreshold = errors.New("anomaly thresholds must be positive, or -1 to turn the check off")
+
+type (
+	// AnomalyThresholds tune when a scheduled run is reported as anomalous.
+	// Zero values use the defaults, and -1 turns a check off
+	AnomalyThresholds struct {
+		// Where anomalies are POSTed, without it they're only listed with the runs
+		NotifyURL string `json:"notifyURL,omitempty"`
+		// How far below the baseline the page count may drop, in percent
+		PageDropPercent float64 `json:"pageDropPercent,omitempty"`
+		// How far above the baseline the 404 count may rise, in percent
+		NotFoundSpikePercent float64 `json:"notFoundSpikePercent,omitempty"`
+		// How many external hosts no earlier run reached or linked to may appear
+		NewDomains int `json:"newDomains,omitempty"`
+	}
+	Anomaly struct {
+		Kind     string  `json:"kind"`
+		Message  string  `json:"message"`
+		Value    float64 `json:"value"`
+		Baseline float64 `json:"baseline"`
+	}
+	// ScheduleRun sums up one finished run of a schedule
+	ScheduleRun struct {
+		CrawlID         string    `json:"crawlID"`
+		FinishedAt      time.Time `json:"finishedAt"`
+		Pages           int       `json:"pages"`
+		NotFound        int       `json:"notFound"`
+		ExternalDomains int       `json:"externalDomains"`
+		NewDomains      []string  `json:"newDomains,omitempty"`
+		Anomalies       []Anomaly `json:"anomalies,omitempty"`
+	}
+	// scheduleRunRecord is what's kept of a run, the hosts it linked to included
+	scheduleRunRecord struct {
+		ScheduleRun
+		Domains []string `json:"domains"`
+	}
+	ScheduleRunsResponse struct {
+		Runs []ScheduleRun `json:"runs"`
+	}
+	AnomalyNotification struct {
+		ScheduleID string    `json:"scheduleID"`
+		URL        string    `json:"url"`
+		CrawlID    string    `json:"crawlID"`
+		DetectedAt time.Time `json:"detectedAt"`
+		Anomalies  []Anomaly `json:"anomalies"`
+	}
+)
+
+// Helper function to build the redis key holding a schedule's latest runs, newest first
+func scheduleHistoryKey(scheduleID string) string {
+	return fmt.Sprintf("go-crawler-schedule-history-%s", scheduleID)
+}
+
+// Helper function to pick a threshold, or its default if it's unset
+func thresholdOrDefault(threshold, fallback float64) float64 {
+	if threshold == 0 {
+		return fallback
+	}
+	return threshold
+}
+
+// summarizeRun counts what the anomaly checks look at in a finished run of a
+// schedule for siteURL. Hosts other than siteURL's, and its subdomains, are external
+func summarizeRun(siteURL, crawlID string, nodes []graphNode) scheduleRunRecord {
+	run := scheduleRunRecord{ScheduleRun: ScheduleRun{CrawlID: crawlID, FinishedAt: time.Now()}}
+	site := ""
+	if parsedURL, err := url.Parse(siteURL); err == nil {
+		site = parsedURL.Hostname()
+	}
+	external := make(map[string]bool)
+	addHost := func(rawURL string) {
+		parsedURL, err := url.Parse(rawURL)
+		if err != nil || parsedURL.Hostname() == "" || hostMatchesDomain(rawURL, site) {
+			return
+		}
+		external[strings.ToLower(parsedURL.Hostname())] = true
+	}
+	for _, node := range nodes {
+		run.Pages++
+		if node.Status == http.StatusNotFound {
+			run.NotFound++
+		}
+		addHost(node.Parent)
+		for _, child := range node.Children {
+			addHost(child)
+		}
+	}
+	run.ExternalDomains = len(external)
+	for host := range external {
+		run.Domains = append(run.Domains, host)
+	}
+	sort.Strings(run.Domains)
+	if len(run.Domains) > maxRunDomains {
+		run.Domains = run.Domains[:maxRunDomains]
+	}
+	return run
+}
+
+// detectAnomalies compares a run with the average of the runs before it
+func detectAnomalies(run *scheduleRunRecord, baseline []scheduleRunRecord, thresholds AnomalyThresholds) {
+	if len(baseline) < minBaselineRuns {
+		return
+	}
+	pages, notFound := 0.0, 0.0
+	known := make(map[string]bool)
+	for _, earlier := range baseline {
+		pages += float64(earlier.Pages)
+		notFound += float64(earlier.NotFound)
+		for _, host := range earlier.Domains {
+			known[host] = true
+		}
+	}
+	pages /= float64(len(baseline))
+	notFound /= float64(len(baseline))
+
+	if drop := thresholdOrDefault(thresholds.PageDropPercent, defaultPageDropPercent); drop > 0 && pages > 0 && float64(run.Pages) < pages*(1-drop/100) {
+		run.Anomalies = append(run.Anomalies, Anomaly{
+			Kind:     anomalyPageDrop,
+			Message:  fmt.Sprintf("%d pages, %.0f%% fewer than the usual %.1f", run.Pages, 100*(1-float64(run.Pages)/pages), pages),
+			Value:    float64(run.Pages),
+			Baseline: roundBaseline(pages),
+		})
+	}
+	if spike := thresholdOrDefault(thresholds.NotFoundSpikePercent, defaultNotFoundSpikePercent); spike > 0 && float64(run.NotFound) > notFound*(1+spike/100) && float64(run.NotFound)-notFound >= minNotFoundSpike {
+		run.Anomalies = append(run.Anomalies, Anomaly{
+			Kind:     anomalyNotFoundSpike,
+			Message:  fmt.Sprintf("%d pages answered 404, against %.1f usually", run.NotFound, notFound),
+			Value:    float64(run.NotFound),
+			Baseline: roundBaseline(notFound),
+		})
+	}
+	for _, host := range run.Domains {
+		if !known[host] {
+			run.NewDomains = append(run.NewDomains, host)
+		}
+	}
+	if limit := int(thresholdOrDefault(float64(thresholds.NewDomains), defaultNewDomains)); limit > 0 && len(run.NewDomains) >= limit {
+		run.Anomalies = append(run.Anomalies, Anomaly{
+			Kind:    anomalyNewDomains,
+			Message: fmt.Sprintf("%d external hosts no earlier run reached or linked to, e.g. %s", len(run.NewDomains), run.NewDomains[0]),
+			Value:   float64(len(run.NewDomains)),
+		})
+	}
+}
+
+// loadScheduleHistory returns a schedule's latest runs, newest first
+func loadScheduleHistory(rdb *redis.Client, scheduleID string) ([]scheduleRunRecord, error) {
+	raw, err := rdb.LRange(ctx, scheduleHistoryKey(scheduleID), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	runs := make([]scheduleRunRecord, 0, len(raw))
+	for _, rawRun := range raw {
+		var run scheduleRunRecord
+		if err := json.Unmarshal([]byte(rawRun), &run); err != nil {
+			continue
+		}
+		runs = append(runs, run)
+	}
+	return runs, nil
+}
+
+// checkScheduledRun records a finished run of schedule, comparing it with the
+// runs before it and notifying the schedule's NotifyURL of anything unusual
+func checkScheduledRun(rdb *redis.Client, schedule Schedule, crawlID string, nodes []graphNode) {
+	baseline, err := loadScheduleHistory(rdb, schedule.ID)
+	if err != nil {
+		withError(logger.Error(), err).Str("schedule_id", schedule.ID).Msg("Failed to load history of schedule")
+		return
+	}
+	ignored, err := loadIgnoreSet(rdb, crawlID)
+	if err != nil {
+		withError(crawlLogger(crawlID).Error(), err).Str("schedule_id", schedule.ID).Msg("Failed to load annotations of scheduled run")
+		return
+	}
+	thresholds := AnomalyThresholds{}
+	if schedule.Anomalies != nil {
+		thresholds = *schedule.Anomalies
+	}
+	run := summarizeRun(schedule.URL, crawlID, ignored.filter(nodes))
+	detectAnomalies(&run, baseline, thresholds)
+
+	marshalled, _ := json.Marshal(run)
+	pipe := rdb.TxPipeline()
+	pipe.LPush(ctx, scheduleHistoryKey(schedule.ID), marshalled)
+	pipe.LTrim(ctx, scheduleHistoryKey(schedule.ID), 0, scheduleHistoryRuns-1)
+	if _, err := pipe.Exec(ctx); err != nil {
+		withError(crawlLogger(crawlID).Error(), err).Str("schedule_id", schedule.ID).Msg("Failed to record run of schedule")
+	}
+	if len(run.Anomalies) == 0 {
+		return
+	}
+	crawlLogger(crawlID).Warn().Str("schedule_id", schedule.ID).Int("anomalies", len(run.Anomalies)).Msg("Scheduled run has anomalies")
+	if thresholds.NotifyURL == "" {
+		return
+	}
+	notification := AnomalyNotification{ScheduleID: schedule.ID, URL: schedule.URL, CrawlID: crawlID, DetectedAt: run.FinishedAt, Anomalies: run.Anomalies}
+	if err := deliverJSON(thresholds.NotifyURL, notification); err != nil {
+		withError(crawlLogger(crawlID).Error(), err).Str("url", thresholds.NotifyURL).Msg("Failed to deliver anomalies")
+	}
+}
+
+// Helper function to check the anomaly settings a schedule was created with
+func validateAnomalyThresholds(thresholds *AnomalyThresholds) error {
+	if thresholds.NotifyURL != "" {
+		notifyURL, err := url.Parse(thresholds.NotifyURL)
+		if err != nil || (notifyURL.Scheme != "http" && notifyURL.Scheme != "https") {
+			return errors.New("anomalies.notifyURL must be an http(s) url")
+		}
+	}
+	for _, percent := range []float64{thresholds.PageDropPercent, thresholds.NotFoundSpikePercent} {
+		if percent < 0 && percent != -1 {
+			return errAnomalyThreshold
+		}
+	}
+	if thresholds.PageDropPercent > 100 {
+		return errors.New("anomalies.pageDropPercent can't be over 100")
+	}
+	if thresholds.NewDomains < -1 {
+		return errAnomalyThreshold
+	}
+	return nil
+}
+
+// Schedule runs handler - GET /schedules/{schedule_ID}/runs
+func scheduleRunsHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
+	scheduleID := mux.Vars(r)["schedule_ID"]
+	exists, err := rdb.HExists(ctx, schedulesKey, scheduleID).Result()
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to get schedule")
+		return
+	}
+	if !exists {
+		sendErrorResponse(w, http.StatusNotFound, "Schedule not found")
+		return
+	}
+	history, err := loadScheduleHistory(rdb, scheduleID)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to get runs")
+		return
+	}
+	response := ScheduleRunsResponse{Runs: make([]ScheduleRun, 0, len(history))}
+	for _, run := range history {
+		response.Runs = append(response.Runs, run.ScheduleRun)
+	}
+	sendJSONResponse(w, http.StatusOK, response)
+}
+
+// Helper function to round a baseline for display
+func roundBaseline(value float64) float64 {
+	return math.Round(value*10) / 10
+}
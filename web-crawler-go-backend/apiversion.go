@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// Request header picking the version unversioned paths are served with,
+	// and response header naming the version that served a request
+	apiVersionHeader = "API-Version"
+	// Version the crawl, graph, query and schedule endpoints are served under
+	currentAPIVersion = "v1"
+	// Version unversioned paths get when the request doesn't pick one. It stays
+	// v1 when later versions ship, so clients from before versioning keep working
+	defaultAPIVersion = "v1"
+)
+
+// Every version served, oldest first. A breaking change adds a version with
+// routes of its own, and the earlier ones keep theirs
+var apiVersions = []string{"v1"}
+
+var apiVersionPattern = regexp.MustCompile(`^/(v[0-9]+)(/|$)`)
+
+// Helper function to tell whether a version is served
+func knownAPIVersion(version string) bool {
+	for _, known := range apiVersions {
+		if version == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Helper function to build the path of an endpoint in the current version
+func versionedPath(path string) string {
+	return "/" + currentAPIVersion + path
+}
+
+// Helper function to drop the version a path is under, if any
+func unversionedPath(path string) string {
+	if match := apiVersionPattern.FindStringSubmatch(path); match != nil {
+		return strings.TrimPrefix(path, "/"+match[1])
+	}
+	return path
+}
+
+// negotiateAPIVersion serves paths from before versioning, like /crawl, with
+// the version asked for in API-Version (v1 if none). Those answers carry a
+// Deprecation header and a link to the versioned path. Paths that aren't part
+// of a version, like /healthz and /admin, are passed on as they are
+func negotiateAPIVersion(router *mux.Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if match := apiVersionPattern.FindStringSubmatch(r.URL.Path); match != nil {
+			if knownAPIVersion(match[1]) {
+				w.Header().Set(apiVersionHeader, match[1])
+			}
+			router.ServeHTTP(w, r)
+			return
+		}
+
+		version := r.Header.Get(apiVersionHeader)
+		if version == "" {
+			version = defaultAPIVersion
+		}
+		probe := version
+		if !knownAPIVersion(version) {
+			probe = defaultAPIVersion
+		}
+		versioned := versionedRequest(r, probe)
+		var match mux.RouteMatch
+		if !router.Match(versioned, &match) || match.MatchErr != nil {
+			// Not an API endpoint, or a method it doesn't have
+			router.ServeHTTP(w, r)
+			return
+		}
+		if probe != version {
+			sendErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("Unknown %s %q, use one of %s", apiVersionHeader, version, strings.Join(apiVersions, ", ")))
+			return
+		}
+		w.Header().Set(apiVersionHeader, version)
+		w.Header().Set("Deprecation", "true")
+		w.Header().Add("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", versioned.URL.RequestURI()))
+		router.ServeHTTP(w, versioned)
+	})
+}
+
+// Helper function to copy r with its path under version
+func versionedRequest(r *http.Request, version string) *http.Request {
+	versioned := r.Clone(r.Context())
+	versioned.URL.Path = "/" + version + r.URL.Path
+	if r.URL.RawPath != "" {
+		versioned.URL.RawPath = "/" + version + r.URL.RawPath
+	}
+	return versioned
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+	"golang.org/x/net/html"
+)
+
+const (
+	// Paragraphs shorter than this don't count towards the score of their block
+	minArticleParagraph = 25
+	// A best block with less text than this isn't an article, the page's whole
+	// visible text is kept instead
+	minArticleText = 250
+)
+
+var (
+	// Classes and ids of menus, sidebars, comments and the like, left out
+	// unless they also look like content
+	boilerplatePattern = regexp.MustCompile(`(?i)banner|breadcrumb|comment|cookie|footer|footnote|masthead|menu|modal|nav|popup|promo|related|share|sidebar|social|sponsor|widget|(^|[-_ ])ads?([-_ ]|$)`)
+	contentPattern     = regexp.MustCompile(`(?i)article|blog|body|content|entry|main|post|story|text`)
+)
+
+// Elements never part of an article
+var boilerplateTags = map[string]bool{
+	"aside": true, "button": true, "footer": true, "form": true, "header": true, "iframe": true, "nav": true,
+	"noscript": true, "script": true, "select": true, "style": true, "svg": true, "template": true,
+}
+
+// Elements that start a paragraph of their own in the extracted text
+var blockTags = map[string]bool{
+	"article": true, "blockquote": true, "br": true, "dd": true, "div": true, "dt": true, "figcaption": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true, "li": true, "main": true,
+	"ol": true, "p": true, "pre": true, "section": true, "table": true, "td": true, "th": true, "tr": true, "ul": true,
+}
+
+// ArticleResponse is the main text of a stored page, see GET /crawl/{id}/article
+type ArticleResponse struct {
+	URL   string `json:"url"`
+	Title string `json:"title"`
+	Text  string `json:"text"`
+	Words int    `json:"words"`
+}
+
+// extractArticle returns a page's title and the text of its main article,
+// paragraphs separated by blank lines. Like Readability, every paragraph
+// scores its parent by its length and commas, and half as much its
+// grandparent. The block scoring best once its links are discounted is the
+// article. Menus, sidebars, comments and footers are left out
+func extractArticle(page []byte) (string, string) {
+	title, text := extractPageText(page)
+	doc, err := html.Parse(bytes.NewReader(page))
+	if err != nil {
+		return title, text
+	}
+	scores := make(map[*html.Node]float64)
+	var score func(node *html.Node)
+	score = func(node *html.Node) {
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type != html.ElementNode || boilerplate(child) {
+				continue
+			}
+			if child.Data == "p" || child.Data == "pre" || child.Data == "td" || child.Data == "blockquote" {
+				paragraph := nodeText(child)
+				if len(paragraph) >= minArticleParagraph {
+					points := 1 + float64(strings.Count(paragraph, ",")) + min(float64(len(paragraph))/100, 3)
+					if parent := child.Parent; parent != nil {
+						scores[parent] += points
+						if grandparent := parent.Parent; grandparent != nil {
+							scores[grandparent] += points / 2
+						}
+					}
+				}
+			}
+			score(child)
+		}
+	}
+	score(doc)
+	var best *html.Node
+	bestScore := 0.0
+	for node, points := range scores {
+		points = (points + classWeight(node)) * (1 - linkDensity(node))
+		if best == nil || points > bestScore {
+			best, bestScore = node, points
+		}
+	}
+	if best == nil {
+		return title, text
+	}
+	article := articleText(best)
+	if len(article) < minArticleText {
+		return title, text
+	}
+	return title, article
+}
+
+// Helper function to tell whether an element is boilerplate, by its tag or its class and id
+func boilerplate(node *html.Node) bool {
+	if boilerplateTags[node.Data] {
+		return true
+	}
+	names := attr(node, "class") + " " + attr(node, "id")
+	return boilerplatePattern.MatchString(names) && !contentPattern.MatchString(names)
+}
+
+// Helper function to weigh a block by what its class and id say it is
+func classWeight(node *html.Node) float64 {
+	names := attr(node, "class") + " " + attr(node, "id")
+	weight := 0.0
+	if contentPattern.MatchString(names) {
+		weight += 25
+	}
+	if boilerplatePattern.MatchString(names) {
+		weight -= 25
+	}
+	if node.Data == "article" || node.Data == "main" {
+		weight += 25
+	}
+	return weight
+}
+
+// Helper function to get the share of a block's text that is link text
+func linkDensity(node *html.Node) float64 {
+	total := len(nodeText(node))
+	if total == 0 {
+		return 1
+	}
+	links := 0
+	var count func(node *html.Node)
+	count = func(node *html.Node) {
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type == html.ElementNode && child.Data == "a" {
+				links += len(nodeText(child))
+			} else {
+				count(child)
+			}
+		}
+	}
+	count(node)
+	return float64(links) / float64(total)
+}
+
+// Helper function to get the value of an element's attribute
+func attr(node *html.Node, name string) string {
+	for _, attribute := range node.Attr {
+		if attribute.Key == name {
+			return attribute.Val
+		}
+	}
+	return ""
+}
+
+// Helper function to get the text of an element, whitespace collapsed
+func nodeText(node *html.Node) string {
+	var text strings.Builder
+	var collect func(node *html.Node)
+	collect = func(node *html.Node) {
+		if node.Type == html.TextNode {
+			text.WriteString(node.Data)
+			text.WriteString(" ")
+		}
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type != html.ElementNode || !boilerplateTags[child.Data] {
+				collect(child)
+			}
+		}
+	}
+	collect(node)
+	return strings.Join(strings.Fields(text.String()), " ")
+}
+
+// articleText returns the text of the article's block, a paragraph per
+// block element, without the boilerplate within it
+func articleText(article *html.Node) string {
+	var paragraphs []string
+	var line strings.Builder
+	flush := func() {
+		if words := strings.Join(strings.Fields(line.String()), " "); words != "" {
+			paragraphs = append(paragraphs, words)
+		}
+		line.Reset()
+	}
+	var collect func(node *html.Node)
+	collect = func(node *html.Node) {
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			switch {
+			case child.Type == html.TextNode:
+				line.WriteString(child.Data)
+				line.WriteString(" ")
+			case child.Type != html.ElementNode || boilerplate(child):
+			case blockTags[child.Data]:
+				flush()
+				collect(child)
+				flush()
+			default:
+				collect(child)
+			}
+		}
+	}
+	collect(article)
+	flush()
+	return strings.Join(paragraphs, "\n\n")
+}
+
+// Crawl article handler - GET /crawl/{crawl_ID}/article?url=
+func crawlArticleHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
+	crawlID := mux.Vars(r)["crawl_ID"]
+	url := r.URL.Query().Get("url")
+	if url == "" {
+		sendErrorResponse(w, http.StatusBadRequest, "Must specify a page with url")
+		return
+	}
+
+	page, err := resultStore.Page(crawlID, url)
+	if err == errPageNotStored {
+		sendErrorResponse(w, http.StatusNotFound, "Page not stored, the crawl must be started with storeBodies raw or article")
+		return
+	}
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to get page")
+		return
+	}
+	body, err := page.decompress()
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Corrupt page")
+		return
+	}
+	response := ArticleResponse{URL: url}
+	switch page.Format {
+	case storeBodiesArticle:
+		// Stored the way newStoredPage lays text out
+		response.Title, response.Text, _ = strings.Cut(string(body), "\n\n")
+	case storeBodiesRaw:
+		header := http.Header{"Content-Type": {page.ContentType}}
+		if contentType := responseContentType(header); !strings.Contains(contentType, "html") && contentType != unknownContentType {
+			sendErrorResponse(w, http.StatusUnprocessableEntity, "Page isn't HTML")
+			return
+		}
+		response.Title, response.Text = extractArticle(decodePage(body, header))
+	default:
+		sendErrorResponse(w, http.StatusUnprocessableEntity, "Page stored as text, without the markup the article is found with")
+		return
+	}
+	response.Words = len(strings.Fields(response.Text))
+	sendJSONResponse(w, http.StatusOK, response)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+)
+
+const maxArtifactBytes = 10 << 20
+
+type (
+	// Artifact describes a derived output (analysis, chart, export) attached to a crawl
+	Artifact struct {
+		ID          string          `json:"id"`
+		Name        string          `json:"name"`
+		ContentType string          `json:"contentType"`
+		Size        int64           `json:"size"`
+		Metadata    json.RawMessage `json:"metadata,omitempty"`
+		CreatedAt   time.Time       `json:"createdAt"`
+	}
+	ArtifactsResponse struct {
+		Artifacts []Artifact `json:"artifacts"`
+	}
+)
+
+// Helper function to build the redis key holding a crawl's artifact metadata
+func artifactsKey(uniqueID string) string {
+	return fmt.Sprintf("go-crawler-artifacts-%s", uniqueID)
+}
+
+// Helper function to build the redis key holding an artifact's content
+func artifactContentKey(uniqueID, artifactID string) string {
+	return fmt.Sprintf("go-crawler-artifact-%s-%s", uniqueID, artifactID)
+}
+
+func loadArtifact(rdb *redis.Client, crawlID, artifactID string) (*Artifact, error) {
+	raw, err := rdb.HGet(ctx, artifactsKey(crawlID), artifactID).Bytes()
+	if err != nil {
+		return nil, err
+	}
+	var artifact Artifact
+	if err := json.Unmarshal(raw, &artifact); err != nil {
+		return nil, err
+	}
+	return &artifact, nil
+}
+
+// Upload artifact handler - POST /crawl/{crawl_ID}/artifacts
+// Expects multipart/form-data with a "file" part and optional "name" and "metadata" (JSON) fields
+func uploadArtifactHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
+	crawlID := mux.Vars(r)["crawl_ID"]
+	if length, _ := resultStore.Len(crawlID); length == 0 {
+		sendErrorResponse(w, http.StatusNotFound, "Crawl not found")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxArtifactBytes+1<<20)
+	if err := r.ParseMultipartForm(maxArtifactBytes); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid multipart form")
+		return
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "File is required")
+		return
+	}
+	defer file.Close()
+	content, err := ioutil.ReadAll(file)
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Failed to read file")
+		return
+	}
+	if len(content) > maxArtifactBytes {
+		sendErrorResponse(w, http.StatusRequestEntityTooLarge, "Artifact too large")
+		return
+	}
+
+	artifact := Artifact{
+		ID:          fmt.Sprintf("%d", time.Now().UnixNano()),
+		Name:        r.FormValue("name"),
+		ContentType: header.Header.Get("Content-Type"),
+		Size:        int64(len(content)),
+		CreatedAt:   time.Now(),
+	}
+	if artifact.Name == "" {
+		artifact.Name = header.Filename
+	}
+	if artifact.ContentType == "" {
+		artifact.ContentType = http.DetectContentType(content)
+	}
+	if metadata := r.FormValue("metadata"); metadata != "" {
+		if !json.Valid([]byte(metadata)) {
+			sendErrorResponse(w, http.StatusBadRequest, "Metadata must be valid JSON")
+			return
+		}
+		artifact.Metadata = json.RawMessage(metadata)
+	}
+
+	// Artifacts live exactly as long as the crawl they belong to
+	ttl := rdb.PTTL(ctx, resultsKey(crawlID)).Val()
+	if ttl < 0 {
+		ttl = 0
+	}
+	marshalled, _ := json.Marshal(artifact)
+	pipe := rdb.TxPipeline()
+	pipe.Set(ctx, artifactContentKey(crawlID, artifact.ID), content, ttl)
+	pipe.HSet(ctx, artifactsKey(crawlID), artifact.ID, marshalled)
+	if ttl > 0 {
+		pipe.PExpire(ctx, artifactsKey(crawlID), ttl)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to save artifact")
+		return
+	}
+	sendJSONResponse(w, http.StatusCreated, artifact)
+}
+
+// List artifacts handler - GET /crawl/{crawl_ID}/artifacts
+func listArtifactsHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
+	raw, err := rdb.HGetAll(ctx, artifactsKey(mux.Vars(r)["crawl_ID"])).Result()
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to get artifacts")
+		return
+	}
+	artifacts := make([]Artifact, 0, len(raw))
+	for _, rawArtifact := range raw {
+		var artifact Artifact
+		if err := json.Unmarshal([]byte(rawArtifact), &artifact); err != nil {
+			continue
+		}
+		artifacts = append(artifacts, artifact)
+	}
+	sort.Slice(artifacts, func(i, j int) bool {
+		return artifacts[i].CreatedAt.Before(artifacts[j].CreatedAt)
+	})
+	sendJSONResponse(w, http.StatusOK, ArtifactsResponse{Artifacts: artifacts})
+}
+
+// Get artifact handler - GET /crawl/{crawl_ID}/artifacts/{artifact_ID}
+func getArtifactHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
+	vars := mux.Vars(r)
+	artifact, err := loadArtifact(rdb, vars["crawl_ID"], vars["artifact_ID"])
+	if err == redis.Nil {
+		sendErrorResponse(w, http.StatusNotFound, "Artifact not found")
+		return
+	}
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to get artifact")
+		return
+	}
+	content, err := rdb.Get(ctx, artifactContentKey(vars["crawl_ID"], artifact.ID)).Bytes()
+	if err == redis.Nil {
+		sendErrorResponse(w, http.StatusNotFound, "Artifact not found")
+		return
+	}
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to get artifact")
+		return
+	}
+
+	w.Header().Set("Content-Type", artifact.ContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", artifact.Name))
+	w.WriteHeader(http.StatusOK)
+	w.Write(content)
+}
+
+// Delete artifact handler - DELETE /crawl/{crawl_ID}/artifacts/{artifact_ID}
+func deleteArtifactHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
+	vars := mux.Vars(r)
+	deleted, err := rdb.HDel(ctx, artifactsKey(vars["crawl_ID"]), vars["artifact_ID"]).Result()
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to delete artifact")
+		return
+	}
+	if deleted == 0 {
+		sendErrorResponse(w, http.StatusNotFound, "Artifact not found")
+		return
+	}
+	rdb.Del(ctx, artifactContentKey(vars["crawl_ID"], vars["artifact_ID"]))
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// Largest responses kept per crawl, and reported by default
+	maxLargestAssets     = 100
+	defaultLargestAssets = 20
+	// Separates the fields of contentTypesKey and the members of largestAssetsKey
+	assetFieldSeparator = "|"
+	// Reported for responses without a usable Content-Type
+	unknownContentType = "unknown"
+)
+
+type (
+	AssetsReportResponse struct {
+		Pages int64 `json:"pages"`
+		Bytes int64 `json:"bytes"`
+		// Most bytes first
+		ContentTypes []ContentTypeStats `json:"contentTypes"`
+		Largest      []AssetSize        `json:"largest"`
+	}
+	ContentTypeStats struct {
+		ContentType string `json:"contentType"`
+		Pages       int64  `json:"pages"`
+		Bytes       int64  `json:"bytes"`
+		// Share of the crawl's bytes
+		PercentOfBytes float64 `json:"percentOfBytes"`
+	}
+	AssetSize struct {
+		URL         string `json:"url"`
+		ContentType string `json:"contentType"`
+		Bytes       int64  `json:"bytes"`
+	}
+)
+
+// Helper function to build the redis key holding a crawl's pages and bytes per content type
+func contentTypesKey(uniqueID string) string {
+	return fmt.Sprintf("go-crawler-content-types-%s", uniqueID)
+}
+
+// Helper function to build the redis key holding a crawl's largest responses
+func largestAssetsKey(uniqueID string) string {
+	return fmt.Sprintf("go-crawler-largest-%s", uniqueID)
+}
+
+// Helper function to get the media type of a response, without parameters
+func responseContentType(header http.Header) string {
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil || mediaType == "" {
+		return unknownContentType
+	}
+	return strings.ToLower(mediaType)
+}
+
+// recordAsset counts a fetched response towards its crawl's content type
+// statistics and, if it's big enough, its largest assets
+func recordAsset(rdb *redis.Client, uniqueID, url, contentType string, size int64) {
+	pipe := rdb.TxPipeline()
+	pipe.HIncrBy(ctx, contentTypesKey(uniqueID), contentType+assetFieldSeparator+"pages", 1)
+	pipe.HIncrBy(ctx, contentTypesKey(uniqueID), contentType+assetFieldSeparator+"bytes", size)
+	pipe.ZAdd(ctx, largestAssetsKey(uniqueID), &redis.Z{Score: float64(size), Member: contentType + assetFieldSeparator + url})
+	// Only the largest ones are kept, the set never grows past maxLargestAssets
+	pipe.ZRemRangeByRank(ctx, largestAssetsKey(uniqueID), 0, -maxLargestAssets-1)
+	expireLikeCheckpoint(pipe, contentTypesKey(uniqueID))
+	expireLikeCheckpoint(pipe, largestAssetsKey(uniqueID))
+	if _, err := pipe.Exec(ctx); err != nil {
+		withError(crawlLogger(uniqueID).Error(), err).Str("url", url).Msg("Failed to record size")
+	}
+}
+
+// Crawl assets handler - GET /crawl/{crawl_ID}/assets
+func crawlAssetsHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
+	crawlID := mux.Vars(r)["crawl_ID"]
+	top := defaultLargestAssets
+	if rawTop := r.URL.Query().Get("top"); rawTop != "" {
+		var err error
+		top, err = strconv.Atoi(rawTop)
+		if err != nil || top < 1 || top > maxLargestAssets {
+			sendErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("top must be between 1 and %d", maxLargestAssets))
+			return
+		}
+	}
+
+	fields, err := rdb.HGetAll(ctx, contentTypesKey(crawlID)).Result()
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to get content types")
+		return
+	}
+	if len(fields) == 0 {
+		// Nothing fetched yet, unless there is no such crawl at all
+		exists, err := crawlExists(rdb, crawlID)
+		if err != nil {
+			sendErrorResponse(w, http.StatusInternalServerError, "Failed to look up crawl")
+			return
+		}
+		if !exists {
+			sendErrorResponse(w, http.StatusNotFound, "Crawl not found")
+			return
+		}
+	}
+	largest, err := rdb.ZRevRangeWithScores(ctx, largestAssetsKey(crawlID), 0, int64(top-1)).Result()
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to get largest assets")
+		return
+	}
+
+	response := AssetsReportResponse{ContentTypes: []ContentTypeStats{}, Largest: []AssetSize{}}
+	byType := make(map[string]*ContentTypeStats)
+	for field, value := range fields {
+		separator := strings.LastIndex(field, assetFieldSeparator)
+		if separator < 0 {
+			continue
+		}
+		contentType := field[:separator]
+		stats := byType[contentType]
+		if stats == nil {
+			stats = &ContentTypeStats{ContentType: contentType}
+			byType[contentType] = stats
+		}
+		count, _ := strconv.ParseInt(value, 10, 64)
+		switch field[separator+1:] {
+		case "pages":
+			stats.Pages = count
+			response.Pages += count
+		case "bytes":
+			stats.Bytes = count
+			response.Bytes += count
+		}
+	}
+	for _, stats := range byType {
+		if response.Bytes > 0 {
+			stats.PercentOfBytes = math.Round(1000*float64(stats.Bytes)/float64(response.Bytes)) / 10
+		}
+		response.ContentTypes = append(response.ContentTypes, *stats)
+	}
+	sort.Slice(response.ContentTypes, func(i, j int) bool {
+		if response.ContentTypes[i].Bytes != response.ContentTypes[j].Bytes {
+			return response.ContentTypes[i].Bytes > response.ContentTypes[j].Bytes
+		}
+		return response.ContentTypes[i].ContentType < response.ContentTypes[j].ContentType
+	})
+	for _, member := range largest {
+		parts := strings.SplitN(member.Member.(string), assetFieldSeparator, 2)
+		if len(parts) != 2 {
+			continue
+		}
+		response.Largest = append(response.Largest, AssetSize{URL: parts[1], ContentType: parts[0], Bytes: int64(member.Score)})
+	}
+	sendJSONResponse(w, http.StatusOK, response)
+}
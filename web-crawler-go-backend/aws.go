@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	sqsWaitSeconds = 20
+	// A received job stays hidden from other workers this long, and is
+	// extended every sqsVisibilityRenewSeconds while its crawl runs
+	sqsVisibilitySeconds      = 60
+	sqsVisibilityRenewSeconds = 20
+	// Rows in the results table expire through DynamoDB's TTL on expiresAt
+	dynamoResultsTTL = 7 * 24 * time.Hour
+)
+
+type (
+	// sqsJobQueue reads crawl commands from an SQS queue, deleting each
+	// once its crawl finishes
+	sqsJobQueue struct {
+		rdb      *redis.Client
+		client   *sqs.Client
+		queueURL string
+	}
+	// dynamoResultSink writes every node of a crawl to a DynamoDB table with
+	// partition key crawlID (string) and sort key seq (number)
+	dynamoResultSink struct {
+		client *dynamodb.Client
+		table  string
+	}
+	dynamoResult struct {
+		CrawlID string `dynamodbav:"crawlID"`
+		// Nanoseconds since the epoch when the row was written, orders a crawl's rows
+		Seq int64 `dynamodbav:"seq"`
+		// The node in the same JSON as the Redis results list
+		Node      string `dynamodbav:"node,omitempty"`
+		Done      bool   `dynamodbav:"done,omitempty"`
+		ExpiresAt int64  `dynamodbav:"expiresAt"`
+	}
+)
+
+// Helper function to load AWS credentials and region the standard way (env, shared config, instance role)
+func loadAWSConfig() (aws.Config, error) {
+	return config.LoadDefaultConfig(ctx)
+}
+
+func newSQSJobQueue(rdb *redis.Client, awsConfig aws.Config, queueURL string) *sqsJobQueue {
+	return &sqsJobQueue{rdb: rdb, client: sqs.NewFromConfig(awsConfig), queueURL: queueURL}
+}
+
+func (q *sqsJobQueue) enqueue(command string) error {
+	_, err := q.client.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:    aws.String(q.queueURL),
+		MessageBody: aws.String(command),
+	})
+	return err
+}
+
+// length is SQS's approximate count of visible messages, jobs being worked on aren't included
+func (q *sqsJobQueue) length() (int64, error) {
+	attributes, err := q.client.GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{
+		QueueUrl:       aws.String(q.queueURL),
+		AttributeNames: []sqstypes.QueueAttributeName{sqstypes.QueueAttributeNameApproximateNumberOfMessages},
+	})
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(attributes.Attributes[string(sqstypes.QueueAttributeNameApproximateNumberOfMessages)], 10, 64)
+}
+
+func (q *sqsJobQueue) consume(handle func(command string) error) {
+	logger.Info().Str("queue", q.queueURL).Msg("Consuming jobs")
+	for {
+		received, err := q.client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(q.queueURL),
+			MaxNumberOfMessages: 1,
+			WaitTimeSeconds:     sqsWaitSeconds,
+			VisibilityTimeout:   sqsVisibilitySeconds,
+		})
+		reportSubscriber(err)
+		if err != nil {
+			withError(logger.Error(), err).Msg("Failed to read from job queue")
+			time.Sleep(queueRetryDelaySeconds * time.Second)
+			continue
+		}
+		for _, message := range received.Messages {
+			if message.Body == nil || message.ReceiptHandle == nil {
+				continue
+			}
+			go func(command, receiptHandle string) {
+				stop := q.keepJobHidden(receiptHandle)
+				runJob(q.rdb, command, handle)
+				close(stop)
+				_, err := q.client.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+					QueueUrl:      aws.String(q.queueURL),
+					ReceiptHandle: aws.String(receiptHandle),
+				})
+				if err != nil {
+					withError(logger.Error(), err).Msg("Failed to delete finished job")
+				}
+			}(*message.Body, *message.ReceiptHandle)
+		}
+	}
+}
+
+// keepJobHidden extends the job's visibility timeout until stop is closed, so
+// SQS only hands it to another worker if this one dies
+func (q *sqsJobQueue) keepJobHidden(receiptHandle string) chan struct{} {
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(sqsVisibilityRenewSeconds * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				_, err := q.client.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+					QueueUrl:          aws.String(q.queueURL),
+					ReceiptHandle:     aws.String(receiptHandle),
+					VisibilityTimeout: sqsVisibilitySeconds,
+				})
+				if err != nil {
+					withError(logger.Error(), err).Msg("Failed to extend job visibility")
+				}
+			}
+		}
+	}()
+	return stop
+}
+
+func newDynamoResultSink(awsConfig aws.Config, table string) *dynamoResultSink {
+	return &dynamoResultSink{client: dynamodb.NewFromConfig(awsConfig), table: table}
+}
+
+// Helper function to write one row of a crawl's results
+func (sink *dynamoResultSink) put(row dynamoResult) error {
+	row.Seq = time.Now().UnixNano()
+	row.ExpiresAt = time.Now().Add(dynamoResultsTTL).Unix()
+	item, err := attributevalue.MarshalMap(row)
+	if err != nil {
+		return err
+	}
+	_, err = sink.client.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String(sink.table), Item: item})
+	return err
+}
+
+func (sink *dynamoResultSink) publish(crawlID string, node graphNode) error {
+	marshalled, err := json.Marshal(&node)
+	if err != nil {
+		return err
+	}
+	return sink.put(dynamoResult{CrawlID: crawlID, Node: string(marshalled)})
+}
+
+func (sink *dynamoResultSink) finish(crawlID string) error {
+	return sink.put(dynamoResult{CrawlID: crawlID, Done: true})
+}
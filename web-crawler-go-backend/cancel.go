@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+)
+
+// Field of the crawl's meta hash saying when it was canceled
+const canceledAtField = "canceledAt"
+
+// Tells a crawl's worker that it was canceled, see saveCheckpoint
+var errCrawlCanceled = errors.New("crawl was canceled")
+
+type CancelCrawlResponse struct {
+	State        string `json:"state"`
+	PagesFetched int64  `json:"pagesFetched"`
+}
+
+// Helper function to tell whether a crawl was canceled
+func crawlCanceled(rdb *redis.Client, uniqueID string) bool {
+	return rdb.HExists(ctx, crawlMetaKey(uniqueID), canceledAtField).Val()
+}
+
+// Cancel crawl handler - POST /crawl/{crawl_ID}/cancel
+func cancelCrawlHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
+	crawlID := mux.Vars(r)["crawl_ID"]
+	policy, ok := requireTenant(w, r)
+	if !ok {
+		return
+	}
+
+	ttl := currentPolicy().resultsTTL
+	checkpoint, err := loadCheckpoint(rdb, crawlID)
+	switch {
+	case err == redis.Nil:
+		// Not started yet, or already over
+		_, done, err := resultStore.Range(crawlID, -1, -1)
+		if err != nil {
+			sendErrorResponse(w, http.StatusInternalServerError, "Failed to get results")
+			return
+		}
+		if done {
+			sendErrorResponse(w, http.StatusConflict, "Crawl is already done")
+			return
+		}
+	case err != nil:
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to get checkpoint")
+		return
+	default:
+		if !ownsCrawl(policy, checkpoint.Limits) {
+			sendErrorResponse(w, http.StatusForbidden, "Crawl belongs to another tenant")
+			return
+		}
+		if checkpoint.ResultsTTLSeconds > 0 {
+			ttl = time.Duration(checkpoint.ResultsTTLSeconds) * time.Second
+		}
+	}
+
+	// The worker notices at its next checkpoint, a crawl still queued is skipped
+	recordCrawlMeta(rdb, crawlID, canceledAtField, time.Now().Format(time.RFC3339))
+	if err := abandonCrawl(rdb, crawlID, ttl); err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to cancel crawl")
+		return
+	}
+	crawlLogger(crawlID).Info().Msg("Canceled crawl")
+
+	nodes, _, err := resultStore.Range(crawlID, 0, -1)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to get results")
+		return
+	}
+	sendJSONResponse(w, http.StatusOK, CancelCrawlResponse{State: crawlStateCanceled, PagesFetched: int64(len(nodes))})
+}
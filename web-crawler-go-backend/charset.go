@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/net/html/charset"
+)
+
+var pageCharsetMetric = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "crawler_page_charsets_total",
+	Help: "HTML pages fetched, by the charset they were decoded from.",
+}, []string{"charset"})
+
+// decodePage returns an HTML page as UTF-8, for everything that reads it:
+// links, feeds, text, scripts and SEO tags. The charset comes from a byte order
+// mark, the Content-Type header or a <meta> tag near the top of the page, in
+// that order. Pages that declare none are read as UTF-8 when they're valid
+// UTF-8 and as windows-1252 otherwise, like browsers do. Other content is returned as is
+func decodePage(page []byte, header http.Header) []byte {
+	contentType := responseContentType(header)
+	if !strings.Contains(contentType, "html") && contentType != unknownContentType {
+		return page
+	}
+	encoding, name, _ := charset.DetermineEncoding(page, header.Get("Content-Type"))
+	pageCharsetMetric.WithLabelValues(name).Inc()
+	if name == "utf-8" {
+		return page
+	}
+	decoded, err := encoding.NewDecoder().Bytes(page)
+	if err != nil {
+		return page
+	}
+	return decoded
+}
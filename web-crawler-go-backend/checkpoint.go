@@ -0,0 +1,310 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+)
+
+const (
+	checkpointPeriodInSeconds = 5
+	// Checkpoints outlive the results TTL by a wide margin, a crashed
+	// crawl has to be noticed before it can be resumed
+	checkpointTTL = time.Hour
+)
+
+type (
+	frontierItem struct {
+		URL   string
+		Depth int
+	}
+	// frontier counts the urls that have been scheduled but not yet
+	// fetched, so an interrupted crawl knows where to pick up from
+	frontier struct {
+		sync.Mutex
+		pending map[frontierItem]int
+	}
+	crawlCheckpoint struct {
+		URL       string
+		Depth     int
+		StartTime time.Time
+		UpdatedAt time.Time
+		// When the crawl last found a page, the janitor gives up on crawls that stop
+		LastProgress time.Time
+		StatusOf     string `json:",omitempty"`
+		StoreBodies  string `json:",omitempty"`
+		SEOAudit     bool   `json:",omitempty"`
+		// Whether the security headers of every page are recorded
+		SecurityHeaders bool `json:",omitempty"`
+		// Whether the entries of feeds are crawled, and pages revalidated
+		FollowFeeds bool `json:",omitempty"`
+		HTTPCache   bool `json:",omitempty"`
+		// flag or prune, when near-duplicate pages are looked for
+		NearDuplicates string `json:",omitempty"`
+		// The crawl's own User-Agents
+		UserAgents []string          `json:",omitempty"`
+		Headers    map[string]string `json:",omitempty"`
+		// Whether the crawl has a cookie jar, and the cookies it started with.
+		// Those the sites set since are lost, a resumed crawl starts over with these
+		CookieJar bool          `json:",omitempty"`
+		Cookies   []CrawlCookie `json:",omitempty"`
+		// Basic or bearer credentials, and the hosts they're sent to
+		Credentials []CrawlCredential `json:",omitempty"`
+		Proxy       string            `json:",omitempty"`
+		ProxyPool   string            `json:",omitempty"`
+		Render      bool              `json:",omitempty"`
+		Screenshots bool              `json:",omitempty"`
+		// Whether PDF and Word documents are read
+		ExtractDocuments bool `json:",omitempty"`
+		// Unset in checkpoints written before it was always recorded, those use the default
+		ResultsTTLSeconds int `json:",omitempty"`
+		// Only when the crawl is held to a tenant's policy or asked for limits of its own
+		Limits   *CrawlLimits `json:",omitempty"`
+		Visited  []string
+		Frontier []frontierItem
+	}
+)
+
+func (f *frontier) add(url string, depth int) {
+	f.Lock()
+	defer f.Unlock()
+	f.pending[frontierItem{URL: url, Depth: depth}]++
+}
+
+func (f *frontier) remove(url string, depth int) {
+	f.Lock()
+	defer f.Unlock()
+	item := frontierItem{URL: url, Depth: depth}
+	if f.pending[item] <= 1 {
+		delete(f.pending, item)
+		return
+	}
+	f.pending[item]--
+}
+
+func (f *frontier) size() int {
+	f.Lock()
+	defer f.Unlock()
+	size := 0
+	for _, count := range f.pending {
+		size += count
+	}
+	return size
+}
+
+func (f *frontier) items() []frontierItem {
+	f.Lock()
+	defer f.Unlock()
+	items := make([]frontierItem, 0, len(f.pending))
+	for item := range f.pending {
+		items = append(items, item)
+	}
+	return items
+}
+
+// Helper function to build the redis key holding a crawl's checkpoint
+func checkpointKey(uniqueID string) string {
+	return fmt.Sprintf("go-crawler-checkpoint-%s", uniqueID)
+}
+
+// expireLikeCheckpoint gives a key the crawl writes to while it runs the
+// checkpoint's TTL. Like the checkpoint, it lasts until the crawl finishes and
+// everything gets the results TTL, so a crashed crawl doesn't leak it
+func expireLikeCheckpoint(pipe redis.Pipeliner, key string) {
+	pipe.Expire(ctx, key, checkpointTTL)
+}
+
+// crawlDataTTL returns how long a crawl's keys have left, so keys added to it
+// later expire along with it. That's the checkpoint's TTL while it runs and the
+// results TTL once it's finished, whichever store the results are in
+func crawlDataTTL(rdb *redis.Client, uniqueID string) time.Duration {
+	for _, key := range []string{crawlMetaKey(uniqueID), checkpointKey(uniqueID)} {
+		if ttl := rdb.PTTL(ctx, key).Val(); ttl > 0 {
+			return ttl
+		}
+	}
+	// Crawls started without a request ID may have no meta, expire like any other
+	return currentPolicy().resultsTTL
+}
+
+// saveCheckpoint writes the crawl's checkpoint, only overwriting an existing one
+// unless first is set. If it's gone the crawl was canceled or the janitor abandoned
+// it, and errCrawlCanceled or errCrawlAbandoned is returned
+func saveCheckpoint(rdb *redis.Client, uniqueID, url string, depth int, session *crawlSession, first bool) error {
+	checkpoint := crawlCheckpoint{
+		URL:              url,
+		Depth:            depth,
+		StartTime:        session.startTime,
+		UpdatedAt:        time.Now(),
+		LastProgress:     session.lastProgress,
+		StatusOf:         session.statusOf,
+		StoreBodies:      session.storeBodies,
+		SEOAudit:         session.seoAudit,
+		SecurityHeaders:  session.securityHeaders,
+		FollowFeeds:      session.followFeeds,
+		HTTPCache:        session.httpCache,
+		NearDuplicates:   session.nearDuplicates,
+		UserAgents:       session.userAgents,
+		Headers:          session.headers,
+		CookieJar:        session.cookieJar,
+		Cookies:          session.cookies,
+		Credentials:      session.credentials,
+		Proxy:            session.proxy,
+		ProxyPool:        session.proxyPool,
+		Render:           session.render,
+		Screenshots:      session.screenshots,
+		ExtractDocuments: session.extractDocuments,
+		// Frontier first, anything visited after this snapshot will still be in it
+		Frontier: session.frontier.items(),
+		Visited:  session.urlMap.keys(),
+	}
+	checkpoint.Limits = &session.limits
+	// Even the default, which may be reloaded before the crawl is resumed
+	checkpoint.ResultsTTLSeconds = int(session.resultsTTL / time.Second)
+	marshalled, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	if first {
+		return rdb.Set(ctx, checkpointKey(uniqueID), marshalled, checkpointTTL).Err()
+	}
+	saved, err := rdb.SetXX(ctx, checkpointKey(uniqueID), marshalled, checkpointTTL).Result()
+	if err != nil {
+		return err
+	}
+	if !saved {
+		if crawlCanceled(rdb, uniqueID) {
+			return errCrawlCanceled
+		}
+		return errCrawlAbandoned
+	}
+	return nil
+}
+
+func loadCheckpoint(rdb *redis.Client, uniqueID string) (*crawlCheckpoint, error) {
+	raw, err := rdb.Get(ctx, checkpointKey(uniqueID)).Bytes()
+	if err != nil {
+		return nil, err
+	}
+	var checkpoint crawlCheckpoint
+	if err := json.Unmarshal(raw, &checkpoint); err != nil {
+		return nil, err
+	}
+
+	// Nodes may have been published after the checkpoint was taken, replay them
+	// so their pages aren't fetched twice and their children aren't lost
+	nodes, _, err := resultStore.Range(uniqueID, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	pending := make(map[string]int, len(checkpoint.Frontier))
+	for _, item := range checkpoint.Frontier {
+		if item.Depth > pending[item.URL] {
+			pending[item.URL] = item.Depth
+		}
+	}
+	visited := make(map[string]bool, len(checkpoint.Visited))
+	for _, url := range checkpoint.Visited {
+		// In-flight urls were marked as visited but never finished
+		if _, ok := pending[url]; !ok {
+			visited[url] = true
+		}
+	}
+	for _, node := range nodes {
+		visited[node.Parent] = true
+		delete(pending, node.Parent)
+		for _, child := range node.Children {
+			if !visited[child] && node.Depth-1 > pending[child] {
+				pending[child] = node.Depth - 1
+			}
+		}
+	}
+
+	checkpoint.Visited = make([]string, 0, len(visited))
+	for url := range visited {
+		checkpoint.Visited = append(checkpoint.Visited, url)
+	}
+	checkpoint.Frontier = make([]frontierItem, 0, len(pending))
+	for url, depth := range pending {
+		checkpoint.Frontier = append(checkpoint.Frontier, frontierItem{URL: url, Depth: depth})
+	}
+	return &checkpoint, nil
+}
+
+// restore seeds the session from a checkpoint, returning the urls still to be crawled
+func (session *crawlSession) restore(checkpoint *crawlCheckpoint) []frontierItem {
+	session.startTime = checkpoint.StartTime
+	session.urlMap.reset(checkpoint.Visited)
+	return checkpoint.Frontier
+}
+
+// Resume crawl handler - POST /crawl/{crawl_ID}/resume
+func resumeCrawlHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
+	crawlID := mux.Vars(r)["crawl_ID"]
+	policy, ok := requireTenant(w, r)
+	if !ok {
+		return
+	}
+
+	raw, err := rdb.Get(ctx, checkpointKey(crawlID)).Bytes()
+	if err == redis.Nil {
+		sendErrorResponse(w, http.StatusNotFound, "No checkpoint found for crawl")
+		return
+	}
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to get checkpoint")
+		return
+	}
+	var checkpoint crawlCheckpoint
+	if err := json.Unmarshal(raw, &checkpoint); err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Corrupt checkpoint")
+		return
+	}
+
+	if !ownsCrawl(policy, checkpoint.Limits) {
+		sendErrorResponse(w, http.StatusForbidden, "Crawl belongs to another tenant")
+		return
+	}
+
+	// A worker that is still alive keeps refreshing its checkpoint
+	if time.Since(checkpoint.UpdatedAt) < 2*checkpointPeriodInSeconds*time.Second {
+		sendErrorResponse(w, http.StatusConflict, "Crawl is still running")
+		return
+	}
+
+	job := newCrawlJob(r.Context(), checkpoint.URL)
+	job.CrawlID = crawlID
+	job.Resume = true
+	job.StatusOf = checkpoint.StatusOf
+	job.StoreBodies = checkpoint.StoreBodies
+	job.SEOAudit = checkpoint.SEOAudit
+	job.SecurityHeaders = checkpoint.SecurityHeaders
+	job.FollowFeeds = checkpoint.FollowFeeds
+	job.HTTPCache = checkpoint.HTTPCache
+	job.NearDuplicates = checkpoint.NearDuplicates
+	job.UserAgents = checkpoint.UserAgents
+	job.Headers = checkpoint.Headers
+	job.CookieJar, job.Cookies = checkpoint.CookieJar, checkpoint.Cookies
+	job.Credentials = checkpoint.Credentials
+	job.Proxy, job.ProxyPool = checkpoint.Proxy, checkpoint.ProxyPool
+	job.Render, job.Screenshots = checkpoint.Render, checkpoint.Screenshots
+	job.ExtractDocuments = checkpoint.ExtractDocuments
+	job.ResultsTTLSeconds = checkpoint.ResultsTTLSeconds
+	job.Limits = checkpoint.Limits
+	if !takeCrawlQuota(w, r, rdb, policy) {
+		return
+	}
+	if err := queueJob(job); err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to queue command")
+		return
+	}
+
+	response := InitializeCrawlResponse{CrawlID: crawlID, ResultsURL: buildResultsLink(apiScheme(), r.Host, crawlID, 0)}
+	sendJSONResponse(w, http.StatusAccepted, response)
+}
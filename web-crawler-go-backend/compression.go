@@ -0,0 +1,77 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Encodings page fetches ask for. Asking for them ourselves stops the transport
+// from decoding gzip for us, so the fetcher decodes both
+const acceptEncoding = "gzip, br"
+
+type (
+	// countingReader counts the bytes read through it
+	countingReader struct {
+		reader io.Reader
+		count  int64
+	}
+	// decodingBody is the body of a compressed response, decoded as it's read.
+	// Like the transport's, a malformed body only fails once it's read
+	decodingBody struct {
+		encoding string
+		// The body as sent, counting the bytes off the wire
+		wire    *countingReader
+		closer  io.Closer
+		decoder io.Reader
+		err     error
+	}
+)
+
+func (counter *countingReader) Read(p []byte) (int, error) {
+	n, err := counter.reader.Read(p)
+	counter.count += int64(n)
+	return n, err
+}
+
+func (body *decodingBody) Read(p []byte) (int, error) {
+	if body.decoder == nil && body.err == nil {
+		switch body.encoding {
+		case "gzip":
+			body.decoder, body.err = gzip.NewReader(body.wire)
+		case "br":
+			body.decoder = brotli.NewReader(body.wire)
+		}
+	}
+	if body.err != nil {
+		return 0, body.err
+	}
+	return body.decoder.Read(p)
+}
+
+func (body *decodingBody) Close() error {
+	return body.closer.Close()
+}
+
+// decodeBody swaps the body of a response compressed with gzip or br for its
+// decoded content, and drops the headers describing the encoded one, the way
+// the transport does. It returns nil if the response wasn't compressed
+func decodeBody(resp *http.Response) *decodingBody {
+	encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	if encoding == "x-gzip" {
+		encoding = "gzip"
+	}
+	if encoding != "gzip" && encoding != "br" {
+		return nil
+	}
+	body := &decodingBody{encoding: encoding, wire: &countingReader{reader: resp.Body}, closer: resp.Body}
+	resp.Body = body
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return body
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Every flag can also be set with this prefix and its name upper cased, e.g.
+// CRAWLER_REDIS_ADDR for -redis-addr. Flags given on the command line win
+const configEnvPrefix = "CRAWLER_"
+
+// Set from flags or the environment before anything else runs. The crawl
+// defaults that can change while running are in crawlPolicy
+var (
+	// How long connecting to a host, or a TLS handshake, may take
+	dialTimeout = 2 * time.Second
+	// Address the HTTP server listens on
+	serverAddr = ":8080"
+	// Allowed origins for CORS
+	allowedOrigins = stringList{
+		"http://localhost:3000",
+		"http://localhost:5173",
+		"http://localhost:8080",
+		"https://localhost:3000",
+		"https://localhost:5173",
+		"https://localhost:8080",
+	}
+	redisOptions = redis.Options{Addr: "localhost:6379"}
+	// Address the admin endpoints and metrics are served on, never on serverAddr.
+	// Empty serves neither
+	adminAddr string
+	// Optional address the gRPC API is served on
+	grpcAddr string
+	// Optional, the API is served over TLS with either a certificate of its own
+	// or one Let's Encrypt issues for the autocert domains
+	tlsCertFile, tlsKeyFile string
+	autocertDomains         stringList
+	autocertCacheDir        = filepath.Join(os.TempDir(), "go-crawler-autocert")
+	autocertEmail           string
+	// Optional identity provider whose JWTs are accepted as bearer tokens, and
+	// the claims naming the caller's tenant and user
+	jwksURL, jwtIssuer, jwtAudience string
+	jwtTenantClaim                  = "tenant"
+	jwtUserClaim                    = "sub"
+	// Optional plaintext address redirecting to the API, and answering ACME challenges
+	httpRedirectAddr string
+	// Whether POST /crawl checks the seed answers before queuing the crawl
+	seedPreflight bool
+	// File settings were read from, if any, so they can be read again
+	configFile string
+	// Flags given on the command line, they win over the file and the environment every time
+	commandLineSettings = map[string]string{}
+)
+
+// stringList is a flag holding comma separated values
+type stringList []string
+
+func (list *stringList) String() string {
+	return strings.Join(*list, ",")
+}
+
+func (list *stringList) Set(value string) error {
+	*list = stringList{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*list = append(*list, item)
+		}
+	}
+	return nil
+}
+
+// registerConfigFlags adds the flags for everything above
+func registerConfigFlags(flags *flag.FlagSet) {
+	flags.StringVar(&configFile, configFileFlag, "", "YAML or TOML file to read settings from, overridden by the environment and the other flags")
+	flags.StringVar(&redisOptions.Addr, "redis-addr", redisOptions.Addr, "Redis server every process shares, host:port")
+	flags.StringVar(&redisOptions.Password, "redis-password", "", "password for -redis-addr")
+	flags.IntVar(&redisOptions.DB, "redis-db", 0, "database number to use on -redis-addr")
+	flags.StringVar(&serverAddr, "addr", serverAddr, "address the API server listens on")
+	flags.StringVar(&adminAddr, "admin-addr", "localhost:9091", "serve /admin/* and /metrics on this address, they're never served on -addr. Empty to serve neither")
+	flags.StringVar(&grpcAddr, "grpc-addr", "", "if set, also serve the gRPC API on this address, e.g. :9090")
+	flags.Var(&allowedOrigins, "cors-origins", "comma separated origins browsers may call the API from")
+	flags.StringVar(&tlsCertFile, "tls-cert", "", "if set with -tls-key, serve the API over HTTPS with this PEM certificate (chain)")
+	flags.StringVar(&tlsKeyFile, "tls-key", "", "PEM private key of -tls-cert")
+	flags.Var(&autocertDomains, "autocert-domains", "if set, serve the API over HTTPS with certificates Let's Encrypt issues for these comma separated domains")
+	flags.StringVar(&autocertCacheDir, "autocert-cache", autocertCacheDir, "directory -autocert-domains certificates are kept in across restarts")
+	flags.StringVar(&autocertEmail, "autocert-email", "", "contact address given to Let's Encrypt for -autocert-domains")
+	flags.StringVar(&httpRedirectAddr, "http-redirect-addr", "", "if set with TLS, also listen for plain HTTP here, e.g. :80, redirecting to HTTPS and answering Let's Encrypt's challenges")
+	flags.StringVar(&jwksURL, "jwks-url", "", "if set, accept JWTs signed with the keys served here as bearer tokens, e.g. https://idp.example.com/.well-known/jwks.json")
+	flags.StringVar(&jwtIssuer, "jwt-issuer", "", "if set, the iss tokens must have")
+	flags.StringVar(&jwtAudience, "jwt-audience", "", "if set, the aud tokens must include")
+	flags.StringVar(&jwtTenantClaim, "jwt-tenant-claim", jwtTenantClaim, "claim naming the tenant of -tenant-policies a token's caller belongs to")
+	flags.StringVar(&jwtUserClaim, "jwt-user-claim", jwtUserClaim, "claim identifying a token's user, attached to the crawls it starts")
+	flags.BoolVar(&seedPreflight, "seed-preflight", false, "send a HEAD request to the url of every new crawl, refusing it if the host can't be reached or answers 404 or 410")
+	flags.DurationVar(&dialTimeout, "dial-timeout", dialTimeout, "how long connecting to a host or a TLS handshake may take, for crawls and Kafka")
+	flags.BoolVar(&fetchHTTP2, "http2", fetchHTTP2, "fetch pages over HTTP/2 from the servers that offer it")
+	flags.BoolVar(&fetchHTTP3, "http3", false, "experimental, fetch over HTTP/3 from the servers advertising it with Alt-Svc, falling back to TCP when it fails")
+	flags.StringVar(&defaultUserAgent, "user-agent", defaultUserAgent, "User-Agent sent by crawls that don't set their own")
+	flags.StringVar(&userAgentContact, "user-agent-contact", "", "if set, a url about the crawler or to reach its operator, appended to -user-agent as (+url)")
+	flags.StringVar(&proxyAddr, "proxy", "", "if set, crawls without a proxy of their own fetch through this http, https or socks5 proxy url instead of the one HTTPS_PROXY and HTTP_PROXY name, e.g. socks5h://127.0.0.1:9050 for Tor")
+	flags.Var(&allowedProxies, "allowed-proxies", "comma separated proxy urls a crawl's own proxy may be, compared on scheme, host and port so crawls may add a user:password. Crawls can't have their own proxy without it, nor when -proxy is set")
+	flags.Var(&proxyPoolAddrs, "proxy-pool", "comma separated proxy urls crawls asking for proxyPool spread their requests over")
+	flags.StringVar(&ipFamily, "ip-family", ipFamily, "addresses crawls connect to hosts at: any, prefer-ipv4, prefer-ipv6, or only ipv4 or ipv6")
+	flags.Var(&dnsServers, "dns-servers", "comma separated DNS servers crawls look hosts up with instead of the system's, ip[:port], or domain=ip[:port] to send the lookups of a domain to its own servers")
+	flags.StringVar(&dnsOverHTTPS, "dns-over-https", "", "DNS-over-HTTPS endpoint crawls look hosts up with instead of the system's, like https://1.1.1.1/dns-query. -dns-servers may still send some domains to their own servers")
+	flags.BoolVar(&dnsCache, "dns-cache", false, "cache the addresses of the hosts crawls look up, for as long as their TTLs allow")
+	flags.IntVar(&renderWorkers, "render-workers", 0, "pages a worker renders in its headless browser at once, for crawls with render. 0 doesn't start the browser")
+	flags.IntVar(&renderBrowserPages, "render-browser-pages", 500, "pages the headless browser renders before a new one is started in its place, so it doesn't grow forever. 0 keeps it until it dies")
+	flags.StringVar(&chromePath, "chrome-path", "", "Chrome or Chromium binary of the headless browser, found on the PATH if unset")
+	flags.IntVar(&maxConnsPerHost, "max-conns-per-host", 0, "connections a worker opens to each host at most, 0 for no limit")
+}
+
+// loadSettings layers the configuration file and the environment under the
+// command line flags already parsed into flags
+func loadSettings(flags *flag.FlagSet) error {
+	if configFile != "" {
+		if err := applyConfigFile(flags, configFile); err != nil {
+			return err
+		}
+	}
+	if err := applyEnvironment(flags); err != nil {
+		return err
+	}
+	for name, value := range commandLineSettings {
+		if flags.Lookup(name) != nil {
+			flags.Set(name, value)
+		}
+	}
+	return nil
+}
+
+// Helper function to get the environment variable a flag can be set with
+func configEnvName(flagName string) string {
+	return configEnvPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// applyEnvironment sets flags from their environment variables
+func applyEnvironment(flags *flag.FlagSet) error {
+	var err error
+	flags.VisitAll(func(f *flag.Flag) {
+		value, ok := os.LookupEnv(configEnvName(f.Name))
+		if !ok || err != nil {
+			return
+		}
+		if setErr := flags.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("invalid value %q for %s: %v", value, configEnvName(f.Name), setErr)
+		}
+	})
+	return err
+}
+
+// validateConfig checks the configuration makes sense before anything uses it
+func validateConfig() error {
+	if _, _, err := net.SplitHostPort(redisOptions.Addr); err != nil {
+		return fmt.Errorf("-redis-addr must be host:port: %v", err)
+	}
+	if redisOptions.DB < 0 {
+		return errors.New("-redis-db can't be negative")
+	}
+	if _, _, err := net.SplitHostPort(serverAddr); err != nil {
+		return fmt.Errorf("-addr must be [host]:port: %v", err)
+	}
+	if adminAddr != "" {
+		if _, _, err := net.SplitHostPort(adminAddr); err != nil {
+			return fmt.Errorf("-admin-addr must be [host]:port: %v", err)
+		}
+		if adminAddr == serverAddr {
+			return errors.New("-admin-addr must differ from -addr")
+		}
+	}
+	if fetchHTTP3 && !http3Supported {
+		return errors.New("-http3 needs a binary built with -tags http3")
+	}
+	if grpcAddr != "" {
+		if _, _, err := net.SplitHostPort(grpcAddr); err != nil {
+			return fmt.Errorf("-grpc-addr must be [host]:port: %v", err)
+		}
+		if grpcAddr == serverAddr || grpcAddr == adminAddr {
+			return errors.New("-grpc-addr must differ from -addr and -admin-addr")
+		}
+	}
+	for _, origin := range allowedOrigins {
+		if origin == "*" {
+			continue
+		}
+		parsed, err := url.Parse(origin)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" || parsed.Path != "" {
+			return fmt.Errorf("-cors-origins has %q, expected origins like https://example.com or *", origin)
+		}
+	}
+	if (tlsCertFile == "") != (tlsKeyFile == "") {
+		return errors.New("-tls-cert and -tls-key go together")
+	}
+	if tlsCertFile != "" {
+		// Checked now, the server only finds out once it's in the background
+		if _, err := tls.LoadX509KeyPair(tlsCertFile, tlsKeyFile); err != nil {
+			return fmt.Errorf("-tls-cert and -tls-key don't load: %v", err)
+		}
+	}
+	if tlsCertFile != "" && len(autocertDomains) > 0 {
+		return errors.New("pick one of -tls-cert and -autocert-domains")
+	}
+	if jwksURL != "" {
+		if parsed, err := url.Parse(jwksURL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return errors.New("-jwks-url must be an http or https url")
+		}
+	}
+	if httpRedirectAddr != "" && !tlsEnabled() {
+		return errors.New("-http-redirect-addr needs -tls-cert or -autocert-domains")
+	}
+	if httpRedirectAddr != "" {
+		if _, _, err := net.SplitHostPort(httpRedirectAddr); err != nil {
+			return fmt.Errorf("-http-redirect-addr must be [host]:port: %v", err)
+		}
+	}
+	if dialTimeout <= 0 {
+		return errors.New("-dial-timeout must be positive")
+	}
+	if proxyAddr != "" {
+		if _, err := parseProxyURL(proxyAddr); err != nil {
+			return fmt.Errorf("-proxy: %v", err)
+		}
+	}
+	for _, proxy := range allowedProxies {
+		if _, err := parseProxyURL(proxy); err != nil {
+			return fmt.Errorf("-allowed-proxies has %q: %v", proxy, err)
+		}
+	}
+	for _, proxy := range proxyPoolAddrs {
+		if _, err := parseProxyURL(proxy); err != nil {
+			return fmt.Errorf("-proxy-pool has %q: %v", proxy, err)
+		}
+	}
+	if renderWorkers < 0 || renderBrowserPages < 0 {
+		return errors.New("-render-workers and -render-browser-pages can't be negative")
+	}
+	if renderWorkers > 0 && proxyAddr != "" {
+		if proxy, _ := url.Parse(proxyAddr); proxy.User != nil {
+			return errors.New("the headless browser of -render-workers can't authenticate to a -proxy with a password")
+		}
+	}
+	if err := validateIPFamily(ipFamily); err != nil {
+		return fmt.Errorf("-ip-family: %v", err)
+	}
+	if _, err := newCrawlResolver(dnsServers, dnsOverHTTPS, dnsCache); err != nil {
+		return fmt.Errorf("-dns-servers and -dns-over-https: %v", err)
+	}
+	if err := validateUserAgents([]string{crawlerUserAgent()}); err != nil {
+		return fmt.Errorf("-user-agent and -user-agent-contact: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// Flag naming the configuration file, it can't be set from the file itself
+const configFileFlag = "config"
+
+// The sections of a configuration file and the flags each one may set, under
+// the flag's name. Whatever isn't here (the load test) is only a flag
+var configSections = map[string][]string{
+	"crawl":         {"max-depth", "crawl-concurrency", "results-ttl", "max-page-bytes", "max-links", "dial-timeout", "http2", "http3", "max-conns-per-host", "user-agent", "user-agent-contact", "proxy", "allowed-proxies", "proxy-pool", "ip-family", "dns-servers", "dns-over-https", "dns-cache", "render-workers", "render-browser-pages", "chrome-path", "seed-preflight", "shared-visited", "tracker-signatures"},
+	"politeness":    {"host-rate", "host-burst", "blocked-domains"},
+	"queue":         {"queue", "kafka-brokers", "kafka-jobs-topic", "amqp-url", "amqp-queue", "amqp-prefetch", "sqs-queue-url"},
+	"storage":       {"redis-addr", "redis-password", "redis-db", "postgres-url", "dynamodb-table", "kafka-edges-topic", "amqp-results", "elasticsearch-url", "elasticsearch-index", "neo4j-uri", "neo4j-user", "neo4j-password", "warc-bucket", "warc-prefix", "s3-endpoint", "workspace-dir", "workspace-quota", "workspace-crawl-quota", "cold-store", "screenshot-store", "fetch-cache", "fetch-cache-freshness", "fetch-cache-dir", "fetch-cache-bytes"},
+	"server":        {"addr", "admin-addr", "grpc-addr", "cors-origins", "metrics-addr", "debug-addr", "tls-cert", "tls-key", "autocert-domains", "autocert-cache", "autocert-email", "http-redirect-addr"},
+	"auth":          {"tenant-policies", "debug-token-file", "webhook-secret-file", "jwks-url", "jwt-issuer", "jwt-audience", "jwt-tenant-claim", "jwt-user-claim"},
+	"observability": {"log-level", "log-format", "otlp-endpoint", "otlp-insecure"},
+	"notifications": {"allow-private-deliveries", "slack-webhook-url", "notify-email", "notify-on", "notify-page-threshold", "notify-error-threshold", "smtp-addr", "smtp-from", "smtp-username", "smtp-password-file"},
+}
+
+// applyConfigFile sets flags from a YAML or TOML file, picked by its extension.
+// Call it before applyEnvironment, the environment and the command line win
+func applyConfigFile(flags *flag.FlagSet, path string) error {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	sections := map[string]map[string]interface{}{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(raw, &sections)
+	case ".toml":
+		_, err = toml.Decode(string(raw), &sections)
+	default:
+		return fmt.Errorf("%s: unknown format, expected .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+
+	// Sorted so the same broken file always reports the same problem first
+	names := make([]string, 0, len(sections))
+	for name := range sections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		allowed, ok := configSections[name]
+		if !ok {
+			return fmt.Errorf("%s: unknown section %q", path, name)
+		}
+		keys := make([]string, 0, len(sections[name]))
+		for key := range sections[name] {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			known := false
+			for _, flagName := range allowed {
+				known = known || flagName == key
+			}
+			if !known {
+				return fmt.Errorf("%s: unknown setting %s.%s", path, name, key)
+			}
+			// Only some of the settings are read again on reload
+			if flags.Lookup(key) == nil {
+				continue
+			}
+			value, err := configValue(sections[name][key])
+			if err != nil {
+				return fmt.Errorf("%s: %s.%s: %v", path, name, key, err)
+			}
+			if err := flags.Set(key, value); err != nil {
+				return fmt.Errorf("%s: invalid value %q for %s.%s: %v", path, value, name, key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Helper function to turn a setting read from a file into what its flag would be given,
+// lists become comma separated
+func configValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			itemValue, err := configValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, itemValue)
+		}
+		return strings.Join(items, ","), nil
+	case string, bool, int, int64, float64:
+		return fmt.Sprint(v), nil
+	}
+	return "", fmt.Errorf("expected a string, number, boolean or list, got %T", value)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// Cookies a crawl may start with at most
+const maxCrawlCookies = 50
+
+// CrawlCookie is a cookie a crawl's jar starts with, as if the site had set it
+type CrawlCookie struct {
+	// A page of the site the cookie is for. It's sent to that host, on every path
+	URL   string `json:"url"`
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Helper function to check the cookies a crawl asks to start with
+func validateCrawlCookies(cookies []CrawlCookie) error {
+	if len(cookies) > maxCrawlCookies {
+		return fmt.Errorf("cookies may list at most %d cookies", maxCrawlCookies)
+	}
+	for _, cookie := range cookies {
+		parsed, err := url.Parse(cookie.URL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Hostname() == "" {
+			return errors.New("the url of every cookie must be an http or https url")
+		}
+		if err := (&http.Cookie{Name: cookie.Name, Value: cookie.Value}).Valid(); err != nil {
+			return fmt.Errorf("cookie %q: %v", cookie.Name, err)
+		}
+	}
+	return nil
+}
+
+// newCrawlCookieJar returns a jar holding the crawl's cookies. It keeps the
+// cookies sites set for the rest of the crawl, the way a browser would
+func newCrawlCookieJar(cookies []CrawlCookie) *cookiejar.Jar {
+	// Never fails, the error is only there for future options
+	jar, _ := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	for _, cookie := range cookies {
+		// Checked when the crawl was started
+		site, err := url.Parse(cookie.URL)
+		if err != nil {
+			continue
+		}
+		jar.SetCookies(site, []*http.Cookie{{Name: cookie.Name, Value: cookie.Value, Path: "/"}})
+	}
+	return jar
+}
+
+// crawlClient returns the client a crawl fetches with: the worker's own, or
+// for crawls with a cookie jar, a copy sharing its transport with the jar set
+func crawlClient(client *http.Client, cookieJar bool, cookies []CrawlCookie) *http.Client {
+	if !cookieJar {
+		return client
+	}
+	withJar := *client
+	withJar.Jar = newCrawlCookieJar(cookies)
+	return &withJar
+}
@@ -0,0 +1,1150 @@
+// gRPC API of Bishop's web crawler, served on -grpc-addr. Every call is
+// handled by the REST endpoint it mirrors, see the README's gRPC section.
+//
+// Regenerate crawler.pb.go and crawler_grpc.pb.go after changing this file with
+//   protoc --go_out=. --go_opt=paths=source_relative \
+//     --go-grpc_out=. --go-grpc_opt=paths=source_relative crawlerpb/crawler.proto
+// using protoc-gen-go v1.26.0 and protoc-gen-go-grpc v1.1.0, which match the
+// protobuf and grpc modules in go.mod.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.26.0
+// 	protoc        (unknown)
+// source: crawlerpb/crawler.proto
+
+package crawlerpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type StartCrawlRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Url string `protobuf:"bytes,1,opt,name=url,proto3" json:"url,omitempty"`
+	// Optional ID of an earlier crawl, pages it visited are skipped
+	ExcludeVisitedFrom string `protobuf:"bytes,2,opt,name=exclude_visited_from,json=excludeVisitedFrom,proto3" json:"exclude_visited_from,omitempty"`
+	// "full" (the default), "seo", a full crawl that also audits every page, or
+	// "status", which only checks the status of every url the crawl status_of found
+	Type     string `protobuf:"bytes,3,opt,name=type,proto3" json:"type,omitempty"`
+	StatusOf string `protobuf:"bytes,4,opt,name=status_of,json=statusOf,proto3" json:"status_of,omitempty"`
+	// Optional, "raw", "text" or "article" stores the body of every page fetched
+	StoreBodies string `protobuf:"bytes,5,opt,name=store_bodies,json=storeBodies,proto3" json:"store_bodies,omitempty"`
+	// Optional, how long the results are kept once the crawl is done
+	TtlSeconds int32 `protobuf:"varint,6,opt,name=ttl_seconds,json=ttlSeconds,proto3" json:"ttl_seconds,omitempty"`
+	// Optional, lower than the defaults or what the tenant's policy allows
+	Depth   int32   `protobuf:"varint,7,opt,name=depth,proto3" json:"depth,omitempty"`
+	MaxRate float64 `protobuf:"fixed64,8,opt,name=max_rate,json=maxRate,proto3" json:"max_rate,omitempty"`
+	// Optional, records the security headers of every page, see GET /v1/crawl/{id}/security-headers
+	SecurityHeaders bool `protobuf:"varint,9,opt,name=security_headers,json=securityHeaders,proto3" json:"security_headers,omitempty"`
+	// Optional, also crawls the pages the site's sitemap lists
+	Sitemap bool `protobuf:"varint,10,opt,name=sitemap,proto3" json:"sitemap,omitempty"`
+	// Optional, also crawls the entries of the RSS and Atom feeds pages link to
+	FollowFeeds bool `protobuf:"varint,11,opt,name=follow_feeds,json=followFeeds,proto3" json:"follow_feeds,omitempty"`
+	// Optional, a signed summary is POSTed here once the crawl finishes or fails
+	CallbackUrl string `protobuf:"bytes,12,opt,name=callback_url,json=callbackUrl,proto3" json:"callback_url,omitempty"`
+	// Optional, pages unchanged since an earlier crawl with http_cache aren't downloaded again
+	HttpCache bool `protobuf:"varint,13,opt,name=http_cache,json=httpCache,proto3" json:"http_cache,omitempty"`
+	// Optional, "flag" marks pages nearly the same as one the crawl already has, "prune" also doesn't crawl their links
+	NearDuplicates string `protobuf:"bytes,14,opt,name=near_duplicates,json=nearDuplicates,proto3" json:"near_duplicates,omitempty"`
+	// Optional, the User-Agent the crawl's requests are sent with, or several sent in turn
+	UserAgent  string   `protobuf:"bytes,15,opt,name=user_agent,json=userAgent,proto3" json:"user_agent,omitempty"`
+	UserAgents []string `protobuf:"bytes,16,rep,name=user_agents,json=userAgents,proto3" json:"user_agents,omitempty"`
+	// Optional, headers added to every request of the crawl, e.g. Accept-Language
+	Headers map[string]string `protobuf:"bytes,17,rep,name=headers,proto3" json:"headers,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	// Optional, keeps the cookies sites set for the rest of the crawl. Cookies
+	// also turns it on, and starts the jar with them
+	CookieJar bool           `protobuf:"varint,18,opt,name=cookie_jar,json=cookieJar,proto3" json:"cookie_jar,omitempty"`
+	Cookies   []*CrawlCookie `protobuf:"bytes,19,rep,name=cookies,proto3" json:"cookies,omitempty"`
+	// Optional, HTTP Basic or bearer credentials for the crawl's site, or the hosts they list
+	Credentials []*CrawlCredential `protobuf:"bytes,20,rep,name=credentials,proto3" json:"credentials,omitempty"`
+	// Optional, every request of the crawl goes through this proxy instead of the server's
+	Proxy string `protobuf:"bytes,21,opt,name=proxy,proto3" json:"proxy,omitempty"`
+	// Optional, round-robin or least-errors, spreads the requests of the crawl
+	// over the proxies of the server's -proxy-pool
+	ProxyPool string `protobuf:"bytes,22,opt,name=proxy_pool,json=proxyPool,proto3" json:"proxy_pool,omitempty"`
+	// Optional, also crawls the links scripts add to pages, loading every HTML
+	// page in the server's headless browser
+	Render bool `protobuf:"varint,23,opt,name=render,proto3" json:"render,omitempty"`
+	// Optional, with render keeps a screenshot of every page rendered
+	Screenshots bool `protobuf:"varint,24,opt,name=screenshots,proto3" json:"screenshots,omitempty"`
+	// Optional, reads the text, metadata and links of PDF and Word documents
+	ExtractDocuments bool `protobuf:"varint,25,opt,name=extract_documents,json=extractDocuments,proto3" json:"extract_documents,omitempty"`
+}
+
+func (x *StartCrawlRequest) Reset() {
+	*x = StartCrawlRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_crawlerpb_crawler_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *StartCrawlRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StartCrawlRequest) ProtoMessage() {}
+
+func (x *StartCrawlRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_crawlerpb_crawler_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StartCrawlRequest.ProtoReflect.Descriptor instead.
+func (*StartCrawlRequest) Descriptor() ([]byte, []int) {
+	return file_crawlerpb_crawler_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *StartCrawlRequest) GetUrl() string {
+	if x != nil {
+		return x.Url
+	}
+	return ""
+}
+
+func (x *StartCrawlRequest) GetExcludeVisitedFrom() string {
+	if x != nil {
+		return x.ExcludeVisitedFrom
+	}
+	return ""
+}
+
+func (x *StartCrawlRequest) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *StartCrawlRequest) GetStatusOf() string {
+	if x != nil {
+		return x.StatusOf
+	}
+	return ""
+}
+
+func (x *StartCrawlRequest) GetStoreBodies() string {
+	if x != nil {
+		return x.StoreBodies
+	}
+	return ""
+}
+
+func (x *StartCrawlRequest) GetTtlSeconds() int32 {
+	if x != nil {
+		return x.TtlSeconds
+	}
+	return 0
+}
+
+func (x *StartCrawlRequest) GetDepth() int32 {
+	if x != nil {
+		return x.Depth
+	}
+	return 0
+}
+
+func (x *StartCrawlRequest) GetMaxRate() float64 {
+	if x != nil {
+		return x.MaxRate
+	}
+	return 0
+}
+
+func (x *StartCrawlRequest) GetSecurityHeaders() bool {
+	if x != nil {
+		return x.SecurityHeaders
+	}
+	return false
+}
+
+func (x *StartCrawlRequest) GetSitemap() bool {
+	if x != nil {
+		return x.Sitemap
+	}
+	return false
+}
+
+func (x *StartCrawlRequest) GetFollowFeeds() bool {
+	if x != nil {
+		return x.FollowFeeds
+	}
+	return false
+}
+
+func (x *StartCrawlRequest) GetCallbackUrl() string {
+	if x != nil {
+		return x.CallbackUrl
+	}
+	return ""
+}
+
+func (x *StartCrawlRequest) GetHttpCache() bool {
+	if x != nil {
+		return x.HttpCache
+	}
+	return false
+}
+
+func (x *StartCrawlRequest) GetNearDuplicates() string {
+	if x != nil {
+		return x.NearDuplicates
+	}
+	return ""
+}
+
+func (x *StartCrawlRequest) GetUserAgent() string {
+	if x != nil {
+		return x.UserAgent
+	}
+	return ""
+}
+
+func (x *StartCrawlRequest) GetUserAgents() []string {
+	if x != nil {
+		return x.UserAgents
+	}
+	return nil
+}
+
+func (x *StartCrawlRequest) GetHeaders() map[string]string {
+	if x != nil {
+		return x.Headers
+	}
+	return nil
+}
+
+func (x *StartCrawlRequest) GetCookieJar() bool {
+	if x != nil {
+		return x.CookieJar
+	}
+	return false
+}
+
+func (x *StartCrawlRequest) GetCookies() []*CrawlCookie {
+	if x != nil {
+		return x.Cookies
+	}
+	return nil
+}
+
+func (x *StartCrawlRequest) GetCredentials() []*CrawlCredential {
+	if x != nil {
+		return x.Credentials
+	}
+	return nil
+}
+
+func (x *StartCrawlRequest) GetProxy() string {
+	if x != nil {
+		return x.Proxy
+	}
+	return ""
+}
+
+func (x *StartCrawlRequest) GetProxyPool() string {
+	if x != nil {
+		return x.ProxyPool
+	}
+	return ""
+}
+
+func (x *StartCrawlRequest) GetRender() bool {
+	if x != nil {
+		return x.Render
+	}
+	return false
+}
+
+func (x *StartCrawlRequest) GetScreenshots() bool {
+	if x != nil {
+		return x.Screenshots
+	}
+	return false
+}
+
+func (x *StartCrawlRequest) GetExtractDocuments() bool {
+	if x != nil {
+		return x.ExtractDocuments
+	}
+	return false
+}
+
+// A cookie a crawl's jar starts with, sent to the host of url on every path
+type CrawlCookie struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Url   string `protobuf:"bytes,1,opt,name=url,proto3" json:"url,omitempty"`
+	Name  string `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	Value string `protobuf:"bytes,3,opt,name=value,proto3" json:"value,omitempty"`
+}
+
+func (x *CrawlCookie) Reset() {
+	*x = CrawlCookie{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_crawlerpb_crawler_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *CrawlCookie) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CrawlCookie) ProtoMessage() {}
+
+func (x *CrawlCookie) ProtoReflect() protoreflect.Message {
+	mi := &file_crawlerpb_crawler_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CrawlCookie.ProtoReflect.Descriptor instead.
+func (*CrawlCookie) Descriptor() ([]byte, []int) {
+	return file_crawlerpb_crawler_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *CrawlCookie) GetUrl() string {
+	if x != nil {
+		return x.Url
+	}
+	return ""
+}
+
+func (x *CrawlCookie) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *CrawlCookie) GetValue() string {
+	if x != nil {
+		return x.Value
+	}
+	return ""
+}
+
+// Credentials sent to hosts, each also covering its subdomains, the crawl's
+// own host when empty. Either username and password, or bearer_token
+type CrawlCredential struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Hosts       []string `protobuf:"bytes,1,rep,name=hosts,proto3" json:"hosts,omitempty"`
+	Username    string   `protobuf:"bytes,2,opt,name=username,proto3" json:"username,omitempty"`
+	Password    string   `protobuf:"bytes,3,opt,name=password,proto3" json:"password,omitempty"`
+	BearerToken string   `protobuf:"bytes,4,opt,name=bearer_token,json=bearerToken,proto3" json:"bearer_token,omitempty"`
+}
+
+func (x *CrawlCredential) Reset() {
+	*x = CrawlCredential{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_crawlerpb_crawler_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *CrawlCredential) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CrawlCredential) ProtoMessage() {}
+
+func (x *CrawlCredential) ProtoReflect() protoreflect.Message {
+	mi := &file_crawlerpb_crawler_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CrawlCredential.ProtoReflect.Descriptor instead.
+func (*CrawlCredential) Descriptor() ([]byte, []int) {
+	return file_crawlerpb_crawler_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *CrawlCredential) GetHosts() []string {
+	if x != nil {
+		return x.Hosts
+	}
+	return nil
+}
+
+func (x *CrawlCredential) GetUsername() string {
+	if x != nil {
+		return x.Username
+	}
+	return ""
+}
+
+func (x *CrawlCredential) GetPassword() string {
+	if x != nil {
+		return x.Password
+	}
+	return ""
+}
+
+func (x *CrawlCredential) GetBearerToken() string {
+	if x != nil {
+		return x.BearerToken
+	}
+	return ""
+}
+
+type CrawlLimits struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Tenant      string   `protobuf:"bytes,1,opt,name=tenant,proto3" json:"tenant,omitempty"`
+	Depth       int32    `protobuf:"varint,2,opt,name=depth,proto3" json:"depth,omitempty"`
+	Rate        float64  `protobuf:"fixed64,3,opt,name=rate,proto3" json:"rate,omitempty"`
+	Scopes      []string `protobuf:"bytes,4,rep,name=scopes,proto3" json:"scopes,omitempty"`
+	PagesPerDay int32    `protobuf:"varint,5,opt,name=pages_per_day,json=pagesPerDay,proto3" json:"pages_per_day,omitempty"`
+}
+
+func (x *CrawlLimits) Reset() {
+	*x = CrawlLimits{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_crawlerpb_crawler_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *CrawlLimits) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CrawlLimits) ProtoMessage() {}
+
+func (x *CrawlLimits) ProtoReflect() protoreflect.Message {
+	mi := &file_crawlerpb_crawler_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CrawlLimits.ProtoReflect.Descriptor instead.
+func (*CrawlLimits) Descriptor() ([]byte, []int) {
+	return file_crawlerpb_crawler_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *CrawlLimits) GetTenant() string {
+	if x != nil {
+		return x.Tenant
+	}
+	return ""
+}
+
+func (x *CrawlLimits) GetDepth() int32 {
+	if x != nil {
+		return x.Depth
+	}
+	return 0
+}
+
+func (x *CrawlLimits) GetRate() float64 {
+	if x != nil {
+		return x.Rate
+	}
+	return 0
+}
+
+func (x *CrawlLimits) GetScopes() []string {
+	if x != nil {
+		return x.Scopes
+	}
+	return nil
+}
+
+func (x *CrawlLimits) GetPagesPerDay() int32 {
+	if x != nil {
+		return x.PagesPerDay
+	}
+	return 0
+}
+
+type StartCrawlResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	CrawlId string `protobuf:"bytes,1,opt,name=crawl_id,json=crawlId,proto3" json:"crawl_id,omitempty"`
+	// The same crawl over REST
+	ResultsUrl string       `protobuf:"bytes,2,opt,name=results_url,json=resultsUrl,proto3" json:"results_url,omitempty"`
+	Limits     *CrawlLimits `protobuf:"bytes,3,opt,name=limits,proto3" json:"limits,omitempty"`
+}
+
+func (x *StartCrawlResponse) Reset() {
+	*x = StartCrawlResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_crawlerpb_crawler_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *StartCrawlResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StartCrawlResponse) ProtoMessage() {}
+
+func (x *StartCrawlResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_crawlerpb_crawler_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StartCrawlResponse.ProtoReflect.Descriptor instead.
+func (*StartCrawlResponse) Descriptor() ([]byte, []int) {
+	return file_crawlerpb_crawler_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *StartCrawlResponse) GetCrawlId() string {
+	if x != nil {
+		return x.CrawlId
+	}
+	return ""
+}
+
+func (x *StartCrawlResponse) GetResultsUrl() string {
+	if x != nil {
+		return x.ResultsUrl
+	}
+	return ""
+}
+
+func (x *StartCrawlResponse) GetLimits() *CrawlLimits {
+	if x != nil {
+		return x.Limits
+	}
+	return nil
+}
+
+type GetResultsRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	CrawlId string `protobuf:"bytes,1,opt,name=crawl_id,json=crawlId,proto3" json:"crawl_id,omitempty"`
+	// Skips the results before this one, to pick up where a broken stream left off
+	StartIndex int64 `protobuf:"varint,2,opt,name=start_index,json=startIndex,proto3" json:"start_index,omitempty"`
+}
+
+func (x *GetResultsRequest) Reset() {
+	*x = GetResultsRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_crawlerpb_crawler_proto_msgTypes[5]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *GetResultsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetResultsRequest) ProtoMessage() {}
+
+func (x *GetResultsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_crawlerpb_crawler_proto_msgTypes[5]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetResultsRequest.ProtoReflect.Descriptor instead.
+func (*GetResultsRequest) Descriptor() ([]byte, []int) {
+	return file_crawlerpb_crawler_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *GetResultsRequest) GetCrawlId() string {
+	if x != nil {
+		return x.CrawlId
+	}
+	return ""
+}
+
+func (x *GetResultsRequest) GetStartIndex() int64 {
+	if x != nil {
+		return x.StartIndex
+	}
+	return 0
+}
+
+// One page of the crawl and the links found on it
+type GraphNode struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Position in the crawl's results, the start_index to resume after it is index + 1
+	Index    int64    `protobuf:"varint,1,opt,name=index,proto3" json:"index,omitempty"`
+	Parent   string   `protobuf:"bytes,2,opt,name=parent,proto3" json:"parent,omitempty"`
+	Children []string `protobuf:"bytes,3,rep,name=children,proto3" json:"children,omitempty"`
+	// Since the crawl started
+	TimeFoundNanos int64 `protobuf:"varint,4,opt,name=time_found_nanos,json=timeFoundNanos,proto3" json:"time_found_nanos,omitempty"`
+	Depth          int32 `protobuf:"varint,5,opt,name=depth,proto3" json:"depth,omitempty"`
+	// The page's HTTP status, when the fetcher knows it
+	Status int32 `protobuf:"varint,6,opt,name=status,proto3" json:"status,omitempty"`
+	// Only set by status crawls, on urls that couldn't be checked
+	Error string `protobuf:"bytes,7,opt,name=error,proto3" json:"error,omitempty"`
+}
+
+func (x *GraphNode) Reset() {
+	*x = GraphNode{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_crawlerpb_crawler_proto_msgTypes[6]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *GraphNode) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GraphNode) ProtoMessage() {}
+
+func (x *GraphNode) ProtoReflect() protoreflect.Message {
+	mi := &file_crawlerpb_crawler_proto_msgTypes[6]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GraphNode.ProtoReflect.Descriptor instead.
+func (*GraphNode) Descriptor() ([]byte, []int) {
+	return file_crawlerpb_crawler_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *GraphNode) GetIndex() int64 {
+	if x != nil {
+		return x.Index
+	}
+	return 0
+}
+
+func (x *GraphNode) GetParent() string {
+	if x != nil {
+		return x.Parent
+	}
+	return ""
+}
+
+func (x *GraphNode) GetChildren() []string {
+	if x != nil {
+		return x.Children
+	}
+	return nil
+}
+
+func (x *GraphNode) GetTimeFoundNanos() int64 {
+	if x != nil {
+		return x.TimeFoundNanos
+	}
+	return 0
+}
+
+func (x *GraphNode) GetDepth() int32 {
+	if x != nil {
+		return x.Depth
+	}
+	return 0
+}
+
+func (x *GraphNode) GetStatus() int32 {
+	if x != nil {
+		return x.Status
+	}
+	return 0
+}
+
+func (x *GraphNode) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+type CancelCrawlRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	CrawlId string `protobuf:"bytes,1,opt,name=crawl_id,json=crawlId,proto3" json:"crawl_id,omitempty"`
+}
+
+func (x *CancelCrawlRequest) Reset() {
+	*x = CancelCrawlRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_crawlerpb_crawler_proto_msgTypes[7]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *CancelCrawlRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CancelCrawlRequest) ProtoMessage() {}
+
+func (x *CancelCrawlRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_crawlerpb_crawler_proto_msgTypes[7]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CancelCrawlRequest.ProtoReflect.Descriptor instead.
+func (*CancelCrawlRequest) Descriptor() ([]byte, []int) {
+	return file_crawlerpb_crawler_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *CancelCrawlRequest) GetCrawlId() string {
+	if x != nil {
+		return x.CrawlId
+	}
+	return ""
+}
+
+type CancelCrawlResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	State        string `protobuf:"bytes,1,opt,name=state,proto3" json:"state,omitempty"`
+	PagesFetched int64  `protobuf:"varint,2,opt,name=pages_fetched,json=pagesFetched,proto3" json:"pages_fetched,omitempty"`
+}
+
+func (x *CancelCrawlResponse) Reset() {
+	*x = CancelCrawlResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_crawlerpb_crawler_proto_msgTypes[8]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *CancelCrawlResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CancelCrawlResponse) ProtoMessage() {}
+
+func (x *CancelCrawlResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_crawlerpb_crawler_proto_msgTypes[8]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CancelCrawlResponse.ProtoReflect.Descriptor instead.
+func (*CancelCrawlResponse) Descriptor() ([]byte, []int) {
+	return file_crawlerpb_crawler_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *CancelCrawlResponse) GetState() string {
+	if x != nil {
+		return x.State
+	}
+	return ""
+}
+
+func (x *CancelCrawlResponse) GetPagesFetched() int64 {
+	if x != nil {
+		return x.PagesFetched
+	}
+	return 0
+}
+
+var File_crawlerpb_crawler_proto protoreflect.FileDescriptor
+
+var file_crawlerpb_crawler_proto_rawDesc = []byte{
+	0x0a, 0x17, 0x63, 0x72, 0x61, 0x77, 0x6c, 0x65, 0x72, 0x70, 0x62, 0x2f, 0x63, 0x72, 0x61, 0x77,
+	0x6c, 0x65, 0x72, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x0a, 0x63, 0x72, 0x61, 0x77, 0x6c,
+	0x65, 0x72, 0x2e, 0x76, 0x31, 0x22, 0xbf, 0x07, 0x0a, 0x11, 0x53, 0x74, 0x61, 0x72, 0x74, 0x43,
+	0x72, 0x61, 0x77, 0x6c, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x10, 0x0a, 0x03, 0x75,
+	0x72, 0x6c, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x75, 0x72, 0x6c, 0x12, 0x30, 0x0a,
+	0x14, 0x65, 0x78, 0x63, 0x6c, 0x75, 0x64, 0x65, 0x5f, 0x76, 0x69, 0x73, 0x69, 0x74, 0x65, 0x64,
+	0x5f, 0x66, 0x72, 0x6f, 0x6d, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x12, 0x65, 0x78, 0x63,
+	0x6c, 0x75, 0x64, 0x65, 0x56, 0x69, 0x73, 0x69, 0x74, 0x65, 0x64, 0x46, 0x72, 0x6f, 0x6d, 0x12,
+	0x12, 0x0a, 0x04, 0x74, 0x79, 0x70, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x74,
+	0x79, 0x70, 0x65, 0x12, 0x1b, 0x0a, 0x09, 0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x5f, 0x6f, 0x66,
+	0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x4f, 0x66,
+	0x12, 0x21, 0x0a, 0x0c, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x5f, 0x62, 0x6f, 0x64, 0x69, 0x65, 0x73,
+	0x18, 0x05, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x42, 0x6f, 0x64,
+	0x69, 0x65, 0x73, 0x12, 0x1f, 0x0a, 0x0b, 0x74, 0x74, 0x6c, 0x5f, 0x73, 0x65, 0x63, 0x6f, 0x6e,
+	0x64, 0x73, 0x18, 0x06, 0x20, 0x01, 0x28, 0x05, 0x52, 0x0a, 0x74, 0x74, 0x6c, 0x53, 0x65, 0x63,
+	0x6f, 0x6e, 0x64, 0x73, 0x12, 0x14, 0x0a, 0x05, 0x64, 0x65, 0x70, 0x74, 0x68, 0x18, 0x07, 0x20,
+	0x01, 0x28, 0x05, 0x52, 0x05, 0x64, 0x65, 0x70, 0x74, 0x68, 0x12, 0x19, 0x0a, 0x08, 0x6d, 0x61,
+	0x78, 0x5f, 0x72, 0x61, 0x74, 0x65, 0x18, 0x08, 0x20, 0x01, 0x28, 0x01, 0x52, 0x07, 0x6d, 0x61,
+	0x78, 0x52, 0x61, 0x74, 0x65, 0x12, 0x29, 0x0a, 0x10, 0x73, 0x65, 0x63, 0x75, 0x72, 0x69, 0x74,
+	0x79, 0x5f, 0x68, 0x65, 0x61, 0x64, 0x65, 0x72, 0x73, 0x18, 0x09, 0x20, 0x01, 0x28, 0x08, 0x52,
+	0x0f, 0x73, 0x65, 0x63, 0x75, 0x72, 0x69, 0x74, 0x79, 0x48, 0x65, 0x61, 0x64, 0x65, 0x72, 0x73,
+	0x12, 0x18, 0x0a, 0x07, 0x73, 0x69, 0x74, 0x65, 0x6d, 0x61, 0x70, 0x18, 0x0a, 0x20, 0x01, 0x28,
+	0x08, 0x52, 0x07, 0x73, 0x69, 0x74, 0x65, 0x6d, 0x61, 0x70, 0x12, 0x21, 0x0a, 0x0c, 0x66, 0x6f,
+	0x6c, 0x6c, 0x6f, 0x77, 0x5f, 0x66, 0x65, 0x65, 0x64, 0x73, 0x18, 0x0b, 0x20, 0x01, 0x28, 0x08,
+	0x52, 0x0b, 0x66, 0x6f, 0x6c, 0x6c, 0x6f, 0x77, 0x46, 0x65, 0x65, 0x64, 0x73, 0x12, 0x21, 0x0a,
+	0x0c, 0x63, 0x61, 0x6c, 0x6c, 0x62, 0x61, 0x63, 0x6b, 0x5f, 0x75, 0x72, 0x6c, 0x18, 0x0c, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x0b, 0x63, 0x61, 0x6c, 0x6c, 0x62, 0x61, 0x63, 0x6b, 0x55, 0x72, 0x6c,
+	0x12, 0x1d, 0x0a, 0x0a, 0x68, 0x74, 0x74, 0x70, 0x5f, 0x63, 0x61, 0x63, 0x68, 0x65, 0x18, 0x0d,
+	0x20, 0x01, 0x28, 0x08, 0x52, 0x09, 0x68, 0x74, 0x74, 0x70, 0x43, 0x61, 0x63, 0x68, 0x65, 0x12,
+	0x27, 0x0a, 0x0f, 0x6e, 0x65, 0x61, 0x72, 0x5f, 0x64, 0x75, 0x70, 0x6c, 0x69, 0x63, 0x61, 0x74,
+	0x65, 0x73, 0x18, 0x0e, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0e, 0x6e, 0x65, 0x61, 0x72, 0x44, 0x75,
+	0x70, 0x6c, 0x69, 0x63, 0x61, 0x74, 0x65, 0x73, 0x12, 0x1d, 0x0a, 0x0a, 0x75, 0x73, 0x65, 0x72,
+	0x5f, 0x61, 0x67, 0x65, 0x6e, 0x74, 0x18, 0x0f, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x75, 0x73,
+	0x65, 0x72, 0x41, 0x67, 0x65, 0x6e, 0x74, 0x12, 0x1f, 0x0a, 0x0b, 0x75, 0x73, 0x65, 0x72, 0x5f,
+	0x61, 0x67, 0x65, 0x6e, 0x74, 0x73, 0x18, 0x10, 0x20, 0x03, 0x28, 0x09, 0x52, 0x0a, 0x75, 0x73,
+	0x65, 0x72, 0x41, 0x67, 0x65, 0x6e, 0x74, 0x73, 0x12, 0x44, 0x0a, 0x07, 0x68, 0x65, 0x61, 0x64,
+	0x65, 0x72, 0x73, 0x18, 0x11, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x2a, 0x2e, 0x63, 0x72, 0x61, 0x77,
+	0x6c, 0x65, 0x72, 0x2e, 0x76, 0x31, 0x2e, 0x53, 0x74, 0x61, 0x72, 0x74, 0x43, 0x72, 0x61, 0x77,
+	0x6c, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x2e, 0x48, 0x65, 0x61, 0x64, 0x65, 0x72, 0x73,
+	0x45, 0x6e, 0x74, 0x72, 0x79, 0x52, 0x07, 0x68, 0x65, 0x61, 0x64, 0x65, 0x72, 0x73, 0x12, 0x1d,
+	0x0a, 0x0a, 0x63, 0x6f, 0x6f, 0x6b, 0x69, 0x65, 0x5f, 0x6a, 0x61, 0x72, 0x18, 0x12, 0x20, 0x01,
+	0x28, 0x08, 0x52, 0x09, 0x63, 0x6f, 0x6f, 0x6b, 0x69, 0x65, 0x4a, 0x61, 0x72, 0x12, 0x31, 0x0a,
+	0x07, 0x63, 0x6f, 0x6f, 0x6b, 0x69, 0x65, 0x73, 0x18, 0x13, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x17,
+	0x2e, 0x63, 0x72, 0x61, 0x77, 0x6c, 0x65, 0x72, 0x2e, 0x76, 0x31, 0x2e, 0x43, 0x72, 0x61, 0x77,
+	0x6c, 0x43, 0x6f, 0x6f, 0x6b, 0x69, 0x65, 0x52, 0x07, 0x63, 0x6f, 0x6f, 0x6b, 0x69, 0x65, 0x73,
+	0x12, 0x3d, 0x0a, 0x0b, 0x63, 0x72, 0x65, 0x64, 0x65, 0x6e, 0x74, 0x69, 0x61, 0x6c, 0x73, 0x18,
+	0x14, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x1b, 0x2e, 0x63, 0x72, 0x61, 0x77, 0x6c, 0x65, 0x72, 0x2e,
+	0x76, 0x31, 0x2e, 0x43, 0x72, 0x61, 0x77, 0x6c, 0x43, 0x72, 0x65, 0x64, 0x65, 0x6e, 0x74, 0x69,
+	0x61, 0x6c, 0x52, 0x0b, 0x63, 0x72, 0x65, 0x64, 0x65, 0x6e, 0x74, 0x69, 0x61, 0x6c, 0x73, 0x12,
+	0x14, 0x0a, 0x05, 0x70, 0x72, 0x6f, 0x78, 0x79, 0x18, 0x15, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05,
+	0x70, 0x72, 0x6f, 0x78, 0x79, 0x12, 0x1d, 0x0a, 0x0a, 0x70, 0x72, 0x6f, 0x78, 0x79, 0x5f, 0x70,
+	0x6f, 0x6f, 0x6c, 0x18, 0x16, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x70, 0x72, 0x6f, 0x78, 0x79,
+	0x50, 0x6f, 0x6f, 0x6c, 0x12, 0x16, 0x0a, 0x06, 0x72, 0x65, 0x6e, 0x64, 0x65, 0x72, 0x18, 0x17,
+	0x20, 0x01, 0x28, 0x08, 0x52, 0x06, 0x72, 0x65, 0x6e, 0x64, 0x65, 0x72, 0x12, 0x20, 0x0a, 0x0b,
+	0x73, 0x63, 0x72, 0x65, 0x65, 0x6e, 0x73, 0x68, 0x6f, 0x74, 0x73, 0x18, 0x18, 0x20, 0x01, 0x28,
+	0x08, 0x52, 0x0b, 0x73, 0x63, 0x72, 0x65, 0x65, 0x6e, 0x73, 0x68, 0x6f, 0x74, 0x73, 0x12, 0x2b,
+	0x0a, 0x11, 0x65, 0x78, 0x74, 0x72, 0x61, 0x63, 0x74, 0x5f, 0x64, 0x6f, 0x63, 0x75, 0x6d, 0x65,
+	0x6e, 0x74, 0x73, 0x18, 0x19, 0x20, 0x01, 0x28, 0x08, 0x52, 0x10, 0x65, 0x78, 0x74, 0x72, 0x61,
+	0x63, 0x74, 0x44, 0x6f, 0x63, 0x75, 0x6d, 0x65, 0x6e, 0x74, 0x73, 0x1a, 0x3a, 0x0a, 0x0c, 0x48,
+	0x65, 0x61, 0x64, 0x65, 0x72, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x12, 0x10, 0x0a, 0x03, 0x6b,
+	0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12, 0x14, 0x0a,
+	0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x76, 0x61,
+	0x6c, 0x75, 0x65, 0x3a, 0x02, 0x38, 0x01, 0x22, 0x49, 0x0a, 0x0b, 0x43, 0x72, 0x61, 0x77, 0x6c,
+	0x43, 0x6f, 0x6f, 0x6b, 0x69, 0x65, 0x12, 0x10, 0x0a, 0x03, 0x75, 0x72, 0x6c, 0x18, 0x01, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x03, 0x75, 0x72, 0x6c, 0x12, 0x12, 0x0a, 0x04, 0x6e, 0x61, 0x6d, 0x65,
+	0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x12, 0x14, 0x0a, 0x05,
+	0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x76, 0x61, 0x6c,
+	0x75, 0x65, 0x22, 0x82, 0x01, 0x0a, 0x0f, 0x43, 0x72, 0x61, 0x77, 0x6c, 0x43, 0x72, 0x65, 0x64,
+	0x65, 0x6e, 0x74, 0x69, 0x61, 0x6c, 0x12, 0x14, 0x0a, 0x05, 0x68, 0x6f, 0x73, 0x74, 0x73, 0x18,
+	0x01, 0x20, 0x03, 0x28, 0x09, 0x52, 0x05, 0x68, 0x6f, 0x73, 0x74, 0x73, 0x12, 0x1a, 0x0a, 0x08,
+	0x75, 0x73, 0x65, 0x72, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08,
+	0x75, 0x73, 0x65, 0x72, 0x6e, 0x61, 0x6d, 0x65, 0x12, 0x1a, 0x0a, 0x08, 0x70, 0x61, 0x73, 0x73,
+	0x77, 0x6f, 0x72, 0x64, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x70, 0x61, 0x73, 0x73,
+	0x77, 0x6f, 0x72, 0x64, 0x12, 0x21, 0x0a, 0x0c, 0x62, 0x65, 0x61, 0x72, 0x65, 0x72, 0x5f, 0x74,
+	0x6f, 0x6b, 0x65, 0x6e, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x62, 0x65, 0x61, 0x72,
+	0x65, 0x72, 0x54, 0x6f, 0x6b, 0x65, 0x6e, 0x22, 0x8b, 0x01, 0x0a, 0x0b, 0x43, 0x72, 0x61, 0x77,
+	0x6c, 0x4c, 0x69, 0x6d, 0x69, 0x74, 0x73, 0x12, 0x16, 0x0a, 0x06, 0x74, 0x65, 0x6e, 0x61, 0x6e,
+	0x74, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x74, 0x65, 0x6e, 0x61, 0x6e, 0x74, 0x12,
+	0x14, 0x0a, 0x05, 0x64, 0x65, 0x70, 0x74, 0x68, 0x18, 0x02, 0x20, 0x01, 0x28, 0x05, 0x52, 0x05,
+	0x64, 0x65, 0x70, 0x74, 0x68, 0x12, 0x12, 0x0a, 0x04, 0x72, 0x61, 0x74, 0x65, 0x18, 0x03, 0x20,
+	0x01, 0x28, 0x01, 0x52, 0x04, 0x72, 0x61, 0x74, 0x65, 0x12, 0x16, 0x0a, 0x06, 0x73, 0x63, 0x6f,
+	0x70, 0x65, 0x73, 0x18, 0x04, 0x20, 0x03, 0x28, 0x09, 0x52, 0x06, 0x73, 0x63, 0x6f, 0x70, 0x65,
+	0x73, 0x12, 0x22, 0x0a, 0x0d, 0x70, 0x61, 0x67, 0x65, 0x73, 0x5f, 0x70, 0x65, 0x72, 0x5f, 0x64,
+	0x61, 0x79, 0x18, 0x05, 0x20, 0x01, 0x28, 0x05, 0x52, 0x0b, 0x70, 0x61, 0x67, 0x65, 0x73, 0x50,
+	0x65, 0x72, 0x44, 0x61, 0x79, 0x22, 0x81, 0x01, 0x0a, 0x12, 0x53, 0x74, 0x61, 0x72, 0x74, 0x43,
+	0x72, 0x61, 0x77, 0x6c, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x19, 0x0a, 0x08,
+	0x63, 0x72, 0x61, 0x77, 0x6c, 0x5f, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07,
+	0x63, 0x72, 0x61, 0x77, 0x6c, 0x49, 0x64, 0x12, 0x1f, 0x0a, 0x0b, 0x72, 0x65, 0x73, 0x75, 0x6c,
+	0x74, 0x73, 0x5f, 0x75, 0x72, 0x6c, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0a, 0x72, 0x65,
+	0x73, 0x75, 0x6c, 0x74, 0x73, 0x55, 0x72, 0x6c, 0x12, 0x2f, 0x0a, 0x06, 0x6c, 0x69, 0x6d, 0x69,
+	0x74, 0x73, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x17, 0x2e, 0x63, 0x72, 0x61, 0x77, 0x6c,
+	0x65, 0x72, 0x2e, 0x76, 0x31, 0x2e, 0x43, 0x72, 0x61, 0x77, 0x6c, 0x4c, 0x69, 0x6d, 0x69, 0x74,
+	0x73, 0x52, 0x06, 0x6c, 0x69, 0x6d, 0x69, 0x74, 0x73, 0x22, 0x4f, 0x0a, 0x11, 0x47, 0x65, 0x74,
+	0x52, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x19,
+	0x0a, 0x08, 0x63, 0x72, 0x61, 0x77, 0x6c, 0x5f, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x07, 0x63, 0x72, 0x61, 0x77, 0x6c, 0x49, 0x64, 0x12, 0x1f, 0x0a, 0x0b, 0x73, 0x74, 0x61,
+	0x72, 0x74, 0x5f, 0x69, 0x6e, 0x64, 0x65, 0x78, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0a,
+	0x73, 0x74, 0x61, 0x72, 0x74, 0x49, 0x6e, 0x64, 0x65, 0x78, 0x22, 0xc3, 0x01, 0x0a, 0x09, 0x47,
+	0x72, 0x61, 0x70, 0x68, 0x4e, 0x6f, 0x64, 0x65, 0x12, 0x14, 0x0a, 0x05, 0x69, 0x6e, 0x64, 0x65,
+	0x78, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x05, 0x69, 0x6e, 0x64, 0x65, 0x78, 0x12, 0x16,
+	0x0a, 0x06, 0x70, 0x61, 0x72, 0x65, 0x6e, 0x74, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06,
+	0x70, 0x61, 0x72, 0x65, 0x6e, 0x74, 0x12, 0x1a, 0x0a, 0x08, 0x63, 0x68, 0x69, 0x6c, 0x64, 0x72,
+	0x65, 0x6e, 0x18, 0x03, 0x20, 0x03, 0x28, 0x09, 0x52, 0x08, 0x63, 0x68, 0x69, 0x6c, 0x64, 0x72,
+	0x65, 0x6e, 0x12, 0x28, 0x0a, 0x10, 0x74, 0x69, 0x6d, 0x65, 0x5f, 0x66, 0x6f, 0x75, 0x6e, 0x64,
+	0x5f, 0x6e, 0x61, 0x6e, 0x6f, 0x73, 0x18, 0x04, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0e, 0x74, 0x69,
+	0x6d, 0x65, 0x46, 0x6f, 0x75, 0x6e, 0x64, 0x4e, 0x61, 0x6e, 0x6f, 0x73, 0x12, 0x14, 0x0a, 0x05,
+	0x64, 0x65, 0x70, 0x74, 0x68, 0x18, 0x05, 0x20, 0x01, 0x28, 0x05, 0x52, 0x05, 0x64, 0x65, 0x70,
+	0x74, 0x68, 0x12, 0x16, 0x0a, 0x06, 0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x18, 0x06, 0x20, 0x01,
+	0x28, 0x05, 0x52, 0x06, 0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x12, 0x14, 0x0a, 0x05, 0x65, 0x72,
+	0x72, 0x6f, 0x72, 0x18, 0x07, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x65, 0x72, 0x72, 0x6f, 0x72,
+	0x22, 0x2f, 0x0a, 0x12, 0x43, 0x61, 0x6e, 0x63, 0x65, 0x6c, 0x43, 0x72, 0x61, 0x77, 0x6c, 0x52,
+	0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x19, 0x0a, 0x08, 0x63, 0x72, 0x61, 0x77, 0x6c, 0x5f,
+	0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x63, 0x72, 0x61, 0x77, 0x6c, 0x49,
+	0x64, 0x22, 0x50, 0x0a, 0x13, 0x43, 0x61, 0x6e, 0x63, 0x65, 0x6c, 0x43, 0x72, 0x61, 0x77, 0x6c,
+	0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x14, 0x0a, 0x05, 0x73, 0x74, 0x61, 0x74,
+	0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x73, 0x74, 0x61, 0x74, 0x65, 0x12, 0x23,
+	0x0a, 0x0d, 0x70, 0x61, 0x67, 0x65, 0x73, 0x5f, 0x66, 0x65, 0x74, 0x63, 0x68, 0x65, 0x64, 0x18,
+	0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0c, 0x70, 0x61, 0x67, 0x65, 0x73, 0x46, 0x65, 0x74, 0x63,
+	0x68, 0x65, 0x64, 0x32, 0xec, 0x01, 0x0a, 0x07, 0x43, 0x72, 0x61, 0x77, 0x6c, 0x65, 0x72, 0x12,
+	0x4b, 0x0a, 0x0a, 0x53, 0x74, 0x61, 0x72, 0x74, 0x43, 0x72, 0x61, 0x77, 0x6c, 0x12, 0x1d, 0x2e,
+	0x63, 0x72, 0x61, 0x77, 0x6c, 0x65, 0x72, 0x2e, 0x76, 0x31, 0x2e, 0x53, 0x74, 0x61, 0x72, 0x74,
+	0x43, 0x72, 0x61, 0x77, 0x6c, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x1e, 0x2e, 0x63,
+	0x72, 0x61, 0x77, 0x6c, 0x65, 0x72, 0x2e, 0x76, 0x31, 0x2e, 0x53, 0x74, 0x61, 0x72, 0x74, 0x43,
+	0x72, 0x61, 0x77, 0x6c, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x44, 0x0a, 0x0a,
+	0x47, 0x65, 0x74, 0x52, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x73, 0x12, 0x1d, 0x2e, 0x63, 0x72, 0x61,
+	0x77, 0x6c, 0x65, 0x72, 0x2e, 0x76, 0x31, 0x2e, 0x47, 0x65, 0x74, 0x52, 0x65, 0x73, 0x75, 0x6c,
+	0x74, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x15, 0x2e, 0x63, 0x72, 0x61, 0x77,
+	0x6c, 0x65, 0x72, 0x2e, 0x76, 0x31, 0x2e, 0x47, 0x72, 0x61, 0x70, 0x68, 0x4e, 0x6f, 0x64, 0x65,
+	0x30, 0x01, 0x12, 0x4e, 0x0a, 0x0b, 0x43, 0x61, 0x6e, 0x63, 0x65, 0x6c, 0x43, 0x72, 0x61, 0x77,
+	0x6c, 0x12, 0x1e, 0x2e, 0x63, 0x72, 0x61, 0x77, 0x6c, 0x65, 0x72, 0x2e, 0x76, 0x31, 0x2e, 0x43,
+	0x61, 0x6e, 0x63, 0x65, 0x6c, 0x43, 0x72, 0x61, 0x77, 0x6c, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73,
+	0x74, 0x1a, 0x1f, 0x2e, 0x63, 0x72, 0x61, 0x77, 0x6c, 0x65, 0x72, 0x2e, 0x76, 0x31, 0x2e, 0x43,
+	0x61, 0x6e, 0x63, 0x65, 0x6c, 0x43, 0x72, 0x61, 0x77, 0x6c, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e,
+	0x73, 0x65, 0x42, 0x1f, 0x5a, 0x1d, 0x62, 0x69, 0x73, 0x68, 0x6f, 0x70, 0x73, 0x2d, 0x77, 0x65,
+	0x62, 0x2d, 0x63, 0x72, 0x61, 0x77, 0x6c, 0x65, 0x72, 0x2f, 0x63, 0x72, 0x61, 0x77, 0x6c, 0x65,
+	0x72, 0x70, 0x62, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_crawlerpb_crawler_proto_rawDescOnce sync.Once
+	file_crawlerpb_crawler_proto_rawDescData = file_crawlerpb_crawler_proto_rawDesc
+)
+
+func file_crawlerpb_crawler_proto_rawDescGZIP() []byte {
+	file_crawlerpb_crawler_proto_rawDescOnce.Do(func() {
+		file_crawlerpb_crawler_proto_rawDescData = protoimpl.X.CompressGZIP(file_crawlerpb_crawler_proto_rawDescData)
+	})
+	return file_crawlerpb_crawler_proto_rawDescData
+}
+
+var file_crawlerpb_crawler_proto_msgTypes = make([]protoimpl.MessageInfo, 10)
+var file_crawlerpb_crawler_proto_goTypes = []interface{}{
+	(*StartCrawlRequest)(nil),   // 0: crawler.v1.StartCrawlRequest
+	(*CrawlCookie)(nil),         // 1: crawler.v1.CrawlCookie
+	(*CrawlCredential)(nil),     // 2: crawler.v1.CrawlCredential
+	(*CrawlLimits)(nil),         // 3: crawler.v1.CrawlLimits
+	(*StartCrawlResponse)(nil),  // 4: crawler.v1.StartCrawlResponse
+	(*GetResultsRequest)(nil),   // 5: crawler.v1.GetResultsRequest
+	(*GraphNode)(nil),           // 6: crawler.v1.GraphNode
+	(*CancelCrawlRequest)(nil),  // 7: crawler.v1.CancelCrawlRequest
+	(*CancelCrawlResponse)(nil), // 8: crawler.v1.CancelCrawlResponse
+	nil,                         // 9: crawler.v1.StartCrawlRequest.HeadersEntry
+}
+var file_crawlerpb_crawler_proto_depIdxs = []int32{
+	9, // 0: crawler.v1.StartCrawlRequest.headers:type_name -> crawler.v1.StartCrawlRequest.HeadersEntry
+	1, // 1: crawler.v1.StartCrawlRequest.cookies:type_name -> crawler.v1.CrawlCookie
+	2, // 2: crawler.v1.StartCrawlRequest.credentials:type_name -> crawler.v1.CrawlCredential
+	3, // 3: crawler.v1.StartCrawlResponse.limits:type_name -> crawler.v1.CrawlLimits
+	0, // 4: crawler.v1.Crawler.StartCrawl:input_type -> crawler.v1.StartCrawlRequest
+	5, // 5: crawler.v1.Crawler.GetResults:input_type -> crawler.v1.GetResultsRequest
+	7, // 6: crawler.v1.Crawler.CancelCrawl:input_type -> crawler.v1.CancelCrawlRequest
+	4, // 7: crawler.v1.Crawler.StartCrawl:output_type -> crawler.v1.StartCrawlResponse
+	6, // 8: crawler.v1.Crawler.GetResults:output_type -> crawler.v1.GraphNode
+	8, // 9: crawler.v1.Crawler.CancelCrawl:output_type -> crawler.v1.CancelCrawlResponse
+	7, // [7:10] is the sub-list for method output_type
+	4, // [4:7] is the sub-list for method input_type
+	4, // [4:4] is the sub-list for extension type_name
+	4, // [4:4] is the sub-list for extension extendee
+	0, // [0:4] is the sub-list for field type_name
+}
+
+func init() { file_crawlerpb_crawler_proto_init() }
+func file_crawlerpb_crawler_proto_init() {
+	if File_crawlerpb_crawler_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_crawlerpb_crawler_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*StartCrawlRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_crawlerpb_crawler_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*CrawlCookie); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_crawlerpb_crawler_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*CrawlCredential); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_crawlerpb_crawler_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*CrawlLimits); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_crawlerpb_crawler_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*StartCrawlResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_crawlerpb_crawler_proto_msgTypes[5].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*GetResultsRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_crawlerpb_crawler_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*GraphNode); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_crawlerpb_crawler_proto_msgTypes[7].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*CancelCrawlRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_crawlerpb_crawler_proto_msgTypes[8].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*CancelCrawlResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_crawlerpb_crawler_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   10,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_crawlerpb_crawler_proto_goTypes,
+		DependencyIndexes: file_crawlerpb_crawler_proto_depIdxs,
+		MessageInfos:      file_crawlerpb_crawler_proto_msgTypes,
+	}.Build()
+	File_crawlerpb_crawler_proto = out.File
+	file_crawlerpb_crawler_proto_rawDesc = nil
+	file_crawlerpb_crawler_proto_goTypes = nil
+	file_crawlerpb_crawler_proto_depIdxs = nil
+}
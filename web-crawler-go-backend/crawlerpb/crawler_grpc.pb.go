@@ -0,0 +1,209 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package crawlerpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// CrawlerClient is the client API for Crawler service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type CrawlerClient interface {
+	// Queues a crawl, like POST /v1/crawl
+	StartCrawl(ctx context.Context, in *StartCrawlRequest, opts ...grpc.CallOption) (*StartCrawlResponse, error)
+	// Streams a crawl's results as they're found, from start_index on, and ends
+	// once the crawl is done. Like following GET /v1/crawl/{id} until it has no next link
+	GetResults(ctx context.Context, in *GetResultsRequest, opts ...grpc.CallOption) (Crawler_GetResultsClient, error)
+	// Stops a crawl, like POST /v1/crawl/{id}/cancel
+	CancelCrawl(ctx context.Context, in *CancelCrawlRequest, opts ...grpc.CallOption) (*CancelCrawlResponse, error)
+}
+
+type crawlerClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewCrawlerClient(cc grpc.ClientConnInterface) CrawlerClient {
+	return &crawlerClient{cc}
+}
+
+func (c *crawlerClient) StartCrawl(ctx context.Context, in *StartCrawlRequest, opts ...grpc.CallOption) (*StartCrawlResponse, error) {
+	out := new(StartCrawlResponse)
+	err := c.cc.Invoke(ctx, "/crawler.v1.Crawler/StartCrawl", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *crawlerClient) GetResults(ctx context.Context, in *GetResultsRequest, opts ...grpc.CallOption) (Crawler_GetResultsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Crawler_ServiceDesc.Streams[0], "/crawler.v1.Crawler/GetResults", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &crawlerGetResultsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Crawler_GetResultsClient interface {
+	Recv() (*GraphNode, error)
+	grpc.ClientStream
+}
+
+type crawlerGetResultsClient struct {
+	grpc.ClientStream
+}
+
+func (x *crawlerGetResultsClient) Recv() (*GraphNode, error) {
+	m := new(GraphNode)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *crawlerClient) CancelCrawl(ctx context.Context, in *CancelCrawlRequest, opts ...grpc.CallOption) (*CancelCrawlResponse, error) {
+	out := new(CancelCrawlResponse)
+	err := c.cc.Invoke(ctx, "/crawler.v1.Crawler/CancelCrawl", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// CrawlerServer is the server API for Crawler service.
+// All implementations must embed UnimplementedCrawlerServer
+// for forward compatibility
+type CrawlerServer interface {
+	// Queues a crawl, like POST /v1/crawl
+	StartCrawl(context.Context, *StartCrawlRequest) (*StartCrawlResponse, error)
+	// Streams a crawl's results as they're found, from start_index on, and ends
+	// once the crawl is done. Like following GET /v1/crawl/{id} until it has no next link
+	GetResults(*GetResultsRequest, Crawler_GetResultsServer) error
+	// Stops a crawl, like POST /v1/crawl/{id}/cancel
+	CancelCrawl(context.Context, *CancelCrawlRequest) (*CancelCrawlResponse, error)
+	mustEmbedUnimplementedCrawlerServer()
+}
+
+// UnimplementedCrawlerServer must be embedded to have forward compatible implementations.
+type UnimplementedCrawlerServer struct {
+}
+
+func (UnimplementedCrawlerServer) StartCrawl(context.Context, *StartCrawlRequest) (*StartCrawlResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StartCrawl not implemented")
+}
+func (UnimplementedCrawlerServer) GetResults(*GetResultsRequest, Crawler_GetResultsServer) error {
+	return status.Errorf(codes.Unimplemented, "method GetResults not implemented")
+}
+func (UnimplementedCrawlerServer) CancelCrawl(context.Context, *CancelCrawlRequest) (*CancelCrawlResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CancelCrawl not implemented")
+}
+func (UnimplementedCrawlerServer) mustEmbedUnimplementedCrawlerServer() {}
+
+// UnsafeCrawlerServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to CrawlerServer will
+// result in compilation errors.
+type UnsafeCrawlerServer interface {
+	mustEmbedUnimplementedCrawlerServer()
+}
+
+func RegisterCrawlerServer(s grpc.ServiceRegistrar, srv CrawlerServer) {
+	s.RegisterService(&Crawler_ServiceDesc, srv)
+}
+
+func _Crawler_StartCrawl_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StartCrawlRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CrawlerServer).StartCrawl(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/crawler.v1.Crawler/StartCrawl",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CrawlerServer).StartCrawl(ctx, req.(*StartCrawlRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Crawler_GetResults_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(GetResultsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(CrawlerServer).GetResults(m, &crawlerGetResultsServer{stream})
+}
+
+type Crawler_GetResultsServer interface {
+	Send(*GraphNode) error
+	grpc.ServerStream
+}
+
+type crawlerGetResultsServer struct {
+	grpc.ServerStream
+}
+
+func (x *crawlerGetResultsServer) Send(m *GraphNode) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Crawler_CancelCrawl_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CancelCrawlRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CrawlerServer).CancelCrawl(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/crawler.v1.Crawler/CancelCrawl",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CrawlerServer).CancelCrawl(ctx, req.(*CancelCrawlRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Crawler_ServiceDesc is the grpc.ServiceDesc for Crawler service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Crawler_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "crawler.v1.Crawler",
+	HandlerType: (*CrawlerServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "StartCrawl",
+			Handler:    _Crawler_StartCrawl_Handler,
+		},
+		{
+			MethodName: "CancelCrawl",
+			Handler:    _Crawler_CancelCrawl_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "GetResults",
+			Handler:       _Crawler_GetResults_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "crawlerpb/crawler.proto",
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// Credentials a crawl may have at most
+const maxCrawlCredentials = 10
+
+type (
+	// CrawlCredential authenticates a crawl's requests to some hosts, with
+	// HTTP Basic auth or a bearer token
+	CrawlCredential struct {
+		// Domains the credential is sent to, each also covering its
+		// subdomains. The host of the crawl's url when left out
+		Hosts       []string `json:"hosts,omitempty"`
+		Username    string   `json:"username,omitempty"`
+		Password    string   `json:"password,omitempty"`
+		BearerToken string   `json:"bearerToken,omitempty"`
+	}
+	credentialsKey struct{}
+)
+
+// validateCrawlCredentials checks the credentials a crawl asks for, and
+// returns them with the hosts of those that leave them out set to the seed's
+func validateCrawlCredentials(seedURL string, credentials []CrawlCredential) ([]CrawlCredential, error) {
+	if len(credentials) > maxCrawlCredentials {
+		return nil, fmt.Errorf("credentials may list at most %d credentials", maxCrawlCredentials)
+	}
+	seed, err := url.Parse(seedURL)
+	if err != nil {
+		return nil, errors.New("URL doesn't parse")
+	}
+	scoped := make([]CrawlCredential, 0, len(credentials))
+	for _, credential := range credentials {
+		basic := credential.Username != "" || credential.Password != ""
+		if basic == (credential.BearerToken != "") {
+			return nil, errors.New("every credential needs a username and password, or a bearerToken")
+		}
+		if strings.Contains(credential.Username, ":") {
+			return nil, errors.New("usernames can't contain a colon")
+		}
+		if !httpguts.ValidHeaderFieldValue(credential.authorization()) {
+			return nil, errors.New("credentials must be one line")
+		}
+		if len(credential.Hosts) == 0 {
+			credential.Hosts = []string{seed.Hostname()}
+		}
+		for _, host := range credential.Hosts {
+			if host == "" || strings.ContainsAny(host, "/:*") {
+				return nil, errors.New("the hosts of credentials must be domain names, they also cover their subdomains")
+			}
+		}
+		scoped = append(scoped, credential)
+	}
+	return scoped, nil
+}
+
+// Helper function to build the Authorization header of a credential
+func (credential CrawlCredential) authorization() string {
+	if credential.BearerToken != "" {
+		return "Bearer " + credential.BearerToken
+	}
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(credential.Username+":"+credential.Password))
+}
+
+// withCredentials returns a context whose requests are authenticated with
+// the first of the crawl's credentials that covers their host
+func withCredentials(crawlCtx context.Context, credentials []CrawlCredential) context.Context {
+	if len(credentials) == 0 {
+		return crawlCtx
+	}
+	return context.WithValue(crawlCtx, credentialsKey{}, credentials)
+}
+
+// Helper function to get the Authorization header a request to rawURL is sent
+// with, if the crawl of crawlCtx has a credential for its host
+func credentialAuthorization(crawlCtx context.Context, rawURL string) string {
+	credentials, _ := crawlCtx.Value(credentialsKey{}).([]CrawlCredential)
+	for _, credential := range credentials {
+		for _, host := range credential.Hosts {
+			if hostMatchesDomain(rawURL, host) {
+				return credential.authorization()
+			}
+		}
+	}
+	return ""
+}
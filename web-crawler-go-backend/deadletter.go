@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+)
+
+// Jobs that failed or could not be parsed are parked here for an operator to look at
+const deadLetterKey = "go-crawler-dead-letters"
+
+// Stands in for the header values, cookie values, passwords and tokens of jobs
+// that are logged or listed. The stored letter keeps them for the requeue
+const redactedSecret = "REDACTED"
+
+type (
+	DeadLetter struct {
+		ID       string    `json:"id"`
+		Command  string    `json:"command"`
+		Error    string    `json:"error"`
+		Stack    string    `json:"stack,omitempty"`
+		FailedAt time.Time `json:"failedAt"`
+	}
+	DeadLettersResponse struct {
+		DeadLetters []DeadLetter `json:"deadLetters"`
+	}
+	RequeueDeadLetterRequest struct {
+		// Optional replacement for the original command, e.g. to fix a malformed job
+		Command string `json:"command"`
+	}
+	// crawlPanic is a panic in one of a crawl's goroutines, returned as the
+	// crawl's error so the job is dead-lettered instead of the worker crashing
+	crawlPanic struct {
+		value interface{}
+		stack string
+	}
+)
+
+func (p *crawlPanic) Error() string {
+	return fmt.Sprintf("panic: %v", p.value)
+}
+
+// Helper function to turn a panic in the goroutine it's deferred in into the error err points to
+func recoverCrawlPanic(err *error) {
+	if r := recover(); r != nil {
+		*err = &crawlPanic{value: r, stack: string(debug.Stack())}
+	}
+}
+
+// runJob calls handle, dead-lettering the command if it fails or panics
+func runJob(rdb *redis.Client, command string, handle func(command string) error) {
+	atomic.AddInt64(&activeCrawls, 1)
+	defer atomic.AddInt64(&activeCrawls, -1)
+	defer func() {
+		if r := recover(); r != nil {
+			deadLetter(rdb, command, fmt.Errorf("panic: %v", r), string(debug.Stack()))
+		}
+	}()
+	if err := handle(command); err != nil {
+		stack := ""
+		var panicked *crawlPanic
+		if errors.As(err, &panicked) {
+			stack = panicked.stack
+		}
+		deadLetter(rdb, command, err, stack)
+	}
+}
+
+func deadLetter(rdb *redis.Client, command string, jobErr error, stack string) {
+	withError(logger.Warn(), jobErr).Str("command", redactJob(command)).Msg("Dead-lettering job")
+	letter := DeadLetter{
+		ID:       fmt.Sprintf("%d", time.Now().UnixNano()),
+		Command:  command,
+		Error:    jobErr.Error(),
+		Stack:    stack,
+		FailedAt: time.Now(),
+	}
+	marshalled, _ := json.Marshal(letter)
+	if err := rdb.RPush(ctx, deadLetterKey, marshalled).Err(); err != nil {
+		withError(logger.Error(), err).Msg("Failed to dead-letter job")
+	}
+}
+
+// redactJob returns a job with its secrets replaced by redactedSecret, for
+// logs and the dead letter listing. Comma separated jobs can't carry any
+func redactJob(command string) string {
+	if !strings.HasPrefix(command, "{") {
+		return command
+	}
+	var job crawlJob
+	if err := json.Unmarshal([]byte(command), &job); err != nil {
+		return fmt.Sprintf("(malformed job of %d bytes, not shown)", len(command))
+	}
+	if len(job.Headers) > 0 {
+		headers := make(map[string]string, len(job.Headers))
+		for name := range job.Headers {
+			headers[name] = redactedSecret
+		}
+		job.Headers = headers
+	}
+	job.Cookies = append([]CrawlCookie(nil), job.Cookies...)
+	for i := range job.Cookies {
+		job.Cookies[i].Value = redactedSecret
+	}
+	job.Credentials = append([]CrawlCredential(nil), job.Credentials...)
+	for i, credential := range job.Credentials {
+		if credential.Password != "" {
+			job.Credentials[i].Password = redactedSecret
+		}
+		if credential.BearerToken != "" {
+			job.Credentials[i].BearerToken = redactedSecret
+		}
+	}
+	job.Proxy = redactURL(job.Proxy)
+	redacted, _ := json.Marshal(job)
+	return string(redacted)
+}
+
+// Helper function to hide the password of a URL
+func redactURL(rawURL string) string {
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil || parsedURL.User == nil {
+		return rawURL
+	}
+	return parsedURL.Redacted()
+}
+
+// restoreSecrets puts the secrets of the original job back into a replacement
+// based on its redacted listing, wherever it still has redactedSecret
+func restoreSecrets(replacement, original crawlJob) crawlJob {
+	for name, value := range replacement.Headers {
+		if value == redactedSecret {
+			replacement.Headers[name] = original.Headers[name]
+		}
+	}
+	for i, cookie := range replacement.Cookies {
+		for _, originalCookie := range original.Cookies {
+			if cookie.Value == redactedSecret && cookie.URL == originalCookie.URL && cookie.Name == originalCookie.Name {
+				replacement.Cookies[i].Value = originalCookie.Value
+			}
+		}
+	}
+	for i, credential := range replacement.Credentials {
+		if i >= len(original.Credentials) || credential.Username != original.Credentials[i].Username {
+			continue
+		}
+		if credential.Password == redactedSecret {
+			replacement.Credentials[i].Password = original.Credentials[i].Password
+		}
+		if credential.BearerToken == redactedSecret {
+			replacement.Credentials[i].BearerToken = original.Credentials[i].BearerToken
+		}
+	}
+	if replacement.Proxy != "" && replacement.Proxy == redactURL(original.Proxy) {
+		replacement.Proxy = original.Proxy
+	}
+	return replacement
+}
+
+// Helper function to find a dead letter by ID, returning it along with its raw list entry
+func findDeadLetter(rdb *redis.Client, id string) (*DeadLetter, string, error) {
+	rawLetters, err := rdb.LRange(ctx, deadLetterKey, 0, -1).Result()
+	if err != nil {
+		return nil, "", err
+	}
+	for _, rawLetter := range rawLetters {
+		var letter DeadLetter
+		if json.Unmarshal([]byte(rawLetter), &letter) == nil && letter.ID == id {
+			return &letter, rawLetter, nil
+		}
+	}
+	return nil, "", nil
+}
+
+// List dead letters handler - GET /admin/dead-letters
+func listDeadLettersHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
+	rawLetters, err := rdb.LRange(ctx, deadLetterKey, 0, -1).Result()
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to get dead letters")
+		return
+	}
+	letters := make([]DeadLetter, 0, len(rawLetters))
+	for _, rawLetter := range rawLetters {
+		var letter DeadLetter
+		if err := json.Unmarshal([]byte(rawLetter), &letter); err != nil {
+			continue
+		}
+		letter.Command = redactJob(letter.Command)
+		letters = append(letters, letter)
+	}
+	sendJSONResponse(w, http.StatusOK, DeadLettersResponse{DeadLetters: letters})
+}
+
+// Requeue dead letter handler - POST /admin/dead-letters/{letter_ID}/requeue
+func requeueDeadLetterHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
+	var req RequeueDeadLetterRequest
+	// The body is optional, an empty one requeues the original command
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && r.ContentLength > 0 {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	letter, rawLetter, err := findDeadLetter(rdb, mux.Vars(r)["letter_ID"])
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to get dead letters")
+		return
+	}
+	if letter == nil {
+		sendErrorResponse(w, http.StatusNotFound, "Dead letter not found")
+		return
+	}
+
+	command := letter.Command
+	if req.Command != "" {
+		// A fixed up job has to be one the workers can read
+		replacement, err := decodeJob(req.Command)
+		if err != nil {
+			sendErrorResponse(w, http.StatusBadRequest, "Invalid command: "+err.Error())
+			return
+		}
+		command = req.Command
+		// It's usually the redacted listing, edited, and the original may be a
+		// job that was dead-lettered for not being one the workers can read
+		var original crawlJob
+		if strings.HasPrefix(command, "{") && json.Unmarshal([]byte(letter.Command), &original) == nil {
+			restored, _ := json.Marshal(restoreSecrets(replacement, original))
+			command = string(restored)
+		}
+	}
+	if err := jobs.enqueue(command); err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to queue command")
+		return
+	}
+	rdb.LRem(ctx, deadLetterKey, 1, rawLetter)
+	w.WriteHeader(http.StatusAccepted)
+}
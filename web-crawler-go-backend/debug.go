@@ -0,0 +1,58 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"expvar"
+	"io/ioutil"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+)
+
+// loadDebugToken reads the token guarding the debug endpoints from a file, so it isn't on the command line
+func loadDebugToken(path string) (string, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(raw))
+	if token == "" {
+		return "", errors.New("the token file is empty")
+	}
+	return token, nil
+}
+
+// requireDebugToken only lets requests through that carry token, either as
+// "Authorization: Bearer <token>" or as the password of basic auth, which
+// lets go tool pprof fetch profiles from http://debug:<token>@host/... directly
+func requireDebugToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if _, password, ok := r.BasicAuth(); ok {
+			given = password
+		}
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="debug"`)
+			sendErrorResponse(w, http.StatusUnauthorized, "A valid debug token is required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// serveDebug exposes pprof profiles and expvar runtime stats on their own
+// address, which should not be reachable from outside the cluster either way
+func serveDebug(addr, token string) {
+	router := http.NewServeMux()
+	router.HandleFunc("/debug/pprof/", pprof.Index)
+	router.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	router.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	router.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	router.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	router.Handle("/debug/vars", expvar.Handler())
+	logger.Info().Str("addr", addr).Msg("Serving debug endpoints")
+	if err := http.ListenAndServe(addr, requireDebugToken(token, router)); err != nil {
+		withError(logger.Error(), err).Msg("Debug server error")
+	}
+}
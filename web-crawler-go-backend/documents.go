@@ -0,0 +1,303 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/ledongthuc/pdf"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// The kinds of documents crawls recognize instead of reading them as HTML
+const (
+	documentPDF  = "pdf"
+	documentDOCX = "docx"
+	documentDOC  = "doc"
+)
+
+const (
+	// Largest part of a Word document read once decompressed, so a zip bomb
+	// stops there
+	maxDOCXPartBytes = 50 << 20
+	// Pages of a PDF read at most, for their text and links
+	maxPDFPages = 1000
+)
+
+var documentMediaTypes = map[string]string{
+	"application/pdf":    documentPDF,
+	"application/x-pdf":  documentPDF,
+	"application/msword": documentDOC,
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document": documentDOCX,
+}
+
+var documentsMetric = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "crawler_documents_total",
+	Help: "PDF and Word documents found by crawls, by type and by result of reading them with extractDocuments: ok, error or skipped.",
+}, []string{"type", "result"})
+
+// DocumentInfo describes a PDF or Word document a crawl found. Everything but
+// Type is only read by crawls with extractDocuments
+type DocumentInfo struct {
+	// pdf, docx or doc, which isn't read
+	Type   string
+	Pages  int    `json:",omitempty"`
+	Title  string `json:",omitempty"`
+	Author string `json:",omitempty"`
+	Words  int    `json:",omitempty"`
+	// The hyperlinks in the document, absolute. Documents are leaf nodes,
+	// these aren't crawled
+	Links []string `json:",omitempty"`
+	// Indexed for search and stored with storeBodies text or article, up to maxIndexedTextBytes
+	text string
+}
+
+// documentType tells whether a response is a document, by its Content-Type,
+// or its extension when servers send documents as generic binary files
+func documentType(header http.Header, pageURL string) string {
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	if kind, ok := documentMediaTypes[mediaType]; ok {
+		return kind
+	}
+	if mediaType != "" && mediaType != "application/octet-stream" && mediaType != "binary/octet-stream" {
+		return ""
+	}
+	parsedURL, err := url.Parse(pageURL)
+	if err != nil {
+		return ""
+	}
+	switch strings.ToLower(path.Ext(parsedURL.Path)) {
+	case ".pdf":
+		return documentPDF
+	case ".docx":
+		return documentDOCX
+	case ".doc":
+		return documentDOC
+	}
+	return ""
+}
+
+// readDocument fills in what can be read of a whole document, its links
+// resolved against pageURL
+func readDocument(document *DocumentInfo, pageURL string, page []byte) error {
+	var text string
+	var links []string
+	var err error
+	switch document.Type {
+	case documentPDF:
+		text, links, err = readPDF(document, page)
+	case documentDOCX:
+		text, links, err = readDOCX(document, page)
+	default:
+		return fmt.Errorf("%s documents can't be read", document.Type)
+	}
+	if err != nil {
+		return err
+	}
+	document.Words = len(strings.Fields(text))
+	document.text = strings.TrimSpace(text)
+	if len(document.text) > maxIndexedTextBytes {
+		document.text = strings.ToValidUTF8(document.text[:maxIndexedTextBytes], "")
+	}
+	document.Links = documentLinks(pageURL, links)
+	return nil
+}
+
+// readPDF reads a PDF's info dictionary, the text of its pages and the URIs
+// of their link annotations
+func readPDF(document *DocumentInfo, page []byte) (text string, links []string, err error) {
+	// The parser panics on some malformed files
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("malformed PDF: %v", r)
+		}
+	}()
+	reader, err := pdf.NewReader(bytes.NewReader(page), int64(len(page)))
+	if err != nil {
+		return "", nil, err
+	}
+	info := reader.Trailer().Key("Info")
+	document.Title, document.Author = strings.TrimSpace(info.Key("Title").Text()), strings.TrimSpace(info.Key("Author").Text())
+	document.Pages = reader.NumPage()
+	var content strings.Builder
+	for i := 1; i <= document.Pages && i <= maxPDFPages; i++ {
+		pdfPage := reader.Page(i)
+		if pdfPage.V.IsNull() {
+			continue
+		}
+		// Text is read up to the length indexed. Pages past it, and those
+		// whose text can't be read, still have their links
+		if content.Len() < maxIndexedTextBytes {
+			if pageText, err := pdfPage.GetPlainText(nil); err == nil {
+				content.WriteString(pageText)
+				content.WriteString("\n\n")
+			}
+		}
+		annotations := pdfPage.V.Key("Annots")
+		for j := 0; j < annotations.Len(); j++ {
+			annotation := annotations.Index(j)
+			if annotation.Key("Subtype").Name() == "Link" {
+				links = append(links, annotation.Key("A").Key("URI").RawString())
+			}
+		}
+	}
+	return content.String(), links, nil
+}
+
+// readDOCX reads a Word document's core properties, the text of its body and
+// its external hyperlinks
+func readDOCX(document *DocumentInfo, page []byte) (string, []string, error) {
+	archive, err := zip.NewReader(bytes.NewReader(page), int64(len(page)))
+	if err != nil {
+		return "", nil, err
+	}
+	var core struct {
+		Title   string `xml:"title"`
+		Creator string `xml:"creator"`
+	}
+	if err := decodeDOCXPart(archive, "docProps/core.xml", &core); err != nil {
+		return "", nil, err
+	}
+	document.Title, document.Author = strings.TrimSpace(core.Title), strings.TrimSpace(core.Creator)
+	// Only as many as Word counted when it last saved the document
+	var app struct {
+		Pages string `xml:"Pages"`
+	}
+	if err := decodeDOCXPart(archive, "docProps/app.xml", &app); err != nil {
+		return "", nil, err
+	}
+	document.Pages, _ = strconv.Atoi(strings.TrimSpace(app.Pages))
+	var relationships struct {
+		Relationship []struct {
+			Type       string `xml:"Type,attr"`
+			Target     string `xml:"Target,attr"`
+			TargetMode string `xml:"TargetMode,attr"`
+		}
+	}
+	if err := decodeDOCXPart(archive, "word/_rels/document.xml.rels", &relationships); err != nil {
+		return "", nil, err
+	}
+	var links []string
+	for _, relationship := range relationships.Relationship {
+		if relationship.TargetMode == "External" && strings.HasSuffix(relationship.Type, "/hyperlink") {
+			links = append(links, relationship.Target)
+		}
+	}
+	body, err := archive.Open("word/document.xml")
+	if err != nil {
+		return "", nil, err
+	}
+	defer body.Close()
+	limitedBody := &io.LimitedReader{R: body, N: maxDOCXPartBytes}
+	var content strings.Builder
+	decoder := xml.NewDecoder(limitedBody)
+	inText := false
+	for {
+		// Only as much text as is indexed is read, a body cut off at
+		// maxDOCXPartBytes has the text before it
+		if content.Len() >= maxIndexedTextBytes {
+			return content.String(), links, nil
+		}
+		token, err := decoder.Token()
+		if err == io.EOF || (err != nil && limitedBody.N <= 0) {
+			return content.String(), links, nil
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		switch token := token.(type) {
+		case xml.StartElement:
+			switch token.Name.Local {
+			case "t":
+				inText = true
+			case "tab":
+				content.WriteString("\t")
+			case "br":
+				content.WriteString("\n")
+			}
+		case xml.EndElement:
+			switch token.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				content.WriteString("\n\n")
+			}
+		case xml.CharData:
+			if inText {
+				content.Write(token)
+			}
+		}
+	}
+}
+
+// Helper function to decode one of the XML parts of a Word document, the
+// optional ones may be missing
+func decodeDOCXPart(archive *zip.Reader, name string, v interface{}) error {
+	part, err := archive.Open(name)
+	if err != nil {
+		return nil
+	}
+	defer part.Close()
+	return xml.NewDecoder(io.LimitReader(part, maxDOCXPartBytes)).Decode(v)
+}
+
+// Helper function to resolve the links of a document, keeping the http ones
+// once each, up to the policy's maxLinks
+func documentLinks(pageURL string, links []string) []string {
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return nil
+	}
+	maxLinks := currentPolicy().maxLinks
+	seen := make(map[string]bool)
+	resolved := []string{}
+	for _, link := range links {
+		parsedLink, err := base.Parse(strings.TrimSpace(link))
+		if err != nil || (parsedLink.Scheme != "http" && parsedLink.Scheme != "https") {
+			continue
+		}
+		parsedLink.Fragment = ""
+		if absolute := parsedLink.String(); !seen[absolute] {
+			seen[absolute] = true
+			resolved = append(resolved, absolute)
+		}
+		if len(resolved) == maxLinks {
+			break
+		}
+	}
+	return resolved
+}
+
+// document recognizes a fetched document, reading it when the crawl has
+// extractDocuments. Nil for every other page
+func (f realFetcher) document(result *FetchResult, resp *http.Response, page []byte) *DocumentInfo {
+	kind := documentType(resp.Header, result.FinalURL)
+	if kind == "" {
+		return nil
+	}
+	document := &DocumentInfo{Type: kind}
+	switch {
+	case !f.extractDocuments:
+	case resp.StatusCode != http.StatusOK || result.Truncated || kind == documentDOC:
+		// Error pages aren't documents, neither format can be read from part of
+		// a file, and the binary format of older Word files isn't read at all
+		documentsMetric.WithLabelValues(kind, "skipped").Inc()
+	default:
+		if err := readDocument(document, result.FinalURL, page); err != nil {
+			documentsMetric.WithLabelValues(kind, "error").Inc()
+			withError(crawlLogger(f.crawlID).Warn(), err).Str("url", result.URL).Msg("Failed to read document")
+		} else {
+			documentsMetric.WithLabelValues(kind, "ok").Inc()
+		}
+	}
+	return document
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+)
+
+type (
+	DomainsReportResponse struct {
+		// Most pages first, then most inbound links
+		Domains []DomainStats `json:"domains"`
+	}
+	DomainStats struct {
+		Domain string `json:"domain"`
+		// Pages of the domain in the results, 0 for domains that were only linked to
+		Pages int `json:"pages"`
+		// Links to the domain on other domains' pages, and from its pages to other domains
+		InboundLinks  int `json:"inboundLinks"`
+		OutboundLinks int `json:"outboundLinks"`
+		// Links between pages of the domain
+		InternalLinks int `json:"internalLinks"`
+		// Pages answering with a 4xx or 5xx, or that a status crawl couldn't check
+		Errors    int     `json:"errors"`
+		ErrorRate float64 `json:"errorRate"`
+	}
+)
+
+// Helper function to get the lowercased host of a url, empty if it has none
+func urlHost(rawURL string) string {
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(parsedURL.Hostname())
+}
+
+// Crawl domains handler - GET /crawl/{crawl_ID}/domains
+func crawlDomainsHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
+	crawlID := mux.Vars(r)["crawl_ID"]
+
+	nodes, done, err := resultStore.Range(crawlID, 0, -1)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to get results")
+		return
+	}
+	ignored, err := loadIgnoreSet(rdb, crawlID)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to get annotations")
+		return
+	}
+	nodes = ignored.filter(nodes)
+	if !done {
+		exists, err := crawlExists(rdb, crawlID)
+		if err != nil {
+			sendErrorResponse(w, http.StatusInternalServerError, "Failed to look up crawl")
+			return
+		}
+		if !exists {
+			sendErrorResponse(w, http.StatusNotFound, "Crawl not found")
+			return
+		}
+		sendErrorResponse(w, http.StatusConflict, "Crawl is still running")
+		return
+	}
+
+	byDomain := make(map[string]*DomainStats)
+	domainStats := func(domain string) *DomainStats {
+		stats := byDomain[domain]
+		if stats == nil {
+			stats = &DomainStats{Domain: domain}
+			byDomain[domain] = stats
+		}
+		return stats
+	}
+	for _, node := range nodes {
+		domain := urlHost(node.Parent)
+		if domain == "" {
+			continue
+		}
+		stats := domainStats(domain)
+		stats.Pages++
+		if nodeFailed(node) {
+			stats.Errors++
+		}
+		for _, child := range node.Children {
+			target := urlHost(child)
+			switch {
+			case target == "":
+			case target == domain:
+				stats.InternalLinks++
+			default:
+				stats.OutboundLinks++
+				domainStats(target).InboundLinks++
+			}
+		}
+	}
+
+	response := DomainsReportResponse{Domains: make([]DomainStats, 0, len(byDomain))}
+	for _, stats := range byDomain {
+		if stats.Pages > 0 {
+			stats.ErrorRate = math.Round(10000*float64(stats.Errors)/float64(stats.Pages)) / 10000
+		}
+		response.Domains = append(response.Domains, *stats)
+	}
+	sort.Slice(response.Domains, func(i, j int) bool {
+		a, b := response.Domains[i], response.Domains[j]
+		if a.Pages != b.Pages {
+			return a.Pages > b.Pages
+		}
+		if a.InboundLinks != b.InboundLinks {
+			return a.InboundLinks > b.InboundLinks
+		}
+		return a.Domain < b.Domain
+	})
+	sendJSONResponse(w, http.StatusOK, response)
+}
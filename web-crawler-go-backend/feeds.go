@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+)
+
+const (
+	// Feeds a page links to that are recorded, and read when following feeds
+	maxFeedsPerPage = 3
+	// Entries of a feed added to the frontier at most, newest first in most feeds
+	maxFeedEntries = 100
+	// Largest feed read
+	maxFeedBytes = 10 << 20
+)
+
+// Link types of the feeds pages announce, and the format they're reported as
+var feedTypes = map[string]string{
+	"application/rss+xml":  "rss",
+	"application/atom+xml": "atom",
+}
+
+type (
+	// PageFeed is a feed a page links to with <link rel="alternate">
+	PageFeed struct {
+		URL string `json:"url"`
+		// rss or atom, as the page says
+		Type  string `json:"type"`
+		Title string `json:"title,omitempty"`
+		// Only set by crawls following feeds, on the first page found linking
+		// to the feed: what the feed says about itself, and how many entries it
+		// had or why it couldn't be read
+		FeedTitle string `json:"feedTitle,omitempty"`
+		Updated   string `json:"updated,omitempty"`
+		Entries   int    `json:"entries,omitempty"`
+		Error     string `json:"error,omitempty"`
+	}
+	// feedDocument is an RSS 2.0 or an Atom feed, told apart by XMLName
+	feedDocument struct {
+		XMLName xml.Name
+		// RSS
+		ChannelTitle  string   `xml:"channel>title"`
+		LastBuildDate string   `xml:"channel>lastBuildDate"`
+		ItemLinks     []string `xml:"channel>item>link"`
+		// Atom
+		Title   string      `xml:"title"`
+		Updated string      `xml:"updated"`
+		Entries []atomEntry `xml:"entry"`
+	}
+	atomEntry struct {
+		Links []struct {
+			Href string `xml:"href,attr"`
+			Rel  string `xml:"rel,attr"`
+		} `xml:"link"`
+	}
+)
+
+// scrapeFeedLinks returns the RSS and Atom feeds page links to, resolved
+// against its url, up to maxFeedsPerPage
+func scrapeFeedLinks(pageURL string, page []byte) []PageFeed {
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return nil
+	}
+	feeds := []PageFeed{}
+	seen := map[string]bool{}
+	z := html.NewTokenizer(bytes.NewReader(page))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			return feeds
+		}
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			continue
+		}
+		token := z.Token()
+		if token.Data != "link" {
+			continue
+		}
+		var rel, linkType, href, title string
+		for _, attr := range token.Attr {
+			switch attr.Key {
+			case "rel":
+				rel = strings.ToLower(attr.Val)
+			case "type":
+				linkType = strings.ToLower(strings.TrimSpace(attr.Val))
+			case "href":
+				href = strings.TrimSpace(attr.Val)
+			case "title":
+				title = strings.TrimSpace(attr.Val)
+			}
+		}
+		feedType, isFeed := feedTypes[linkType]
+		if !isFeed || href == "" || !containsToken(strings.Fields(rel), "alternate") {
+			continue
+		}
+		feedURL, err := base.Parse(href)
+		if err != nil || seen[feedURL.String()] {
+			continue
+		}
+		seen[feedURL.String()] = true
+		feeds = append(feeds, PageFeed{URL: feedURL.String(), Type: feedType, Title: title})
+		if len(feeds) >= maxFeedsPerPage {
+			return feeds
+		}
+	}
+}
+
+// Helper function to check if a list of rel tokens has a given one
+func containsToken(tokens []string, token string) bool {
+	for _, t := range tokens {
+		if t == token {
+			return true
+		}
+	}
+	return false
+}
+
+// readFeed fetches a feed and fills in what it says about itself, returning
+// the urls of its entries. Failures are recorded on the feed, they don't fail the page
+func (f realFetcher) readFeed(ctx context.Context, feed *PageFeed) []string {
+	entries, err := f.fetchFeed(ctx, feed)
+	if err != nil {
+		feed.Error = err.Error()
+		crawlLogger(f.crawlID).Debug().Str("feed", feed.URL).Str("error", feed.Error).Msg("Failed to read feed")
+		return nil
+	}
+	feed.Entries = len(entries)
+	if len(entries) > maxFeedEntries {
+		entries = entries[:maxFeedEntries]
+	}
+	return entries
+}
+
+func (f realFetcher) fetchFeed(ctx context.Context, feed *PageFeed) ([]string, error) {
+	if !f.limits.inScope(feed.URL) {
+		return nil, errOutOfScope
+	}
+	if currentPolicy().blocks(feed.URL) {
+		return nil, errBlockedDomain
+	}
+	f.waitForTurn(feed.URL)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, feed.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("feed answered %d", resp.StatusCode)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxFeedBytes))
+	if err != nil {
+		return nil, err
+	}
+
+	var document feedDocument
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	// Plenty of feeds are still in ISO-8859-1 or windows-1252
+	decoder.CharsetReader = charset.NewReaderLabel
+	if err := decoder.Decode(&document); err != nil {
+		return nil, fmt.Errorf("malformed feed: %v", err)
+	}
+	base, _ := url.Parse(resp.Request.URL.String())
+	links := []string{}
+	switch document.XMLName.Local {
+	case "rss":
+		feed.FeedTitle = strings.TrimSpace(document.ChannelTitle)
+		feed.Updated = strings.TrimSpace(document.LastBuildDate)
+		links = document.ItemLinks
+	case "feed":
+		feed.FeedTitle = strings.TrimSpace(document.Title)
+		feed.Updated = strings.TrimSpace(document.Updated)
+		for _, entry := range document.Entries {
+			// The entry's page is its alternate link, which is the default rel
+			for _, link := range entry.Links {
+				if link.Rel == "" || link.Rel == "alternate" {
+					links = append(links, link.Href)
+					break
+				}
+			}
+		}
+	default:
+		return nil, fmt.Errorf("not an RSS or Atom feed: <%s>", document.XMLName.Local)
+	}
+
+	entries := make([]string, 0, len(links))
+	for _, link := range links {
+		entryURL, err := base.Parse(strings.TrimSpace(link))
+		if err == nil && isCrawlableURL(entryURL.String()) {
+			entries = append(entries, entryURL.String())
+		}
+	}
+	return entries, nil
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Where -fetch-cache keeps responses
+const (
+	fetchCacheRedis = "redis"
+	fetchCacheDisk  = "disk"
+)
+
+var fetchCacheMetric = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "crawler_fetch_cache_lookups_total",
+	Help: "Fetch cache lookups, by result (hit or miss).",
+}, []string{"result"})
+
+type (
+	// fetchCache shares recently fetched responses between the crawls of a
+	// worker (disk) or of every worker (redis), keyed by canonical url
+	fetchCache interface {
+		get(pageURL string) *cachedResponse
+		put(pageURL string, response *cachedResponse)
+	}
+	// cachedResponse is a fetched page, as much as the fetcher needs to treat it like a fresh one
+	cachedResponse struct {
+		FinalURL   string      `json:"finalURL"`
+		StatusCode int         `json:"statusCode"`
+		Header     http.Header `json:"header"`
+		Body       []byte      `json:"body"`
+		Truncated  bool        `json:"truncated,omitempty"`
+		FetchedAt  time.Time   `json:"fetchedAt"`
+		// Body is decoded, these say how it was sent
+		ContentEncoding string `json:"contentEncoding,omitempty"`
+		EncodedBytes    int64  `json:"encodedBytes,omitempty"`
+		// HTTP version it came over, unset in responses cached before it was recorded
+		Proto string `json:"proto,omitempty"`
+		// IPv4 or IPv6, unset when it wasn't recorded
+		AddressFamily string `json:"addressFamily,omitempty"`
+	}
+	redisFetchCache struct {
+		rdb       *redis.Client
+		freshness time.Duration
+	}
+	// diskFetchCache keeps responses in files under dir, dropping the least
+	// recently used ones once they take more than capacity bytes
+	diskFetchCache struct {
+		sync.Mutex
+		dir       string
+		capacity  int64
+		freshness time.Duration
+		size      int64
+		// Least recently used at the back, holding diskCacheEntry values
+		order   *list.List
+		entries map[string]*list.Element
+	}
+	diskCacheEntry struct {
+		key  string
+		size int64
+	}
+)
+
+// Set up by -fetch-cache, nil when responses aren't shared
+var sharedFetchCache fetchCache
+
+// canonicalURL is the key of a page in the fetch cache: the scheme and host
+// lower cased, without the default port, the fragment or empty parts, and
+// with the query parameters sorted
+func canonicalURL(rawURL string) string {
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	parsedURL.Scheme = strings.ToLower(parsedURL.Scheme)
+	parsedURL.Host = strings.ToLower(parsedURL.Host)
+	if port := parsedURL.Port(); (parsedURL.Scheme == "http" && port == "80") || (parsedURL.Scheme == "https" && port == "443") {
+		parsedURL.Host = parsedURL.Hostname()
+	}
+	if parsedURL.Path == "" {
+		parsedURL.Path = "/"
+	}
+	parsedURL.Fragment = ""
+	parsedURL.RawFragment = ""
+	// Encode sorts the parameters by name, and keeps the order of repeated ones
+	parsedURL.RawQuery = parsedURL.Query().Encode()
+	return parsedURL.String()
+}
+
+// Helper function to build the key a page is cached under, urls are hashed to keep keys short
+func fetchCacheKey(pageURL string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(canonicalURL(pageURL))))
+}
+
+// Helper function to tell whether a response may be shared with other crawls.
+// Server errors and rate limiting are passing, and no-store is respected
+func cacheableResponse(resp *http.Response) bool {
+	if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+		return false
+	}
+	return !strings.Contains(strings.ToLower(resp.Header.Get("Cache-Control")), "no-store")
+}
+
+// response rebuilds enough of the original response for the fetcher, and
+// everything it hands the page to. Redirects followed to get it are lost
+func (cached *cachedResponse) response() *http.Response {
+	finalURL, err := url.Parse(cached.FinalURL)
+	if err != nil {
+		finalURL = &url.URL{}
+	}
+	proto := cached.Proto
+	major, minor, ok := http.ParseHTTPVersion(proto)
+	if !ok {
+		proto, major, minor = "HTTP/1.1", 1, 1
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", cached.StatusCode, http.StatusText(cached.StatusCode)),
+		StatusCode:    cached.StatusCode,
+		Proto:         proto,
+		ProtoMajor:    major,
+		ProtoMinor:    minor,
+		Header:        cached.Header,
+		ContentLength: int64(len(cached.Body)),
+		Request:       &http.Request{Method: http.MethodGet, URL: finalURL},
+	}
+}
+
+// Helper function to build the redis key holding a cached response
+func redisFetchCacheKey(pageURL string) string {
+	return "go-crawler-fetch-cache-" + fetchCacheKey(pageURL)
+}
+
+func (cache redisFetchCache) get(pageURL string) *cachedResponse {
+	raw, err := cache.rdb.Get(ctx, redisFetchCacheKey(pageURL)).Bytes()
+	if err != nil {
+		return nil
+	}
+	var cached cachedResponse
+	if json.Unmarshal(raw, &cached) != nil {
+		return nil
+	}
+	return &cached
+}
+
+func (cache redisFetchCache) put(pageURL string, response *cachedResponse) {
+	encoded, err := json.Marshal(response)
+	if err != nil {
+		return
+	}
+	// Expires when it's no longer fresh
+	if err := cache.rdb.Set(ctx, redisFetchCacheKey(pageURL), encoded, cache.freshness).Err(); err != nil {
+		withError(logger.Error(), err).Str("url", pageURL).Msg("Failed to cache response")
+	}
+}
+
+// newDiskFetchCache starts a cache in dir, removing the responses an earlier
+// process left there: entries aren't tracked across restarts, and are soon stale anyway
+func newDiskFetchCache(dir string, capacity int64, freshness time.Duration) (*diskFetchCache, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	leftovers, err := filepath.Glob(filepath.Join(dir, strings.Repeat("[0-9a-f]", sha256.Size*2)))
+	if err != nil {
+		return nil, err
+	}
+	for _, leftover := range leftovers {
+		os.Remove(leftover)
+	}
+	return &diskFetchCache{dir: dir, capacity: capacity, freshness: freshness, order: list.New(), entries: make(map[string]*list.Element)}, nil
+}
+
+func (cache *diskFetchCache) get(pageURL string) *cachedResponse {
+	key := fetchCacheKey(pageURL)
+	cache.Lock()
+	element, ok := cache.entries[key]
+	if ok {
+		cache.order.MoveToFront(element)
+	}
+	cache.Unlock()
+	if !ok {
+		return nil
+	}
+	raw, err := ioutil.ReadFile(filepath.Join(cache.dir, key))
+	if err != nil {
+		return nil
+	}
+	var cached cachedResponse
+	if json.Unmarshal(raw, &cached) != nil || time.Since(cached.FetchedAt) > cache.freshness {
+		return nil
+	}
+	return &cached
+}
+
+func (cache *diskFetchCache) put(pageURL string, response *cachedResponse) {
+	encoded, err := json.Marshal(response)
+	if err != nil || int64(len(encoded)) > cache.capacity {
+		return
+	}
+	key := fetchCacheKey(pageURL)
+	cache.Lock()
+	defer cache.Unlock()
+	// Written under the lock, so a file never outlives its entry
+	if err := ioutil.WriteFile(filepath.Join(cache.dir, key), encoded, 0600); err != nil {
+		withError(logger.Error(), err).Str("url", pageURL).Msg("Failed to cache response")
+		return
+	}
+	if element, ok := cache.entries[key]; ok {
+		cache.size -= element.Value.(diskCacheEntry).size
+		cache.order.Remove(element)
+	}
+	cache.entries[key] = cache.order.PushFront(diskCacheEntry{key: key, size: int64(len(encoded))})
+	cache.size += int64(len(encoded))
+	for cache.size > cache.capacity {
+		oldest := cache.order.Back()
+		entry := oldest.Value.(diskCacheEntry)
+		os.Remove(filepath.Join(cache.dir, entry.key))
+		cache.order.Remove(oldest)
+		delete(cache.entries, entry.key)
+		cache.size -= entry.size
+	}
+}
+
+// Helper function to look a page up in the shared fetch cache, counting hits and misses
+func (f realFetcher) lookUpFetchCache(pageURL string) *cachedResponse {
+	if sharedFetchCache == nil || f.unshared {
+		return nil
+	}
+	cached := sharedFetchCache.get(pageURL)
+	if cached == nil {
+		fetchCacheMetric.WithLabelValues("miss").Inc()
+		return nil
+	}
+	fetchCacheMetric.WithLabelValues("hit").Inc()
+	return cached
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// FetchResult is everything a Fetcher learned about one url
+type FetchResult struct {
+	// The url asked for, and the one the response came from after redirects
+	URL      string
+	FinalURL string
+	// Zero when the fetcher couldn't tell, e.g. one wrapped with adaptLegacyFetcher
+	StatusCode int
+	Header     http.Header
+	// The page as read into memory, up to -max-page-bytes
+	Body      io.Reader
+	Truncated bool
+	// Links found on the page, to other domains than its own, and the entries
+	// of its feeds when the crawl follows them
+	URLs []string
+	// RSS and Atom feeds the page links to
+	Feeds []PageFeed
+	// The page answered 304 Not Modified, its links and feeds are the ones
+	// cached when it was last fetched and Body is empty
+	Unchanged bool
+	// The response came from the fetch cache, another crawl fetched it recently
+	FromCache bool
+	FetchedAt time.Time
+	// Until the response headers arrived, and until the whole body was read
+	ResponseTime time.Duration
+	Duration     time.Duration
+	// Set by crawls with nearDuplicates, see graphNode
+	Simhash         string
+	NearDuplicateOf string
+	// Hex SHA-256 of Body
+	ContentHash string
+	// Bytes in Body, and when the response was compressed, its encoding and
+	// the bytes read off the wire to decode them
+	BodyBytes       int64
+	ContentEncoding string
+	EncodedBytes    int64
+	// HTTP/1.1 or HTTP/2.0
+	Protocol string
+	// IPv4 or IPv6, the family of the server address the page came from
+	AddressFamily string
+	// The page was loaded in the headless browser, URLs has the links its scripts added
+	Rendered bool
+	// API path of the screenshot taken when it was rendered
+	Screenshot string
+	// Set when the page is a PDF or Word document
+	Document *DocumentInfo
+}
+
+// LegacyFetcher is the original Fetcher interface, returning only the body of
+// URL and a slice of URLs found on that page
+type LegacyFetcher interface {
+	Fetch(ctx context.Context, url string) (body string, urls []string, err error)
+}
+
+type legacyFetcherAdapter struct {
+	fetcher LegacyFetcher
+}
+
+// adaptLegacyFetcher lets a fetcher of the original interface be used to crawl
+func adaptLegacyFetcher(fetcher LegacyFetcher) Fetcher {
+	return legacyFetcherAdapter{fetcher: fetcher}
+}
+
+func (adapter legacyFetcherAdapter) Fetch(ctx context.Context, url string) (*FetchResult, error) {
+	start := time.Now()
+	body, urls, err := adapter.fetcher.Fetch(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	return &FetchResult{
+		URL:          url,
+		FinalURL:     url,
+		Header:       http.Header{},
+		Body:         strings.NewReader(body),
+		URLs:         urls,
+		FetchedAt:    start,
+		ResponseTime: time.Since(start),
+		Duration:     time.Since(start),
+	}, nil
+}
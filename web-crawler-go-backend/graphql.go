@@ -0,0 +1,409 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/graphql-go/graphql"
+)
+
+const (
+	// Page size of the node and edge lists when the query doesn't pick one, and the most it may pick
+	defaultGraphQLPageSize = 100
+	maxGraphQLPageSize     = 1000
+	// Most nodes and edges one request may return, however deeply it nests
+	maxGraphQLResults = 10000
+)
+
+var errGraphQLTooLarge = fmt.Errorf("query returns more than %d nodes and edges, ask for fewer with first or filters", maxGraphQLResults)
+
+type GraphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+type (
+	// graphQLSession holds what one request has loaded, so nested fields don't
+	// read the same crawl twice
+	graphQLSession struct {
+		sync.Mutex
+		r      *http.Request
+		crawls map[string]*graphQLCrawl
+		// Nodes and edges returned so far
+		returned int
+	}
+	graphQLSessionKey struct{}
+	graphQLCrawl      struct {
+		id    string
+		done  bool
+		nodes []graphNode
+		// Index of the node fetched at a url, and the urls linking to it
+		byURL   map[string]int
+		parents map[string][]string
+	}
+	// graphQLNode is a url of a crawl, with the node found there if it was fetched
+	graphQLNode struct {
+		crawl *graphQLCrawl
+		url   string
+		node  *graphNode
+	}
+	graphQLEdge struct {
+		crawl          *graphQLCrawl
+		source, target string
+	}
+)
+
+// Helper function to get the session of the request a resolver runs for
+func graphQLSessionOf(resolveCtx context.Context) *graphQLSession {
+	return resolveCtx.Value(graphQLSessionKey{}).(*graphQLSession)
+}
+
+// crawl loads a crawl's results, once per request. Crawls of other tenants
+// aren't found, like on the REST endpoints
+func (session *graphQLSession) crawl(id string) (*graphQLCrawl, error) {
+	session.Lock()
+	defer session.Unlock()
+	if crawl, ok := session.crawls[id]; ok {
+		return crawl, nil
+	}
+	if !inCallerNamespace(session.r, id) {
+		return nil, nil
+	}
+	nodes, done, err := resultStore.Range(id, 0, -1)
+	if err != nil {
+		return nil, errors.New("failed to get results")
+	}
+	if len(nodes) == 0 && !done {
+		return nil, nil
+	}
+	crawl := &graphQLCrawl{id: id, done: done, nodes: nodes, byURL: make(map[string]int, len(nodes)), parents: map[string][]string{}}
+	for i, node := range nodes {
+		if _, ok := crawl.byURL[node.Parent]; !ok {
+			crawl.byURL[node.Parent] = i
+		}
+		for _, child := range node.Children {
+			crawl.parents[child] = append(crawl.parents[child], node.Parent)
+		}
+	}
+	session.crawls[id] = crawl
+	return crawl, nil
+}
+
+// take counts n more results towards the request's limit
+func (session *graphQLSession) take(n int) error {
+	session.Lock()
+	defer session.Unlock()
+	session.returned += n
+	if session.returned > maxGraphQLResults {
+		return errGraphQLTooLarge
+	}
+	return nil
+}
+
+// Helper function to look up the node at a url of the crawl
+func (crawl *graphQLCrawl) node(url string) graphQLNode {
+	if i, ok := crawl.byURL[url]; ok {
+		return graphQLNode{crawl: crawl, url: url, node: &crawl.nodes[i]}
+	}
+	return graphQLNode{crawl: crawl, url: url}
+}
+
+// Helper function to read the filter arguments of a field
+func graphQLFilter(args map[string]interface{}) (resultFilter, error) {
+	filter := resultFilter{}
+	filter.Domain, _ = args["domain"].(string)
+	filter.MinDepth, _ = args["minDepth"].(int)
+	filter.MaxDepth, _ = args["maxDepth"].(int)
+	filter.URLContains, _ = args["urlContains"].(string)
+	filter.Status, _ = args["status"].(string)
+	if !validStatusFilter(filter.Status) {
+		return filter, fmt.Errorf("status must be error, ok, a class like 4xx or a code like 404, not %q", filter.Status)
+	}
+	return filter, nil
+}
+
+// Helper function to cut the page a list field asks for out of items
+func graphQLPage(resolve graphql.ResolveParams, total int) (start, end int, err error) {
+	first, ok := resolve.Args["first"].(int)
+	if !ok {
+		first = defaultGraphQLPageSize
+	}
+	offset, _ := resolve.Args["offset"].(int)
+	if first < 0 || first > maxGraphQLPageSize || offset < 0 {
+		return 0, 0, fmt.Errorf("first must be between 0 and %d, and offset not negative", maxGraphQLPageSize)
+	}
+	start, end = offset, offset+first
+	if start > total {
+		start = total
+	}
+	if end > total {
+		end = total
+	}
+	return start, end, graphQLSessionOf(resolve.Context).take(end - start)
+}
+
+// Helper function to build the paging and filter arguments of a list field
+func graphQLListArgs() graphql.FieldConfigArgument {
+	return graphql.FieldConfigArgument{
+		"first":       &graphql.ArgumentConfig{Type: graphql.Int, Description: fmt.Sprintf("Page size, %d by default and at most %d", defaultGraphQLPageSize, maxGraphQLPageSize)},
+		"offset":      &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 0},
+		"domain":      &graphql.ArgumentConfig{Type: graphql.String, Description: "Only urls on this domain or its subdomains"},
+		"minDepth":    &graphql.ArgumentConfig{Type: graphql.Int},
+		"maxDepth":    &graphql.ArgumentConfig{Type: graphql.Int},
+		"urlContains": &graphql.ArgumentConfig{Type: graphql.String},
+		"status":      &graphql.ArgumentConfig{Type: graphql.String, Description: "error, ok, a class like 4xx or a code like 404"},
+	}
+}
+
+// Helper function to list the nodes at urls, filtered and paged as a field asks
+func resolveGraphQLNodes(resolve graphql.ResolveParams, crawl *graphQLCrawl, urls []string) (interface{}, error) {
+	filter, err := graphQLFilter(resolve.Args)
+	if err != nil {
+		return nil, err
+	}
+	matching := []graphQLNode{}
+	for _, url := range urls {
+		node := crawl.node(url)
+		// Urls that weren't fetched only have a url to filter on
+		candidate := graphNode{Parent: url}
+		if node.node != nil {
+			candidate = *node.node
+		}
+		if filter.matches(candidate) && (node.node != nil || (filter.MinDepth <= 0 && filter.MaxDepth <= 0 && filter.Status == "")) {
+			matching = append(matching, node)
+		}
+	}
+	start, end, err := graphQLPage(resolve, len(matching))
+	if err != nil {
+		return nil, err
+	}
+	return matching[start:end], nil
+}
+
+var (
+	graphQLNodeType, graphQLEdgeType, graphQLCrawlType *graphql.Object
+	graphQLSchema                                      graphql.Schema
+)
+
+func init() {
+	graphQLNodeType = graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Node",
+		Description: "A url of the crawl, and what was found there if it was fetched",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"url": &graphql.Field{Type: graphql.NewNonNull(graphql.String), Resolve: func(resolve graphql.ResolveParams) (interface{}, error) {
+					return resolve.Source.(graphQLNode).url, nil
+				}},
+				"fetched": &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean), Description: "False for urls only linked to, beyond the crawl's depth or scope", Resolve: func(resolve graphql.ResolveParams) (interface{}, error) {
+					return resolve.Source.(graphQLNode).node != nil, nil
+				}},
+				"depth": &graphql.Field{Type: graphql.Int, Description: "Depth left when the page was fetched, the seed has the crawl's depth", Resolve: func(resolve graphql.ResolveParams) (interface{}, error) {
+					if node := resolve.Source.(graphQLNode).node; node != nil {
+						return node.Depth, nil
+					}
+					return nil, nil
+				}},
+				"status": &graphql.Field{Type: graphql.Int, Resolve: func(resolve graphql.ResolveParams) (interface{}, error) {
+					if node := resolve.Source.(graphQLNode).node; node != nil && node.Status != 0 {
+						return node.Status, nil
+					}
+					return nil, nil
+				}},
+				"error": &graphql.Field{Type: graphql.String, Resolve: func(resolve graphql.ResolveParams) (interface{}, error) {
+					if node := resolve.Source.(graphQLNode).node; node != nil && node.Error != "" {
+						return node.Error, nil
+					}
+					return nil, nil
+				}},
+				"timeFoundSeconds": &graphql.Field{Type: graphql.Float, Description: "Since the crawl started", Resolve: func(resolve graphql.ResolveParams) (interface{}, error) {
+					if node := resolve.Source.(graphQLNode).node; node != nil {
+						return node.TimeFound.Seconds(), nil
+					}
+					return nil, nil
+				}},
+				"children": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphQLNodeType))),
+					Description: "The urls this page links to",
+					Args:        graphQLListArgs(),
+					Resolve: func(resolve graphql.ResolveParams) (interface{}, error) {
+						source := resolve.Source.(graphQLNode)
+						if source.node == nil {
+							return []graphQLNode{}, nil
+						}
+						return resolveGraphQLNodes(resolve, source.crawl, source.node.Children)
+					},
+				},
+				"parents": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphQLNodeType))),
+					Description: "The pages linking to this url",
+					Args:        graphQLListArgs(),
+					Resolve: func(resolve graphql.ResolveParams) (interface{}, error) {
+						source := resolve.Source.(graphQLNode)
+						return resolveGraphQLNodes(resolve, source.crawl, source.crawl.parents[source.url])
+					},
+				},
+			}
+		}),
+	})
+
+	graphQLEdgeType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "Edge",
+		Fields: graphql.Fields{
+			"source": &graphql.Field{Type: graphql.NewNonNull(graphQLNodeType), Resolve: func(resolve graphql.ResolveParams) (interface{}, error) {
+				edge := resolve.Source.(graphQLEdge)
+				return edge.crawl.node(edge.source), nil
+			}},
+			"target": &graphql.Field{Type: graphql.NewNonNull(graphQLNodeType), Resolve: func(resolve graphql.ResolveParams) (interface{}, error) {
+				edge := resolve.Source.(graphQLEdge)
+				return edge.crawl.node(edge.target), nil
+			}},
+			"foundBy": &graphql.Field{
+				Type:        graphql.NewList(graphql.NewNonNull(graphql.String)),
+				Description: "Only on merged graphs, the crawls that found the edge",
+				Resolve: func(resolve graphql.ResolveParams) (interface{}, error) {
+					edge := resolve.Source.(graphQLEdge)
+					if node := edge.crawl.node(edge.source).node; node != nil && node.Sources != nil {
+						return node.Sources[edge.target], nil
+					}
+					return nil, nil
+				},
+			},
+		},
+	})
+
+	edgeArgs := graphQLListArgs()
+	edgeArgs["targetDomain"] = &graphql.ArgumentConfig{Type: graphql.String, Description: "Only edges to urls on this domain or its subdomains"}
+	graphQLCrawlType = graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Crawl",
+		Description: "A crawl or merged graph",
+		Fields: graphql.Fields{
+			"id": &graphql.Field{Type: graphql.NewNonNull(graphql.ID), Resolve: func(resolve graphql.ResolveParams) (interface{}, error) {
+				return resolve.Source.(*graphQLCrawl).id, nil
+			}},
+			"done": &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean), Resolve: func(resolve graphql.ResolveParams) (interface{}, error) {
+				return resolve.Source.(*graphQLCrawl).done, nil
+			}},
+			"pagesFetched": &graphql.Field{Type: graphql.NewNonNull(graphql.Int), Resolve: func(resolve graphql.ResolveParams) (interface{}, error) {
+				return len(resolve.Source.(*graphQLCrawl).nodes), nil
+			}},
+			"node": &graphql.Field{
+				Type: graphQLNodeType,
+				Args: graphql.FieldConfigArgument{"url": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)}},
+				Resolve: func(resolve graphql.ResolveParams) (interface{}, error) {
+					crawl := resolve.Source.(*graphQLCrawl)
+					node := crawl.node(resolve.Args["url"].(string))
+					if node.node == nil && len(crawl.parents[node.url]) == 0 {
+						return nil, nil
+					}
+					return node, nil
+				},
+			},
+			"nodes": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphQLNodeType))),
+				Description: "The pages fetched, in the order they were found",
+				Args:        graphQLListArgs(),
+				Resolve: func(resolve graphql.ResolveParams) (interface{}, error) {
+					crawl := resolve.Source.(*graphQLCrawl)
+					urls := make([]string, 0, len(crawl.nodes))
+					for _, node := range crawl.nodes {
+						urls = append(urls, node.Parent)
+					}
+					return resolveGraphQLNodes(resolve, crawl, urls)
+				},
+			},
+			"edges": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphQLEdgeType))),
+				Description: "Links between pages, the filters apply to the page the link is on",
+				Args:        edgeArgs,
+				Resolve: func(resolve graphql.ResolveParams) (interface{}, error) {
+					crawl := resolve.Source.(*graphQLCrawl)
+					filter, err := graphQLFilter(resolve.Args)
+					if err != nil {
+						return nil, err
+					}
+					targetDomain, _ := resolve.Args["targetDomain"].(string)
+					edges := []graphQLEdge{}
+					for _, node := range crawl.nodes {
+						if !filter.matches(node) {
+							continue
+						}
+						for _, child := range node.Children {
+							if targetDomain == "" || hostMatchesDomain(child, targetDomain) {
+								edges = append(edges, graphQLEdge{crawl: crawl, source: node.Parent, target: child})
+							}
+						}
+					}
+					start, end, err := graphQLPage(resolve, len(edges))
+					if err != nil {
+						return nil, err
+					}
+					return edges[start:end], nil
+				},
+			},
+		},
+	})
+
+	var err error
+	graphQLSchema, err = graphql.NewSchema(graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{
+			Name: "Query",
+			Fields: graphql.Fields{
+				"crawl": &graphql.Field{
+					Type:        graphQLCrawlType,
+					Description: "A crawl or merged graph of the caller's, null if there is none with this ID",
+					Args:        graphql.FieldConfigArgument{"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)}},
+					Resolve: func(resolve graphql.ResolveParams) (interface{}, error) {
+						crawl, err := graphQLSessionOf(resolve.Context).crawl(resolve.Args["id"].(string))
+						if crawl == nil || err != nil {
+							// A typed nil would not come out as null
+							return nil, err
+						}
+						return crawl, nil
+					},
+				},
+			},
+		}),
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// GraphQL handler - POST /graphql, or GET /graphql?query=
+func graphQLHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
+	var req GraphQLRequest
+	if r.Method == http.MethodGet {
+		req.Query = r.URL.Query().Get("query")
+		req.OperationName = r.URL.Query().Get("operationName")
+		if variables := r.URL.Query().Get("variables"); variables != "" {
+			if err := json.Unmarshal([]byte(variables), &req.Variables); err != nil {
+				sendErrorResponse(w, http.StatusBadRequest, "variables must be a JSON object")
+				return
+			}
+		}
+	} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	if req.Query == "" {
+		sendErrorResponse(w, http.StatusBadRequest, "query is required")
+		return
+	}
+
+	session := &graphQLSession{r: r, crawls: map[string]*graphQLCrawl{}}
+	result := graphql.Do(graphql.Params{
+		Schema:         graphQLSchema,
+		RequestString:  req.Query,
+		OperationName:  req.OperationName,
+		VariableValues: req.Variables,
+		Context:        context.WithValue(r.Context(), graphQLSessionKey{}, session),
+	})
+	// Errors are part of a GraphQL answer, next to whatever data could be resolved
+	sendJSONResponse(w, http.StatusOK, result)
+}
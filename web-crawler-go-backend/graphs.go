@@ -0,0 +1,282 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+)
+
+const (
+	mergedGraphPrefix = "merged-"
+	// Merged graphs are meant to accumulate history, so they outlive their inputs
+	mergedGraphTTL = 7 * 24 * time.Hour
+)
+
+type (
+	MergeGraphsRequest struct {
+		CrawlIDs []string `json:"crawlIDs"`
+	}
+	AsOfResponse struct {
+		AsOf time.Time `json:"asOf"`
+		// The crawls the returned graph was built from
+		CrawlIDs []string    `json:"crawlIDs"`
+		Edges    []graphNode `json:"edges"`
+	}
+	MergeGraphsResponse struct {
+		GraphID    string   `json:"graphID"`
+		ResultsURL string   `json:"resultsURL"`
+		CrawlIDs   []string `json:"crawlIDs"`
+		Nodes      int      `json:"nodes"`
+		Edges      int      `json:"edges"`
+	}
+)
+
+// mergeGraphs deduplicates nodes by URL and edges by (parent, child), recording
+// which crawls contributed each edge. Inputs may themselves be merged graphs
+func mergeGraphs(graphs map[string][]graphNode) []graphNode {
+	merged := map[string]*graphNode{}
+	nodeSources := map[string]map[string]bool{}
+	sources := map[string]map[string]map[string]bool{}
+	order := []string{}
+
+	crawlIDs := make([]string, 0, len(graphs))
+	for crawlID := range graphs {
+		crawlIDs = append(crawlIDs, crawlID)
+	}
+	sort.Strings(crawlIDs)
+
+	for _, crawlID := range crawlIDs {
+		for _, node := range graphs[crawlID] {
+			existing, ok := merged[node.Parent]
+			if !ok {
+				existing = &graphNode{Parent: node.Parent, Children: []string{}, TimeFound: node.TimeFound, Depth: node.Depth}
+				merged[node.Parent] = existing
+				nodeSources[node.Parent] = map[string]bool{}
+				sources[node.Parent] = map[string]map[string]bool{}
+				order = append(order, node.Parent)
+			}
+			if len(node.NodeSources) > 0 {
+				for _, source := range node.NodeSources {
+					nodeSources[node.Parent][source] = true
+				}
+			} else {
+				nodeSources[node.Parent][crawlID] = true
+			}
+			if node.Depth > existing.Depth {
+				existing.Depth = node.Depth
+			}
+			if node.TimeFound < existing.TimeFound {
+				existing.TimeFound = node.TimeFound
+			}
+			for _, child := range node.Children {
+				edgeSources, ok := sources[node.Parent][child]
+				if !ok {
+					edgeSources = map[string]bool{}
+					sources[node.Parent][child] = edgeSources
+					existing.Children = append(existing.Children, child)
+				}
+				if len(node.Sources[child]) > 0 {
+					for _, source := range node.Sources[child] {
+						edgeSources[source] = true
+					}
+				} else {
+					edgeSources[crawlID] = true
+				}
+			}
+		}
+	}
+
+	nodes := make([]graphNode, 0, len(order))
+	for _, parent := range order {
+		node := merged[parent]
+		for source := range nodeSources[parent] {
+			node.NodeSources = append(node.NodeSources, source)
+		}
+		sort.Strings(node.NodeSources)
+		node.Sources = make(map[string][]string, len(node.Children))
+		for child, edgeSources := range sources[parent] {
+			for source := range edgeSources {
+				node.Sources[child] = append(node.Sources[child], source)
+			}
+			sort.Strings(node.Sources[child])
+		}
+		nodes = append(nodes, *node)
+	}
+	// Keep the results in discovery order, like a normal crawl
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return nodes[i].TimeFound < nodes[j].TimeFound
+	})
+	return nodes
+}
+
+// Merge graphs handler - POST /graphs/merge
+func mergeGraphsHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
+	var req MergeGraphsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	if len(req.CrawlIDs) < 2 {
+		sendErrorResponse(w, http.StatusBadRequest, "At least two crawl IDs are required")
+		return
+	}
+
+	graphs := make(map[string][]graphNode, len(req.CrawlIDs))
+	for _, crawlID := range req.CrawlIDs {
+		if !inCallerNamespace(r, crawlID) {
+			sendErrorResponse(w, http.StatusNotFound, fmt.Sprintf("Crawl %s not found", crawlID))
+			return
+		}
+		nodes, done, err := resultStore.Range(crawlID, 0, -1)
+		if err != nil {
+			sendErrorResponse(w, http.StatusInternalServerError, "Failed to get results")
+			return
+		}
+		if len(nodes) == 0 && !done {
+			sendErrorResponse(w, http.StatusNotFound, fmt.Sprintf("Crawl %s not found", crawlID))
+			return
+		}
+		if !done {
+			sendErrorResponse(w, http.StatusConflict, fmt.Sprintf("Crawl %s is still running", crawlID))
+			return
+		}
+		graphs[crawlID] = nodes
+	}
+	merged := mergeGraphs(graphs)
+
+	// Store the merged graph like any other finished crawl, so every endpoint that reads crawls works on it
+	graphID := namespaceID(callerNamespace(r), fmt.Sprintf("%s%d", mergedGraphPrefix, time.Now().UnixNano()))
+	edges := 0
+	for _, node := range merged {
+		edges += len(node.Children)
+	}
+	err := resultStore.Append(graphID, merged...)
+	if err == nil {
+		err = resultStore.MarkDone(graphID)
+	}
+	if err == nil {
+		err = resultStore.Expire(graphID, mergedGraphTTL)
+	}
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to save merged graph")
+		return
+	}
+
+	response := MergeGraphsResponse{
+		GraphID:    graphID,
+		ResultsURL: buildResultsLink(apiScheme(), r.Host, graphID, 0),
+		CrawlIDs:   req.CrawlIDs,
+		Nodes:      len(merged),
+		Edges:      edges,
+	}
+	sendJSONResponse(w, http.StatusCreated, response)
+}
+
+// Helper function to recover when a crawl started, crawl IDs are its start time in
+// nanoseconds after the tenant's namespace
+func crawlStartTime(crawlID string) (time.Time, bool) {
+	_, started := splitNamespace(crawlID)
+	nanos, err := strconv.ParseInt(started, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, nanos), true
+}
+
+// Helper function to parse an as-of date, either RFC 3339 or a bare day (meaning the end of it)
+func parseAsOf(raw string) (time.Time, error) {
+	if asOf, err := time.Parse(time.RFC3339, raw); err == nil {
+		return asOf, nil
+	}
+	day, err := time.Parse("2006-01-02", raw)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return day.Add(24*time.Hour - time.Nanosecond), nil
+}
+
+// graphAsOf rebuilds the graph seen by the given crawls from a merged graph's sources
+func graphAsOf(nodes []graphNode, crawls map[string]bool) []graphNode {
+	snapshot := []graphNode{}
+	for _, node := range nodes {
+		seen := false
+		for _, source := range node.NodeSources {
+			seen = seen || crawls[source]
+		}
+		if !seen {
+			continue
+		}
+		children := []string{}
+		for _, child := range node.Children {
+			for _, source := range node.Sources[child] {
+				if crawls[source] {
+					children = append(children, child)
+					break
+				}
+			}
+		}
+		snapshot = append(snapshot, graphNode{Parent: node.Parent, Children: children, TimeFound: node.TimeFound, Depth: node.Depth})
+	}
+	return snapshot
+}
+
+// As-of handler - GET /graphs/{graph_ID}/asof?date=
+// Returns the graph as the latest crawl on or before date saw it, or with
+// cumulative=true every edge any crawl up to that date found
+func graphAsOfHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
+	asOf, err := parseAsOf(r.URL.Query().Get("date"))
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "date must be RFC 3339 or YYYY-MM-DD")
+		return
+	}
+	cumulative := r.URL.Query().Get("cumulative") == "true"
+
+	graphID := mux.Vars(r)["graph_ID"]
+	nodes, done, err := resultStore.Range(graphID, 0, -1)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to get results")
+		return
+	}
+	if len(nodes) == 0 && !done {
+		sendErrorResponse(w, http.StatusNotFound, "Graph not found")
+		return
+	}
+
+	// Collect every crawl that contributed to the graph before the cut-off
+	var latest string
+	var latestStart time.Time
+	before := map[string]bool{}
+	for _, node := range nodes {
+		for _, source := range node.NodeSources {
+			start, ok := crawlStartTime(source)
+			if !ok || start.After(asOf) {
+				continue
+			}
+			before[source] = true
+			if start.After(latestStart) {
+				latest, latestStart = source, start
+			}
+		}
+	}
+	if len(before) == 0 {
+		sendErrorResponse(w, http.StatusNotFound, "No crawls in this graph on or before that date")
+		return
+	}
+
+	crawls := map[string]bool{latest: true}
+	if cumulative {
+		crawls = before
+	}
+	crawlIDs := make([]string, 0, len(crawls))
+	for crawlID := range crawls {
+		crawlIDs = append(crawlIDs, crawlID)
+	}
+	sort.Strings(crawlIDs)
+	sendJSONResponse(w, http.StatusOK, AsOfResponse{AsOf: asOf, CrawlIDs: crawlIDs, Edges: graphAsOf(nodes, crawls)})
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"bishops-web-crawler/crawlerpb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+)
+
+// How often GetResults looks for new results of a running crawl
+const grpcResultsPollPeriod = 500 * time.Millisecond
+
+// How long GetResults waits for a crawl it can't find to be picked up by a
+// worker, so one started just before isn't taken for a crawl that doesn't exist
+const grpcQueuedCrawlWait = 10 * time.Second
+
+var (
+	// Metadata passed on to the REST endpoints as headers
+	grpcRequestHeaders = []string{"Authorization", requestIDHeader, idempotencyHeader, "traceparent", "tracestate"}
+	// Headers of the REST answer passed back as metadata
+	grpcResponseHeaders = append([]string{requestIDHeader, idempotentReplayHeader, "Retry-After"}, quotaHeaders...)
+)
+
+// grpcServer serves the Crawler service. Every call goes through the REST
+// endpoint it mirrors, middleware and all, so tenants, quotas, namespaces and
+// idempotency keys work the same over both
+type grpcServer struct {
+	crawlerpb.UnimplementedCrawlerServer
+	api http.Handler
+}
+
+// grpcResponse is what a REST endpoint answers a gRPC call into
+type grpcResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (response *grpcResponse) Header() http.Header {
+	return response.header
+}
+
+func (response *grpcResponse) Write(data []byte) (int, error) {
+	if response.status == 0 {
+		response.status = http.StatusOK
+	}
+	return response.body.Write(data)
+}
+
+func (response *grpcResponse) WriteHeader(status int) {
+	if response.status == 0 {
+		response.status = status
+	}
+}
+
+// Helper function to turn the status of a REST answer into a gRPC code
+func grpcCode(httpStatus int) codes.Code {
+	switch httpStatus {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict, http.StatusUnprocessableEntity:
+		return codes.FailedPrecondition
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusNotImplemented:
+		return codes.Unimplemented
+	case http.StatusServiceUnavailable:
+		return codes.Unavailable
+	}
+	return codes.Internal
+}
+
+// Helper function to get the REST API's host as a gRPC client reached it,
+// for the links in answers
+func restHost(authority string) string {
+	host, _, err := net.SplitHostPort(authority)
+	if err != nil {
+		host = authority
+	}
+	if host == "" {
+		host = "localhost"
+	}
+	_, port, _ := net.SplitHostPort(serverAddr)
+	return net.JoinHostPort(host, port)
+}
+
+// call sends a gRPC call to its REST endpoint, decoding the answer into
+// response. The headers worth passing back are returned as metadata, even
+// when the endpoint refused the call
+func (server *grpcServer) call(callCtx context.Context, method, path string, body, response interface{}) (metadata.MD, error) {
+	var reader io.Reader = http.NoBody
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	r, err := http.NewRequestWithContext(callCtx, method, path, reader)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	incoming, _ := metadata.FromIncomingContext(callCtx)
+	for _, name := range grpcRequestHeaders {
+		for _, value := range incoming.Get(name) {
+			r.Header.Add(name, value)
+		}
+	}
+	if body != nil {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	authority := ""
+	if values := incoming.Get(":authority"); len(values) > 0 {
+		authority = values[0]
+	}
+	r.Host = restHost(authority)
+	if caller, ok := peer.FromContext(callCtx); ok {
+		r.RemoteAddr = caller.Addr.String()
+	}
+
+	answer := &grpcResponse{header: http.Header{}}
+	server.api.ServeHTTP(answer, r)
+	headers := metadata.MD{}
+	for _, name := range grpcResponseHeaders {
+		if values := answer.header.Values(name); len(values) > 0 {
+			headers.Set(name, values...)
+		}
+	}
+	if answer.status < 200 || answer.status > 299 {
+		var refusal ErrorResponse
+		if json.Unmarshal(answer.body.Bytes(), &refusal) != nil || refusal.Message == "" {
+			refusal.Message = http.StatusText(answer.status)
+		}
+		return headers, status.Error(grpcCode(answer.status), refusal.Message)
+	}
+	if response != nil {
+		if err := json.Unmarshal(answer.body.Bytes(), response); err != nil {
+			return headers, status.Error(codes.Internal, "malformed answer: "+err.Error())
+		}
+	}
+	return headers, nil
+}
+
+// StartCrawl queues a crawl with POST /crawl
+func (server *grpcServer) StartCrawl(callCtx context.Context, req *crawlerpb.StartCrawlRequest) (*crawlerpb.StartCrawlResponse, error) {
+	body := InitializeCrawlRequest{
+		URL:                req.Url,
+		ExcludeVisitedFrom: req.ExcludeVisitedFrom,
+		Type:               req.Type,
+		StatusOf:           req.StatusOf,
+		StoreBodies:        req.StoreBodies,
+		TTLSeconds:         int(req.TtlSeconds),
+		Depth:              int(req.Depth),
+		MaxRate:            req.MaxRate,
+		SecurityHeaders:    req.SecurityHeaders,
+		Sitemap:            req.Sitemap,
+		FollowFeeds:        req.FollowFeeds,
+		CallbackURL:        req.CallbackUrl,
+		HTTPCache:          req.HttpCache,
+		NearDuplicates:     req.NearDuplicates,
+		UserAgent:          req.UserAgent,
+		UserAgents:         req.UserAgents,
+		Headers:            req.Headers,
+		CookieJar:          req.CookieJar,
+		Proxy:              req.Proxy,
+		ProxyPool:          req.ProxyPool,
+		Render:             req.Render,
+		Screenshots:        req.Screenshots,
+		ExtractDocuments:   req.ExtractDocuments,
+	}
+	for _, cookie := range req.Cookies {
+		body.Cookies = append(body.Cookies, CrawlCookie{URL: cookie.Url, Name: cookie.Name, Value: cookie.Value})
+	}
+	for _, credential := range req.Credentials {
+		body.Credentials = append(body.Credentials, CrawlCredential{Hosts: credential.Hosts, Username: credential.Username, Password: credential.Password, BearerToken: credential.BearerToken})
+	}
+	var started InitializeCrawlResponse
+	headers, err := server.call(callCtx, http.MethodPost, versionedPath("/crawl"), body, &started)
+	grpc.SetHeader(callCtx, headers)
+	if err != nil {
+		return nil, err
+	}
+	response := &crawlerpb.StartCrawlResponse{CrawlId: started.CrawlID, ResultsUrl: started.ResultsURL}
+	if limits := started.Limits; limits != nil {
+		response.Limits = &crawlerpb.CrawlLimits{
+			Tenant:      limits.Tenant,
+			Depth:       int32(limits.Depth),
+			Rate:        limits.Rate,
+			Scopes:      limits.Scopes,
+			PagesPerDay: int32(limits.PagesPerDay),
+		}
+	}
+	return response, nil
+}
+
+// awaitCrawl asks GET /crawl/{crawl_ID}/status about a crawl until it's found,
+// failing with NOT_FOUND once grpcQueuedCrawlWait is up. Results of a crawl
+// that doesn't exist would otherwise be polled for forever
+func (server *grpcServer) awaitCrawl(callCtx context.Context, crawlID string) error {
+	deadline := time.Now().Add(grpcQueuedCrawlWait)
+	for {
+		var crawlStatus CrawlStatusResponse
+		_, err := server.call(callCtx, http.MethodGet, versionedPath("/crawl/"+url.PathEscape(crawlID)+"/status"), nil, &crawlStatus)
+		if status.Code(err) != codes.NotFound || time.Now().After(deadline) {
+			return err
+		}
+		select {
+		case <-time.After(grpcResultsPollPeriod):
+		case <-callCtx.Done():
+			return status.Error(codes.Canceled, callCtx.Err().Error())
+		}
+	}
+}
+
+// GetResults follows GET /crawl/{crawl_ID} until the crawl is done, sending
+// every result as it comes
+func (server *grpcServer) GetResults(req *crawlerpb.GetResultsRequest, stream crawlerpb.Crawler_GetResultsServer) error {
+	if req.CrawlId == "" {
+		return status.Error(codes.InvalidArgument, "crawl_id is required")
+	}
+	if req.StartIndex < 0 {
+		return status.Error(codes.InvalidArgument, "start_index must not be negative")
+	}
+	if err := server.awaitCrawl(stream.Context(), req.CrawlId); err != nil {
+		return err
+	}
+	index := req.StartIndex
+	for first := true; ; first = false {
+		var page LookupCrawlResponse
+		path := fmt.Sprintf("%s?startIndex=%d", versionedPath("/crawl/"+url.PathEscape(req.CrawlId)), index)
+		headers, err := server.call(stream.Context(), http.MethodGet, path, nil, &page)
+		if first {
+			stream.SetHeader(headers)
+		}
+		if err != nil {
+			return err
+		}
+		for _, node := range page.Edges {
+			err := stream.Send(&crawlerpb.GraphNode{
+				Index:          index,
+				Parent:         node.Parent,
+				Children:       node.Children,
+				TimeFoundNanos: int64(node.TimeFound),
+				Depth:          int32(node.Depth),
+				Status:         int32(node.Status),
+				Error:          node.Error,
+			})
+			if err != nil {
+				return err
+			}
+			index++
+		}
+		// No next link once the crawl is done
+		if page.Links == nil {
+			return nil
+		}
+		if len(page.Edges) == 0 {
+			select {
+			case <-time.After(grpcResultsPollPeriod):
+			case <-stream.Context().Done():
+				return status.Error(codes.Canceled, stream.Context().Err().Error())
+			}
+		}
+	}
+}
+
+// CancelCrawl stops a crawl with POST /crawl/{crawl_ID}/cancel
+func (server *grpcServer) CancelCrawl(callCtx context.Context, req *crawlerpb.CancelCrawlRequest) (*crawlerpb.CancelCrawlResponse, error) {
+	if req.CrawlId == "" {
+		return nil, status.Error(codes.InvalidArgument, "crawl_id is required")
+	}
+	var canceled CancelCrawlResponse
+	headers, err := server.call(callCtx, http.MethodPost, versionedPath("/crawl/"+url.PathEscape(req.CrawlId)+"/cancel"), nil, &canceled)
+	grpc.SetHeader(callCtx, headers)
+	if err != nil {
+		return nil, err
+	}
+	return &crawlerpb.CancelCrawlResponse{State: canceled.State, PagesFetched: canceled.PagesFetched}, nil
+}
+
+// serveGRPC serves the Crawler service on grpcAddr, over TLS with -tls-cert.
+// Server reflection is on, so tools like grpcurl work without the .proto
+func serveGRPC(api http.Handler) {
+	var options []grpc.ServerOption
+	if tlsCertFile != "" {
+		creds, err := credentials.NewServerTLSFromFile(tlsCertFile, tlsKeyFile)
+		if err != nil {
+			withError(logger.Error(), err).Msg("Failed to load gRPC certificate")
+			return
+		}
+		options = append(options, grpc.Creds(creds))
+	}
+	listener, err := net.Listen("tcp", grpcAddr)
+	if err != nil {
+		withError(logger.Error(), err).Msg("gRPC server error")
+		return
+	}
+	server := grpc.NewServer(options...)
+	crawlerpb.RegisterCrawlerServer(server, &grpcServer{api: api})
+	reflection.Register(server)
+	logger.Info().Str("addr", grpcAddr).Bool("tls", tlsCertFile != "").Msg("Starting gRPC server")
+	if err := server.Serve(listener); err != nil {
+		withError(logger.Error(), err).Msg("gRPC server error")
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+const (
+	// Headers a crawl may add to its requests at most, and their size altogether
+	maxRequestHeaders     = 20
+	maxRequestHeaderBytes = 8 << 10
+)
+
+// Headers the crawler sets itself, or that would change how responses are
+// read rather than what the site sends. The User-Agent has its own options
+var reservedRequestHeaders = map[string]bool{
+	"Accept-Encoding":   true,
+	"Connection":        true,
+	"Content-Length":    true,
+	"Host":              true,
+	"If-Match":          true,
+	"If-Modified-Since": true,
+	"If-None-Match":     true,
+	"If-Range":          true,
+	"Keep-Alive":        true,
+	"Range":             true,
+	"Te":                true,
+	"Trailer":           true,
+	"Transfer-Encoding": true,
+	"Upgrade":           true,
+	"User-Agent":        true,
+}
+
+type (
+	requestHeadersKey struct{}
+	// crawlHeaderTransport adds the headers of the crawl a request is sent for,
+	// from its context, its credentials for the request's host, and its
+	// User-Agent, or the crawler's own on requests outside of crawls. Each
+	// request of a redirect is given its own, so credentials never follow a
+	// redirect to another host
+	crawlHeaderTransport struct {
+		base http.RoundTripper
+	}
+)
+
+// validateRequestHeaders checks the headers a crawl asks to send, and returns
+// them with canonical names
+func validateRequestHeaders(headers map[string]string) (map[string]string, error) {
+	if len(headers) > maxRequestHeaders {
+		return nil, fmt.Errorf("headers may have at most %d entries", maxRequestHeaders)
+	}
+	canonical := make(map[string]string, len(headers))
+	size := 0
+	for name, value := range headers {
+		if !httpguts.ValidHeaderFieldName(name) || !httpguts.ValidHeaderFieldValue(value) {
+			return nil, fmt.Errorf("header %q isn't a valid HTTP header", name)
+		}
+		name = http.CanonicalHeaderKey(name)
+		if reservedRequestHeaders[name] || strings.HasPrefix(name, "Proxy-") {
+			return nil, fmt.Errorf("header %s is set by the crawler and can't be replaced", name)
+		}
+		if _, ok := canonical[name]; ok {
+			return nil, fmt.Errorf("header %s is given twice", name)
+		}
+		canonical[name] = value
+		size += len(name) + len(value)
+	}
+	if size > maxRequestHeaderBytes {
+		return nil, fmt.Errorf("headers may take at most %d bytes", maxRequestHeaderBytes)
+	}
+	return canonical, nil
+}
+
+// withRequestHeaders returns a context whose requests are sent with the crawl's headers
+func withRequestHeaders(crawlCtx context.Context, headers map[string]string) context.Context {
+	if len(headers) == 0 {
+		return crawlCtx
+	}
+	header := make(http.Header, len(headers))
+	for name, value := range headers {
+		header.Set(name, value)
+	}
+	return context.WithValue(crawlCtx, requestHeadersKey{}, header)
+}
+
+func (t crawlHeaderTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	header, _ := req.Context().Value(requestHeadersKey{}).(http.Header)
+	authorization := credentialAuthorization(req.Context(), req.URL.String())
+	userAgent := req.Header.Get("User-Agent")
+	if userAgent == "" {
+		userAgent = requestUserAgent(req.Context())
+	} else if len(header) == 0 && authorization == "" {
+		return t.base.RoundTrip(req)
+	}
+	// Round trippers mustn't change the request they're given
+	req = req.Clone(req.Context())
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	req.Header.Set("User-Agent", userAgent)
+	return t.base.RoundTrip(req)
+}
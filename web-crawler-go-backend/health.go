@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// Readiness checks give up after this long, probes time out soon after
+	readinessTimeoutSeconds = 2
+
+	readinessCheckRedis      = "redis"
+	readinessCheckSubscriber = "subscriber"
+)
+
+type (
+	HealthResponse struct {
+		Status string `json:"status"`
+	}
+	ReadinessResponse struct {
+		Ready  bool             `json:"ready"`
+		Checks []ReadinessCheck `json:"checks"`
+	}
+	ReadinessCheck struct {
+		Name  string `json:"name"`
+		OK    bool   `json:"ok"`
+		Error string `json:"error,omitempty"`
+	}
+)
+
+// Set in main for processes that consume jobs, the others only serve the API
+var consumesJobs bool
+
+// What this process's job consumer last ran into, if it has one
+var subscriber = struct {
+	sync.Mutex
+	started bool
+	err     error
+}{}
+
+// reportSubscriber records how the job consumer's last read of the queue went,
+// nil for a successful read, even one that found nothing
+func reportSubscriber(err error) {
+	subscriber.Lock()
+	defer subscriber.Unlock()
+	subscriber.started = true
+	subscriber.err = err
+}
+
+// checkSubscriber tells whether jobs can be picked up. A process consuming
+// jobs checks its own consumer, one that only serves the API needs a live worker
+func checkSubscriber(rdb *redis.Client) error {
+	if !consumesJobs {
+		workers, err := loadWorkers(rdb)
+		if err != nil {
+			return err
+		}
+		if len(workers) == 0 {
+			return errors.New("no worker has sent a heartbeat recently")
+		}
+		return nil
+	}
+	subscriber.Lock()
+	defer subscriber.Unlock()
+	if !subscriber.started {
+		return errors.New("not consuming jobs yet")
+	}
+	return subscriber.err
+}
+
+// Health handler - GET /healthz
+func healthHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
+	sendJSONResponse(w, http.StatusOK, HealthResponse{Status: "ok"})
+}
+
+// Readiness handler - GET /readyz, 503 unless Redis answers and jobs can be picked up
+func readinessHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
+	pingCtx, cancel := context.WithTimeout(r.Context(), readinessTimeoutSeconds*time.Second)
+	defer cancel()
+	response := ReadinessResponse{Ready: true}
+	check := func(name string, err error) {
+		result := ReadinessCheck{Name: name, OK: err == nil}
+		if err != nil {
+			result.Error = err.Error()
+			response.Ready = false
+		}
+		response.Checks = append(response.Checks, result)
+	}
+	redisErr := rdb.Ping(pingCtx).Err()
+	check(readinessCheckRedis, redisErr)
+	if redisErr != nil {
+		check(readinessCheckSubscriber, errors.New("redis is unreachable"))
+	} else {
+		check(readinessCheckSubscriber, checkSubscriber(rdb))
+	}
+
+	status := http.StatusOK
+	if !response.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	sendJSONResponse(w, status, response)
+}
@@ -0,0 +1,194 @@
+//go:build http3
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+const (
+	// How long an Alt-Svc advertisement holds when it doesn't say (RFC 7838)
+	defaultAltSvcMaxAge = 24 * time.Hour
+	// How long an origin whose HTTP/3 failed is only fetched from over TCP
+	http3FailureBackoff = 10 * time.Minute
+	// QUIC doesn't hear about servers going away, so connections are kept
+	// alive with pings and given up on once they go unanswered this long
+	http3IdleTimeout = 10 * time.Second
+)
+
+var http3Metric = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "crawler_http3_requests_total",
+	Help: "Requests to origins advertising HTTP/3, by result: h3, or fallback when HTTP/3 failed and TCP was used instead.",
+}, []string{"result"})
+
+// Set from -http3, experimental
+var fetchHTTP3 bool
+
+// Whether the binary was built with -tags http3, which brings in quic-go
+const http3Supported = true
+
+type (
+	// altSvcTransport sends requests to the origins that advertised HTTP/3
+	// with Alt-Svc over QUIC, and everything else over tcp. A request whose
+	// HTTP/3 attempt fails is sent again over tcp
+	altSvcTransport struct {
+		tcp http.RoundTripper
+		h3  *http3.Transport
+		sync.Mutex
+		// host:port of the origin -> where it serves HTTP/3
+		alternatives map[string]altService
+	}
+	altService struct {
+		// UDP port on the origin's host
+		port    string
+		expires time.Time
+		// HTTP/3 failed, the origin isn't tried again until expires
+		broken bool
+	}
+)
+
+func newAltSvcTransport(tcp http.RoundTripper) *altSvcTransport {
+	transport := &altSvcTransport{tcp: tcp, alternatives: make(map[string]altService)}
+	transport.h3 = &http3.Transport{
+		QUICConfig: &quic.Config{HandshakeIdleTimeout: dialTimeout, MaxIdleTimeout: http3IdleTimeout, KeepAlivePeriod: http3IdleTimeout / 2},
+		Dial:       transport.dial,
+	}
+	return transport
+}
+
+// Helper function to get the host:port of the origin a request goes to
+func originAddr(req *http.Request) string {
+	if req.URL.Port() != "" {
+		return req.URL.Host
+	}
+	return net.JoinHostPort(req.URL.Hostname(), "443")
+}
+
+func (t *altSvcTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	origin := originAddr(req)
+	// Only requests without a body can be sent again over tcp, and QUIC can't
+	// go through the proxies requests may be sent through
+	if req.URL.Scheme == "https" && (req.Body == nil || req.Body == http.NoBody) && t.advertised(origin) && !proxied(req) {
+		resp, err := t.h3.RoundTrip(req)
+		if err == nil {
+			http3Metric.WithLabelValues("h3").Inc()
+			return resp, nil
+		}
+		if req.Context().Err() != nil {
+			return nil, err
+		}
+		withError(logger.Debug(), err).Str("origin", origin).Msg("HTTP/3 failed, falling back to TCP")
+		http3Metric.WithLabelValues("fallback").Inc()
+		t.Lock()
+		t.alternatives[origin] = altService{broken: true, expires: time.Now().Add(http3FailureBackoff)}
+		t.Unlock()
+	}
+	resp, err := t.tcp.RoundTrip(req)
+	if err == nil && req.URL.Scheme == "https" {
+		t.learn(origin, req.URL.Hostname(), resp.Header.Values("Alt-Svc"))
+	}
+	return resp, err
+}
+
+// Helper function to tell whether an origin currently advertises HTTP/3
+func (t *altSvcTransport) advertised(origin string) bool {
+	t.Lock()
+	defer t.Unlock()
+	alternative, ok := t.alternatives[origin]
+	return ok && !alternative.broken && time.Now().Before(alternative.expires)
+}
+
+// learn records what an origin's Alt-Svc headers say about HTTP/3, unless it
+// failed recently
+func (t *altSvcTransport) learn(origin, host string, values []string) {
+	if len(values) == 0 {
+		return
+	}
+	port, maxAge, cleared := parseAltSvcH3(host, values)
+	t.Lock()
+	defer t.Unlock()
+	if current, ok := t.alternatives[origin]; ok && current.broken && time.Now().Before(current.expires) {
+		return
+	}
+	switch {
+	case cleared:
+		delete(t.alternatives, origin)
+	case port != "":
+		t.alternatives[origin] = altService{port: port, expires: time.Now().Add(maxAge)}
+	}
+}
+
+// dial connects to the UDP port the origin advertised instead of its tcp one
+func (t *altSvcTransport) dial(ctx context.Context, addr string, tlsConfig *tls.Config, config *quic.Config) (quic.EarlyConnection, error) {
+	t.Lock()
+	alternative := t.alternatives[addr]
+	t.Unlock()
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if alternative.port != "" {
+		port = alternative.port
+	}
+	if strings.HasSuffix(host, ".localhost") {
+		host = loopbackAddress()
+	}
+	if (crawlResolver != nil || ipFamily != ipFamilyAny) && net.ParseIP(host) == nil {
+		ips, err := resolveForDial(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		host = ips[0].String()
+	}
+	return quic.DialAddrEarly(ctx, net.JoinHostPort(host, port), tlsConfig, config)
+}
+
+// parseAltSvcH3 returns the port Alt-Svc header values advertise HTTP/3 on,
+// on the origin's own host, and how long for. Alternatives on other hosts
+// aren't used. cleared is set by "clear", which withdraws earlier advertisements
+func parseAltSvcH3(host string, values []string) (port string, maxAge time.Duration, cleared bool) {
+	for _, value := range values {
+		for _, entry := range strings.Split(value, ",") {
+			params := strings.Split(entry, ";")
+			alternative := strings.TrimSpace(params[0])
+			if alternative == "clear" {
+				return "", 0, true
+			}
+			equals := strings.Index(alternative, "=")
+			if equals < 0 || alternative[:equals] != "h3" {
+				continue
+			}
+			authority, err := strconv.Unquote(strings.TrimSpace(alternative[equals+1:]))
+			if err != nil {
+				continue
+			}
+			altHost, altPort, err := net.SplitHostPort(authority)
+			if err != nil || (altHost != "" && !strings.EqualFold(altHost, host)) {
+				continue
+			}
+			maxAge = defaultAltSvcMaxAge
+			for _, param := range params[1:] {
+				param = strings.TrimSpace(param)
+				if strings.HasPrefix(param, "ma=") {
+					if seconds, err := strconv.Atoi(strings.TrimPrefix(param, "ma=")); err == nil {
+						maxAge = time.Duration(seconds) * time.Second
+					}
+				}
+			}
+			return altPort, maxAge, false
+		}
+	}
+	return "", 0, false
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// How long what a crawl learned about a page is kept for later crawls to revalidate
+const httpCacheTTL = 30 * 24 * time.Hour
+
+// cachedPage is what crawls with httpCache remember of a page, so later ones
+// can ask whether it changed and reuse its links if it didn't
+type cachedPage struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+	// Every link found, before the crawl's scopes and the blocked domains
+	Links []string   `json:"links"`
+	Feeds []PageFeed `json:"feeds,omitempty"`
+	// Of the body, unchanged pages report it too
+	ContentHash string `json:"contentHash,omitempty"`
+}
+
+// Helper function to build the redis key holding what's cached of a page.
+// Shared by every crawl, urls are hashed to keep keys short
+func httpCacheKey(url string) string {
+	return fmt.Sprintf("go-crawler-http-cache-%x", sha256.Sum256([]byte(url)))
+}
+
+// Helper function to load what's cached of a page, nil if nothing is
+func loadCachedPage(rdb *redis.Client, url string) *cachedPage {
+	raw, err := rdb.Get(ctx, httpCacheKey(url)).Result()
+	if err != nil {
+		return nil
+	}
+	var cached cachedPage
+	if json.Unmarshal([]byte(raw), &cached) != nil {
+		return nil
+	}
+	return &cached
+}
+
+// Helper function to ask the server for the page only if it changed since it was cached
+func (cached *cachedPage) addConditions(req *http.Request) {
+	if cached.ETag != "" {
+		req.Header.Set("If-None-Match", cached.ETag)
+	}
+	if cached.LastModified != "" {
+		req.Header.Set("If-Modified-Since", cached.LastModified)
+	}
+}
+
+// storeCachedPage remembers the validators and links of a page, if the server
+// gave it validators to revalidate it with
+func storeCachedPage(rdb *redis.Client, uniqueID, url string, header http.Header, contentHash string, links []string, feeds []PageFeed) {
+	cached := cachedPage{ETag: header.Get("ETag"), LastModified: header.Get("Last-Modified"), Links: links, ContentHash: contentHash}
+	if cached.ETag == "" && cached.LastModified == "" {
+		return
+	}
+	// Only what the page says about its feeds, the next crawl reads them again if it follows feeds
+	for _, feed := range feeds {
+		cached.Feeds = append(cached.Feeds, PageFeed{URL: feed.URL, Type: feed.Type, Title: feed.Title})
+	}
+	encoded, err := json.Marshal(cached)
+	if err != nil {
+		return
+	}
+	if err := rdb.Set(ctx, httpCacheKey(url), encoded, httpCacheTTL).Err(); err != nil {
+		withError(crawlLogger(uniqueID).Error(), err).Str("url", url).Msg("Failed to cache page")
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	idempotencyHeader = "Idempotency-Key"
+	// Set on answers that are a replay of the first request with the same key
+	idempotentReplayHeader = "Idempotent-Replayed"
+	// How long a key is remembered, retries after that start a new crawl
+	idempotencyTTL = 24 * time.Hour
+	// A request still being handled holds its key this long at most, in case its process dies
+	idempotencyPendingTTL = time.Minute
+	maxIdempotencyKeyLen  = 255
+)
+
+// idempotentResponse is what's kept under a key: the request that first used
+// it and, once it's answered, the answer
+type idempotentResponse struct {
+	Fingerprint string `json:"fingerprint"`
+	Pending     bool   `json:"pending,omitempty"`
+	Status      int    `json:"status,omitempty"`
+	ContentType string `json:"contentType,omitempty"`
+	Body        []byte `json:"body,omitempty"`
+}
+
+// Helper function to build the redis key remembering an idempotency key, in
+// the caller's namespace so tenants can't replay each other's answers
+func idempotencyKey(namespace, key string) string {
+	return fmt.Sprintf("go-crawler-idempotency-%s-%x", namespace, sha256.Sum256([]byte(key)))
+}
+
+// responseCapture keeps a copy of the answer it passes on
+type responseCapture struct {
+	statusRecorder
+	body bytes.Buffer
+}
+
+func (capture *responseCapture) Write(data []byte) (int, error) {
+	capture.body.Write(data)
+	return capture.ResponseWriter.Write(data)
+}
+
+// withIdempotency makes a handler honour Idempotency-Key: the first request
+// with a key is handled and its successful answer kept, retries with the same
+// key and body get that answer again instead of being handled twice
+func withIdempotency(handler func(http.ResponseWriter, *http.Request, *redis.Client)) func(http.ResponseWriter, *http.Request, *redis.Client) {
+	return func(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
+		key := r.Header.Get(idempotencyHeader)
+		if key == "" {
+			handler(w, r, rdb)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLen {
+			sendErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("%s must be at most %d characters", idempotencyHeader, maxIdempotencyKeyLen))
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			sendErrorResponse(w, http.StatusBadRequest, "Failed to read request")
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		fingerprint := fmt.Sprintf("%x", sha256.Sum256(body))
+		redisKey := idempotencyKey(callerNamespace(r), key)
+
+		pending, _ := json.Marshal(idempotentResponse{Fingerprint: fingerprint, Pending: true})
+		claimed, err := rdb.SetNX(ctx, redisKey, pending, idempotencyPendingTTL).Result()
+		if err != nil {
+			sendErrorResponse(w, http.StatusInternalServerError, "Failed to check "+idempotencyHeader)
+			return
+		}
+		if !claimed {
+			replayIdempotent(w, rdb, redisKey, fingerprint)
+			return
+		}
+
+		capture := &responseCapture{statusRecorder: statusRecorder{ResponseWriter: w, status: http.StatusOK}}
+		handler(capture, r, rdb)
+		if capture.status < 200 || capture.status > 299 {
+			// Nothing was started, the client may fix the request and retry with the same key
+			rdb.Del(ctx, redisKey)
+			return
+		}
+		answer, _ := json.Marshal(idempotentResponse{
+			Fingerprint: fingerprint,
+			Status:      capture.status,
+			ContentType: capture.Header().Get("Content-Type"),
+			Body:        capture.body.Bytes(),
+		})
+		if err := rdb.Set(ctx, redisKey, answer, idempotencyTTL).Err(); err != nil {
+			withError(logger.Error(), err).Str("request_id", requestIDFromContext(r.Context())).Msg("Failed to save idempotent response")
+		}
+	}
+}
+
+// Helper function to answer a request whose key was already used
+func replayIdempotent(w http.ResponseWriter, rdb *redis.Client, redisKey, fingerprint string) {
+	raw, err := rdb.Get(ctx, redisKey).Bytes()
+	if err == redis.Nil {
+		// Expired or failed in between, the client can simply retry
+		sendErrorResponse(w, http.StatusConflict, "A request with this "+idempotencyHeader+" just finished, retry it")
+		return
+	}
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to check "+idempotencyHeader)
+		return
+	}
+	var previous idempotentResponse
+	if err := json.Unmarshal(raw, &previous); err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Corrupt idempotent response")
+		return
+	}
+	if previous.Fingerprint != fingerprint {
+		sendErrorResponse(w, http.StatusUnprocessableEntity, idempotencyHeader+" was already used for a different request")
+		return
+	}
+	if previous.Pending {
+		sendErrorResponse(w, http.StatusConflict, "A request with this "+idempotencyHeader+" is still being handled")
+		return
+	}
+	w.Header().Set("Content-Type", previous.ContentType)
+	w.Header().Set(idempotentReplayHeader, "true")
+	w.WriteHeader(previous.Status)
+	w.Write(previous.Body)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+)
+
+// How often a running crawl checks for urls injected through the API
+const injectionPollPeriod = time.Second
+
+type (
+	InjectURLsRequest struct {
+		URLs []string `json:"urls"`
+		// Optional, defaults to (and is capped at) the depth of the crawl itself
+		Depth int `json:"depth"`
+	}
+	InjectURLsResponse struct {
+		Injected []string `json:"injected"`
+		// Already visited, already queued, not crawlable or outside the crawl's scopes
+		Skipped []string `json:"skipped"`
+	}
+)
+
+// Helper function to build the redis key holding the urls waiting to join a crawl's frontier
+func injectedURLsKey(uniqueID string) string {
+	return fmt.Sprintf("go-crawler-injected-%s", uniqueID)
+}
+
+// Helper function to check a url is one the fetcher would follow itself
+func isCrawlableURL(rawURL string) bool {
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") {
+		return false
+	}
+	_, err = getDomainFromURL(rawURL)
+	return err == nil
+}
+
+// popInjectedURLs drains the urls injected into a crawl since the last call
+func popInjectedURLs(rdb *redis.Client, uniqueID string) []frontierItem {
+	items := []frontierItem{}
+	for {
+		raw, err := rdb.LPop(ctx, injectedURLsKey(uniqueID)).Bytes()
+		if err != nil {
+			if err != redis.Nil {
+				withError(crawlLogger(uniqueID).Error(), err).Msg("Failed to read injected urls")
+			}
+			return items
+		}
+		var item frontierItem
+		if err := json.Unmarshal(raw, &item); err != nil {
+			continue
+		}
+		items = append(items, item)
+	}
+}
+
+// Inject urls handler - POST /crawl/{crawl_ID}/urls
+func injectURLsHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
+	policy, ok := requireTenant(w, r)
+	if !ok {
+		return
+	}
+	var req InjectURLsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	if len(req.URLs) == 0 {
+		sendErrorResponse(w, http.StatusBadRequest, "At least one URL is required")
+		return
+	}
+	if req.Depth < 0 {
+		sendErrorResponse(w, http.StatusBadRequest, "depth must not be negative")
+		return
+	}
+
+	// Only running (or resumable) crawls have a checkpoint, finished ones have nothing to inject into
+	crawlID := mux.Vars(r)["crawl_ID"]
+	checkpoint, err := loadCheckpoint(rdb, crawlID)
+	if err == redis.Nil {
+		sendErrorResponse(w, http.StatusNotFound, "Crawl is not running")
+		return
+	}
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to get checkpoint")
+		return
+	}
+	if !ownsCrawl(policy, checkpoint.Limits) {
+		sendErrorResponse(w, http.StatusForbidden, "Crawl belongs to another tenant")
+		return
+	}
+	if checkpoint.StatusOf != "" {
+		sendErrorResponse(w, http.StatusConflict, "Status crawls only check the urls of the crawl they were started from")
+		return
+	}
+	depth := checkpoint.Depth
+	if req.Depth > 0 && req.Depth < depth {
+		depth = req.Depth
+	}
+
+	// Best effort dedup, the worker's visited set has the final say
+	seen := make(map[string]bool, len(checkpoint.Visited)+len(checkpoint.Frontier))
+	for _, visited := range checkpoint.Visited {
+		seen[visited] = true
+	}
+	for _, item := range checkpoint.Frontier {
+		seen[item.URL] = true
+	}
+
+	response := InjectURLsResponse{Injected: []string{}, Skipped: []string{}}
+	pipe := rdb.TxPipeline()
+	for _, injected := range req.URLs {
+		if seen[injected] || !isCrawlableURL(injected) || (checkpoint.Limits != nil && !checkpoint.Limits.inScope(injected)) || currentPolicy().blocks(injected) {
+			response.Skipped = append(response.Skipped, injected)
+			continue
+		}
+		seen[injected] = true
+		marshalled, _ := json.Marshal(frontierItem{URL: injected, Depth: depth})
+		pipe.RPush(ctx, injectedURLsKey(crawlID), marshalled)
+		response.Injected = append(response.Injected, injected)
+	}
+	if len(response.Injected) > 0 {
+		expireLikeCheckpoint(pipe, injectedURLsKey(crawlID))
+		if _, err := pipe.Exec(ctx); err != nil {
+			sendErrorResponse(w, http.StatusInternalServerError, "Failed to inject urls")
+			return
+		}
+	}
+	sendJSONResponse(w, http.StatusAccepted, response)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+)
+
+// Which addresses of a host crawls connect to, set with -ip-family
+const (
+	// Both, the way Go dials: the first address's family, falling back to the other
+	ipFamilyAny = "any"
+	// Every address of the family first, then the others
+	ipFamilyPreferIPv4 = "prefer-ipv4"
+	ipFamilyPreferIPv6 = "prefer-ipv6"
+	// Only addresses of the family, hosts without one can't be fetched
+	ipFamilyIPv4 = "ipv4"
+	ipFamilyIPv6 = "ipv6"
+)
+
+// Set from -ip-family, checked at startup
+var ipFamily = ipFamilyAny
+
+func validateIPFamily(family string) error {
+	switch family {
+	case ipFamilyAny, ipFamilyPreferIPv4, ipFamilyPreferIPv6, ipFamilyIPv4, ipFamilyIPv6:
+		return nil
+	}
+	return fmt.Errorf("%q must be any, prefer-ipv4, prefer-ipv6, ipv4 or ipv6", family)
+}
+
+// Helper function to restrict a dial's network to the family -ip-family requires
+func familyNetwork(network string) string {
+	if network != "tcp" && network != "udp" {
+		return network
+	}
+	switch ipFamily {
+	case ipFamilyIPv4:
+		return network + "4"
+	case ipFamilyIPv6:
+		return network + "6"
+	}
+	return network
+}
+
+// orderAddresses puts the addresses of host in the order -ip-family wants
+// them tried, dropping the ones of a family it doesn't allow
+func orderAddresses(host string, ips []net.IP) ([]net.IP, error) {
+	wantIPv4 := ipFamily == ipFamilyIPv4 || ipFamily == ipFamilyPreferIPv4
+	switch ipFamily {
+	case ipFamilyIPv4, ipFamilyIPv6:
+		kept := make([]net.IP, 0, len(ips))
+		for _, ip := range ips {
+			if (ip.To4() != nil) == wantIPv4 {
+				kept = append(kept, ip)
+			}
+		}
+		if len(kept) == 0 {
+			return nil, &net.DNSError{Err: "no " + ipFamily + " address", Name: host, IsNotFound: true}
+		}
+		return kept, nil
+	case ipFamilyPreferIPv4, ipFamilyPreferIPv6:
+		ordered := append([]net.IP(nil), ips...)
+		sort.SliceStable(ordered, func(i, j int) bool {
+			return (ordered[i].To4() != nil) == wantIPv4 && (ordered[j].To4() != nil) != wantIPv4
+		})
+		return ordered, nil
+	}
+	return ips, nil
+}
+
+// Helper function to pick the loopback address *.localhost hosts are dialed
+// at, of the family -ip-family requires
+func loopbackAddress() string {
+	if ipFamily == ipFamilyIPv6 {
+		return "::1"
+	}
+	return "127.0.0.1"
+}
+
+// Helper function to name the family of the address a page was fetched from
+func addressFamily(addr net.Addr) string {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	switch {
+	case !ok:
+		return ""
+	case tcpAddr.IP.To4() != nil:
+		return "IPv4"
+	default:
+		return "IPv6"
+	}
+}
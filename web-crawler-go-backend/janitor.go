@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	janitorPeriodInSeconds = 60
+	// A crawl that hasn't found a page in this long is given up on, whether
+	// its worker died or is still alive but hung
+	stuckCrawlTimeout = 10 * time.Minute
+	// Running totals of what the janitor cleaned up
+	janitorStatsKey = "go-crawler-janitor-stats"
+	janitorScanSize = 100
+)
+
+type JanitorStats struct {
+	LastRun time.Time `json:"lastRun"`
+	Runs    int64     `json:"runs"`
+	// Results lists with no expiry whose crawl has no checkpoint, left behind by a crashed worker
+	OrphanedResults int64 `json:"orphanedResults"`
+	// Crawls whose checkpoint shows no progress for longer than stuckCrawlTimeout
+	StuckCrawls int64 `json:"stuckCrawls"`
+}
+
+// Tells a hung crawl that the janitor gave up on it, see saveCheckpoint
+var errCrawlAbandoned = errors.New("crawl was abandoned by the janitor")
+
+// runJanitor periodically cleans up after crawls that will never finish on
+// their own. Any number of processes can run it, only the elected leader does the work
+func runJanitor(rdb *redis.Client) {
+	election := newLeaderElection(rdb, leaderRoleJanitor)
+	ticker := time.NewTicker(janitorPeriodInSeconds * time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		if !election.isLeader() {
+			continue
+		}
+		cleanUp(rdb)
+	}
+}
+
+func cleanUp(rdb *redis.Client) {
+	stuck, err := abandonStuckCrawls(rdb)
+	if err != nil {
+		withError(logger.Error(), err).Msg("Failed to look for stuck crawls")
+	}
+	// Stuck crawls are handled first, so their results lists aren't counted as orphans too
+	orphaned, err := expireOrphanedResults(rdb)
+	if err != nil {
+		withError(logger.Error(), err).Msg("Failed to look for orphaned results")
+	}
+	if stuck > 0 || orphaned > 0 {
+		logger.Info().Int64("stuck", stuck).Int64("orphaned", orphaned).Msg("Janitor abandoned stuck crawls and expired orphaned results lists")
+	}
+
+	pipe := rdb.TxPipeline()
+	pipe.HIncrBy(ctx, janitorStatsKey, "runs", 1)
+	pipe.HIncrBy(ctx, janitorStatsKey, "stuckCrawls", stuck)
+	pipe.HIncrBy(ctx, janitorStatsKey, "orphanedResults", orphaned)
+	pipe.HSet(ctx, janitorStatsKey, "lastRun", time.Now().Format(time.RFC3339))
+	if _, err := pipe.Exec(ctx); err != nil {
+		withError(logger.Error(), err).Msg("Failed to save janitor stats")
+	}
+}
+
+// Helper function to list every key matching pattern without blocking Redis
+func scanKeys(rdb *redis.Client, pattern string) ([]string, error) {
+	var keys []string
+	iter := rdb.Scan(ctx, 0, pattern, janitorScanSize).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	return keys, iter.Err()
+}
+
+// Helper function to close a crawl nobody will finish: readers see it as done,
+// its data expires like a finished crawl's, and it can no longer be resumed
+func abandonCrawl(rdb *redis.Client, crawlID string, ttl time.Duration) error {
+	_, done, err := resultStore.Range(crawlID, -1, -1)
+	if err != nil {
+		return err
+	}
+	if !done {
+		if err := resultStore.MarkDone(crawlID); err != nil {
+			return err
+		}
+	}
+	expireCrawlData(rdb, crawlID, ttl)
+	return rdb.Del(ctx, checkpointKey(crawlID), injectedURLsKey(crawlID)).Err()
+}
+
+func abandonStuckCrawls(rdb *redis.Client) (int64, error) {
+	keys, err := scanKeys(rdb, checkpointKey("*"))
+	if err != nil {
+		return 0, err
+	}
+	var abandoned int64
+	for _, key := range keys {
+		raw, err := rdb.Get(ctx, key).Bytes()
+		if err != nil {
+			continue
+		}
+		var checkpoint crawlCheckpoint
+		if err := json.Unmarshal(raw, &checkpoint); err != nil {
+			continue
+		}
+		lastProgress := checkpoint.LastProgress
+		if lastProgress.IsZero() {
+			// Written before checkpoints tracked progress
+			lastProgress = checkpoint.UpdatedAt
+		}
+		if time.Since(lastProgress) < stuckCrawlTimeout {
+			continue
+		}
+		crawlID := strings.TrimPrefix(key, checkpointKey(""))
+		ttl := currentPolicy().resultsTTL
+		if checkpoint.ResultsTTLSeconds > 0 {
+			ttl = time.Duration(checkpoint.ResultsTTLSeconds) * time.Second
+		}
+		if err := abandonCrawl(rdb, crawlID, ttl); err != nil {
+			withError(crawlLogger(crawlID).Error(), err).Msg("Failed to abandon stuck crawl")
+			continue
+		}
+		crawlLogger(crawlID).Warn().Str("url", checkpoint.URL).Time("last_progress", lastProgress).Msg("Abandoned crawl after no progress")
+		abandoned++
+	}
+	return abandoned, nil
+}
+
+// A running crawl's results list has no expiry until it finishes, but it
+// always has a checkpoint. Without one, the worker died and the list would never expire
+func expireOrphanedResults(rdb *redis.Client) (int64, error) {
+	keys, err := scanKeys(rdb, resultsKey("*"))
+	if err != nil {
+		return 0, err
+	}
+	var expired int64
+	for _, key := range keys {
+		if ttl := rdb.TTL(ctx, key).Val(); ttl != -1 {
+			continue
+		}
+		crawlID := strings.TrimPrefix(key, resultsKey(""))
+		if rdb.Exists(ctx, checkpointKey(crawlID)).Val() != 0 {
+			continue
+		}
+		if err := abandonCrawl(rdb, crawlID, currentPolicy().resultsTTL); err != nil {
+			withError(crawlLogger(crawlID).Error(), err).Msg("Failed to expire orphaned results")
+			continue
+		}
+		expired++
+	}
+	return expired, nil
+}
+
+// Janitor stats handler - GET /admin/janitor
+func janitorStatsHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
+	raw, err := rdb.HGetAll(ctx, janitorStatsKey).Result()
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to get janitor stats")
+		return
+	}
+	var stats JanitorStats
+	stats.LastRun, _ = time.Parse(time.RFC3339, raw["lastRun"])
+	stats.Runs, _ = strconv.ParseInt(raw["runs"], 10, 64)
+	stats.OrphanedResults, _ = strconv.ParseInt(raw["orphanedResults"], 10, 64)
+	stats.StuckCrawls, _ = strconv.ParseInt(raw["stuckCrawls"], 10, 64)
+	sendJSONResponse(w, http.StatusOK, stats)
+}
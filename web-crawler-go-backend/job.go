@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Version of the job encoding written by this build. Workers refuse jobs from
+// a newer one rather than run them with fields they don't know about
+const jobVersion = 1
+
+// Options of the comma separated "url,uniqueID,option..." jobs queued before
+// versioned jobs existed. They're still read, so a queue drains across an upgrade
+const (
+	resumeCommand        = "resume"
+	statusOfOption       = "status="
+	storeBodiesOption    = "bodies="
+	excludeVisitedOption = "exclude="
+	resultsTTLOption     = "ttl="
+	traceOption          = "trace="
+	requestOption        = "request="
+	userOption           = "user="
+	depthOption          = "depth="
+	rateOption           = "rate="
+	scopeOption          = "scope="
+	tenantOption         = "tenant="
+	quotaOption          = "quota="
+	// Separated the host patterns of scopeOption, commas already separated options
+	scopeSeparator = "|"
+)
+
+// crawlJob is what the server queues for a worker, everything the crawl needs to run
+type crawlJob struct {
+	Version int    `json:"version"`
+	URL     string `json:"url"`
+	CrawlID string `json:"crawlID"`
+	// Pick up from the crawl's last checkpoint
+	Resume bool `json:"resume,omitempty"`
+	// Skip the pages another crawl already visited
+	ExcludeVisitedFrom string `json:"excludeVisitedFrom,omitempty"`
+	// Crawl the pages of the site's sitemap too, and the entries of the feeds pages link to
+	Sitemap     bool `json:"sitemap,omitempty"`
+	FollowFeeds bool `json:"followFeeds,omitempty"`
+	// Revalidate pages cached by earlier crawls instead of downloading them again
+	HTTPCache bool `json:"httpCache,omitempty"`
+	// Flag or prune the pages nearly the same as one already crawled
+	NearDuplicates string `json:"nearDuplicates,omitempty"`
+	// Sent in turn instead of the crawler's own User-Agent
+	UserAgents []string `json:"userAgents,omitempty"`
+	// Added to every request of the crawl
+	Headers map[string]string `json:"headers,omitempty"`
+	// Keep the cookies sites set, starting with Cookies
+	CookieJar bool          `json:"cookieJar,omitempty"`
+	Cookies   []CrawlCookie `json:"cookies,omitempty"`
+	// Authenticate the requests to the hosts they cover
+	Credentials []CrawlCredential `json:"credentials,omitempty"`
+	// Fetch through this proxy instead of the server's
+	Proxy string `json:"proxy,omitempty"`
+	// Spread the requests over the worker's -proxy-pool, picking proxies this way
+	ProxyPool string `json:"proxyPool,omitempty"`
+	// Load HTML pages in the worker's headless browser, crawling the links scripts add
+	Render bool `json:"render,omitempty"`
+	// Keep a screenshot of every page rendered in the -screenshot-store
+	Screenshots bool `json:"screenshots,omitempty"`
+	// Read the text, metadata and links of PDF and Word documents
+	ExtractDocuments bool `json:"extractDocuments,omitempty"`
+	// Makes this a status crawl of the urls another crawl found
+	StatusOf string `json:"statusOf,omitempty"`
+	// Audits every page fetched, see GET /crawl/{id}/seo
+	SEOAudit          bool         `json:"seoAudit,omitempty"`
+	SecurityHeaders   bool         `json:"securityHeaders,omitempty"`
+	StoreBodies       string       `json:"storeBodies,omitempty"`
+	ResultsTTLSeconds int          `json:"resultsTTLSeconds,omitempty"`
+	Limits            *CrawlLimits `json:"limits,omitempty"`
+	// W3C traceparent, ID of the API request and user of the token that queued the crawl
+	TraceParent string `json:"traceParent,omitempty"`
+	RequestID   string `json:"requestID,omitempty"`
+	UserID      string `json:"userID,omitempty"`
+	// Where to POST a summary once the crawl is over, and the API host and scheme its results are linked on
+	CallbackURL string `json:"callbackURL,omitempty"`
+	APIHost     string `json:"apiHost,omitempty"`
+	APIScheme   string `json:"apiScheme,omitempty"`
+	// Where to notify about the crawl on top of the global settings
+	Notify *NotifySettings `json:"notify,omitempty"`
+}
+
+// Helper function to start a job for url, carrying ctx's trace, and the
+// request and user it comes from if any
+func newCrawlJob(jobCtx context.Context, url string) crawlJob {
+	job := crawlJob{URL: url, TraceParent: traceParent(jobCtx), RequestID: requestIDFromContext(jobCtx)}
+	if caller, ok := tokenClaimsFromContext(jobCtx); ok {
+		job.UserID = caller.user
+	}
+	return job
+}
+
+// Helper function to queue a job for the workers
+func queueJob(job crawlJob) error {
+	job.Version = jobVersion
+	payload, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return jobs.enqueue(string(payload))
+}
+
+// decodeJob reads a job off the queue, in either encoding
+func decodeJob(payload string) (crawlJob, error) {
+	if !strings.HasPrefix(payload, "{") {
+		return parseLegacyJob(payload)
+	}
+	var job crawlJob
+	if err := json.Unmarshal([]byte(payload), &job); err != nil {
+		return crawlJob{}, fmt.Errorf("malformed job: %v", err)
+	}
+	if job.Version > jobVersion {
+		return crawlJob{}, fmt.Errorf("job is version %d, this worker only understands up to %d", job.Version, jobVersion)
+	}
+	if job.URL == "" || job.CrawlID == "" {
+		return crawlJob{}, errors.New("malformed job, url and crawlID are required")
+	}
+	return job, nil
+}
+
+// parseLegacyJob reads a comma separated job
+func parseLegacyJob(command string) (crawlJob, error) {
+	splitCommand := strings.Split(command, ",")
+	if len(splitCommand) < 2 || splitCommand[0] == "" || splitCommand[1] == "" {
+		return crawlJob{}, errors.New("malformed command, expected url,uniqueID")
+	}
+	job := crawlJob{URL: splitCommand[0], CrawlID: splitCommand[1]}
+	limits := CrawlLimits{}
+	for _, option := range splitCommand[2:] {
+		switch {
+		case option == resumeCommand:
+			job.Resume = true
+		case strings.HasPrefix(option, traceOption):
+			job.TraceParent = strings.TrimPrefix(option, traceOption)
+		case strings.HasPrefix(option, requestOption):
+			job.RequestID = strings.TrimPrefix(option, requestOption)
+		case strings.HasPrefix(option, userOption):
+			job.UserID = strings.TrimPrefix(option, userOption)
+		case strings.HasPrefix(option, excludeVisitedOption):
+			job.ExcludeVisitedFrom = strings.TrimPrefix(option, excludeVisitedOption)
+		case strings.HasPrefix(option, statusOfOption):
+			job.StatusOf = strings.TrimPrefix(option, statusOfOption)
+		case strings.HasPrefix(option, storeBodiesOption):
+			job.StoreBodies = strings.TrimPrefix(option, storeBodiesOption)
+		case strings.HasPrefix(option, resultsTTLOption):
+			job.ResultsTTLSeconds, _ = strconv.Atoi(strings.TrimPrefix(option, resultsTTLOption))
+		case strings.HasPrefix(option, depthOption):
+			limits.Depth, _ = strconv.Atoi(strings.TrimPrefix(option, depthOption))
+		case strings.HasPrefix(option, rateOption):
+			limits.Rate, _ = strconv.ParseFloat(strings.TrimPrefix(option, rateOption), 64)
+		case strings.HasPrefix(option, scopeOption):
+			limits.Scopes = strings.Split(strings.TrimPrefix(option, scopeOption), scopeSeparator)
+		case strings.HasPrefix(option, tenantOption):
+			limits.Tenant = strings.TrimPrefix(option, tenantOption)
+		case strings.HasPrefix(option, quotaOption):
+			parts := strings.SplitN(strings.TrimPrefix(option, quotaOption), "/", 2)
+			if len(parts) == 2 {
+				limits.QuotaIdentity = parts[0]
+				limits.PagesPerDay, _ = strconv.Atoi(parts[1])
+			}
+		}
+	}
+	job.Limits = &limits
+	return job, nil
+}
+
+// crawlLimits returns what the job's crawl is allowed. Checked by the server,
+// but the queue may hold anything
+func (job crawlJob) crawlLimits(policy crawlPolicy) CrawlLimits {
+	limits := CrawlLimits{}
+	if job.Limits != nil {
+		limits = *job.Limits
+	}
+	if limits.Depth <= 0 || limits.Depth > policy.maxDepth {
+		limits.Depth = policy.maxDepth
+	}
+	if limits.Rate < 0 {
+		limits.Rate = 0
+	}
+	if limits.QuotaIdentity == "" || limits.PagesPerDay <= 0 {
+		limits.QuotaIdentity, limits.PagesPerDay = "", 0
+	}
+	return limits
+}
+
+// resultsTTL returns how long the job's results are kept, the policy's default
+// unless it asked for something valid
+func (job crawlJob) resultsTTL(policy crawlPolicy) time.Duration {
+	if job.ResultsTTLSeconds > 0 && job.ResultsTTLSeconds <= maxCrawlResultsTTL {
+		return time.Duration(job.ResultsTTLSeconds) * time.Second
+	}
+	return policy.resultsTTL
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// Crawl commands wait here until a worker picks them up
+	jobQueueKey = "go-crawler-jobs"
+	// Workers with commands in a processing list of their own, see processingQueueKey
+	processingWorkersKey = "go-crawler-jobs-processing-workers"
+	// How often list workers look for the processing lists of dead workers
+	processingSweepSeconds = 30
+	queueRetryDelaySeconds = 1
+
+	// In stream mode, workers share jobs through a consumer group instead
+	jobStreamKey   = "go-crawler-job-stream"
+	jobStreamGroup = "go-crawler-workers"
+	// Workers touch their pending jobs this often to show they're still alive
+	streamHeartbeatSeconds = 10
+	// Pending jobs left untouched for this long belong to a dead worker
+	streamClaimIdleSeconds = 60
+	streamBlockSeconds     = 5
+
+	queueModeList   = "list"
+	queueModeStream = "stream"
+	queueModeKafka  = "kafka"
+	queueModeAMQP   = "amqp"
+	queueModeSQS    = "sqs"
+)
+
+type (
+	// jobQueue carries crawl commands from the API to the workers
+	jobQueue interface {
+		enqueue(command string) error
+		// length counts the jobs no worker has picked up yet
+		length() (int64, error)
+		// consume blocks forever, handing each queued command to handle and
+		// acknowledging it once handle returns. Failed jobs are dead-lettered
+		consume(handle func(command string) error)
+	}
+	redisListQueue struct {
+		rdb *redis.Client
+	}
+	redisStreamQueue struct {
+		rdb *redis.Client
+	}
+)
+
+// jobQueueMode picks how commands travel from the API to the workers
+var jobQueueMode = queueModeList
+
+// jobs is the queue picked by jobQueueMode, set up once in main
+var jobs jobQueue
+
+func (q redisListQueue) enqueue(command string) error {
+	return q.rdb.LPush(ctx, jobQueueKey, command).Err()
+}
+
+func (q redisListQueue) length() (int64, error) {
+	return q.rdb.LLen(ctx, jobQueueKey).Result()
+}
+
+func (q redisStreamQueue) enqueue(command string) error {
+	return q.rdb.XAdd(ctx, &redis.XAddArgs{
+		Stream: jobStreamKey,
+		Values: map[string]interface{}{"command": command},
+	}).Err()
+}
+
+func (q redisStreamQueue) length() (int64, error) {
+	// Delivered but unacknowledged jobs are still in the stream, don't count them
+	length, err := q.rdb.XLen(ctx, jobStreamKey).Result()
+	if err != nil {
+		return 0, err
+	}
+	pending, err := q.rdb.XPending(ctx, jobStreamKey, jobStreamGroup).Result()
+	if err != nil {
+		return 0, err
+	}
+	return length - pending.Count, nil
+}
+
+// Helper function to build the redis key of a worker's processing list. A
+// worker's commands move there while it works on them, and are only removed
+// once the crawl finishes
+func processingQueueKey(worker string) string {
+	return fmt.Sprintf("go-crawler-jobs-processing-%s", worker)
+}
+
+// requeueProcessingJobs puts back the commands of workers that stopped
+// heartbeating while working on them, so their interrupted crawls get
+// retried. Live workers keep theirs
+func requeueProcessingJobs(rdb *redis.Client, consumer string) {
+	workers, err := rdb.SMembers(ctx, processingWorkersKey).Result()
+	if err != nil {
+		withError(logger.Error(), err).Msg("Failed to list processing jobs")
+		return
+	}
+	for _, worker := range workers {
+		if worker == consumer {
+			continue
+		}
+		alive, err := rdb.Exists(ctx, workerKey(worker)).Result()
+		if err != nil || alive > 0 {
+			continue
+		}
+		// RPOPLPUSH moves each command once, even with several workers requeueing
+		for {
+			command, err := rdb.RPopLPush(ctx, processingQueueKey(worker), jobQueueKey).Result()
+			if err == redis.Nil {
+				rdb.SRem(ctx, processingWorkersKey, worker)
+				break
+			}
+			if err != nil {
+				withError(logger.Error(), err).Msg("Failed to requeue interrupted jobs")
+				return
+			}
+			logger.Info().Str("worker", worker).Str("command", redactJob(command)).Msg("Requeued interrupted job")
+		}
+	}
+}
+
+func (q redisListQueue) consume(handle func(command string) error) {
+	rdb := q.rdb
+	consumer := consumerName()
+	processing := processingQueueKey(consumer)
+	requeueProcessingJobs(rdb, consumer)
+	lastSweep := time.Now()
+	reportSubscriber(nil)
+	for {
+		if time.Since(lastSweep) >= processingSweepSeconds*time.Second {
+			requeueProcessingJobs(rdb, consumer)
+			lastSweep = time.Now()
+		}
+		// Registered before every pop, so a job is never in a list the sweep doesn't
+		// know about, even if a sweep took this worker for dead and dropped it
+		rdb.SAdd(ctx, processingWorkersKey, consumer)
+		// Blocks for a while only, so a consumer that can reach Redis again says so soon
+		command, err := rdb.BRPopLPush(ctx, jobQueueKey, processing, streamBlockSeconds*time.Second).Result()
+		if err == redis.Nil {
+			reportSubscriber(nil)
+			continue
+		}
+		reportSubscriber(err)
+		if err != nil {
+			withError(logger.Error(), err).Msg("Failed to read from job queue")
+			time.Sleep(queueRetryDelaySeconds * time.Second)
+			continue
+		}
+		go func() {
+			runJob(rdb, command, handle)
+			rdb.LRem(ctx, processing, 1, command)
+		}()
+	}
+}
+
+// consume joins the worker consumer group, so every job is delivered
+// to exactly one worker and stays pending until acknowledged
+func (q redisStreamQueue) consume(handle func(command string) error) {
+	rdb := q.rdb
+	consumer := consumerName()
+	err := rdb.XGroupCreateMkStream(ctx, jobStreamKey, jobStreamGroup, "0").Err()
+	if err != nil && strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		// Some worker created it before us
+		err = nil
+	}
+	if err != nil {
+		withError(logger.Error(), err).Msg("Failed to create consumer group")
+	}
+	logger.Info().Str("group", jobStreamGroup).Str("consumer", consumer).Msg("Joined consumer group")
+	reportSubscriber(err)
+
+	for {
+		messages := claimAbandonedJobs(rdb, consumer)
+		streams, err := rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    jobStreamGroup,
+			Consumer: consumer,
+			Streams:  []string{jobStreamKey, ">"},
+			Count:    1,
+			Block:    streamBlockSeconds * time.Second,
+		}).Result()
+		if err == redis.Nil {
+			err = nil
+		}
+		reportSubscriber(err)
+		if err != nil {
+			withError(logger.Error(), err).Msg("Failed to read from job stream")
+			time.Sleep(queueRetryDelaySeconds * time.Second)
+			continue
+		}
+		for _, stream := range streams {
+			messages = append(messages, stream.Messages...)
+		}
+
+		for _, message := range messages {
+			command, ok := message.Values["command"].(string)
+			if !ok {
+				deadLetter(rdb, fmt.Sprint(message.Values), errors.New("job has no command field"), "")
+				rdb.XAck(ctx, jobStreamKey, jobStreamGroup, message.ID)
+				continue
+			}
+			go func(id string) {
+				stop := keepJobClaimed(rdb, consumer, id)
+				runJob(rdb, command, handle)
+				close(stop)
+				rdb.XAck(ctx, jobStreamKey, jobStreamGroup, id)
+				rdb.XDel(ctx, jobStreamKey, id)
+			}(message.ID)
+		}
+	}
+}
+
+// claimAbandonedJobs takes over jobs whose worker stopped heartbeating
+func claimAbandonedJobs(rdb *redis.Client, consumer string) []redis.XMessage {
+	pending, err := rdb.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: jobStreamKey,
+		Group:  jobStreamGroup,
+		Start:  "-",
+		End:    "+",
+		Count:  10,
+	}).Result()
+	if err != nil {
+		return nil
+	}
+	ids := []string{}
+	for _, entry := range pending {
+		if entry.Idle >= streamClaimIdleSeconds*time.Second {
+			ids = append(ids, entry.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	// MinIdle makes sure two workers can't both claim the same job
+	messages, err := rdb.XClaim(ctx, &redis.XClaimArgs{
+		Stream:   jobStreamKey,
+		Group:    jobStreamGroup,
+		Consumer: consumer,
+		MinIdle:  streamClaimIdleSeconds * time.Second,
+		Messages: ids,
+	}).Result()
+	if err != nil {
+		return nil
+	}
+	for _, message := range messages {
+		logger.Info().Str("message_id", message.ID).Msg("Claimed abandoned job")
+	}
+	return messages
+}
+
+// keepJobClaimed resets the job's idle time until stop is closed
+func keepJobClaimed(rdb *redis.Client, consumer, id string) chan struct{} {
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(streamHeartbeatSeconds * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				rdb.XClaimJustID(ctx, &redis.XClaimArgs{
+					Stream:   jobStreamKey,
+					Group:    jobStreamGroup,
+					Consumer: consumer,
+					Messages: []string{id},
+				})
+			}
+		}
+	}()
+	return stop
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	// Keys are fetched again this often, and when a token names one we don't have,
+	// but not more often than jwksMinRefreshInterval
+	jwksRefreshInterval    = time.Hour
+	jwksMinRefreshInterval = time.Minute
+	jwksTimeoutSeconds     = 10
+)
+
+// User IDs taken from tokens are stored with every crawl they start, so they're kept short
+var tokenIDPattern = regexp.MustCompile(`^[^,|]{1,256}$`)
+
+// Signing algorithms accepted, never "none" or the HMAC ones a public key can't check
+var jwtMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+// Set up in main when -jwks-url is given
+var jwtKeys *jwksCache
+
+// tokenClaims is who a verified token says is calling
+type tokenClaims struct {
+	tenant string
+	user   string
+}
+
+type tokenClaimsKey struct{}
+
+// jwksCache holds the identity provider's signing keys by their ID
+type jwksCache struct {
+	sync.Mutex
+	url     string
+	client  *http.Client
+	keys    map[string]interface{}
+	fetched time.Time
+}
+
+// Helper function to fetch the keys at url, failing if there are none usable
+func newJWKSCache(url string) (*jwksCache, error) {
+	cache := &jwksCache{url: url, client: &http.Client{Timeout: jwksTimeoutSeconds * time.Second}}
+	if err := cache.refresh(); err != nil {
+		return nil, err
+	}
+	return cache, nil
+}
+
+// refresh replaces the keys with the ones the JWKS endpoint serves now
+func (cache *jwksCache) refresh() error {
+	cache.fetched = time.Now()
+	resp, err := cache.client.Get(cache.url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s answered %d", cache.url, resp.StatusCode)
+	}
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return fmt.Errorf("%s is not a JWKS: %v", cache.url, err)
+	}
+	keys := map[string]interface{}{}
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		switch jwk.Kty {
+		case "RSA":
+			n, nErr := decodeJWKInt(jwk.N)
+			e, eErr := decodeJWKInt(jwk.E)
+			if nErr != nil || eErr != nil || !e.IsInt64() {
+				continue
+			}
+			keys[jwk.Kid] = &rsa.PublicKey{N: n, E: int(e.Int64())}
+		case "EC":
+			curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+			curve, ok := curves[jwk.Crv]
+			x, xErr := decodeJWKInt(jwk.X)
+			y, yErr := decodeJWKInt(jwk.Y)
+			if !ok || xErr != nil || yErr != nil || !curve.IsOnCurve(x, y) {
+				continue
+			}
+			keys[jwk.Kid] = &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+		}
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("%s has no RSA or EC signing keys", cache.url)
+	}
+	cache.keys = keys
+	return nil
+}
+
+// Helper function to decode a base64url number of a JWK
+func decodeJWKInt(encoded string) (*big.Int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || len(raw) == 0 {
+		return nil, errors.New("invalid key parameter")
+	}
+	return new(big.Int).SetBytes(raw), nil
+}
+
+// key returns the key with ID kid, fetching the keys again if they're old or
+// it's one we haven't seen, as the provider rotates them
+func (cache *jwksCache) key(kid string) (interface{}, error) {
+	cache.Lock()
+	defer cache.Unlock()
+	_, known := cache.keys[kid]
+	age := time.Since(cache.fetched)
+	if age > jwksRefreshInterval || (!known && age > jwksMinRefreshInterval) {
+		if err := cache.refresh(); err != nil {
+			// The keys we have are still good
+			withError(logger.Warn(), err).Msg("Failed to refresh JWKS")
+		}
+	}
+	if key, ok := cache.keys[kid]; ok {
+		return key, nil
+	}
+	// Tokens without a kid are fine when there's only one key to check them with
+	if kid == "" && len(cache.keys) == 1 {
+		for _, key := range cache.keys {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// verify checks a token's signature, expiry, issuer and audience, returning
+// the tenant and user it was issued for
+func (cache *jwksCache) verify(token string) (tokenClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(parsed *jwt.Token) (interface{}, error) {
+		kid, _ := parsed.Header["kid"].(string)
+		return cache.key(kid)
+	}, jwt.WithValidMethods(jwtMethods))
+	if err != nil {
+		return tokenClaims{}, err
+	}
+	if jwtIssuer != "" && !claims.VerifyIssuer(jwtIssuer, true) {
+		return tokenClaims{}, errors.New("token has the wrong issuer")
+	}
+	if jwtAudience != "" && !claims.VerifyAudience(jwtAudience, true) {
+		return tokenClaims{}, errors.New("token has the wrong audience")
+	}
+	caller := tokenClaims{}
+	caller.tenant, _ = claims[jwtTenantClaim].(string)
+	caller.user, _ = claims[jwtUserClaim].(string)
+	if !tokenIDPattern.MatchString(caller.user) {
+		return tokenClaims{}, fmt.Errorf("token's %s claim is missing or has a comma", jwtUserClaim)
+	}
+	if caller.tenant != "" && !tenantNamePattern.MatchString(caller.tenant) {
+		return tokenClaims{}, fmt.Errorf("token's %s claim isn't a tenant name of letters, digits, - and _", jwtTenantClaim)
+	}
+	return caller, nil
+}
+
+// Helper function to get who the verified token of ctx's request was issued for
+func tokenClaimsFromContext(requestCtx context.Context) (tokenClaims, bool) {
+	caller, ok := requestCtx.Value(tokenClaimsKey{}).(tokenClaims)
+	return caller, ok
+}
+
+// jwtMiddleware verifies bearer tokens that are JWTs, API keys are left to
+// tenantFor. A token that doesn't verify is refused whatever the endpoint
+func jwtMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if jwtKeys == nil || strings.Count(token, ".") != 2 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		caller, err := jwtKeys.verify(token)
+		if err != nil {
+			logger.Info().Err(err).Str("request_id", requestIDFromContext(r.Context())).Msg("Refused bearer token")
+			sendErrorResponse(w, http.StatusUnauthorized, "Invalid bearer token")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tokenClaimsKey{}, caller)))
+	})
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/segmentio/kafka-go"
+)
+
+// Workers in the fleet share the jobs topic through this consumer group
+const kafkaJobsGroup = "go-crawler-workers"
+
+type (
+	// kafkaJobQueue reads crawl commands from a Kafka topic. Redis is still
+	// used for results, dead letters and everything else
+	kafkaJobQueue struct {
+		rdb     *redis.Client
+		brokers []string
+		topic   string
+		writer  *kafka.Writer
+	}
+	// kafkaOffsets tracks the jobs a worker is running, so an offset is only
+	// committed once every job before it on its partition has finished
+	kafkaOffsets struct {
+		sync.Mutex
+		running map[int][]*kafkaJob
+	}
+	kafkaJob struct {
+		message kafka.Message
+		done    bool
+	}
+	// kafkaEdgeSink publishes every edge a crawl finds to a topic, keyed by crawl ID
+	kafkaEdgeSink struct {
+		writer *kafka.Writer
+	}
+	// EdgeEvent is one message on the edges topic. The last message of
+	// every crawl has Done set and no edge
+	EdgeEvent struct {
+		CrawlID   string        `json:"crawlID"`
+		Parent    string        `json:"parent,omitempty"`
+		Child     string        `json:"child,omitempty"`
+		Depth     int           `json:"depth,omitempty"`
+		TimeFound time.Duration `json:"timeFound,omitempty"`
+		Done      bool          `json:"done,omitempty"`
+	}
+)
+
+func newKafkaJobQueue(rdb *redis.Client, brokers []string, topic string) *kafkaJobQueue {
+	return &kafkaJobQueue{
+		rdb:     rdb,
+		brokers: brokers,
+		topic:   topic,
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(brokers...),
+			Topic:        topic,
+			Balancer:     &kafka.LeastBytes{},
+			RequiredAcks: kafka.RequireAll,
+		},
+	}
+}
+
+func (q *kafkaJobQueue) enqueue(command string) error {
+	return q.writer.WriteMessages(ctx, kafka.Message{Value: []byte(command)})
+}
+
+// length is the consumer group's lag, which also counts jobs that are
+// being worked on but not committed yet
+func (q *kafkaJobQueue) length() (int64, error) {
+	client := &kafka.Client{Addr: kafka.TCP(q.brokers...), Timeout: dialTimeout}
+	metadata, err := client.Metadata(ctx, &kafka.MetadataRequest{Topics: []string{q.topic}})
+	if err != nil {
+		return 0, err
+	}
+	if len(metadata.Topics) == 0 || metadata.Topics[0].Error != nil {
+		return 0, fmt.Errorf("topic %s not found", q.topic)
+	}
+	partitions := []int{}
+	lastOffsets := []kafka.OffsetRequest{}
+	for _, partition := range metadata.Topics[0].Partitions {
+		partitions = append(partitions, partition.ID)
+		lastOffsets = append(lastOffsets, kafka.LastOffsetOf(partition.ID))
+	}
+
+	committed, err := client.OffsetFetch(ctx, &kafka.OffsetFetchRequest{
+		GroupID: kafkaJobsGroup,
+		Topics:  map[string][]int{q.topic: partitions},
+	})
+	if err != nil {
+		return 0, err
+	}
+	latest, err := client.ListOffsets(ctx, &kafka.ListOffsetsRequest{
+		Topics: map[string][]kafka.OffsetRequest{q.topic: lastOffsets},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	committedOffsets := map[int]int64{}
+	for _, partition := range committed.Topics[q.topic] {
+		committedOffsets[partition.Partition] = partition.CommittedOffset
+	}
+	lag := int64(0)
+	for _, partition := range latest.Topics[q.topic] {
+		// A group that never committed on a partition has a negative offset
+		start, ok := committedOffsets[partition.Partition]
+		if !ok || start < 0 {
+			start = partition.FirstOffset
+		}
+		lag += partition.LastOffset - start
+	}
+	return lag, nil
+}
+
+func (q *kafkaJobQueue) consume(handle func(command string) error) {
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers: q.brokers,
+		Topic:   q.topic,
+		GroupID: kafkaJobsGroup,
+	})
+	defer reader.Close()
+	logger.Info().Str("group", kafkaJobsGroup).Str("topic", q.topic).Msg("Joined consumer group")
+	reportSubscriber(nil)
+
+	offsets := &kafkaOffsets{running: map[int][]*kafkaJob{}}
+	for {
+		message, err := reader.FetchMessage(ctx)
+		if err != nil {
+			withError(logger.Error(), err).Msg("Failed to read from job topic")
+			reportSubscriber(err)
+			time.Sleep(queueRetryDelaySeconds * time.Second)
+			// Reads block until there's a job, so the retry is as good as it gets for a sign of recovery
+			reportSubscriber(nil)
+			continue
+		}
+		job := offsets.start(message)
+		go func() {
+			runJob(q.rdb, string(job.message.Value), handle)
+			if commit, ok := offsets.finish(job); ok {
+				if err := reader.CommitMessages(ctx, commit); err != nil {
+					withError(logger.Error(), err).Msg("Failed to commit job offset")
+				}
+			}
+		}()
+	}
+}
+
+func (offsets *kafkaOffsets) start(message kafka.Message) *kafkaJob {
+	offsets.Lock()
+	defer offsets.Unlock()
+	job := &kafkaJob{message: message}
+	offsets.running[message.Partition] = append(offsets.running[message.Partition], job)
+	return job
+}
+
+// finish marks job as done, returning the message whose offset can now be
+// committed, if finishing it completed a run of jobs at the head of its partition
+func (offsets *kafkaOffsets) finish(job *kafkaJob) (kafka.Message, bool) {
+	offsets.Lock()
+	defer offsets.Unlock()
+	job.done = true
+	running := offsets.running[job.message.Partition]
+	finished := 0
+	for finished < len(running) && running[finished].done {
+		finished++
+	}
+	if finished == 0 {
+		return kafka.Message{}, false
+	}
+	offsets.running[job.message.Partition] = running[finished:]
+	return running[finished-1].message, true
+}
+
+func newKafkaEdgeSink(brokers []string, topic string) *kafkaEdgeSink {
+	return &kafkaEdgeSink{writer: &kafka.Writer{
+		Addr:  kafka.TCP(brokers...),
+		Topic: topic,
+		// Keying by crawl keeps each crawl's edges in order on one partition
+		Balancer: &kafka.Hash{},
+	}}
+}
+
+func (sink *kafkaEdgeSink) publish(crawlID string, node graphNode) error {
+	messages := make([]kafka.Message, 0, len(node.Children))
+	for _, child := range node.Children {
+		marshalled, _ := json.Marshal(EdgeEvent{CrawlID: crawlID, Parent: node.Parent, Child: child, Depth: node.Depth, TimeFound: node.TimeFound})
+		messages = append(messages, kafka.Message{Key: []byte(crawlID), Value: marshalled})
+	}
+	if len(messages) == 0 {
+		return nil
+	}
+	return sink.writer.WriteMessages(ctx, messages...)
+}
+
+func (sink *kafkaEdgeSink) finish(crawlID string) error {
+	marshalled, _ := json.Marshal(EdgeEvent{CrawlID: crawlID, Done: true})
+	return sink.writer.WriteMessages(ctx, kafka.Message{Key: []byte(crawlID), Value: marshalled})
+}
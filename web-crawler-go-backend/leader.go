@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// A leader that stops renewing is replaced once its lease runs out
+	leaderLeaseSeconds = 15
+	leaderRenewSeconds = 5
+
+	leaderRoleScheduler = "scheduler"
+	leaderRoleJanitor   = "janitor"
+	leaderRoleRetention = "retention"
+)
+
+// Every singleton subsystem, for reporting who leads each one
+var leaderRoles = []string{leaderRoleScheduler, leaderRoleJanitor, leaderRoleRetention}
+
+// Only extends the lease if we still hold it, another process may have taken over
+var renewLeaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// leaderElection lets any number of processes run a subsystem while only one
+// of them, the holder of a lease in Redis, actually does its work
+type leaderElection struct {
+	rdb    *redis.Client
+	role   string
+	id     string
+	leader int32
+}
+
+// Helper function to build the redis key holding a role's leader lease
+func leaderKey(role string) string {
+	return fmt.Sprintf("go-crawler-leader-%s", role)
+}
+
+// newLeaderElection starts campaigning for role in the background
+func newLeaderElection(rdb *redis.Client, role string) *leaderElection {
+	election := &leaderElection{rdb: rdb, role: role, id: consumerName()}
+	go election.campaign()
+	return election
+}
+
+func (election *leaderElection) isLeader() bool {
+	return atomic.LoadInt32(&election.leader) == 1
+}
+
+func (election *leaderElection) campaign() {
+	lease := leaderLeaseSeconds * time.Second
+	ticker := time.NewTicker(leaderRenewSeconds * time.Second)
+	defer ticker.Stop()
+	for {
+		held := false
+		var err error
+		if election.isLeader() {
+			var renewed int64
+			renewed, err = renewLeaseScript.Run(ctx, election.rdb, []string{leaderKey(election.role)}, election.id, lease.Milliseconds()).Int64()
+			held = renewed == 1
+		} else {
+			held, err = election.rdb.SetNX(ctx, leaderKey(election.role), election.id, lease).Result()
+		}
+		if err != nil {
+			// Without Redis we can't tell whether someone else took over, so step down
+			withError(logger.Error(), err).Str("role", election.role).Msg("Failed to renew leadership")
+			held = false
+		}
+
+		if held && !election.isLeader() {
+			logger.Info().Str("role", election.role).Msg("Became leader")
+		} else if !held && election.isLeader() {
+			logger.Warn().Str("role", election.role).Msg("Lost leadership")
+		}
+		if held {
+			atomic.StoreInt32(&election.leader, 1)
+		} else {
+			atomic.StoreInt32(&election.leader, 0)
+		}
+		<-ticker.C
+	}
+}
+
+// Helper function to report which process leads each role, empty if nobody does right now
+func loadLeaders(rdb *redis.Client) (map[string]string, error) {
+	leaders := make(map[string]string, len(leaderRoles))
+	for _, role := range leaderRoles {
+		leader, err := rdb.Get(ctx, leaderKey(role)).Result()
+		if err != nil && err != redis.Nil {
+			return nil, err
+		}
+		leaders[role] = leader
+	}
+	return leaders, nil
+}
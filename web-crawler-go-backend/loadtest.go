@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	// Number of pages on the synthetic site, page n links to the next
+	// -max-links pages after n*maxLinks (wrapping around)
+	syntheticSiteSize = 500
+	// A step that improves throughput by less than this is considered saturated
+	saturationThreshold = 1.1
+)
+
+type (
+	loadTestOptions struct {
+		crawls    int
+		latency   time.Duration
+		jitter    time.Duration
+		errorRate float64
+	}
+	loadTestStep struct {
+		concurrentCrawls int
+		pages            int
+		elapsed          time.Duration
+	}
+	// faultyTransport wraps the fetcher's transport, adding latency and random
+	// failures while the request holds one of the crawl's fetch slots
+	faultyTransport struct {
+		base      http.RoundTripper
+		latency   time.Duration
+		jitter    time.Duration
+		errorRate float64
+	}
+)
+
+var errInjected = errors.New("injected fetch error")
+
+func (t faultyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	delay := t.latency
+	if t.jitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(t.jitter)))
+	}
+	time.Sleep(delay)
+	if rand.Float64() < t.errorRate {
+		return nil, errInjected
+	}
+	return t.base.RoundTrip(req)
+}
+
+func (step loadTestStep) throughput() float64 {
+	return float64(step.pages) / step.elapsed.Seconds()
+}
+
+// Helper function to build the url of a page on the synthetic site
+func syntheticURL(port string, page int) string {
+	return fmt.Sprintf("http://s%d.localhost:%s/selftest/synthetic/%d", page, port, page)
+}
+
+// Synthetic site handler - GET /selftest/synthetic/{page}
+func syntheticSiteHandler(w http.ResponseWriter, r *http.Request, page int) {
+	_, port, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		port = "80"
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, "<!DOCTYPE html>\n<html><head><title>Synthetic page %d</title></head><body>\n", page)
+	maxLinks := currentPolicy().maxLinks
+	for i := 1; i <= maxLinks; i++ {
+		child := (page*maxLinks + i) % syntheticSiteSize
+		fmt.Fprintf(w, "<a href=\"%s\">Page %d</a>\n", syntheticURL(port, child), child)
+	}
+	fmt.Fprint(w, "</body></html>\n")
+}
+
+// runSimulatedCrawl crawls without touching redis, returning the number of pages found
+func runSimulatedCrawl(url string, fetcher Fetcher) int {
+	graphCh := make(chan graphNode)
+	doneCh := make(chan struct{}, 1)
+	session := &crawlSession{
+		startTime:   time.Now(),
+		urlMap:      &SafeMap{v: make(map[string]bool)},
+		frontier:    &frontier{pending: make(map[frontierItem]int)},
+		resultsChan: graphCh,
+	}
+	depth := currentPolicy().maxDepth
+	session.frontier.add(url, depth)
+	go func() {
+		Crawl(context.Background(), url, depth, fetcher, session)
+		doneCh <- struct{}{}
+	}()
+
+	pages := 0
+	for {
+		select {
+		case <-doneCh:
+			return pages
+		case <-graphCh:
+			pages++
+		}
+	}
+}
+
+// runLoadTest serves the synthetic site on a loopback port and crawls it with
+// an increasing number of concurrent crawls, reporting where throughput levels off
+func runLoadTest(opts loadTestOptions, client *http.Client) error {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	go http.Serve(listener, http.HandlerFunc(selfTestSiteHandler))
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	client = &http.Client{Transport: faultyTransport{
+		base:      client.Transport,
+		latency:   opts.latency,
+		jitter:    opts.jitter,
+		errorRate: opts.errorRate,
+	}}
+
+	fmt.Printf("Load test: up to %d concurrent crawls, latency %s (+%s jitter), error rate %.2f\n",
+		opts.crawls, opts.latency, opts.jitter, opts.errorRate)
+
+	var steps []loadTestStep
+	for concurrent := 1; ; concurrent *= 2 {
+		if concurrent > opts.crawls {
+			concurrent = opts.crawls
+		}
+		step := runLoadTestStep(concurrent, port, client)
+		steps = append(steps, step)
+		fmt.Printf("%4d crawls: %6d pages in %8s, %8.1f pages/sec\n",
+			step.concurrentCrawls, step.pages, step.elapsed.Round(time.Millisecond), step.throughput())
+		if concurrent == opts.crawls {
+			break
+		}
+	}
+
+	for i := 1; i < len(steps); i++ {
+		if steps[i].throughput() < steps[i-1].throughput()*saturationThreshold {
+			fmt.Printf("Saturated at %d concurrent crawls (%.1f pages/sec)\n",
+				steps[i-1].concurrentCrawls, steps[i-1].throughput())
+			return nil
+		}
+	}
+	fmt.Println("No saturation point found, try more crawls")
+	return nil
+}
+
+func runLoadTestStep(concurrent int, port string, client *http.Client) loadTestStep {
+	pagesCh := make(chan int, concurrent)
+	start := time.Now()
+	for i := 0; i < concurrent; i++ {
+		fetcher := realFetcher{client: client, guard: make(chan struct{}, currentPolicy().concurrency)}
+		// Spread the seeds out so crawls don't all start on the same page
+		seed := syntheticURL(port, i*syntheticSiteSize/concurrent)
+		go func() {
+			pagesCh <- runSimulatedCrawl(seed, fetcher)
+		}()
+	}
+
+	step := loadTestStep{concurrentCrawls: concurrent}
+	for i := 0; i < concurrent; i++ {
+		step.pages += <-pagesCh
+	}
+	step.elapsed = time.Since(start)
+	return step
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// Channel a new log level is published on, so every process picks it up
+	logLevelChannel = "go-crawler-log-level"
+
+	logFormatJSON    = "json"
+	logFormatConsole = "console"
+)
+
+// LogLevel is the level every process logs at, one of trace, debug, info, warn or error
+type LogLevel struct {
+	Level string `json:"level"`
+}
+
+// JSON lines on stdout until setupLogging says otherwise
+var logger = zerolog.New(os.Stdout).With().Timestamp().Logger()
+
+// setupLogging picks the minimum level logged and how lines are written
+func setupLogging(level, format string) error {
+	parsedLevel, err := parseLogLevel(level)
+	if err != nil {
+		return err
+	}
+	switch format {
+	case logFormatJSON:
+	case logFormatConsole:
+		logger = logger.Output(zerolog.ConsoleWriter{Out: os.Stdout})
+	default:
+		return fmt.Errorf("unknown log format %q, expected %s or %s", format, logFormatJSON, logFormatConsole)
+	}
+	zerolog.SetGlobalLevel(parsedLevel)
+	return nil
+}
+
+// Helper function to parse a log level, refusing the ones that would silence errors
+func parseLogLevel(level string) (zerolog.Level, error) {
+	parsedLevel, err := zerolog.ParseLevel(level)
+	if err != nil || level == "" || parsedLevel > zerolog.ErrorLevel {
+		return zerolog.NoLevel, fmt.Errorf("unknown log level %q, expected trace, debug, info, warn or error", level)
+	}
+	return parsedLevel, nil
+}
+
+// Helper function to get a logger that tags every line with a crawl's ID, and
+// the ID of the request that started it while this worker runs the crawl
+func crawlLogger(uniqueID string) *zerolog.Logger {
+	fields := logger.With().Str("crawl_id", uniqueID)
+	if requestID, ok := crawlRequestIDs.Load(uniqueID); ok {
+		fields = fields.Str("request_id", requestID.(string))
+	}
+	crawlLog := fields.Logger()
+	return &crawlLog
+}
+
+// Helper function to attach err and its class to a log line
+func withError(event *zerolog.Event, err error) *zerolog.Event {
+	return event.Err(err).Str("error_class", fetchErrorClass(err))
+}
+
+// watchLogLevel applies every log level published on logLevelChannel, by any process
+func watchLogLevel(rdb *redis.Client) {
+	for message := range rdb.Subscribe(ctx, logLevelChannel).Channel() {
+		level, err := parseLogLevel(message.Payload)
+		if err != nil {
+			continue
+		}
+		zerolog.SetGlobalLevel(level)
+		// Without a level of its own, so it's written whatever the new level is
+		logger.Log().Str("log_level", level.String()).Msg("Changed log level")
+	}
+}
+
+// Log level handler - GET /admin/log-level
+func getLogLevelHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
+	sendJSONResponse(w, http.StatusOK, LogLevel{Level: zerolog.GlobalLevel().String()})
+}
+
+// Log level handler - PUT /admin/log-level
+func setLogLevelHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
+	var req LogLevel
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	level, err := parseLogLevel(req.Level)
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	// Set here too, in case this process isn't subscribed yet
+	zerolog.SetGlobalLevel(level)
+	if err := rdb.Publish(ctx, logLevelChannel, level.String()).Err(); err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to publish log level")
+		return
+	}
+	sendJSONResponse(w, http.StatusOK, LogLevel{Level: level.String()})
+}
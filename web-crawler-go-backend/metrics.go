@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Classes fetch errors are counted under
+const (
+	fetchErrorTimeout    = "timeout"
+	fetchErrorCanceled   = "canceled"
+	fetchErrorDNS        = "dns"
+	fetchErrorRefused    = "refused"
+	fetchErrorTLS        = "tls"
+	fetchErrorRedirect   = "redirect"
+	fetchErrorOutOfScope = "out_of_scope"
+	fetchErrorBlocked    = "blocked"
+	fetchErrorQuota      = "quota"
+	fetchErrorOther      = "other"
+)
+
+var (
+	pagesFetchedMetric = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "crawler_pages_fetched_total",
+		Help: "Pages fetched, by crawl type and response status class.",
+	}, []string{"type", "status"})
+	fetchDurationMetric = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "crawler_fetch_duration_seconds",
+		Help:    "Time from sending a request until the whole response was read, by crawl type.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 2, 12),
+	}, []string{"type"})
+	fetchErrorsMetric = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "crawler_fetch_errors_total",
+		Help: "Pages that couldn't be fetched, by crawl type and error class.",
+	}, []string{"type", "class"})
+	redisDurationMetric = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "crawler_redis_operation_duration_seconds",
+		Help:    "Redis commands and pipelines, by command. Blocking reads of the job queue wait here too.",
+		Buckets: prometheus.ExponentialBuckets(0.0005, 2, 14),
+	}, []string{"command"})
+	httpDurationMetric = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "crawler_http_request_duration_seconds",
+		Help:    "API requests, by route, method and status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method", "code"})
+)
+
+// Frontiers of the crawls this worker is running, summed up for crawler_frontier_size
+var activeFrontiers = struct {
+	sync.Mutex
+	frontiers map[*frontier]bool
+}{frontiers: make(map[*frontier]bool)}
+
+func init() {
+	// Counted by the job loop already, for the worker's heartbeat
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "crawler_active_crawls",
+		Help: "Crawls this worker is running.",
+	}, func() float64 {
+		return float64(atomic.LoadInt64(&activeCrawls))
+	})
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "crawler_frontier_size",
+		Help: "Urls scheduled but not yet fetched, across the crawls this worker is running.",
+	}, func() float64 {
+		activeFrontiers.Lock()
+		defer activeFrontiers.Unlock()
+		size := 0
+		for f := range activeFrontiers.frontiers {
+			size += f.size()
+		}
+		return float64(size)
+	})
+}
+
+// trackFrontier counts a crawl's frontier towards crawler_frontier_size until the returned function is called
+func trackFrontier(f *frontier) func() {
+	activeFrontiers.Lock()
+	activeFrontiers.frontiers[f] = true
+	activeFrontiers.Unlock()
+	return func() {
+		activeFrontiers.Lock()
+		delete(activeFrontiers.frontiers, f)
+		activeFrontiers.Unlock()
+	}
+}
+
+// observeFetch records a page that was fetched
+func observeFetch(crawlType string, statusCode int, duration time.Duration) {
+	pagesFetchedMetric.WithLabelValues(crawlType, fmt.Sprintf("%dxx", statusCode/100)).Inc()
+	fetchDurationMetric.WithLabelValues(crawlType).Observe(duration.Seconds())
+}
+
+// observeFetchError records a page that couldn't be fetched
+func observeFetchError(crawlType string, err error) {
+	fetchErrorsMetric.WithLabelValues(crawlType, fetchErrorClass(err)).Inc()
+}
+
+// fetchErrorClass sorts fetch errors into the few kinds operators alert on differently
+func fetchErrorClass(err error) string {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidCert x509.CertificateInvalidError
+	switch {
+	case errors.Is(err, errOutOfScope):
+		return fetchErrorOutOfScope
+	case errors.Is(err, errBlockedDomain):
+		return fetchErrorBlocked
+	case errors.Is(err, errQuotaExceeded):
+		return fetchErrorQuota
+	case errors.Is(err, context.Canceled):
+		return fetchErrorCanceled
+	case errors.As(err, &dnsErr):
+		return fetchErrorDNS
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return fetchErrorTimeout
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return fetchErrorRefused
+	case errors.As(err, &unknownAuthority), errors.As(err, &hostnameErr), errors.As(err, &invalidCert), strings.Contains(err.Error(), "tls:"):
+		return fetchErrorTLS
+	case strings.Contains(err.Error(), "stopped after"):
+		return fetchErrorRedirect
+	}
+	return fetchErrorOther
+}
+
+// redisMetricsHook times every command a Redis client sends
+type redisMetricsHook struct{}
+
+type redisStartKey struct{}
+
+func (redisMetricsHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, redisStartKey{}, time.Now()), nil
+}
+
+func (redisMetricsHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	if start, ok := ctx.Value(redisStartKey{}).(time.Time); ok {
+		redisDurationMetric.WithLabelValues(cmd.Name()).Observe(time.Since(start).Seconds())
+	}
+	return nil
+}
+
+func (redisMetricsHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, redisStartKey{}, time.Now()), nil
+}
+
+func (redisMetricsHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	if start, ok := ctx.Value(redisStartKey{}).(time.Time); ok {
+		redisDurationMetric.WithLabelValues("pipeline").Observe(time.Since(start).Seconds())
+	}
+	return nil
+}
+
+// statusRecorder remembers the status code a handler answered with
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (recorder *statusRecorder) WriteHeader(status int) {
+	recorder.status = status
+	recorder.ResponseWriter.WriteHeader(status)
+}
+
+// Flush keeps streaming handlers streaming through the recorder
+func (recorder *statusRecorder) Flush() {
+	if flusher, ok := recorder.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// metricsMiddleware times every API request, labelled with its route's template
+// rather than its path so crawl IDs don't each get their own series
+func metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+		route := "unknown"
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+		httpDurationMetric.WithLabelValues(route, r.Method, strconv.Itoa(recorder.status)).Observe(time.Since(start).Seconds())
+	})
+}
+
+// serveMetrics exposes /metrics, and the health probes, on its own address for
+// processes without the API server
+func serveMetrics(addr string, rdb *redis.Client) {
+	router := http.NewServeMux()
+	router.Handle("/metrics", promhttp.Handler())
+	router.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		healthHandler(w, r, rdb)
+	})
+	router.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		readinessHandler(w, r, rdb)
+	})
+	logger.Info().Str("addr", addr).Msg("Serving metrics")
+	if err := http.ListenAndServe(addr, router); err != nil {
+		withError(logger.Error(), err).Msg("Metrics server error")
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Separates a tenant's name from the rest of the IDs it creates, names can't contain it
+const namespaceSeparator = "."
+
+// Tenant names end up at the front of crawl IDs, and so in urls and redis keys
+var tenantNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// Route variables holding IDs a tenant created, and what to call them when they aren't found
+var namespacedVars = map[string]string{
+	"crawl_ID":    "Crawl not found",
+	"graph_ID":    "Graph not found",
+	"schedule_ID": "Schedule not found",
+	"query_ID":    "Query not found",
+}
+
+// Helper function to make a new ID in a tenant's namespace, IDs made without
+// a tenant stay bare start times
+func newNamespacedID(tenant string) string {
+	return namespaceID(tenant, fmt.Sprintf("%d", time.Now().UnixNano()))
+}
+
+// Helper function to put an ID in a tenant's namespace
+func namespaceID(tenant, id string) string {
+	if tenant == "" {
+		return id
+	}
+	return tenant + namespaceSeparator + id
+}
+
+// Helper function to split the tenant's namespace off an ID
+func splitNamespace(id string) (tenant, rest string) {
+	if i := strings.Index(id, namespaceSeparator); i >= 0 {
+		return id[:i], id[i+1:]
+	}
+	return "", id
+}
+
+// Helper function to get the namespace of the tenant sending r, "" if it has none
+func callerNamespace(r *http.Request) string {
+	if policy, ok := tenantFor(r); ok && policy != nil {
+		return policy.Name
+	}
+	return ""
+}
+
+// inCallerNamespace reports whether the tenant sending r created the thing with id
+func inCallerNamespace(r *http.Request, id string) bool {
+	tenant, _ := splitNamespace(id)
+	return tenant == callerNamespace(r)
+}
+
+// namespaceMiddleware answers 404 for crawls, graphs, schedules and queries of
+// other tenants, as if they didn't exist, so their IDs can't even be probed
+func namespaceMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		for name, notFound := range namespacedVars {
+			if id, ok := vars[name]; ok && !inCallerNamespace(r, id) {
+				sendErrorResponse(w, http.StatusNotFound, notFound)
+				return
+			}
+		}
+		if crawlID := r.URL.Query().Get("crawlID"); crawlID != "" && !inCallerNamespace(r, crawlID) {
+			sendErrorResponse(w, http.StatusNotFound, "Crawl not found")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
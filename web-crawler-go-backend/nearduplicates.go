@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/bits"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// What crawls with nearDuplicates do with a page whose text is nearly the same as one already crawled
+const (
+	nearDuplicatesFlag  = "flag"
+	nearDuplicatesPrune = "prune"
+)
+
+const (
+	// Bits two simhashes may differ by for their pages to be near-duplicates
+	maxNearDuplicateDistance = 3
+	// Words in a shingle, the features simhashes are built from
+	simhashShingleWords = 3
+	// Pages with less text than this are never flagged, empty and stub pages all look alike
+	minSimhashWords = 20
+)
+
+type (
+	// nearDuplicateIndex holds the simhash of every page of a crawl, split in
+	// four 16-bit blocks. Two simhashes at most maxNearDuplicateDistance bits
+	// apart have at least one block in common, so only the pages sharing a
+	// block with a new one are compared with it
+	nearDuplicateIndex struct {
+		sync.Mutex
+		blocks [4]map[uint16][]fingerprint
+	}
+	fingerprint struct {
+		url     string
+		simhash uint64
+	}
+)
+
+// simhash fingerprints text so that similar texts get fingerprints a few bits
+// apart. ok is false when there's too little text to tell
+func simhash(text string) (hash uint64, ok bool) {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	if len(words) < minSimhashWords {
+		return 0, false
+	}
+	var weights [64]int
+	for i := 0; i+simhashShingleWords <= len(words); i++ {
+		shingle := fnv.New64a()
+		shingle.Write([]byte(strings.Join(words[i:i+simhashShingleWords], " ")))
+		feature := shingle.Sum64()
+		for bit := 0; bit < 64; bit++ {
+			if feature&(1<<uint(bit)) != 0 {
+				weights[bit]++
+			} else {
+				weights[bit]--
+			}
+		}
+	}
+	for bit, weight := range weights {
+		if weight > 0 {
+			hash |= 1 << uint(bit)
+		}
+	}
+	return hash, true
+}
+
+// Helper function to write a simhash the way nodes carry it
+func formatSimhash(hash uint64) string {
+	return fmt.Sprintf("%016x", hash)
+}
+
+func newNearDuplicateIndex() *nearDuplicateIndex {
+	index := &nearDuplicateIndex{}
+	for i := range index.blocks {
+		index.blocks[i] = make(map[uint16][]fingerprint)
+	}
+	return index
+}
+
+// match returns the first page added whose simhash is close to hash, or adds
+// url with it and returns an empty string
+func (index *nearDuplicateIndex) match(url string, hash uint64) string {
+	index.Lock()
+	defer index.Unlock()
+	for i, block := range index.blocks {
+		for _, candidate := range block[uint16(hash>>(16*uint(i)))] {
+			if bits.OnesCount64(candidate.simhash^hash) <= maxNearDuplicateDistance {
+				return candidate.url
+			}
+		}
+	}
+	for i, block := range index.blocks {
+		key := uint16(hash >> (16 * uint(i)))
+		block[key] = append(block[key], fingerprint{url: url, simhash: hash})
+	}
+	return ""
+}
+
+// restore adds the pages a crawl found before it was resumed, near-duplicates
+// of earlier ones left out like they were the first time
+func (index *nearDuplicateIndex) restore(nodes []graphNode) {
+	for _, node := range nodes {
+		if node.Simhash == "" || node.NearDuplicateOf != "" {
+			continue
+		}
+		if hash, err := strconv.ParseUint(node.Simhash, 16, 64); err == nil {
+			index.match(node.Parent, hash)
+		}
+	}
+}
+
+// markNearDuplicate fingerprints the text of an HTML page and, if the crawl
+// already has a page with nearly the same text, records which. Pruned
+// near-duplicates lose their links, and aren't crawled below
+func (f realFetcher) markNearDuplicate(result *FetchResult, header http.Header, page []byte) {
+	if result.StatusCode != http.StatusOK || !strings.Contains(responseContentType(header), "html") {
+		return
+	}
+	_, text := extractPageText(page)
+	hash, ok := simhash(text)
+	if !ok {
+		return
+	}
+	result.Simhash = formatSimhash(hash)
+	result.NearDuplicateOf = f.nearDuplicates.match(result.URL, hash)
+	if result.NearDuplicateOf != "" && f.pruneNearDuplicates {
+		result.URLs = []string{}
+	}
+}
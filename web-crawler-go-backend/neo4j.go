@@ -0,0 +1,119 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/neo4j/neo4j-go-driver/v4/neo4j"
+)
+
+const (
+	// Nodes are written in batches of this many, or whatever built up every flush period
+	neo4jBatchSize   = 200
+	neo4jFlushPeriod = time.Second
+)
+
+// Creates the parent page, then the child pages and the crawl's link to each of them.
+// Pages are shared between crawls, links carry the ID of the crawl that found them
+const neo4jMergeNodes = `
+UNWIND $nodes AS node
+MERGE (parent:Page {url: node.parent})
+WITH parent, node
+UNWIND node.children AS childURL
+MERGE (child:Page {url: childURL})
+MERGE (parent)-[link:LINKS_TO {crawlID: node.crawlID}]->(child)
+ON CREATE SET link.depth = node.depth, link.timeFoundMs = node.timeFoundMs
+`
+
+// neo4jEdgeSink writes every parent -> child link a crawl finds into Neo4j
+// as (:Page)-[:LINKS_TO]->(:Page), batching the writes
+type neo4jEdgeSink struct {
+	sync.Mutex
+	driver  neo4j.Driver
+	pending []interface{}
+}
+
+func newNeo4jEdgeSink(uri, user, password string) (*neo4jEdgeSink, error) {
+	driver, err := neo4j.NewDriver(uri, neo4j.BasicAuth(user, password, ""))
+	if err != nil {
+		return nil, err
+	}
+	if err := driver.VerifyConnectivity(); err != nil {
+		driver.Close()
+		return nil, err
+	}
+	sink := &neo4jEdgeSink{driver: driver}
+	// Every MERGE looks pages up by url
+	if err := sink.write("CREATE INDEX page_url IF NOT EXISTS FOR (p:Page) ON (p.url)", nil); err != nil {
+		driver.Close()
+		return nil, err
+	}
+	go sink.flushPeriodically()
+	return sink, nil
+}
+
+// Helper function to run one statement in a write transaction
+func (sink *neo4jEdgeSink) write(cypher string, params map[string]interface{}) error {
+	session := sink.driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	defer session.Close()
+	_, err := session.WriteTransaction(func(tx neo4j.Transaction) (interface{}, error) {
+		result, err := tx.Run(cypher, params)
+		if err != nil {
+			return nil, err
+		}
+		return result.Consume()
+	})
+	return err
+}
+
+// flush writes every pending node. A failed batch is dropped, the crawl's
+// results in Redis are unaffected
+func (sink *neo4jEdgeSink) flush() error {
+	sink.Lock()
+	batch := sink.pending
+	sink.pending = nil
+	sink.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+	return sink.write(neo4jMergeNodes, map[string]interface{}{"nodes": batch})
+}
+
+func (sink *neo4jEdgeSink) flushPeriodically() {
+	ticker := time.NewTicker(neo4jFlushPeriod)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := sink.flush(); err != nil {
+			withError(logger.Error(), err).Msg("Failed to write edges to Neo4j")
+		}
+	}
+}
+
+func (sink *neo4jEdgeSink) publish(crawlID string, node graphNode) error {
+	children := make([]interface{}, 0, len(node.Children))
+	for _, child := range node.Children {
+		children = append(children, child)
+	}
+	sink.Lock()
+	sink.pending = append(sink.pending, map[string]interface{}{
+		"crawlID":     crawlID,
+		"parent":      node.Parent,
+		"children":    children,
+		"depth":       node.Depth,
+		"timeFoundMs": node.TimeFound.Milliseconds(),
+	})
+	full := len(sink.pending) >= neo4jBatchSize
+	sink.Unlock()
+	if full {
+		return sink.flush()
+	}
+	return nil
+}
+
+// finish writes what's left of the crawl and records it as a (:Crawl) node
+func (sink *neo4jEdgeSink) finish(crawlID string) error {
+	if err := sink.flush(); err != nil {
+		return err
+	}
+	return sink.write("MERGE (crawl:Crawl {id: $crawlID}) SET crawl.finishedAt = datetime()", map[string]interface{}{"crawlID": crawlID})
+}
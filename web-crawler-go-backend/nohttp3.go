@@ -0,0 +1,16 @@
+//go:build !http3
+
+package main
+
+import "net/http"
+
+// Set from -http3, which this build refuses
+var fetchHTTP3 bool
+
+// Whether the binary was built with -tags http3, which brings in quic-go
+const http3Supported = false
+
+// Without quic-go every request goes over TCP
+func newAltSvcTransport(tcp http.RoundTripper) http.RoundTripper {
+	return tcp
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Field of the crawl's meta hash holding its own notification settings
+const notifySettingsField = "notify"
+
+// Events notifications are sent on
+const (
+	notifyOnDone   = "done"
+	notifyOnFailed = "failed"
+	// The crawl fetched pageThreshold pages, or errorThreshold pages failed
+	notifyOnPages  = "pages"
+	notifyOnErrors = "errors"
+)
+
+// How often a running crawl is checked against its thresholds
+const notifyThresholdPeriod = 10 * time.Second
+
+// Recipients a crawl may bring of its own, the server's -notify-email aren't counted
+const maxCrawlNotifyEmails = 5
+
+var notifyEvents = map[string]bool{notifyOnDone: true, notifyOnFailed: true, notifyOnPages: true, notifyOnErrors: true}
+
+type (
+	// NotifySettings says where a crawl's notifications go and what they're
+	// sent on. A crawl's own settings replace the global ones field by field
+	NotifySettings struct {
+		// Slack incoming webhook
+		SlackWebhookURL string `json:"slackWebhookURL,omitempty"`
+		// Needs -smtp-addr
+		Email []string `json:"email,omitempty"`
+		// Events notified on, done and failed by default
+		On             []string `json:"on,omitempty"`
+		PageThreshold  int64    `json:"pageThreshold,omitempty"`
+		ErrorThreshold int64    `json:"errorThreshold,omitempty"`
+	}
+	// smtpSettings is the mail server emails are sent through
+	smtpSettings struct {
+		addr, from, username, password string
+	}
+)
+
+var (
+	// From -slack-webhook-url, -notify-email, -notify-on and the thresholds, for every crawl
+	globalNotifySettings NotifySettings
+	// Emails aren't sent without an addr
+	smtpServer smtpSettings
+)
+
+// Helper function to check the tenant's policy lets its crawls email address,
+// every address is allowed when no policies are configured
+func (policy *TenantPolicy) allowsNotifyEmail(address string) bool {
+	if policy == nil {
+		return true
+	}
+	domain := strings.ToLower(address[strings.LastIndex(address, "@")+1:])
+	for _, allowed := range policy.NotifyEmailDomains {
+		allowed = strings.ToLower(allowed)
+		if domain == allowed || strings.HasSuffix(domain, "."+allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// Helper function to check a crawl's own notification settings
+func (settings NotifySettings) validate() error {
+	if settings.SlackWebhookURL != "" {
+		if err := validateCallbackURL(settings.SlackWebhookURL); err != nil {
+			return fmt.Errorf("slackWebhookURL must be an http(s) url")
+		}
+	}
+	if len(settings.Email) > 0 && smtpServer.addr == "" {
+		return fmt.Errorf("email notifications need the server to be started with -smtp-addr")
+	}
+	if len(settings.Email) > maxCrawlNotifyEmails {
+		return fmt.Errorf("notify.email may have at most %d addresses", maxCrawlNotifyEmails)
+	}
+	for _, address := range settings.Email {
+		if !strings.Contains(address, "@") || strings.ContainsAny(address, "\r\n,") {
+			return fmt.Errorf("%q isn't an email address", address)
+		}
+	}
+	for _, event := range settings.On {
+		if !notifyEvents[event] {
+			return fmt.Errorf("notify.on events must be done, failed, pages or errors")
+		}
+	}
+	if settings.PageThreshold < 0 || settings.ErrorThreshold < 0 {
+		return fmt.Errorf("notify thresholds must not be negative")
+	}
+	return nil
+}
+
+// merged returns the global settings with a crawl's own on top
+func (settings NotifySettings) merged(global NotifySettings) NotifySettings {
+	if settings.SlackWebhookURL == "" {
+		settings.SlackWebhookURL = global.SlackWebhookURL
+	}
+	if len(settings.Email) == 0 {
+		settings.Email = global.Email
+	}
+	if len(settings.On) == 0 {
+		settings.On = global.On
+	}
+	if len(settings.On) == 0 {
+		settings.On = []string{notifyOnDone, notifyOnFailed}
+	}
+	if settings.PageThreshold == 0 {
+		settings.PageThreshold = global.PageThreshold
+	}
+	if settings.ErrorThreshold == 0 {
+		settings.ErrorThreshold = global.ErrorThreshold
+	}
+	return settings
+}
+
+// Helper function to tell whether event is notified somewhere
+func (settings NotifySettings) wants(event string) bool {
+	if settings.SlackWebhookURL == "" && (len(settings.Email) == 0 || smtpServer.addr == "") {
+		return false
+	}
+	for _, on := range settings.On {
+		if on == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Helper function to read the notification settings of a crawl from its meta hash
+func crawlNotifySettings(meta map[string]string) NotifySettings {
+	var settings NotifySettings
+	if raw := meta[notifySettingsField]; raw != "" {
+		json.Unmarshal([]byte(raw), &settings)
+	}
+	return settings.merged(globalNotifySettings)
+}
+
+// sendNotification tells the crawl's Slack channel and email recipients about
+// an event, in the background. Failures are only logged
+func sendNotification(uniqueID string, settings NotifySettings, subject, text string) {
+	go func() {
+		if settings.SlackWebhookURL != "" {
+			if err := deliverJSON(settings.SlackWebhookURL, map[string]string{"text": subject + "\n" + text}); err != nil {
+				withError(crawlLogger(uniqueID).Warn(), err).Msg("Failed to notify Slack")
+			}
+		}
+		if len(settings.Email) > 0 && smtpServer.addr != "" {
+			if err := sendEmail(settings.Email, subject, text); err != nil {
+				withError(crawlLogger(uniqueID).Warn(), err).Strs("to", settings.Email).Msg("Failed to send notification email")
+			}
+		}
+	}()
+}
+
+// Helper function to send a plain text email through -smtp-addr
+func sendEmail(to []string, subject, text string) error {
+	var auth smtp.Auth
+	if smtpServer.username != "" {
+		host := strings.Split(smtpServer.addr, ":")[0]
+		auth = smtp.PlainAuth("", smtpServer.username, smtpServer.password, host)
+	}
+	message := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n",
+		smtpServer.from, strings.Join(to, ", "), subject, strings.ReplaceAll(text, "\n", "\r\n"))
+	return smtp.SendMail(smtpServer.addr, auth, smtpServer.from, to, []byte(message))
+}
+
+// notifyCrawlSummary sends the notifications of a crawl that's over
+func notifyCrawlSummary(settings NotifySettings, summary CrawlWebhook) {
+	event := notifyOnDone
+	if summary.State == crawlStateFailed {
+		event = notifyOnFailed
+	}
+	// Whoever canceled the crawl already knows
+	if summary.State == crawlStateCanceled || !settings.wants(event) {
+		return
+	}
+	subject := fmt.Sprintf("Crawl %s of %s %s", summary.CrawlID, summary.URL, summary.State)
+	text := fmt.Sprintf("%d pages fetched, %d couldn't be fetched", summary.PagesFetched, summary.FetchErrors)
+	if summary.Error != "" {
+		text += "\nError: " + summary.Error
+	}
+	if summary.ResultsURL != "" {
+		text += "\nResults: " + summary.ResultsURL
+	}
+	sendNotification(summary.CrawlID, settings, subject, text)
+}
+
+// watchNotifyThresholds checks a running crawl against its page and error
+// thresholds every notifyThresholdPeriod, and once more when the returned
+// function is called. Each threshold is notified once per crawl, resumes included
+func watchNotifyThresholds(rdb *redis.Client, uniqueID, seedURL string) func() {
+	meta, err := rdb.HGetAll(ctx, crawlMetaKey(uniqueID)).Result()
+	if err != nil {
+		return func() {}
+	}
+	settings := crawlNotifySettings(meta)
+	watchPages := settings.PageThreshold > 0 && settings.wants(notifyOnPages)
+	watchErrors := settings.ErrorThreshold > 0 && settings.wants(notifyOnErrors)
+	if !watchPages && !watchErrors {
+		return func() {}
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(notifyThresholdPeriod)
+		defer ticker.Stop()
+		for stopped := false; !stopped; {
+			select {
+			case <-stop:
+				stopped = true
+			case <-ticker.C:
+			}
+			if watchPages {
+				pages, err := resultStore.Len(uniqueID)
+				// Once the crawl is done, the last entry is the finish sentinel
+				if _, done, _ := resultStore.Range(uniqueID, -1, -1); done {
+					pages--
+				}
+				if err == nil && pages >= settings.PageThreshold {
+					if firstNotification(rdb, uniqueID, notifyOnPages) {
+						sendNotification(uniqueID, settings, fmt.Sprintf("Crawl %s of %s passed %d pages", uniqueID, seedURL, settings.PageThreshold), fmt.Sprintf("%d pages fetched so far", pages))
+					}
+					watchPages = false
+				}
+			}
+			if watchErrors {
+				raw, _ := rdb.HGet(ctx, crawlErrorsKey(uniqueID), "fetchErrors").Result()
+				fetchErrors, _ := strconv.ParseInt(raw, 10, 64)
+				if fetchErrors >= settings.ErrorThreshold {
+					if firstNotification(rdb, uniqueID, notifyOnErrors) {
+						sendNotification(uniqueID, settings, fmt.Sprintf("Crawl %s of %s passed %d fetch errors", uniqueID, seedURL, settings.ErrorThreshold), fmt.Sprintf("%d pages couldn't be fetched so far", fetchErrors))
+					}
+					watchErrors = false
+				}
+			}
+			if !watchPages && !watchErrors {
+				return
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+// Helper function to claim a threshold's notification, false if it was already sent
+func firstNotification(rdb *redis.Client, uniqueID, event string) bool {
+	claimed, err := rdb.HSetNX(ctx, crawlMetaKey(uniqueID), "notified-"+event, time.Now().Format(time.RFC3339)).Result()
+	return err == nil && claimed
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Swagger UI is loaded from a CDN, pinned so the page doesn't change under us
+const swaggerUIVersion = "5.17.14"
+
+type (
+	openAPIParameter struct {
+		Name     string      `json:"name"`
+		In       string      `json:"in"`
+		Required bool        `json:"required,omitempty"`
+		Schema   *jsonSchema `json:"schema"`
+	}
+	openAPIMediaType struct {
+		Schema *jsonSchema `json:"schema"`
+	}
+	openAPIBody struct {
+		Description string                      `json:"description,omitempty"`
+		Required    bool                        `json:"required,omitempty"`
+		Content     map[string]openAPIMediaType `json:"content,omitempty"`
+	}
+	openAPIOperation struct {
+		OperationID string                 `json:"operationId"`
+		Tags        []string               `json:"tags"`
+		Parameters  []openAPIParameter     `json:"parameters,omitempty"`
+		RequestBody *openAPIBody           `json:"requestBody,omitempty"`
+		Responses   map[string]openAPIBody `json:"responses"`
+	}
+	openAPISecurityScheme struct {
+		Type        string `json:"type"`
+		Scheme      string `json:"scheme"`
+		Description string `json:"description"`
+	}
+	openAPIComponents struct {
+		Schemas         map[string]*jsonSchema           `json:"schemas"`
+		SecuritySchemes map[string]openAPISecurityScheme `json:"securitySchemes"`
+	}
+	OpenAPISpec struct {
+		OpenAPI    string                                  `json:"openapi"`
+		Info       map[string]string                       `json:"info"`
+		Paths      map[string]map[string]*openAPIOperation `json:"paths"`
+		Components openAPIComponents                       `json:"components"`
+		// Either no credentials or a bearer token, which endpoints need one depends on the configuration
+		Security []map[string][]string `json:"security"`
+	}
+)
+
+// openAPIRef rewrites a schema's references from the /schema definitions to
+// OpenAPI's components, copying it so the original is left alone
+func openAPIRef(schema *jsonSchema) *jsonSchema {
+	if schema == nil {
+		return nil
+	}
+	copied := *schema
+	copied.Ref = strings.Replace(schema.Ref, "#/definitions/", "#/components/schemas/", 1)
+	copied.Items = openAPIRef(schema.Items)
+	copied.AdditionalProperties = openAPIRef(schema.AdditionalProperties)
+	if schema.Properties != nil {
+		copied.Properties = make(map[string]*jsonSchema, len(schema.Properties))
+		for name, property := range schema.Properties {
+			copied.Properties[name] = openAPIRef(property)
+		}
+	}
+	return &copied
+}
+
+// Helper function to describe a JSON body of the named definition
+func openAPIJSON(description, definition string) openAPIBody {
+	body := openAPIBody{Description: description}
+	if definition != "" {
+		body.Content = map[string]openAPIMediaType{"application/json": {Schema: &jsonSchema{Ref: "#/components/schemas/" + definition}}}
+	}
+	return body
+}
+
+// buildOpenAPI describes the API as OpenAPI 3, from the same routes and Go types as /schema
+func buildOpenAPI(router *mux.Router) *OpenAPISpec {
+	schema := buildSchema(router)
+	spec := &OpenAPISpec{
+		OpenAPI: "3.0.3",
+		Info: map[string]string{
+			"title":       "Bishop's web crawler",
+			"version":     schema.Version,
+			"description": "Crawls sites and serves their link graphs. Errors come back as an ErrorResponse with a message.",
+		},
+		Paths: map[string]map[string]*openAPIOperation{},
+		Components: openAPIComponents{
+			Schemas: map[string]*jsonSchema{},
+			SecuritySchemes: map[string]openAPISecurityScheme{
+				"bearer": {Type: "http", Scheme: "bearer", Description: "An API key from -tenant-policies, or a JWT when -jwks-url is set"},
+			},
+		},
+		Security: []map[string][]string{{}, {"bearer": {}}},
+	}
+	for name, definition := range schema.Definitions {
+		spec.Components.Schemas[name] = openAPIRef(definition)
+	}
+
+	for _, endpoint := range schema.Endpoints {
+		if endpoint.Method == http.MethodOptions {
+			continue
+		}
+		operation := &openAPIOperation{
+			OperationID: functionName(endpoint),
+			Tags:        []string{strings.Split(strings.TrimPrefix(unversionedPath(endpoint.Path), "/"), "/")[0]},
+			Responses:   map[string]openAPIBody{"default": openAPIJSON("Error", "ErrorResponse")},
+		}
+		for _, param := range endpoint.PathParams {
+			operation.Parameters = append(operation.Parameters, openAPIParameter{Name: param, In: "path", Required: true, Schema: &jsonSchema{Type: "string"}})
+		}
+		for _, param := range endpoint.QueryParams {
+			operation.Parameters = append(operation.Parameters, openAPIParameter{Name: param, In: "query", Schema: &jsonSchema{Type: "string"}})
+		}
+		if endpoint.Request != "" {
+			body := openAPIJSON("", endpoint.Request)
+			body.Required = true
+			operation.RequestBody = &body
+		}
+		status := endpoint.Status
+		if status == 0 && endpoint.Method == http.MethodDelete {
+			status = http.StatusNoContent
+		}
+		if status == 0 {
+			status = http.StatusOK
+		}
+		operation.Responses[strconv.Itoa(status)] = openAPIJSON(http.StatusText(status), endpoint.Response)
+
+		if spec.Paths[endpoint.Path] == nil {
+			spec.Paths[endpoint.Path] = map[string]*openAPIOperation{}
+		}
+		spec.Paths[endpoint.Path][strings.ToLower(endpoint.Method)] = operation
+	}
+	return spec
+}
+
+var swaggerUIPage = template.Must(template.New("docs").Parse(`<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <title>Bishop's web crawler API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@{{.}}/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@{{.}}/swagger-ui-bundle.js"></script>
+  <script>
+    window.ui = SwaggerUIBundle({url: "openapi.json", dom_id: "#swagger-ui"});
+  </script>
+</body>
+</html>
+`))
+
+// OpenAPI handler - GET /openapi.json
+func openAPIHandler(w http.ResponseWriter, r *http.Request, router *mux.Router) {
+	sendJSONResponse(w, http.StatusOK, buildOpenAPI(router))
+}
+
+// Swagger UI handler - GET /docs
+func swaggerUIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	swaggerUIPage.Execute(w, swaggerUIVersion)
+}
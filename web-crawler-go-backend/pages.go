@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// How a crawl stores the bodies of the pages it fetches, if at all
+	storeBodiesRaw     = "raw"
+	storeBodiesText    = "text"
+	storeBodiesArticle = "article"
+)
+
+// storedPage is a page body kept in the result store, compressed with gzip
+type storedPage struct {
+	URL         string
+	Format      string
+	ContentType string `json:",omitempty"`
+	StatusCode  int    `json:",omitempty"`
+	FetchedAt   time.Time
+	Body        []byte
+}
+
+var errPageNotStored = errors.New("page not stored")
+
+// Helper function to build the redis key holding a crawl's page bodies, one hash field per url
+func pagesKey(uniqueID string) string {
+	return fmt.Sprintf("go-crawler-pages-%s", uniqueID)
+}
+
+// Helper function to name a url within a crawl's stored pages
+func pageURLHash(url string) string {
+	return fmt.Sprintf("%x", sha1.Sum([]byte(url)))
+}
+
+// newStoredPage compresses a fetched page in the given format. Text keeps the
+// title and the visible text only, the way it is indexed for search, and
+// article the title and the text of the main article, both taken from
+// decoded, the page as UTF-8. Documents keep the text extractDocuments read
+func newStoredPage(result *FetchResult, page, decoded []byte, format string) (storedPage, error) {
+	stored := storedPage{URL: result.URL, Format: format, StatusCode: result.StatusCode, FetchedAt: result.FetchedAt}
+	switch {
+	case format == storeBodiesText || format == storeBodiesArticle:
+		var title, text string
+		switch {
+		case result.Document != nil:
+			// What extractDocuments read, documents have no markup to find an article in
+			title, text = result.Document.Title, result.Document.text
+		case format == storeBodiesArticle:
+			title, text = extractArticle(decoded)
+		default:
+			title, text = extractPageText(decoded)
+		}
+		page = []byte(title + "\n\n" + text)
+		stored.ContentType = "text/plain; charset=utf-8"
+	case result.Header != nil:
+		stored.ContentType = result.Header.Get("Content-Type")
+	}
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	if _, err := gz.Write(page); err != nil {
+		return stored, err
+	}
+	if err := gz.Close(); err != nil {
+		return stored, err
+	}
+	stored.Body = compressed.Bytes()
+	return stored, nil
+}
+
+func (store redisResultStore) StorePage(crawlID string, page storedPage) error {
+	marshalled, err := json.Marshal(page)
+	if err != nil {
+		return err
+	}
+	pipe := store.rdb.TxPipeline()
+	pipe.HSet(ctx, pagesKey(crawlID), pageURLHash(page.URL), marshalled)
+	expireLikeCheckpoint(pipe, pagesKey(crawlID))
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (store redisResultStore) Page(crawlID, url string) (*storedPage, error) {
+	raw, err := store.rdb.HGet(ctx, pagesKey(crawlID), pageURLHash(url)).Bytes()
+	if err == redis.Nil {
+		return nil, errPageNotStored
+	}
+	if err != nil {
+		return nil, err
+	}
+	var page storedPage
+	if err := json.Unmarshal(raw, &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// Helper function to get back the body of a stored page
+func (page *storedPage) decompress() ([]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(page.Body))
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(gz)
+}
+
+// Crawl page handler - GET /crawl/{crawl_ID}/page?url=
+func crawlPageHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
+	crawlID := mux.Vars(r)["crawl_ID"]
+	url := r.URL.Query().Get("url")
+	if url == "" {
+		sendErrorResponse(w, http.StatusBadRequest, "Must specify a page with url")
+		return
+	}
+
+	page, err := resultStore.Page(crawlID, url)
+	if err == errPageNotStored {
+		sendErrorResponse(w, http.StatusNotFound, "Page not stored, the crawl must be started with storeBodies")
+		return
+	}
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to get page")
+		return
+	}
+	body, err := page.decompress()
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Corrupt page")
+		return
+	}
+
+	contentType := page.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Crawl-Page-Format", page.Format)
+	w.Header().Set("X-Crawl-Fetched-At", page.FetchedAt.UTC().Format(time.RFC3339))
+	// Served as data, never as a page of ours
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+)
+
+type PathResponse struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Links followed, 0 when from and to are the same page
+	Hops int `json:"hops"`
+	// From from to to, both included
+	Path []string `json:"path"`
+}
+
+// shortestPath finds the fewest links leading from one url to another, with a
+// breadth first search. It returns nil if to can't be reached
+func (graph *linkGraph) shortestPath(from, to int) []int {
+	previous := make([]int, len(graph.urls))
+	for i := range previous {
+		previous[i] = -1
+	}
+	previous[from] = from
+	queue := []int{from}
+	for len(queue) > 0 && previous[to] == -1 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, next := range graph.out[current] {
+			if previous[next] == -1 {
+				previous[next] = current
+				queue = append(queue, next)
+			}
+		}
+	}
+	if previous[to] == -1 {
+		return nil
+	}
+	path := []int{to}
+	for current := to; current != from; current = previous[current] {
+		path = append([]int{previous[current]}, path...)
+	}
+	return path
+}
+
+// Crawl path handler - GET /crawl/{crawl_ID}/path
+func crawlPathHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
+	crawlID := mux.Vars(r)["crawl_ID"]
+	from, to := r.URL.Query().Get("from"), r.URL.Query().Get("to")
+	if to == "" {
+		sendErrorResponse(w, http.StatusBadRequest, "to is required")
+		return
+	}
+
+	nodes, _, err := resultStore.Range(crawlID, 0, -1)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to get results")
+		return
+	}
+	if len(nodes) == 0 {
+		sendErrorResponse(w, http.StatusNotFound, "Crawl not found")
+		return
+	}
+	// The crawl's starting url, unless asked otherwise
+	if from == "" {
+		from = nodes[0].Parent
+	}
+
+	graph := newLinkGraph(nodes)
+	index := make(map[string]int, len(graph.urls))
+	for i, url := range graph.urls {
+		index[url] = i
+	}
+	fromIndex, ok := index[from]
+	if !ok {
+		sendErrorResponse(w, http.StatusNotFound, fmt.Sprintf("%s isn't in the crawl", from))
+		return
+	}
+	toIndex, ok := index[to]
+	if !ok {
+		sendErrorResponse(w, http.StatusNotFound, fmt.Sprintf("%s isn't in the crawl", to))
+		return
+	}
+	path := graph.shortestPath(fromIndex, toIndex)
+	if path == nil {
+		sendErrorResponse(w, http.StatusNotFound, fmt.Sprintf("No links lead from %s to %s", from, to))
+		return
+	}
+
+	response := PathResponse{From: from, To: to, Hops: len(path) - 1, Path: make([]string, 0, len(path))}
+	for _, i := range path {
+		response.Path = append(response.Path, graph.urls[i])
+	}
+	sendJSONResponse(w, http.StatusOK, response)
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+)
+
+var errOutOfScope = errors.New("url is outside the crawl's allowed scopes")
+
+type (
+	// TenantPolicy is the bundle of limits an administrator sets for a tenant.
+	// Zero values leave that limit out
+	TenantPolicy struct {
+		Name string `json:"name"`
+		// Sent by the tenant's clients as "Authorization: Bearer <key>"
+		APIKeys  []string `json:"apiKeys"`
+		MaxDepth int      `json:"maxDepth,omitempty"`
+		// Requests per second each crawl may send, across every worker
+		MaxRate float64 `json:"maxRate,omitempty"`
+		// Hosts crawls may fetch from, "example.com" also allows its subdomains
+		AllowedScopes []string `json:"allowedScopes,omitempty"`
+		// Whether crawls may render pages with the headless browser
+		AllowRender bool `json:"allowRender,omitempty"`
+		// Domains crawls may send their own notification emails to, "example.com"
+		// also allows its subdomains. Without any, they can't bring recipients
+		NotifyEmailDomains []string `json:"notifyEmailDomains,omitempty"`
+		// Quotas of each API key, or each user for tenants signing in with tokens
+		CrawlsPerHour int `json:"crawlsPerHour,omitempty"`
+		PagesPerDay   int `json:"pagesPerDay,omitempty"`
+	}
+	tenantPolicies struct {
+		Tenants []TenantPolicy `json:"tenants"`
+		// Applies to requests without a known API key. Without it they're refused
+		Anonymous *TenantPolicy `json:"anonymous,omitempty"`
+	}
+	// CrawlLimits is what a crawl ends up allowed, once what it asked for is
+	// clamped by its tenant's policy
+	CrawlLimits struct {
+		Tenant string   `json:"tenant,omitempty"`
+		Depth  int      `json:"depth"`
+		Rate   float64  `json:"rate,omitempty"`
+		Scopes []string `json:"scopes,omitempty"`
+		// Whose pages per day quota the crawl's fetches count against
+		QuotaIdentity string `json:"quotaIdentity,omitempty"`
+		PagesPerDay   int    `json:"pagesPerDay,omitempty"`
+	}
+)
+
+// Set up in main when -tenant-policies is given, every request is unrestricted otherwise
+var tenants *tenantPolicies
+
+// loadTenantPolicies reads the policies administrators keep in a JSON file
+func loadTenantPolicies(path string) (*tenantPolicies, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var policies tenantPolicies
+	if err := json.Unmarshal(raw, &policies); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]bool)
+	for _, policy := range policies.Tenants {
+		if !tenantNamePattern.MatchString(policy.Name) {
+			return nil, errors.New("every tenant needs a name of letters, digits, - and _")
+		}
+		for _, scope := range policy.AllowedScopes {
+			if scope == "" || strings.ContainsAny(scope, ","+scopeSeparator) {
+				return nil, fmt.Errorf("tenant %s has an invalid scope %q", policy.Name, scope)
+			}
+		}
+		for _, domain := range policy.NotifyEmailDomains {
+			if domain == "" || strings.ContainsAny(domain, "@,") {
+				return nil, fmt.Errorf("tenant %s has an invalid notify email domain %q", policy.Name, domain)
+			}
+		}
+		for _, key := range policy.APIKeys {
+			if key == "" || keys[key] {
+				return nil, fmt.Errorf("tenant %s has an empty or duplicate API key", policy.Name)
+			}
+			keys[key] = true
+		}
+	}
+	if policies.Anonymous != nil && policies.Anonymous.Name != "" && !tenantNamePattern.MatchString(policies.Anonymous.Name) {
+		return nil, errors.New("the anonymous policy's name may only have letters, digits, - and _")
+	}
+	return &policies, nil
+}
+
+// tenantFor finds the policy of the tenant sending r. It's nil when no policies
+// are configured, and ok is false if the request may not be served at all
+func tenantFor(r *http.Request) (policy *TenantPolicy, ok bool) {
+	if caller, verified := tokenClaimsFromContext(r.Context()); verified {
+		return tenantForToken(caller)
+	}
+	if tenants == nil {
+		// With an identity provider, callers need a token
+		return nil, jwtKeys == nil
+	}
+	key := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if key != "" {
+		for i := range tenants.Tenants {
+			for _, tenantKey := range tenants.Tenants[i].APIKeys {
+				if key == tenantKey {
+					return &tenants.Tenants[i], true
+				}
+			}
+		}
+	}
+	if tenants.Anonymous != nil {
+		return tenants.Anonymous, true
+	}
+	return nil, false
+}
+
+// tenantForToken finds the policy of the tenant a verified token names. Without
+// policies the crawls are only tagged with it
+func tenantForToken(caller tokenClaims) (*TenantPolicy, bool) {
+	if tenants == nil {
+		if caller.tenant == "" {
+			return nil, true
+		}
+		return &TenantPolicy{Name: caller.tenant}, true
+	}
+	if caller.tenant == "" {
+		return tenants.Anonymous, tenants.Anonymous != nil
+	}
+	for i := range tenants.Tenants {
+		if tenants.Tenants[i].Name == caller.tenant {
+			return &tenants.Tenants[i], true
+		}
+	}
+	return nil, false
+}
+
+// Helper function to refuse a request without a tenant, returning whether it was
+func requireTenant(w http.ResponseWriter, r *http.Request) (*TenantPolicy, bool) {
+	policy, ok := tenantFor(r)
+	if !ok {
+		sendErrorResponse(w, http.StatusUnauthorized, "A valid API key or bearer token is required")
+	}
+	return policy, ok
+}
+
+// clampLimits applies a tenant's policy to the depth and rate a request asked
+// for, zero meaning whatever is allowed
+func clampLimits(policy *TenantPolicy, depth int, rate float64) CrawlLimits {
+	limits := CrawlLimits{Depth: currentPolicy().maxDepth, Rate: rate}
+	if depth > 0 && depth < limits.Depth {
+		limits.Depth = depth
+	}
+	if policy == nil {
+		return limits
+	}
+	limits.Tenant = policy.Name
+	if policy.MaxDepth > 0 && policy.MaxDepth < limits.Depth {
+		limits.Depth = policy.MaxDepth
+	}
+	if policy.MaxRate > 0 && (limits.Rate == 0 || limits.Rate > policy.MaxRate) {
+		limits.Rate = policy.MaxRate
+	}
+	limits.Scopes = policy.AllowedScopes
+	return limits
+}
+
+// ownsCrawl reports whether a tenant may act on a crawl started with limits
+func ownsCrawl(policy *TenantPolicy, limits *CrawlLimits) bool {
+	if tenants == nil {
+		return true
+	}
+	crawlTenant := ""
+	if limits != nil {
+		crawlTenant = limits.Tenant
+	}
+	return policy != nil && policy.Name == crawlTenant
+}
+
+// inScope reports whether the limits allow fetching rawURL
+func (limits CrawlLimits) inScope(rawURL string) bool {
+	if len(limits.Scopes) == 0 {
+		return true
+	}
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(parsedURL.Hostname())
+	for _, scope := range limits.Scopes {
+		scope = strings.ToLower(scope)
+		if host == scope || strings.HasSuffix(host, "."+scope) {
+			return true
+		}
+	}
+	return false
+}
+
+// Helper function to build the redis key holding a crawl's token bucket
+func crawlRateLimitKey(uniqueID string) string {
+	return rateLimitKey("crawl-" + uniqueID)
+}
+
+// crawlRateLimiter caps one crawl's requests across every worker, with a
+// token bucket like the per-host limiter's
+type crawlRateLimiter struct {
+	bucket *hostRateLimiter
+	key    string
+}
+
+func newCrawlRateLimiter(rdb *redis.Client, uniqueID string, rate float64) *crawlRateLimiter {
+	return &crawlRateLimiter{bucket: &hostRateLimiter{rdb: rdb, rate: rate, burst: 1}, key: crawlRateLimitKey(uniqueID)}
+}
+
+// wait blocks until the crawl is allowed another request
+func (limiter *crawlRateLimiter) wait() {
+	limiter.bucket.take(limiter.key)
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	// Registers the "postgres" database/sql driver
+	_ "github.com/lib/pq"
+)
+
+// crawl_nodes keeps every node as published, in order. crawl_edges has one
+// row per parent -> child link, for querying with SQL. crawls records which
+// crawls are done, and crawl_pages holds the gzipped page bodies of crawls that store them
+const postgresSchema = `
+CREATE TABLE IF NOT EXISTS crawls (
+	crawl_id TEXT PRIMARY KEY,
+	done BOOLEAN NOT NULL DEFAULT FALSE,
+	finished_at TIMESTAMPTZ
+);
+CREATE TABLE IF NOT EXISTS crawl_nodes (
+	seq BIGSERIAL PRIMARY KEY,
+	crawl_id TEXT NOT NULL,
+	parent TEXT NOT NULL,
+	depth INTEGER NOT NULL,
+	time_found_ns BIGINT NOT NULL,
+	node JSONB NOT NULL
+);
+CREATE INDEX IF NOT EXISTS crawl_nodes_crawl_id ON crawl_nodes (crawl_id, seq);
+CREATE TABLE IF NOT EXISTS crawl_edges (
+	crawl_id TEXT NOT NULL,
+	node_seq BIGINT NOT NULL REFERENCES crawl_nodes (seq) ON DELETE CASCADE,
+	parent TEXT NOT NULL,
+	child TEXT NOT NULL,
+	depth INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS crawl_edges_parent ON crawl_edges (crawl_id, parent);
+CREATE INDEX IF NOT EXISTS crawl_edges_child ON crawl_edges (crawl_id, child);
+CREATE INDEX IF NOT EXISTS crawl_edges_depth ON crawl_edges (crawl_id, depth);
+CREATE TABLE IF NOT EXISTS crawl_pages (
+	crawl_id TEXT NOT NULL,
+	url_hash TEXT NOT NULL,
+	url TEXT NOT NULL,
+	format TEXT NOT NULL,
+	content_type TEXT NOT NULL,
+	status_code INTEGER NOT NULL,
+	fetched_at TIMESTAMPTZ NOT NULL,
+	body BYTEA NOT NULL,
+	PRIMARY KEY (crawl_id, url_hash)
+);
+`
+
+// postgresResultStore keeps crawl results in PostgreSQL, where they stay until
+// deleted instead of expiring with the rest of the crawl's data
+type postgresResultStore struct {
+	db *sql.DB
+}
+
+// newPostgresResultStore connects to url and creates the tables if they don't exist yet
+func newPostgresResultStore(url string) (*postgresResultStore, error) {
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.ExecContext(ctx, postgresSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &postgresResultStore{db: db}, nil
+}
+
+func (store *postgresResultStore) Append(crawlID string, nodes ...graphNode) error {
+	tx, err := store.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for i := range nodes {
+		node := &nodes[i]
+		marshalled, err := json.Marshal(node)
+		if err != nil {
+			return err
+		}
+		var seq int64
+		err = tx.QueryRowContext(ctx,
+			`INSERT INTO crawl_nodes (crawl_id, parent, depth, time_found_ns, node) VALUES ($1, $2, $3, $4, $5) RETURNING seq`,
+			crawlID, node.Parent, node.Depth, int64(node.TimeFound), string(marshalled)).Scan(&seq)
+		if err != nil {
+			return err
+		}
+		for _, child := range node.Children {
+			_, err := tx.ExecContext(ctx,
+				`INSERT INTO crawl_edges (crawl_id, node_seq, parent, child, depth) VALUES ($1, $2, $3, $4, $5)`,
+				crawlID, seq, node.Parent, child, node.Depth)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// Helper function to count a crawl's nodes and tell whether it's done
+func (store *postgresResultStore) count(crawlID string) (int64, bool, error) {
+	var nodes int64
+	var done bool
+	err := store.db.QueryRowContext(ctx,
+		`SELECT (SELECT COUNT(*) FROM crawl_nodes WHERE crawl_id = $1), COALESCE((SELECT done FROM crawls WHERE crawl_id = $1), FALSE)`,
+		crawlID).Scan(&nodes, &done)
+	return nodes, done, err
+}
+
+// Range follows LRANGE on the Redis list: the done marker is the entry after the last node
+func (store *postgresResultStore) Range(crawlID string, start, stop int64) ([]graphNode, bool, error) {
+	nodes, done, err := store.count(crawlID)
+	if err != nil {
+		return nil, false, err
+	}
+	length := nodes
+	if done {
+		length++
+	}
+	if start < 0 {
+		start += length
+	}
+	if stop < 0 {
+		stop += length
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= length {
+		stop = length - 1
+	}
+	if start > stop {
+		return []graphNode{}, false, nil
+	}
+
+	results := []graphNode{}
+	if start < nodes {
+		rows, err := store.db.QueryContext(ctx,
+			`SELECT node FROM crawl_nodes WHERE crawl_id = $1 ORDER BY seq OFFSET $2 LIMIT $3`,
+			crawlID, start, stop-start+1)
+		if err != nil {
+			return nil, false, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var raw []byte
+			if err := rows.Scan(&raw); err != nil {
+				return nil, false, err
+			}
+			var node graphNode
+			if err := json.Unmarshal(raw, &node); err != nil {
+				return nil, false, err
+			}
+			results = append(results, node)
+		}
+		if err := rows.Err(); err != nil {
+			return nil, false, err
+		}
+	}
+	return results, done && stop == nodes, nil
+}
+
+func (store *postgresResultStore) Len(crawlID string) (int64, error) {
+	nodes, done, err := store.count(crawlID)
+	if done {
+		nodes++
+	}
+	return nodes, err
+}
+
+func (store *postgresResultStore) MarkDone(crawlID string) error {
+	_, err := store.db.ExecContext(ctx,
+		`INSERT INTO crawls (crawl_id, done, finished_at) VALUES ($1, TRUE, NOW())
+		ON CONFLICT (crawl_id) DO UPDATE SET done = TRUE, finished_at = NOW()`,
+		crawlID)
+	return err
+}
+
+func (store *postgresResultStore) StorePage(crawlID string, page storedPage) error {
+	_, err := store.db.ExecContext(ctx,
+		`INSERT INTO crawl_pages (crawl_id, url_hash, url, format, content_type, status_code, fetched_at, body) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (crawl_id, url_hash) DO UPDATE SET format = $4, content_type = $5, status_code = $6, fetched_at = $7, body = $8`,
+		crawlID, pageURLHash(page.URL), page.URL, page.Format, page.ContentType, page.StatusCode, page.FetchedAt, page.Body)
+	return err
+}
+
+func (store *postgresResultStore) Page(crawlID, url string) (*storedPage, error) {
+	page := storedPage{URL: url}
+	err := store.db.QueryRowContext(ctx,
+		`SELECT format, content_type, status_code, fetched_at, body FROM crawl_pages WHERE crawl_id = $1 AND url_hash = $2`,
+		crawlID, pageURLHash(url)).Scan(&page.Format, &page.ContentType, &page.StatusCode, &page.FetchedAt, &page.Body)
+	if err == sql.ErrNoRows {
+		return nil, errPageNotStored
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+func (store *postgresResultStore) Delete(crawlID string) error {
+	tx, err := store.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// Edges go with their nodes
+	for _, table := range []string{"crawl_nodes", "crawl_pages", "crawls"} {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE crawl_id = $1", crawlID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Expire does nothing, results in Postgres are kept until deleted
+func (store *postgresResultStore) Expire(crawlID string, ttl time.Duration) error {
+	return nil
+}
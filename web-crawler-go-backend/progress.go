@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+)
+
+const (
+	crawlStateRunning = "running"
+	// The checkpoint stopped being refreshed, the crawl can be resumed
+	crawlStateInterrupted = "interrupted"
+	crawlStateDone        = "done"
+	// Stopped with POST /crawl/{id}/cancel, its results stop where it was
+	crawlStateCanceled = "canceled"
+)
+
+type (
+	CrawlStatusResponse struct {
+		State        string `json:"state"`
+		PagesFetched int64  `json:"pagesFetched"`
+		// Urls still to be fetched, as of the last checkpoint
+		Frontier int `json:"frontier"`
+		// Pages that couldn't be fetched, and the last reason why
+		FetchErrors    int64  `json:"fetchErrors"`
+		LastFetchError string `json:"lastFetchError,omitempty"`
+		// Why the crawl stopped, if it failed
+		Error string `json:"error,omitempty"`
+		// X-Request-ID of the API request that started the crawl
+		RequestID string `json:"requestID,omitempty"`
+		// User whose bearer token started the crawl, with -jwks-url
+		UserID string `json:"userID,omitempty"`
+		// Only while the crawl is running or interrupted
+		Estimate *ProgressEstimate `json:"estimate,omitempty"`
+	}
+	// ProgressEstimate is a guess from the crawl so far, not a promise: it
+	// assumes pages keep linking to new pages at the rate they have until now
+	ProgressEstimate struct {
+		EstimatedPercentComplete float64 `json:"estimatedPercentComplete"`
+		EstimatedTotalPages      int64   `json:"estimatedTotalPages"`
+		// Zero while there is no fetch rate to go on
+		EstimatedSecondsRemaining float64 `json:"estimatedSecondsRemaining"`
+		// New pages found per page fetched so far, which drives the estimate
+		DiscoveryRate float64 `json:"discoveryRate"`
+	}
+)
+
+// Helper function to build the redis key holding what went wrong in a crawl
+func crawlErrorsKey(uniqueID string) string {
+	return fmt.Sprintf("go-crawler-errors-%s", uniqueID)
+}
+
+// recordFetchError counts a page that couldn't be fetched towards the crawl's status
+func recordFetchError(rdb *redis.Client, uniqueID, url string, fetchErr error) {
+	pipe := rdb.TxPipeline()
+	pipe.HIncrBy(ctx, crawlErrorsKey(uniqueID), "fetchErrors", 1)
+	pipe.HSet(ctx, crawlErrorsKey(uniqueID), "lastFetchError", fmt.Sprintf("%s: %v", url, fetchErr))
+	expireLikeCheckpoint(pipe, crawlErrorsKey(uniqueID))
+	if _, err := pipe.Exec(ctx); err != nil {
+		withError(crawlLogger(uniqueID).Error(), err).Str("url", url).Msg("Failed to record fetch error")
+	}
+}
+
+// recordCrawlError keeps the error a crawl failed with, for its status
+func recordCrawlError(rdb *redis.Client, uniqueID string, crawlErr error) {
+	pipe := rdb.TxPipeline()
+	pipe.HSet(ctx, crawlErrorsKey(uniqueID), "error", crawlErr.Error())
+	expireLikeCheckpoint(pipe, crawlErrorsKey(uniqueID))
+	if _, err := pipe.Exec(ctx); err != nil {
+		withError(crawlLogger(uniqueID).Error(), err).Msg("Failed to record crawl error")
+	}
+}
+
+// estimateProgress guesses how much of a crawl is left. A frontier url with d
+// levels of depth left is expected to bring 1 + r + r^2 + ... + r^(d-1)
+// pages with it, r being the rate at which fetched pages have led to new ones
+func estimateProgress(checkpoint *crawlCheckpoint, fetched int64) *ProgressEstimate {
+	discovered := fetched + int64(len(checkpoint.Frontier))
+	rate := 0.0
+	// Status crawls check a fixed list of urls, nothing new is found along the way
+	if fetched > 0 && checkpoint.StatusOf == "" {
+		// The starting url was never discovered by a fetched page
+		rate = float64(discovered-1) / float64(fetched)
+	}
+
+	remaining := 0.0
+	for _, item := range checkpoint.Frontier {
+		if checkpoint.StatusOf != "" {
+			remaining++
+			continue
+		}
+		// Urls past the maximum depth (depth 0) are never fetched
+		for level := 0; level < item.Depth; level++ {
+			remaining += math.Pow(rate, float64(level))
+		}
+	}
+
+	total := float64(fetched) + remaining
+	estimate := &ProgressEstimate{EstimatedTotalPages: int64(math.Round(total)), DiscoveryRate: rate}
+	if total > 0 {
+		estimate.EstimatedPercentComplete = math.Round(1000*float64(fetched)/total) / 10
+	}
+	if elapsed := time.Since(checkpoint.StartTime).Seconds(); fetched > 0 && elapsed > 0 {
+		estimate.EstimatedSecondsRemaining = math.Round(remaining / (float64(fetched) / elapsed))
+	}
+	return estimate
+}
+
+// Crawl status handler - GET /crawl/{crawl_ID}/status
+func crawlStatusHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
+	crawlID := mux.Vars(r)["crawl_ID"]
+
+	nodes, done, err := resultStore.Range(crawlID, 0, -1)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to get results")
+		return
+	}
+	response := CrawlStatusResponse{PagesFetched: int64(len(nodes))}
+	crawlErrors, err := rdb.HGetAll(ctx, crawlErrorsKey(crawlID)).Result()
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to get crawl errors")
+		return
+	}
+	response.FetchErrors, _ = strconv.ParseInt(crawlErrors["fetchErrors"], 10, 64)
+	response.LastFetchError = crawlErrors["lastFetchError"]
+	response.Error = crawlErrors["error"]
+	meta := rdb.HGetAll(ctx, crawlMetaKey(crawlID)).Val()
+	response.RequestID = meta["requestID"]
+	response.UserID = meta["userID"]
+	if done {
+		response.State = crawlStateDone
+		if meta[canceledAtField] != "" {
+			response.State = crawlStateCanceled
+		}
+		sendJSONResponse(w, http.StatusOK, response)
+		return
+	}
+
+	checkpoint, err := loadCheckpoint(rdb, crawlID)
+	if err == redis.Nil {
+		sendErrorResponse(w, http.StatusNotFound, "Crawl not found")
+		return
+	}
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to get checkpoint")
+		return
+	}
+	response.State = crawlStateRunning
+	if time.Since(checkpoint.UpdatedAt) >= 2*checkpointPeriodInSeconds*time.Second {
+		response.State = crawlStateInterrupted
+	}
+	for _, item := range checkpoint.Frontier {
+		if item.Depth > 0 || checkpoint.StatusOf != "" {
+			response.Frontier++
+		}
+	}
+	response.Estimate = estimateProgress(checkpoint, response.PagesFetched)
+	sendJSONResponse(w, http.StatusOK, response)
+}
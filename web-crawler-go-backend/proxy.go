@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Set from -proxy, checked at startup. Crawls without a proxy of their own
+// go through it, or through the one HTTPS_PROXY and HTTP_PROXY name when it's unset
+var proxyAddr string
+
+// Set from -allowed-proxies, checked at startup. A crawl's own proxy must be
+// one of them, and crawls can't have one without it
+var allowedProxies stringList
+
+// Schemes of the proxies the transport can go through
+var proxySchemes = map[string]bool{"http": true, "https": true, "socks5": true, "socks5h": true}
+
+type proxyKey struct{}
+
+// parseProxyURL checks a proxy url, an http, https or socks5 proxy with an
+// optional user:password for it. socks5h is accepted as a synonym of socks5,
+// both have the proxy look up host names, which is what Tor needs
+func parseProxyURL(rawURL string) (*url.URL, error) {
+	proxy, err := url.Parse(rawURL)
+	if err != nil || !proxySchemes[proxy.Scheme] || proxy.Hostname() == "" {
+		return nil, errors.New("proxy must be an http, https, socks5 or socks5h url like http://proxy.example.com:3128")
+	}
+	if (proxy.Path != "" && proxy.Path != "/") || proxy.RawQuery != "" {
+		return nil, errors.New("proxy must be the url of the proxy, without a path")
+	}
+	return proxy, nil
+}
+
+// Helper function to tell whether a request goes through a proxy
+func proxied(req *http.Request) bool {
+	proxy, err := crawlProxy(req)
+	return proxy != nil || err != nil
+}
+
+// allowedCrawlProxy tells whether a crawl may go through proxy, one of
+// allowedProxies whatever user:password the crawl adds. Crawls never pick
+// their own proxy when -proxy is set
+func allowedCrawlProxy(proxy *url.URL) bool {
+	if proxyAddr != "" {
+		return false
+	}
+	for _, rawAllowed := range allowedProxies {
+		allowed, err := parseProxyURL(rawAllowed)
+		if err == nil && proxySchemeName(allowed.Scheme) == proxySchemeName(proxy.Scheme) && strings.EqualFold(allowed.Host, proxy.Host) {
+			return true
+		}
+	}
+	return false
+}
+
+// Helper function to compare proxy schemes, socks5h is the same as socks5
+func proxySchemeName(scheme string) string {
+	if scheme == "socks5h" {
+		return "socks5"
+	}
+	return scheme
+}
+
+// withProxy returns a context whose requests go through the crawl's own proxy
+func withProxy(crawlCtx context.Context, proxy string) context.Context {
+	if proxy == "" {
+		return crawlCtx
+	}
+	// Checked when the crawl was started
+	proxyURL, err := parseProxyURL(proxy)
+	if err != nil {
+		return crawlCtx
+	}
+	return context.WithValue(crawlCtx, proxyKey{}, proxyURL)
+}
+
+// crawlProxy picks the proxy a request goes through, for the transports
+// crawls fetch with. nil sends it directly
+func crawlProxy(req *http.Request) (*url.URL, error) {
+	if proxy, ok := req.Context().Value(proxyKey{}).(*url.URL); ok {
+		return proxy, nil
+	}
+	if proxyAddr != "" {
+		return parseProxyURL(proxyAddr)
+	}
+	return http.ProxyFromEnvironment(req)
+}
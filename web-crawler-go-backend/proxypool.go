@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// How crawls with proxyPool pick the proxy of each request
+const (
+	proxyPoolRoundRobin  = "round-robin"
+	proxyPoolLeastErrors = "least-errors"
+)
+
+const (
+	// A proxy failing this many requests in a row is taken out of the pool
+	// until its health check passes again
+	maxProxyFailures = 3
+	// How often the proxies of the pool are checked, and their error counts halved
+	proxyHealthCheckPeriod = 30 * time.Second
+)
+
+var (
+	proxyRequestsMetric = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "crawler_proxy_requests_total",
+		Help: "Requests sent through the proxies of -proxy-pool, by proxy and result: ok or error.",
+	}, []string{"proxy", "result"})
+	proxyHealthyMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "crawler_proxy_healthy",
+		Help: "Whether each proxy of -proxy-pool is used, 1, or out of the pool, 0.",
+	}, []string{"proxy"})
+)
+
+// Set from -proxy-pool
+var proxyPoolAddrs stringList
+
+// Built from proxyPoolAddrs at startup, nil without one
+var sharedProxyPool *proxyPool
+
+type (
+	// proxyPool spreads the requests of crawls with proxyPool over several
+	// proxies, leaving out the ones that fail
+	proxyPool struct {
+		proxies []*pooledProxy
+		next    uint64
+	}
+	pooledProxy struct {
+		url *url.URL
+		// host:port, the proxy's label in metrics, without its password
+		addr string
+		sync.Mutex
+		// Failed requests, halved at every health check so old ones fade
+		errors int
+		// Requests failed since the last one that went through
+		failuresInARow int
+		healthy        bool
+	}
+	proxyPoolKey struct{}
+	// proxyPoolTransport sends the requests of crawls with proxyPool through
+	// a proxy of the pool, and counts which of them fail
+	proxyPoolTransport struct {
+		base http.RoundTripper
+	}
+)
+
+func newProxyPool(addrs []string) (*proxyPool, error) {
+	pool := &proxyPool{}
+	for _, addr := range addrs {
+		proxyURL, err := parseProxyURL(addr)
+		if err != nil {
+			return nil, err
+		}
+		port := proxyURL.Port()
+		switch {
+		case port != "":
+		case proxyURL.Scheme == "socks5" || proxyURL.Scheme == "socks5h":
+			port = "1080"
+		case proxyURL.Scheme == "https":
+			port = "443"
+		default:
+			port = "80"
+		}
+		proxy := &pooledProxy{url: proxyURL, addr: net.JoinHostPort(proxyURL.Hostname(), port), healthy: true}
+		proxyHealthyMetric.WithLabelValues(proxy.addr).Set(1)
+		pool.proxies = append(pool.proxies, proxy)
+	}
+	return pool, nil
+}
+
+// pick returns the proxy of the next request, out of the healthy ones, or
+// out of all of them when none is
+func (pool *proxyPool) pick(strategy string) *pooledProxy {
+	candidates := make([]*pooledProxy, 0, len(pool.proxies))
+	for _, proxy := range pool.proxies {
+		proxy.Lock()
+		if proxy.healthy {
+			candidates = append(candidates, proxy)
+		}
+		proxy.Unlock()
+	}
+	if len(candidates) == 0 {
+		candidates = pool.proxies
+	}
+	// Where round-robin is, and where least-errors starts looking, so ties are spread too
+	start := int((atomic.AddUint64(&pool.next, 1) - 1) % uint64(len(candidates)))
+	picked := candidates[start]
+	if strategy != proxyPoolLeastErrors {
+		return picked
+	}
+	fewest := -1
+	for i := range candidates {
+		proxy := candidates[(start+i)%len(candidates)]
+		proxy.Lock()
+		if fewest < 0 || proxy.errors < fewest {
+			picked, fewest = proxy, proxy.errors
+		}
+		proxy.Unlock()
+	}
+	return picked
+}
+
+// record counts a request sent through the proxy, taking the proxy out of
+// the pool once too many fail in a row
+func (proxy *pooledProxy) record(failed bool) {
+	proxy.Lock()
+	defer proxy.Unlock()
+	if !failed {
+		proxyRequestsMetric.WithLabelValues(proxy.addr, "ok").Inc()
+		proxy.failuresInARow = 0
+		return
+	}
+	proxyRequestsMetric.WithLabelValues(proxy.addr, "error").Inc()
+	proxy.errors++
+	proxy.failuresInARow++
+	if proxy.failuresInARow >= maxProxyFailures && proxy.healthy {
+		logger.Warn().Str("proxy", proxy.addr).Int("failures", proxy.failuresInARow).Msg("Taking failing proxy out of the pool")
+		proxy.healthy = false
+		proxyHealthyMetric.WithLabelValues(proxy.addr).Set(0)
+	}
+}
+
+// checkHealth connects to every proxy of the pool periodically, taking out
+// the ones that can't be reached and putting back the ones that can
+func (pool *proxyPool) checkHealth() {
+	ticker := time.NewTicker(proxyHealthCheckPeriod)
+	defer ticker.Stop()
+	for range ticker.C {
+		for _, proxy := range pool.proxies {
+			conn, err := net.DialTimeout("tcp", proxy.addr, dialTimeout)
+			if err == nil {
+				conn.Close()
+			}
+			proxy.Lock()
+			proxy.errors /= 2
+			if err != nil && proxy.healthy {
+				withError(logger.Warn(), err).Str("proxy", proxy.addr).Msg("Proxy failed its health check, taking it out of the pool")
+			} else if err == nil && !proxy.healthy {
+				logger.Info().Str("proxy", proxy.addr).Msg("Proxy passed its health check, putting it back in the pool")
+				proxy.failuresInARow = 0
+			}
+			proxy.healthy = err == nil
+			if proxy.healthy {
+				proxyHealthyMetric.WithLabelValues(proxy.addr).Set(1)
+			} else {
+				proxyHealthyMetric.WithLabelValues(proxy.addr).Set(0)
+			}
+			proxy.Unlock()
+		}
+	}
+}
+
+// withProxyPool returns a context whose requests go through the proxies of the
+// pool, picked with strategy
+func withProxyPool(crawlCtx context.Context, strategy string) context.Context {
+	if strategy == "" {
+		return crawlCtx
+	}
+	return context.WithValue(crawlCtx, proxyPoolKey{}, strategy)
+}
+
+func (t proxyPoolTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	strategy, ok := req.Context().Value(proxyPoolKey{}).(string)
+	if !ok || sharedProxyPool == nil {
+		return t.base.RoundTrip(req)
+	}
+	proxy := sharedProxyPool.pick(strategy)
+	// crawlProxy finds it there
+	resp, err := t.base.RoundTrip(req.WithContext(context.WithValue(req.Context(), proxyKey{}, proxy.url)))
+	// Canceled requests say nothing about the proxy. A 407 means it refused us
+	if req.Context().Err() == nil {
+		proxy.record(err != nil || resp.StatusCode == http.StatusProxyAuthRequired)
+	}
+	return resp, err
+}
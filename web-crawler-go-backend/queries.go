@@ -0,0 +1,332 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+)
+
+const savedQueriesKey = "go-crawler-queries"
+
+type (
+	// resultFilter picks the nodes of a crawl a report is interested in
+	resultFilter struct {
+		Domain      string `json:"domain,omitempty"`
+		MinDepth    int    `json:"minDepth,omitempty"`
+		MaxDepth    int    `json:"maxDepth,omitempty"`
+		URLContains string `json:"urlContains,omitempty"`
+		// "error", "ok", a class like "4xx" or a code like "404"
+		Status string `json:"status,omitempty"`
+	}
+	Subscription struct {
+		ScheduleID string `json:"scheduleID"`
+		DeliverTo  string `json:"deliverTo"`
+	}
+	SavedQuery struct {
+		ID            string         `json:"id"`
+		Name          string         `json:"name"`
+		Filter        resultFilter   `json:"filter"`
+		Subscriptions []Subscription `json:"subscriptions"`
+		CreatedAt     time.Time      `json:"createdAt"`
+	}
+	SavedQueriesResponse struct {
+		Queries []SavedQuery `json:"queries"`
+	}
+	Report struct {
+		QueryID     string      `json:"queryID"`
+		QueryName   string      `json:"queryName"`
+		ScheduleID  string      `json:"scheduleID,omitempty"`
+		CrawlID     string      `json:"crawlID"`
+		GeneratedAt time.Time   `json:"generatedAt"`
+		Edges       []graphNode `json:"edges"`
+	}
+)
+
+// Helper function to check whether url is on domain or one of its subdomains
+func hostMatchesDomain(rawURL, domain string) bool {
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(parsedURL.Hostname())
+	domain = strings.ToLower(domain)
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+func (filter resultFilter) matches(node graphNode) bool {
+	if filter.Domain != "" && !hostMatchesDomain(node.Parent, filter.Domain) {
+		return false
+	}
+	if filter.MinDepth > 0 && node.Depth < filter.MinDepth {
+		return false
+	}
+	if filter.MaxDepth > 0 && node.Depth > filter.MaxDepth {
+		return false
+	}
+	if filter.URLContains != "" && !strings.Contains(node.Parent, filter.URLContains) {
+		return false
+	}
+	if filter.Status != "" && !statusMatches(node, filter.Status) {
+		return false
+	}
+	return true
+}
+
+// Helper function to tell whether a node failed, with an error or a 4xx or 5xx
+func nodeFailed(node graphNode) bool {
+	return node.Error != "" || node.Status >= 400
+}
+
+// Helper function to check a node against the status of a filter
+func statusMatches(node graphNode, status string) bool {
+	switch {
+	case status == "error":
+		return nodeFailed(node)
+	case status == "ok":
+		return !nodeFailed(node)
+	case len(status) == 3 && strings.HasSuffix(status, "xx"):
+		return node.Status/100 == int(status[0]-'0')
+	}
+	code, _ := strconv.Atoi(status)
+	return node.Status == code
+}
+
+// Helper function to check that a filter's status is one statusMatches knows
+func validStatusFilter(status string) bool {
+	switch {
+	case status == "", status == "error", status == "ok":
+		return true
+	case len(status) == 3 && strings.HasSuffix(status, "xx"):
+		return status[0] >= '1' && status[0] <= '5'
+	}
+	code, err := strconv.Atoi(status)
+	return err == nil && code >= 100 && code <= 599
+}
+
+// Helper function to read a filter from the query params of a request
+func resultFilterFromQuery(query url.Values) (resultFilter, error) {
+	filter := resultFilter{
+		Domain:      query.Get("domain"),
+		URLContains: query.Get("urlContains"),
+		Status:      query.Get("status"),
+	}
+	for name, depth := range map[string]*int{"minDepth": &filter.MinDepth, "maxDepth": &filter.MaxDepth} {
+		if value := query.Get(name); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < 0 {
+				return filter, fmt.Errorf("%s must be a number, not %q", name, value)
+			}
+			*depth = parsed
+		}
+	}
+	if !validStatusFilter(filter.Status) {
+		return filter, fmt.Errorf("status must be error, ok, a class like 4xx or a code like 404, not %q", filter.Status)
+	}
+	return filter, nil
+}
+
+// Helper function to turn a filter back into query params, for next links
+func (filter resultFilter) query() url.Values {
+	query := url.Values{}
+	if filter.Domain != "" {
+		query.Set("domain", filter.Domain)
+	}
+	if filter.MinDepth > 0 {
+		query.Set("minDepth", strconv.Itoa(filter.MinDepth))
+	}
+	if filter.MaxDepth > 0 {
+		query.Set("maxDepth", strconv.Itoa(filter.MaxDepth))
+	}
+	if filter.URLContains != "" {
+		query.Set("urlContains", filter.URLContains)
+	}
+	if filter.Status != "" {
+		query.Set("status", filter.Status)
+	}
+	return query
+}
+
+func (filter resultFilter) apply(nodes []graphNode) []graphNode {
+	filtered := make([]graphNode, 0, len(nodes))
+	for _, node := range nodes {
+		if filter.matches(node) {
+			filtered = append(filtered, node)
+		}
+	}
+	return filtered
+}
+
+func loadSavedQueries(rdb *redis.Client) ([]SavedQuery, error) {
+	raw, err := rdb.HGetAll(ctx, savedQueriesKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	queries := make([]SavedQuery, 0, len(raw))
+	for _, rawQuery := range raw {
+		var query SavedQuery
+		if err := json.Unmarshal([]byte(rawQuery), &query); err != nil {
+			continue
+		}
+		queries = append(queries, query)
+	}
+	return queries, nil
+}
+
+func loadSavedQuery(rdb *redis.Client, id string) (*SavedQuery, error) {
+	raw, err := rdb.HGet(ctx, savedQueriesKey, id).Bytes()
+	if err != nil {
+		return nil, err
+	}
+	var query SavedQuery
+	if err := json.Unmarshal(raw, &query); err != nil {
+		return nil, err
+	}
+	return &query, nil
+}
+
+func saveQuery(rdb *redis.Client, query *SavedQuery) error {
+	marshalled, _ := json.Marshal(query)
+	return rdb.HSet(ctx, savedQueriesKey, query.ID, marshalled).Err()
+}
+
+// buildReport runs a saved query over a finished crawl, leaving out anything annotated as ignored
+func buildReport(rdb *redis.Client, query SavedQuery, crawlID string) (*Report, error) {
+	nodes, _, err := resultStore.Range(crawlID, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	ignored, err := loadIgnoreSet(rdb, crawlID)
+	if err != nil {
+		return nil, err
+	}
+	return &Report{
+		QueryID:     query.ID,
+		QueryName:   query.Name,
+		CrawlID:     crawlID,
+		GeneratedAt: time.Now(),
+		Edges:       query.Filter.apply(ignored.filter(nodes)),
+	}, nil
+}
+
+// Create saved query handler - POST /queries
+func createSavedQueryHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
+	var query SavedQuery
+	if err := json.NewDecoder(r.Body).Decode(&query); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	if query.Name == "" {
+		sendErrorResponse(w, http.StatusBadRequest, "Name is required")
+		return
+	}
+	if !validStatusFilter(query.Filter.Status) {
+		sendErrorResponse(w, http.StatusBadRequest, "Filter status must be error, ok, a class like 4xx or a code like 404")
+		return
+	}
+
+	query.ID = newNamespacedID(callerNamespace(r))
+	query.CreatedAt = time.Now()
+	// Subscriptions are added through their own endpoint so they can be validated
+	query.Subscriptions = []Subscription{}
+	if err := saveQuery(rdb, &query); err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to save query")
+		return
+	}
+	sendJSONResponse(w, http.StatusCreated, query)
+}
+
+// List saved queries handler - GET /queries
+func listSavedQueriesHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
+	queries, err := loadSavedQueries(rdb)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to get queries")
+		return
+	}
+	// Only the caller's own
+	own := make([]SavedQuery, 0, len(queries))
+	for _, query := range queries {
+		if inCallerNamespace(r, query.ID) {
+			own = append(own, query)
+		}
+	}
+	sendJSONResponse(w, http.StatusOK, SavedQueriesResponse{Queries: own})
+}
+
+// Delete saved query handler - DELETE /queries/{query_ID}
+func deleteSavedQueryHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
+	deleted, err := rdb.HDel(ctx, savedQueriesKey, mux.Vars(r)["query_ID"]).Result()
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to delete query")
+		return
+	}
+	if deleted == 0 {
+		sendErrorResponse(w, http.StatusNotFound, "Query not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Run saved query handler - GET /queries/{query_ID}/report?crawlID=
+func savedQueryReportHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
+	crawlID := r.URL.Query().Get("crawlID")
+	if crawlID == "" {
+		sendErrorResponse(w, http.StatusBadRequest, "Crawl ID is required")
+		return
+	}
+	query, err := loadSavedQuery(rdb, mux.Vars(r)["query_ID"])
+	if err == redis.Nil {
+		sendErrorResponse(w, http.StatusNotFound, "Query not found")
+		return
+	}
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to get query")
+		return
+	}
+	report, err := buildReport(rdb, *query, crawlID)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to build report")
+		return
+	}
+	sendJSONResponse(w, http.StatusOK, report)
+}
+
+// Subscribe handler - POST /queries/{query_ID}/subscriptions
+func subscribeSavedQueryHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
+	var subscription Subscription
+	if err := json.NewDecoder(r.Body).Decode(&subscription); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	deliverTo, err := url.Parse(subscription.DeliverTo)
+	if err != nil || (deliverTo.Scheme != "http" && deliverTo.Scheme != "https") {
+		sendErrorResponse(w, http.StatusBadRequest, "deliverTo must be an http(s) url")
+		return
+	}
+	if exists, _ := rdb.HExists(ctx, schedulesKey, subscription.ScheduleID).Result(); !exists || !inCallerNamespace(r, subscription.ScheduleID) {
+		sendErrorResponse(w, http.StatusNotFound, "Schedule not found")
+		return
+	}
+
+	query, err := loadSavedQuery(rdb, mux.Vars(r)["query_ID"])
+	if err == redis.Nil {
+		sendErrorResponse(w, http.StatusNotFound, "Query not found")
+		return
+	}
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to get query")
+		return
+	}
+	query.Subscriptions = append(query.Subscriptions, subscription)
+	if err := saveQuery(rdb, query); err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to save query")
+		return
+	}
+	sendJSONResponse(w, http.StatusCreated, query)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Quotas of callers without an API key or token of their own, under the anonymous policy
+const anonymousQuotaIdentity = "anonymous"
+
+var (
+	errQuotaExceeded      = errors.New("the pages per day quota the crawl counts against is used up")
+	errCrawlQuotaExceeded = errors.New("the crawls per hour quota is used up")
+)
+
+// Response headers telling callers where they stand, both quotas have a set
+var quotaHeaders = []string{
+	"X-Quota-Crawls-Limit", "X-Quota-Crawls-Remaining", "X-Quota-Crawls-Reset",
+	"X-Quota-Pages-Limit", "X-Quota-Pages-Remaining", "X-Quota-Pages-Reset",
+}
+
+// Helper function to build the redis key counting the crawls an API key started in an hour
+func crawlQuotaKey(identity string, hour time.Time) string {
+	return fmt.Sprintf("go-crawler-quota-crawls-%s-%s", identity, hour.UTC().Format("2006010215"))
+}
+
+// Helper function to build the redis key counting the pages an API key's crawls fetched in a day
+func pageQuotaKey(identity string, day time.Time) string {
+	return fmt.Sprintf("go-crawler-quota-pages-%s-%s", identity, day.UTC().Format("20060102"))
+}
+
+// quotaIdentity names whose quotas a request is charged to: the API key it
+// matched or the tenant and user of its token, hashed so keys don't end up in redis
+func quotaIdentity(r *http.Request, policy *TenantPolicy) string {
+	if caller, ok := tokenClaimsFromContext(r.Context()); ok {
+		// Tenants pick their own user names, the same one in two tenants is two users
+		return hashQuotaIdentity("user:" + caller.tenant + "/" + caller.user)
+	}
+	key := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	for _, tenantKey := range policy.APIKeys {
+		if key != "" && key == tenantKey {
+			return hashQuotaIdentity("key:" + key)
+		}
+	}
+	// Made up keys fall back to the anonymous policy, and share its quotas
+	return anonymousQuotaIdentity
+}
+
+func hashQuotaIdentity(identity string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(identity)))[:16]
+}
+
+// applyQuota makes the crawl's fetches count against the caller's pages per day quota
+func (limits *CrawlLimits) applyQuota(r *http.Request, policy *TenantPolicy) {
+	if policy == nil || policy.PagesPerDay <= 0 {
+		return
+	}
+	limits.QuotaIdentity = quotaIdentity(r, policy)
+	limits.PagesPerDay = policy.PagesPerDay
+}
+
+// Helper function to tell a caller how much of a quota is left, and when it's reset
+func setQuotaHeaders(w http.ResponseWriter, name string, limit, used int64, reset time.Time) {
+	remaining := limit - used
+	if remaining < 0 {
+		remaining = 0
+	}
+	w.Header().Set("X-Quota-"+name+"-Limit", strconv.FormatInt(limit, 10))
+	w.Header().Set("X-Quota-"+name+"-Remaining", strconv.FormatInt(remaining, 10))
+	w.Header().Set("X-Quota-"+name+"-Reset", strconv.FormatInt(reset.Unix(), 10))
+}
+
+// Helper function to refuse a request over quota, the caller may retry at reset
+func sendQuotaExceeded(w http.ResponseWriter, message string, reset time.Time) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(reset).Seconds())+1))
+	sendErrorResponse(w, http.StatusTooManyRequests, message)
+}
+
+// takeCrawlQuota charges a new crawl to the caller's quotas, refusing it with a
+// 429 and returning false if either is used up
+func takeCrawlQuota(w http.ResponseWriter, r *http.Request, rdb *redis.Client, policy *TenantPolicy) bool {
+	if policy == nil || (policy.CrawlsPerHour <= 0 && policy.PagesPerDay <= 0) {
+		return true
+	}
+	identity := quotaIdentity(r, policy)
+	now := time.Now().UTC()
+
+	if policy.PagesPerDay > 0 {
+		reset := now.Truncate(24 * time.Hour).Add(24 * time.Hour)
+		pages, err := rdb.Get(ctx, pageQuotaKey(identity, now)).Int64()
+		if err != nil && err != redis.Nil {
+			sendErrorResponse(w, http.StatusInternalServerError, "Failed to check quota")
+			return false
+		}
+		setQuotaHeaders(w, "Pages", int64(policy.PagesPerDay), pages, reset)
+		if pages >= int64(policy.PagesPerDay) {
+			sendQuotaExceeded(w, fmt.Sprintf("Pages per day quota of %d is used up", policy.PagesPerDay), reset)
+			return false
+		}
+	}
+
+	if policy.CrawlsPerHour > 0 {
+		crawls, reset, err := countCrawl(rdb, identity, policy.CrawlsPerHour)
+		if err != nil && err != errCrawlQuotaExceeded {
+			sendErrorResponse(w, http.StatusInternalServerError, "Failed to check quota")
+			return false
+		}
+		setQuotaHeaders(w, "Crawls", int64(policy.CrawlsPerHour), crawls, reset)
+		if err == errCrawlQuotaExceeded {
+			sendQuotaExceeded(w, fmt.Sprintf("Crawls per hour quota of %d is used up", policy.CrawlsPerHour), reset)
+			return false
+		}
+	}
+	return true
+}
+
+// countCrawl charges a crawl to an identity's crawls per hour quota, returning
+// errCrawlQuotaExceeded and leaving the count alone if it's used up. The count and
+// the end of the hour it's for are returned either way
+func countCrawl(rdb *redis.Client, identity string, crawlsPerHour int) (int64, time.Time, error) {
+	now := time.Now().UTC()
+	reset := now.Truncate(time.Hour).Add(time.Hour)
+	pipe := rdb.TxPipeline()
+	counted := pipe.Incr(ctx, crawlQuotaKey(identity, now))
+	pipe.ExpireAt(ctx, crawlQuotaKey(identity, now), reset.Add(time.Minute))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, reset, err
+	}
+	crawls := counted.Val()
+	if crawls > int64(crawlsPerHour) {
+		// Refused crawls don't count
+		rdb.Decr(ctx, crawlQuotaKey(identity, now))
+		return crawls - 1, reset, errCrawlQuotaExceeded
+	}
+	return crawls, reset, nil
+}
+
+// takePage charges a fetch to the crawl's pages per day quota, if it has one.
+// Best effort like the rate limits, fetches go on if redis is unhappy
+func (limits CrawlLimits) takePage(rdb *redis.Client) error {
+	if limits.QuotaIdentity == "" || limits.PagesPerDay <= 0 || rdb == nil {
+		return nil
+	}
+	now := time.Now().UTC()
+	pipe := rdb.TxPipeline()
+	counted := pipe.Incr(ctx, pageQuotaKey(limits.QuotaIdentity, now))
+	pipe.ExpireAt(ctx, pageQuotaKey(limits.QuotaIdentity, now), now.Truncate(24*time.Hour).Add(25*time.Hour))
+	if _, err := pipe.Exec(ctx); err != nil {
+		withError(logger.Warn(), err).Msg("Quota counter unavailable")
+		return nil
+	}
+	if counted.Val() > int64(limits.PagesPerDay) {
+		return errQuotaExceeded
+	}
+	return nil
+}
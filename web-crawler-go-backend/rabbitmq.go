@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+type (
+	// amqpPublisher shares one channel between everything this process
+	// publishes, reconnecting whenever the broker drops it
+	amqpPublisher struct {
+		sync.Mutex
+		url  string
+		conn *amqp.Connection
+		ch   *amqp.Channel
+	}
+	// amqpJobQueue reads crawl commands from a RabbitMQ queue, acknowledging
+	// each once its crawl finishes. Redis is still used for everything else
+	amqpJobQueue struct {
+		rdb       *redis.Client
+		url       string
+		queue     string
+		prefetch  int
+		publisher *amqpPublisher
+	}
+	// amqpResultSink publishes each crawl's results to a fanout exchange of
+	// its own, named like the crawl's results list
+	amqpResultSink struct {
+		publisher *amqpPublisher
+		declared  sync.Map
+	}
+)
+
+// withChannel runs use on the shared channel, dropping the connection if it
+// fails so the next call starts over on a fresh one
+func (p *amqpPublisher) withChannel(use func(ch *amqp.Channel) error) error {
+	p.Lock()
+	defer p.Unlock()
+	if p.conn == nil || p.conn.IsClosed() {
+		conn, err := amqp.Dial(p.url)
+		if err != nil {
+			return err
+		}
+		ch, err := conn.Channel()
+		if err != nil {
+			conn.Close()
+			return err
+		}
+		p.conn, p.ch = conn, ch
+	}
+	if err := use(p.ch); err != nil {
+		p.conn.Close()
+		p.conn = nil
+		return err
+	}
+	return nil
+}
+
+func newAMQPJobQueue(rdb *redis.Client, url, queue string, prefetch int) *amqpJobQueue {
+	return &amqpJobQueue{rdb: rdb, url: url, queue: queue, prefetch: prefetch, publisher: &amqpPublisher{url: url}}
+}
+
+// Helper function to declare the durable job queue, so it survives broker restarts
+func (q *amqpJobQueue) declare(ch *amqp.Channel) (amqp.Queue, error) {
+	return ch.QueueDeclare(q.queue, true, false, false, false, nil)
+}
+
+func (q *amqpJobQueue) enqueue(command string) error {
+	return q.publisher.withChannel(func(ch *amqp.Channel) error {
+		if _, err := q.declare(ch); err != nil {
+			return err
+		}
+		return ch.PublishWithContext(ctx, "", q.queue, false, false, amqp.Publishing{
+			ContentType:  "text/plain",
+			DeliveryMode: amqp.Persistent,
+			Body:         []byte(command),
+		})
+	})
+}
+
+// length counts the ready messages, jobs delivered to a worker but not yet acknowledged aren't included
+func (q *amqpJobQueue) length() (int64, error) {
+	var length int64
+	err := q.publisher.withChannel(func(ch *amqp.Channel) error {
+		queue, err := q.declare(ch)
+		length = int64(queue.Messages)
+		return err
+	})
+	return length, err
+}
+
+func (q *amqpJobQueue) consume(handle func(command string) error) {
+	for {
+		err := q.consumeConnection(handle)
+		reportSubscriber(err)
+		withError(logger.Error(), err).Msg("Lost connection to job queue")
+		time.Sleep(queueRetryDelaySeconds * time.Second)
+	}
+}
+
+// consumeConnection hands out jobs until the connection drops. The prefetch
+// limit caps how many unacknowledged jobs (and so crawls) this worker holds
+func (q *amqpJobQueue) consumeConnection(handle func(command string) error) error {
+	conn, err := amqp.Dial(q.url)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	if _, err := q.declare(ch); err != nil {
+		return err
+	}
+	if err := ch.Qos(q.prefetch, 0, false); err != nil {
+		return err
+	}
+	deliveries, err := ch.Consume(q.queue, consumerName(), false, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+	logger.Info().Str("queue", q.queue).Int("prefetch", q.prefetch).Msg("Consuming jobs")
+	reportSubscriber(nil)
+
+	for delivery := range deliveries {
+		go func(delivery amqp.Delivery) {
+			runJob(q.rdb, string(delivery.Body), handle)
+			// If the connection is gone by now the job is redelivered, and resumes from its checkpoint
+			if err := delivery.Ack(false); err != nil {
+				withError(logger.Error(), err).Msg("Failed to acknowledge job")
+			}
+		}(delivery)
+	}
+	return errors.New("delivery channel closed")
+}
+
+func newAMQPResultSink(url string) *amqpResultSink {
+	return &amqpResultSink{publisher: &amqpPublisher{url: url}}
+}
+
+// Helper function to publish a message to a crawl's exchange, declaring it first if need be
+func (sink *amqpResultSink) send(crawlID string, body []byte) error {
+	exchange := resultsKey(crawlID)
+	return sink.publisher.withChannel(func(ch *amqp.Channel) error {
+		if _, ok := sink.declared.Load(exchange); !ok {
+			if err := ch.ExchangeDeclare(exchange, amqp.ExchangeFanout, false, false, false, false, nil); err != nil {
+				return err
+			}
+			sink.declared.Store(exchange, true)
+		}
+		return ch.PublishWithContext(ctx, exchange, "", false, false, amqp.Publishing{
+			ContentType: "application/json",
+			Body:        body,
+		})
+	})
+}
+
+func (sink *amqpResultSink) publish(crawlID string, node graphNode) error {
+	marshalled, _ := json.Marshal(&node)
+	return sink.send(crawlID, marshalled)
+}
+
+// finish sends the same sentinel as the results list, then removes the exchange.
+// Messages already routed to a bound queue are kept
+func (sink *amqpResultSink) finish(crawlID string) error {
+	marshalled, _ := json.Marshal(finishSentinel{DoneMessage: "true"})
+	if err := sink.send(crawlID, marshalled); err != nil {
+		return err
+	}
+	exchange := resultsKey(crawlID)
+	sink.declared.Delete(exchange)
+	return sink.publisher.withChannel(func(ch *amqp.Channel) error {
+		return ch.ExchangeDelete(exchange, false, false)
+	})
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// tokenBucketScript takes a token from a host's bucket, returning 0 on success
+// or how many milliseconds to wait before a token will be available.
+// Running it in Redis keeps the bucket consistent across every worker
+var tokenBucketScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1]) or burst
+local ts = tonumber(state[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate / 1000)
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+else
+	wait = math.ceil((1 - tokens) * 1000 / rate)
+end
+redis.call('HSET', KEYS[1], 'tokens', tokens, 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst * 1000 / rate) + 1000)
+return wait
+`)
+
+// hostRateLimiter caps the aggregate request rate to each host across the fleet
+type hostRateLimiter struct {
+	rdb *redis.Client
+	// Requests per second allowed per host, and how many may be sent back to back
+	rate  float64
+	burst int
+	// Optional, kinds of request with a bucket of their own per host, e.g. status-only crawls
+	class string
+}
+
+// Helper function to build the redis key holding a host's token bucket
+func rateLimitKey(host string) string {
+	return fmt.Sprintf("go-crawler-ratelimit-%s", host)
+}
+
+// wait blocks until the fleet is allowed another request to urlToFetch's host
+func (limiter *hostRateLimiter) wait(urlToFetch string) {
+	parsedURL, err := url.Parse(urlToFetch)
+	if err != nil {
+		return
+	}
+	key := rateLimitKey(strings.ToLower(parsedURL.Hostname()))
+	if limiter.class != "" {
+		key = rateLimitKey(limiter.class + "-" + strings.ToLower(parsedURL.Hostname()))
+	}
+	limiter.take(key)
+}
+
+// take blocks until a token can be taken from the bucket at key
+func (limiter *hostRateLimiter) take(key string) {
+	for {
+		now := time.Now().UnixNano() / int64(time.Millisecond)
+		waitMs, err := tokenBucketScript.Run(ctx, limiter.rdb, []string{key}, limiter.rate, limiter.burst, now).Int64()
+		if err != nil {
+			// Politeness is best effort, don't stall the crawl if Redis is unhappy
+			withError(logger.Warn(), err).Msg("Rate limiter unavailable")
+			return
+		}
+		if waitMs == 0 {
+			return
+		}
+		time.Sleep(time.Duration(waitMs) * time.Millisecond)
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Channel a reload is announced on, so every process reads its configuration again
+const policyReloadChannel = "go-crawler-policy-reload"
+
+var errBlockedDomain = errors.New("url is on a blocked domain")
+
+// crawlPolicy holds the crawl defaults and politeness settings, the part of the
+// configuration that can be reloaded without a restart
+type crawlPolicy struct {
+	maxDepth    int
+	concurrency int
+	resultsTTL  time.Duration
+	// Bodies are read up to this size, the rest of the page is ignored
+	maxPageBytes int
+	maxLinks     int
+	hostRate     float64
+	hostBurst    int
+	// Hosts that are never fetched, each also blocking its subdomains
+	blockedDomains stringList
+}
+
+// PolicyResponse is the crawl policy a process is running with
+type PolicyResponse struct {
+	MaxDepth          int      `json:"maxDepth"`
+	Concurrency       int      `json:"concurrency"`
+	ResultsTTLSeconds int      `json:"resultsTTLSeconds"`
+	MaxPageBytes      int      `json:"maxPageBytes"`
+	MaxLinks          int      `json:"maxLinks"`
+	HostRate          float64  `json:"hostRate"`
+	HostBurst         int      `json:"hostBurst"`
+	BlockedDomains    []string `json:"blockedDomains"`
+}
+
+var defaultPolicy = crawlPolicy{
+	maxDepth:     7,
+	concurrency:  3,
+	resultsTTL:   60 * time.Second,
+	maxPageBytes: 8 << 20,
+	maxLinks:     7,
+	hostBurst:    1,
+}
+
+// Swapped as a whole on reload, crawls read it without locking
+var activePolicy atomic.Value
+
+// Helper function to get the crawl policy in effect
+func currentPolicy() crawlPolicy {
+	if policy, ok := activePolicy.Load().(crawlPolicy); ok {
+		return policy
+	}
+	return defaultPolicy
+}
+
+// registerPolicyFlags adds the flags for everything in policy
+func registerPolicyFlags(flags *flag.FlagSet, policy *crawlPolicy) {
+	flags.IntVar(&policy.maxDepth, "max-depth", policy.maxDepth, "depth crawls go to by default, and the deepest they may ask for")
+	flags.IntVar(&policy.concurrency, "crawl-concurrency", policy.concurrency, "pages each crawl fetches at once")
+	flags.DurationVar(&policy.resultsTTL, "results-ttl", policy.resultsTTL, "how long results are kept when the crawl doesn't ask otherwise, in whole seconds")
+	flags.IntVar(&policy.maxPageBytes, "max-page-bytes", policy.maxPageBytes, "bytes of each page that are read, the rest is ignored")
+	flags.IntVar(&policy.maxLinks, "max-links", policy.maxLinks, "links followed from each page")
+	flags.Float64Var(&policy.hostRate, "host-rate", policy.hostRate, "requests per second allowed to any one host across all workers, 0 for no limit")
+	flags.IntVar(&policy.hostBurst, "host-burst", policy.hostBurst, "requests that may be sent to a host back to back before -host-rate applies")
+	flags.Var(&policy.blockedDomains, "blocked-domains", "comma separated hosts never to fetch, subdomains included")
+}
+
+// validate checks the policy makes sense before any crawl uses it
+func (policy crawlPolicy) validate() error {
+	if policy.maxDepth < 1 {
+		return errors.New("-max-depth must be at least 1")
+	}
+	if policy.concurrency < 1 {
+		return errors.New("-crawl-concurrency must be at least 1")
+	}
+	if policy.resultsTTL < time.Second || policy.resultsTTL > maxCrawlResultsTTL*time.Second || policy.resultsTTL%time.Second != 0 {
+		return fmt.Errorf("-results-ttl must be whole seconds between 1s and %s", maxCrawlResultsTTL*time.Second)
+	}
+	if policy.maxPageBytes < 1 {
+		return errors.New("-max-page-bytes must be at least 1")
+	}
+	if policy.maxLinks < 1 {
+		return errors.New("-max-links must be at least 1")
+	}
+	if policy.hostRate < 0 {
+		return errors.New("-host-rate can't be negative")
+	}
+	if policy.hostBurst < 1 {
+		return errors.New("-host-burst must be at least 1")
+	}
+	for _, domain := range policy.blockedDomains {
+		if strings.ContainsAny(domain, "/:") {
+			return fmt.Errorf("-blocked-domains has %q, expected host names like example.com", domain)
+		}
+	}
+	return nil
+}
+
+// Helper function to get the per-host rate limiter new crawls use, nil without -host-rate
+func (policy crawlPolicy) hostLimiter(rdb *redis.Client) *hostRateLimiter {
+	if policy.hostRate <= 0 {
+		return nil
+	}
+	return &hostRateLimiter{rdb: rdb, rate: policy.hostRate, burst: policy.hostBurst}
+}
+
+// blocks reports whether rawURL is on one of the blocked domains
+func (policy crawlPolicy) blocks(rawURL string) bool {
+	if len(policy.blockedDomains) == 0 {
+		return false
+	}
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(parsedURL.Hostname())
+	for _, domain := range policy.blockedDomains {
+		domain = strings.ToLower(domain)
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+func (policy crawlPolicy) response() PolicyResponse {
+	blocked := append([]string{}, policy.blockedDomains...)
+	return PolicyResponse{
+		MaxDepth:          policy.maxDepth,
+		Concurrency:       policy.concurrency,
+		ResultsTTLSeconds: int(policy.resultsTTL / time.Second),
+		MaxPageBytes:      policy.maxPageBytes,
+		MaxLinks:          policy.maxLinks,
+		HostRate:          policy.hostRate,
+		HostBurst:         policy.hostBurst,
+		BlockedDomains:    blocked,
+	}
+}
+
+// reloadPolicy reads the configuration file and the environment again and
+// switches to the policy they give. Crawls already running keep their depth,
+// concurrency, results TTL and rate limits, new ones get the new settings
+func reloadPolicy() (crawlPolicy, error) {
+	policy := defaultPolicy
+	flags := flag.NewFlagSet("reload", flag.ContinueOnError)
+	registerPolicyFlags(flags, &policy)
+	if err := loadSettings(flags); err != nil {
+		return crawlPolicy{}, err
+	}
+	if err := policy.validate(); err != nil {
+		return crawlPolicy{}, err
+	}
+	activePolicy.Store(policy)
+	return policy, nil
+}
+
+// Helper function to reload the policy and log how it went
+func reloadPolicyAndLog(trigger string) error {
+	policy, err := reloadPolicy()
+	if err != nil {
+		withError(logger.Error(), err).Str("trigger", trigger).Msg("Failed to reload crawl policy, keeping the current one")
+		return err
+	}
+	logger.Info().Str("trigger", trigger).Interface("policy", policy.response()).Msg("Reloaded crawl policy")
+	return nil
+}
+
+// watchPolicyReload reloads the policy on SIGHUP, and whenever any process
+// announces a reload on policyReloadChannel
+func watchPolicyReload(rdb *redis.Client) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	announcements := rdb.Subscribe(ctx, policyReloadChannel).Channel()
+	for {
+		select {
+		case <-hangups:
+			reloadPolicyAndLog("sighup")
+		case _, ok := <-announcements:
+			if !ok {
+				return
+			}
+			reloadPolicyAndLog("admin")
+		}
+	}
+}
+
+// Crawl policy handler - GET /admin/policy
+func getPolicyHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
+	sendJSONResponse(w, http.StatusOK, currentPolicy().response())
+}
+
+// Crawl policy handler - POST /admin/policy/reload
+func reloadPolicyHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
+	// Here first, so a broken file is reported to the caller
+	policy, err := reloadPolicy()
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("Failed to reload crawl policy, keeping the current one: %v", err))
+		return
+	}
+	if err := rdb.Publish(ctx, policyReloadChannel, "reload").Err(); err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to announce reload")
+		return
+	}
+	sendJSONResponse(w, http.StatusOK, policy.response())
+}
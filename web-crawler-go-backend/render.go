@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/chromedp/cdproto/emulation"
+	"github.com/chromedp/cdproto/fetch"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	// How long a page may take to load and render in the browser
+	renderTimeout = 30 * time.Second
+	// How long scripts are given after the page loaded to add their links
+	renderSettleTime = 500 * time.Millisecond
+)
+
+var rendersMetric = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "crawler_renders_total",
+	Help: "Pages of crawls with render loaded in the headless browser, by result: ok or error.",
+}, []string{"result"})
+
+// Set from -render-workers and -chrome-path
+var (
+	renderWorkers int
+	chromePath    string
+)
+
+// Started at startup with -render-workers, nil without it
+var sharedRenderPool *renderPool
+
+type (
+	// renderingFetcher fetches pages like realFetcher does, then loads the
+	// HTML ones in the headless browser, so the links scripts add to them are
+	// crawled too
+	renderingFetcher struct {
+		realFetcher
+		pool *renderPool
+		// Whether a screenshot of every page rendered is kept in screenshotStore
+		screenshots bool
+	}
+)
+
+// newRenderPool starts the headless browser the render workers share. Chrome
+// or Chromium is found on the PATH unless path is set
+func newRenderPool(workers int, path string) (*renderPool, error) {
+	options := append([]chromedp.ExecAllocatorOption{}, chromedp.DefaultExecAllocatorOptions[:]...)
+	if path != "" {
+		options = append(options, chromedp.ExecPath(path))
+	}
+	if proxyAddr != "" {
+		// Checked at startup, the browser can't authenticate to a proxy itself
+		options = append(options, chromedp.ProxyServer(proxyAddr))
+	} else if crawlResolver != nil || ipFamily != ipFamilyAny {
+		resolvingProxy, err := startResolvingProxy()
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, chromedp.ProxyServer(resolvingProxy))
+	}
+	if os.Geteuid() == 0 {
+		// Chrome won't start sandboxed as root, which containers often run as
+		options = append(options, chromedp.NoSandbox)
+	}
+	pool := &renderPool{options: options, slots: make(chan struct{}, workers)}
+	var err error
+	if pool.current, err = startBrowser(options); err != nil {
+		return nil, err
+	}
+	return pool, nil
+}
+
+// startResolvingProxy serves a proxy on loopback that connects the way crawls
+// do, so the browser's hosts are looked up with -dns-servers, -dns-over-https
+// and -ip-family too. Returns its URL
+func startResolvingProxy() (string, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	dial := dialLocalhostAware(dialResolving((&net.Dialer{Timeout: dialTimeout}).DialContext))
+	forward := &httputil.ReverseProxy{
+		// The browser sends absolute urls to a proxy, they're forwarded as they are
+		Rewrite:   func(*httputil.ProxyRequest) {},
+		Transport: &http.Transport{DialContext: dial, TLSHandshakeTimeout: dialTimeout},
+	}
+	go http.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			forward.ServeHTTP(w, r)
+			return
+		}
+		upstream, err := dial(r.Context(), "tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer upstream.Close()
+		hijacker, ok := w.(http.Hijacker)
+		if !ok {
+			http.Error(w, "Can't tunnel", http.StatusInternalServerError)
+			return
+		}
+		client, buffered, err := hijacker.Hijack()
+		if err != nil {
+			return
+		}
+		defer client.Close()
+		client.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		go func() {
+			io.Copy(upstream, buffered)
+			upstream.Close()
+		}()
+		io.Copy(client, upstream)
+	}))
+	return "http://" + listener.Addr().String(), nil
+}
+
+// render loads pageURL in a new tab of a new browser context, serving the
+// page itself from body rather than downloading it again, and returns the
+// HTML once scripts ran, with a PNG of the viewport if screenshot is set.
+// Everything else the page needs, the browser downloads, once admit let it.
+// The requests admit refuses fail
+func (pool *renderPool) render(ctx context.Context, pageURL string, header http.Header, body []byte, screenshot bool, admit func(requestURL string) error) (string, []byte, error) {
+	browser, release, err := pool.acquire(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	defer release()
+	// A browser context of its own, so no cookies, storage or cache are shared
+	// with the pages of other renders, and crawls
+	tabCtx, cancel := chromedp.NewContext(browser.ctx, chromedp.WithNewBrowserContext())
+	defer cancel()
+	stop := context.AfterFunc(ctx, cancel)
+	defer stop()
+	tabCtx, cancelTimeout := context.WithTimeout(tabCtx, renderTimeout)
+	defer cancelTimeout()
+
+	var served atomic.Bool
+	chromedp.ListenTarget(tabCtx, func(event interface{}) {
+		paused, ok := event.(*fetch.EventRequestPaused)
+		if !ok {
+			return
+		}
+		// The first document is the page, later ones are frames
+		first := paused.ResourceType == network.ResourceTypeDocument && served.CompareAndSwap(false, true)
+		// Listeners mustn't wait on the browser, nor admit on rate limits
+		go func() {
+			var action chromedp.Action
+			switch {
+			case first:
+				action = fetch.FulfillRequest(paused.RequestID, http.StatusOK).
+					WithResponseHeaders([]*fetch.HeaderEntry{{Name: "Content-Type", Value: header.Get("Content-Type")}}).
+					WithBody(base64.StdEncoding.EncodeToString(body))
+			case admit(paused.Request.URL) != nil:
+				action = fetch.FailRequest(paused.RequestID, network.ErrorReasonBlockedByClient)
+			default:
+				action = fetch.ContinueRequest(paused.RequestID)
+			}
+			chromedp.Run(tabCtx, action)
+		}()
+	})
+	var html string
+	var png []byte
+	actions := chromedp.Tasks{
+		emulation.SetUserAgentOverride(requestUserAgent(ctx)),
+		chromedp.EmulateViewport(screenshotWidth, screenshotHeight),
+		fetch.Enable().WithPatterns([]*fetch.RequestPattern{{URLPattern: "*", RequestStage: fetch.RequestStageRequest}}),
+		chromedp.Navigate(pageURL),
+		chromedp.Sleep(renderSettleTime),
+		chromedp.OuterHTML("html", &html, chromedp.ByQuery),
+	}
+	if screenshot {
+		actions = append(actions, chromedp.CaptureScreenshot(&png))
+	}
+	err = chromedp.Run(tabCtx, actions)
+	if err == nil && !served.Load() {
+		err = errors.New("the browser didn't load the page")
+	}
+	return html, png, err
+}
+
+// admitBrowserRequest holds the requests the browser makes for a page to the
+// crawl's own: in scope, not blocked, within its quota and waiting for the
+// crawl's and the host's turn. Only http and https requests are let through
+func (f renderingFetcher) admitBrowserRequest(requestURL string) error {
+	parsedURL, err := url.Parse(requestURL)
+	if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") {
+		return errors.New("the browser may only make http and https requests")
+	}
+	if !f.limits.inScope(requestURL) {
+		return errOutOfScope
+	}
+	if currentPolicy().blocks(requestURL) {
+		return errBlockedDomain
+	}
+	if err := f.limits.takePage(f.rdb); err != nil {
+		return err
+	}
+	f.waitForTurn(requestURL)
+	return nil
+}
+
+// Helper function to tell whether a fetched page is one the browser can render
+func renderable(result *FetchResult) bool {
+	if result.Unchanged || result.StatusCode != http.StatusOK {
+		return false
+	}
+	mediaType, _, _ := mime.ParseMediaType(result.Header.Get("Content-Type"))
+	return mediaType == "text/html" || mediaType == "application/xhtml+xml"
+}
+
+func (f renderingFetcher) Fetch(ctx context.Context, urlToFetch string) (*FetchResult, error) {
+	result, err := f.realFetcher.Fetch(ctx, urlToFetch)
+	if err != nil || !renderable(result) {
+		return result, err
+	}
+	page, _ := io.ReadAll(result.Body)
+	result.Body = bytes.NewReader(page)
+	html, png, err := f.pool.render(ctx, result.FinalURL, result.Header, page, f.screenshots, f.admitBrowserRequest)
+	if err != nil {
+		if ctx.Err() == nil {
+			rendersMetric.WithLabelValues("error").Inc()
+			withError(crawlLogger(f.crawlID).Warn(), err).Str("url", urlToFetch).Msg("Failed to render page, keeping the links of its HTML")
+		}
+		return result, nil
+	}
+	rendersMetric.WithLabelValues("ok").Inc()
+	result.Rendered = true
+	if png != nil {
+		if err := screenshotStore.put(screenshotName(f.crawlID, urlToFetch), png); err != nil {
+			withError(crawlLogger(f.crawlID).Error(), err).Str("url", urlToFetch).Msg("Failed to store screenshot")
+		} else {
+			result.Screenshot = screenshotPath(f.crawlID, urlToFetch)
+		}
+	}
+	found := make(map[string]bool, len(result.URLs))
+	for _, link := range result.URLs {
+		found[link] = true
+	}
+	for _, link := range scrapeLinks(urlToFetch, strings.NewReader(html)) {
+		if !found[link] && f.limits.inScope(link) && !currentPolicy().blocks(link) {
+			found[link] = true
+			result.URLs = append(result.URLs, link)
+		}
+	}
+	return result, nil
+}
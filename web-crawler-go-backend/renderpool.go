@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/chromedp/cdproto/target"
+	"github.com/chromedp/chromedp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	// How long the browser is given to close before it's killed
+	browserCloseTimeout = 5 * time.Second
+	// How often the browser is checked, and how long it has to answer
+	renderHealthCheckPeriod  = 30 * time.Second
+	renderHealthCheckTimeout = 10 * time.Second
+)
+
+// Set from -render-browser-pages
+var renderBrowserPages int
+
+var errRenderPoolClosed = errors.New("the headless browser was closed")
+
+var (
+	renderQueueWaitMetric = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "crawler_render_queue_wait_seconds",
+		Help:    "Time pages of crawls with render waited for one of the worker's -render-workers.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 2, 14),
+	})
+	browserRestartsMetric = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "crawler_render_browser_restarts_total",
+		Help: "Headless browsers started in place of another, by reason: crashed, unhealthy or recycled.",
+	}, []string{"reason"})
+)
+
+type (
+	// renderPool renders pages in tabs of one headless browser, at most as
+	// many at once as it has slots. The browser is started again when it
+	// dies, fails its health check or rendered -render-browser-pages pages
+	renderPool struct {
+		options []chromedp.ExecAllocatorOption
+		slots   chan struct{}
+		sync.Mutex
+		current *renderBrowser
+		closed  bool
+	}
+	// renderBrowser is one run of the headless browser
+	renderBrowser struct {
+		ctx context.Context
+		// Closes the browser and removes its profile
+		close func()
+		// Pages rendered in it, and the renders still running
+		pages   int
+		running sync.WaitGroup
+	}
+)
+
+// Helper function to start a headless browser
+func startBrowser(options []chromedp.ExecAllocatorOption) (*renderBrowser, error) {
+	allocCtx, cancelAlloc := chromedp.NewExecAllocator(context.Background(), options...)
+	browserCtx, cancelBrowser := chromedp.NewContext(allocCtx)
+	closeBrowser := func() {
+		// Closing it gracefully lets it clean up, a browser that hangs is killed
+		closed := make(chan struct{})
+		go func() {
+			chromedp.Cancel(browserCtx)
+			close(closed)
+		}()
+		select {
+		case <-closed:
+		case <-time.After(browserCloseTimeout):
+		}
+		cancelBrowser()
+		// Waits for the browser to exit, then removes its profile
+		cancelAlloc()
+	}
+	// Starts the browser
+	if err := chromedp.Run(browserCtx); err != nil {
+		cancelBrowser()
+		cancelAlloc()
+		return nil, err
+	}
+	return &renderBrowser{ctx: browserCtx, close: closeBrowser}, nil
+}
+
+// acquire waits for a slot, then returns the browser to render a page in and
+// the function to call once the render is over
+func (pool *renderPool) acquire(ctx context.Context) (*renderBrowser, func(), error) {
+	start := time.Now()
+	select {
+	case pool.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	}
+	renderQueueWaitMetric.Observe(time.Since(start).Seconds())
+	browser, err := pool.browser()
+	if err != nil {
+		<-pool.slots
+		return nil, nil, err
+	}
+	return browser, func() {
+		browser.running.Done()
+		<-pool.slots
+	}, nil
+}
+
+// browser returns the browser to render the next page in, starting a new one
+// when the last one died or rendered its share of pages
+func (pool *renderPool) browser() (*renderBrowser, error) {
+	pool.Lock()
+	defer pool.Unlock()
+	if pool.closed {
+		return nil, errRenderPoolClosed
+	}
+	// chromedp cancels the context of a browser it lost the connection to
+	if pool.current.ctx.Err() != nil {
+		logger.Warn().Msg("The headless browser died, starting it again")
+		if err := pool.replace("crashed"); err != nil {
+			return nil, err
+		}
+	} else if renderBrowserPages > 0 && pool.current.pages >= renderBrowserPages {
+		logger.Info().Int("pages", pool.current.pages).Msg("Starting a new headless browser in place of one that rendered its share of pages")
+		if err := pool.replace("recycled"); err != nil {
+			// It's tried again after as many pages, the old browser still works
+			withError(logger.Error(), err).Msg("Failed to start a new headless browser, keeping the old one")
+			pool.current.pages = 0
+		}
+	}
+	pool.current.pages++
+	pool.current.running.Add(1)
+	return pool.current, nil
+}
+
+// replace starts a new browser in place of the current one, which is closed
+// once the renders still running in it are over. Called with the pool locked
+func (pool *renderPool) replace(reason string) error {
+	browser, err := startBrowser(pool.options)
+	if err != nil {
+		return err
+	}
+	browserRestartsMetric.WithLabelValues(reason).Inc()
+	old := pool.current
+	pool.current = browser
+	go func() {
+		old.running.Wait()
+		old.close()
+	}()
+	return nil
+}
+
+// checkHealth asks the browser for its tabs periodically, starting a new one
+// in place of a browser that died or doesn't answer
+func (pool *renderPool) checkHealth() {
+	ticker := time.NewTicker(renderHealthCheckPeriod)
+	defer ticker.Stop()
+	for range ticker.C {
+		pool.Lock()
+		if pool.closed {
+			pool.Unlock()
+			return
+		}
+		browser := pool.current
+		pool.Unlock()
+		checkCtx, cancel := context.WithTimeout(browser.ctx, renderHealthCheckTimeout)
+		err := chromedp.Run(checkCtx, chromedp.ActionFunc(func(ctx context.Context) error {
+			_, err := target.GetTargets().Do(ctx)
+			return err
+		}))
+		cancel()
+		if err == nil {
+			continue
+		}
+		pool.Lock()
+		// Unless the browser was replaced or closed while it was checked
+		if pool.current == browser && !pool.closed {
+			reason := "unhealthy"
+			if browser.ctx.Err() != nil {
+				reason = "crashed"
+			}
+			withError(logger.Warn(), err).Str("reason", reason).Msg("The headless browser failed its health check, starting it again")
+			if err := pool.replace(reason); err != nil {
+				withError(logger.Error(), err).Msg("Failed to start the headless browser again")
+			}
+		}
+		pool.Unlock()
+	}
+}
+
+// close closes the browser, renders still running fail and later ones aren't started
+func (pool *renderPool) close() {
+	pool.Lock()
+	defer pool.Unlock()
+	if !pool.closed {
+		pool.closed = true
+		pool.current.close()
+	}
+}
+
+// closeOnExit closes the browser when the process is interrupted or
+// terminated, then lets the signal end the process as it would have
+func (pool *renderPool) closeOnExit() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	received := <-signals
+	logger.Info().Str("signal", received.String()).Msg("Closing the headless browser")
+	pool.close()
+	signal.Reset(received)
+	syscall.Kill(os.Getpid(), received.(syscall.Signal))
+}
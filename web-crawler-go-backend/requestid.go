@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// Request IDs callers may send, kept to characters that are safe in logs and headers
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// Request IDs of the crawls this worker is running, so every line logged about them carries it
+var crawlRequestIDs sync.Map
+
+type requestIDKey struct{}
+
+// Helper function to build the redis key holding what's known about how a crawl was started
+func crawlMetaKey(uniqueID string) string {
+	return fmt.Sprintf("go-crawler-meta-%s", uniqueID)
+}
+
+// Helper function to make a random request ID
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return fmt.Sprintf("%x", b)
+}
+
+// Helper function to get the ID of the API request ctx belongs to
+func requestIDFromContext(requestCtx context.Context) string {
+	requestID, _ := requestCtx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+// recordCrawlMeta remembers how a crawl was started, like the request or the
+// user that started it. Resumes and retries keep the first value
+func recordCrawlMeta(rdb *redis.Client, uniqueID, field, value string) {
+	pipe := rdb.TxPipeline()
+	pipe.HSetNX(ctx, crawlMetaKey(uniqueID), field, value)
+	expireLikeCheckpoint(pipe, crawlMetaKey(uniqueID))
+	if _, err := pipe.Exec(ctx); err != nil {
+		withError(crawlLogger(uniqueID).Error(), err).Str("field", field).Msg("Failed to record how crawl was started")
+	}
+}
+
+// requestIDMiddleware gives every API request an ID, the caller's X-Request-ID
+// if it sent a usable one, and echoes it in the response
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(requestIDHeader)
+		if !requestIDPattern.MatchString(requestID) {
+			requestID = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, requestID)
+		trace.SpanFromContext(r.Context()).SetAttributes(label.String("request.id", requestID))
+
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, requestID)))
+		event := logger.Debug().Str("request_id", requestID).Str("method", r.Method).Str("path", r.URL.Path).Int("status", recorder.status).Dur("duration_ms", time.Since(start))
+		if crawlID := mux.Vars(r)["crawl_ID"]; crawlID != "" {
+			event = event.Str("crawl_id", crawlID)
+		}
+		event.Msg("Handled API request")
+	})
+}
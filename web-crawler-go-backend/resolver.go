@@ -0,0 +1,456 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// The system resolver doesn't say how long its answers hold, they're cached this long
+	systemDNSCacheTTL = 30 * time.Second
+	// Bounds on how long answers are cached, whatever their TTLs say. Failed
+	// lookups are cached for the shortest
+	minDNSCacheTTL = 5 * time.Second
+	maxDNSCacheTTL = time.Hour
+	// Hosts the cache holds at most, expired ones are dropped when it's full
+	maxDNSCacheEntries = 100000
+	// Largest DNS response read over UDP, bigger ones are asked for again over TCP
+	maxUDPDNSResponse = 1232
+	// Largest DNS response read from a DNS-over-HTTPS endpoint
+	maxDoHResponse = 64 << 10
+)
+
+var dnsLookupsMetric = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "crawler_dns_lookups_total",
+	Help: "Host lookups of crawls with -dns-cache, -dns-servers or -dns-over-https, by result: hit, miss or error.",
+}, []string{"result"})
+
+// Set from -dns-servers, -dns-over-https and -dns-cache
+var (
+	dnsServers   stringList
+	dnsOverHTTPS string
+	dnsCache     bool
+)
+
+// Built from dnsServers, dnsOverHTTPS and dnsCache at startup, nil to dial the way Go does
+var crawlResolver *cachingResolver
+
+type (
+	// hostResolver looks up the addresses of a host, and how long they hold
+	hostResolver interface {
+		lookup(lookupCtx context.Context, host string) ([]net.IP, time.Duration, error)
+	}
+	// systemResolver asks the system's resolver, which doesn't give TTLs
+	systemResolver struct{}
+	// upstreamResolver asks DNS servers itself. Hosts under one of the domains
+	// of split go to that domain's servers, the longest domain wins. The others
+	// go to servers, or to the DNS-over-HTTPS endpoint dohURL instead
+	upstreamResolver struct {
+		servers []string
+		split   map[string][]string
+		dohURL  string
+		// Never resolves through crawlResolver, endpoints given by name are
+		// looked up by the system
+		dohClient *http.Client
+	}
+	// dnsExchange sends a packed query to a server and reads its response
+	dnsExchange func(exchangeCtx context.Context, query []byte) (*dnsmessage.Message, error)
+	// cachingResolver keeps the answers of another resolver until they expire
+	cachingResolver struct {
+		resolver hostResolver
+		inflight singleflight.Group
+		sync.Mutex
+		entries map[string]dnsCacheEntry
+	}
+	dnsCacheEntry struct {
+		ips     []net.IP
+		err     error
+		expires time.Time
+	}
+)
+
+// newCrawlResolver builds the resolver of -dns-servers and -dns-over-https,
+// cached with -dns-cache. Each entry is ip[:port], or domain=ip[:port] for
+// split horizon
+func newCrawlResolver(entries []string, dohURL string, cache bool) (*cachingResolver, error) {
+	var resolver hostResolver = systemResolver{}
+	if len(entries) > 0 || dohURL != "" {
+		upstream := &upstreamResolver{split: make(map[string][]string)}
+		if dohURL != "" {
+			endpoint, err := url.Parse(dohURL)
+			if err != nil || endpoint.Scheme != "https" || endpoint.Host == "" {
+				return nil, fmt.Errorf("%q must be an https url like https://1.1.1.1/dns-query", dohURL)
+			}
+			upstream.dohURL = dohURL
+			upstream.dohClient = &http.Client{Timeout: dialTimeout}
+		}
+		for _, entry := range entries {
+			domain, server := "", entry
+			if equals := strings.Index(entry, "="); equals >= 0 {
+				domain, server = strings.ToLower(strings.Trim(entry[:equals], ".")), entry[equals+1:]
+				if domain == "" {
+					return nil, fmt.Errorf("%q has no domain before =", entry)
+				}
+			}
+			if _, _, err := net.SplitHostPort(server); err != nil {
+				server = net.JoinHostPort(server, "53")
+			}
+			host, _, err := net.SplitHostPort(server)
+			if err != nil || net.ParseIP(host) == nil {
+				return nil, fmt.Errorf("%q must be an ip[:port] or domain=ip[:port]", entry)
+			}
+			if domain == "" && dohURL != "" {
+				return nil, fmt.Errorf("%q can't be used with -dns-over-https, only domain=ip[:port] entries can", entry)
+			}
+			if domain == "" {
+				upstream.servers = append(upstream.servers, server)
+			} else {
+				upstream.split[domain] = append(upstream.split[domain], server)
+			}
+		}
+		resolver = upstream
+	}
+	caching := &cachingResolver{resolver: resolver, entries: make(map[string]dnsCacheEntry)}
+	if !cache {
+		caching.entries = nil
+	}
+	return caching, nil
+}
+
+func (systemResolver) lookup(lookupCtx context.Context, host string) ([]net.IP, time.Duration, error) {
+	addrs, err := net.DefaultResolver.LookupIPAddr(lookupCtx, host)
+	ips := make([]net.IP, 0, len(addrs))
+	for _, addr := range addrs {
+		ips = append(ips, addr.IP)
+	}
+	return ips, systemDNSCacheTTL, err
+}
+
+// Helper function to pick the servers a host is looked up with
+func (resolver *upstreamResolver) serversFor(host string) []string {
+	best := ""
+	for domain := range resolver.split {
+		if (host == domain || strings.HasSuffix(host, "."+domain)) && len(domain) > len(best) {
+			best = domain
+		}
+	}
+	if best != "" {
+		return resolver.split[best]
+	}
+	return resolver.servers
+}
+
+// lookup asks for the host's A and AAAA records, from the first of its
+// servers that answers, or from the DNS-over-HTTPS endpoint. The addresses
+// hold as long as the shortest TTL
+func (resolver *upstreamResolver) lookup(lookupCtx context.Context, host string) ([]net.IP, time.Duration, error) {
+	servers := resolver.serversFor(strings.ToLower(strings.TrimSuffix(host, ".")))
+	if len(servers) == 0 && resolver.dohURL == "" {
+		// Only split domains were given, the rest goes to the system
+		return systemResolver{}.lookup(lookupCtx, host)
+	}
+	name, err := dnsmessage.NewName(strings.TrimSuffix(host, ".") + ".")
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(servers) == 0 {
+		return lookupAddresses(lookupCtx, host, name, resolver.dohURL, resolver.exchangeDoH)
+	}
+	var lastErr error
+	for _, server := range servers {
+		ips, ttl, err := lookupAddresses(lookupCtx, host, name, server, exchangeUDP(server))
+		if err == nil || isNotFound(err) {
+			return ips, ttl, err
+		}
+		lastErr = err
+	}
+	return nil, 0, lastErr
+}
+
+// Helper function to ask a server for both kinds of addresses of a host
+func lookupAddresses(lookupCtx context.Context, host string, name dnsmessage.Name, server string, exchange dnsExchange) ([]net.IP, time.Duration, error) {
+	ipv4, ipv4TTL, err := queryDNS(lookupCtx, server, exchange, name, dnsmessage.TypeA)
+	if err != nil {
+		return nil, 0, err
+	}
+	ipv6, ipv6TTL, err := queryDNS(lookupCtx, server, exchange, name, dnsmessage.TypeAAAA)
+	if err != nil {
+		return nil, 0, err
+	}
+	// The TTL of a missing kind of address doesn't shorten the other's
+	switch {
+	case len(ipv4) > 0 && len(ipv6) > 0:
+		return append(ipv4, ipv6...), min(ipv4TTL, ipv6TTL), nil
+	case len(ipv4) > 0:
+		return ipv4, ipv4TTL, nil
+	case len(ipv6) > 0:
+		return ipv6, ipv6TTL, nil
+	}
+	return nil, min(ipv4TTL, ipv6TTL), &net.DNSError{Err: "no such host", Name: host, Server: server, IsNotFound: true}
+}
+
+// queryDNS asks server for the records of a type, sending the query with
+// exchange. Without records, the TTL is how long the zone's SOA says their
+// absence holds
+func queryDNS(lookupCtx context.Context, server string, exchange dnsExchange, name dnsmessage.Name, recordType dnsmessage.Type) ([]net.IP, time.Duration, error) {
+	// Unpredictable, so answers can't be spoofed as easily
+	var random [2]byte
+	if _, err := rand.Read(random[:]); err != nil {
+		return nil, 0, err
+	}
+	id := binary.BigEndian.Uint16(random[:])
+	query, err := (&dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: name, Type: recordType, Class: dnsmessage.ClassINET}},
+	}).Pack()
+	if err != nil {
+		return nil, 0, err
+	}
+	exchangeCtx, cancel := context.WithTimeout(lookupCtx, dialTimeout)
+	defer cancel()
+	response, err := exchange(exchangeCtx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+	if response.Header.ID != id || len(response.Questions) != 1 || response.Questions[0].Name != name {
+		return nil, 0, fmt.Errorf("dns server %s answered another question", server)
+	}
+	switch response.Header.RCode {
+	case dnsmessage.RCodeSuccess, dnsmessage.RCodeNameError:
+	default:
+		return nil, 0, fmt.Errorf("dns server %s answered %v", server, response.Header.RCode)
+	}
+	var ips []net.IP
+	ttl := maxDNSCacheTTL
+	for _, answer := range response.Answers {
+		var ip net.IP
+		switch body := answer.Body.(type) {
+		case *dnsmessage.AResource:
+			ip = net.IP(body.A[:])
+		case *dnsmessage.AAAAResource:
+			ip = net.IP(body.AAAA[:])
+		default:
+			// CNAMEs are followed by the server, their targets' records come along
+			continue
+		}
+		ips = append(ips, ip)
+		ttl = min(ttl, time.Duration(answer.Header.TTL)*time.Second)
+	}
+	if len(ips) > 0 {
+		return ips, ttl, nil
+	}
+	ttl = 0
+	for _, authority := range response.Authorities {
+		if soa, ok := authority.Body.(*dnsmessage.SOAResource); ok {
+			ttl = time.Duration(min(authority.Header.TTL, soa.MinTTL)) * time.Second
+		}
+	}
+	return nil, ttl, nil
+}
+
+// exchangeUDP sends queries to server over UDP, and over TCP when the answer doesn't fit
+func exchangeUDP(server string) dnsExchange {
+	return func(exchangeCtx context.Context, query []byte) (*dnsmessage.Message, error) {
+		response, err := exchangeDNSOver(exchangeCtx, "udp", server, query)
+		if err == nil && response.Header.Truncated {
+			return exchangeDNSOver(exchangeCtx, "tcp", server, query)
+		}
+		return response, err
+	}
+}
+
+// exchangeDoH sends a query to the DNS-over-HTTPS endpoint, as RFC 8484 POSTs it
+func (resolver *upstreamResolver) exchangeDoH(exchangeCtx context.Context, query []byte) (*dnsmessage.Message, error) {
+	req, err := http.NewRequestWithContext(exchangeCtx, http.MethodPost, resolver.dohURL, bytes.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	resp, err := resolver.dohClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("dns-over-https endpoint answered %s", resp.Status)
+	}
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, maxDoHResponse))
+	if err != nil {
+		return nil, err
+	}
+	var response dnsmessage.Message
+	if err := response.Unpack(raw); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// Helper function to send a DNS query and read the response, over udp or tcp
+func exchangeDNSOver(exchangeCtx context.Context, network, server string, query []byte) (*dnsmessage.Message, error) {
+	conn, err := (&net.Dialer{}).DialContext(exchangeCtx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := exchangeCtx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	var raw []byte
+	if network == "udp" {
+		if _, err := conn.Write(query); err != nil {
+			return nil, err
+		}
+		raw = make([]byte, maxUDPDNSResponse)
+		n, err := conn.Read(raw)
+		if err != nil {
+			return nil, err
+		}
+		raw = raw[:n]
+	} else {
+		// Over tcp, messages are prefixed with their length
+		framed := binary.BigEndian.AppendUint16(nil, uint16(len(query)))
+		if _, err := conn.Write(append(framed, query...)); err != nil {
+			return nil, err
+		}
+		var length [2]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return nil, err
+		}
+		raw = make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(conn, raw); err != nil {
+			return nil, err
+		}
+	}
+	var response dnsmessage.Message
+	if err := response.Unpack(raw); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// lookup returns the addresses of host, from the cache while they hold.
+// Concurrent lookups of a host share one query
+func (resolver *cachingResolver) lookup(lookupCtx context.Context, host string) ([]net.IP, error) {
+	if resolver.entries != nil {
+		resolver.Lock()
+		entry, ok := resolver.entries[host]
+		resolver.Unlock()
+		if ok && time.Now().Before(entry.expires) {
+			dnsLookupsMetric.WithLabelValues("hit").Inc()
+			return entry.ips, entry.err
+		}
+	}
+	answer, err, _ := resolver.inflight.Do(host, func() (interface{}, error) {
+		// Not tied to the first caller, the others may still want the answer
+		queryCtx, cancel := context.WithTimeout(context.Background(), 2*dialTimeout)
+		defer cancel()
+		ips, ttl, err := resolver.resolver.lookup(queryCtx, host)
+		if err != nil && !isNotFound(err) {
+			// Servers that don't answer aren't remembered for long
+			ttl = 0
+		}
+		resolver.store(host, dnsCacheEntry{ips: ips, err: err, expires: time.Now().Add(clampDNSTTL(ttl))})
+		return ips, err
+	})
+	if err != nil {
+		dnsLookupsMetric.WithLabelValues("error").Inc()
+		return nil, err
+	}
+	dnsLookupsMetric.WithLabelValues("miss").Inc()
+	return answer.([]net.IP), nil
+}
+
+// Helper function to tell a host that doesn't exist from a lookup that failed
+func isNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+// Helper function to keep how long an answer is cached within bounds
+func clampDNSTTL(ttl time.Duration) time.Duration {
+	if ttl < minDNSCacheTTL {
+		return minDNSCacheTTL
+	}
+	if ttl > maxDNSCacheTTL {
+		return maxDNSCacheTTL
+	}
+	return ttl
+}
+
+func (resolver *cachingResolver) store(host string, entry dnsCacheEntry) {
+	if resolver.entries == nil {
+		return
+	}
+	resolver.Lock()
+	defer resolver.Unlock()
+	if len(resolver.entries) >= maxDNSCacheEntries {
+		now := time.Now()
+		for cached, old := range resolver.entries {
+			if now.After(old.expires) {
+				delete(resolver.entries, cached)
+			}
+		}
+		// Still full of live entries, start over rather than grow without bound
+		if len(resolver.entries) >= maxDNSCacheEntries {
+			resolver.entries = make(map[string]dnsCacheEntry)
+		}
+	}
+	resolver.entries[host] = entry
+}
+
+// Helper function to look a host up for a dial, with the crawl resolver or
+// the system's, in the order -ip-family asks for
+func resolveForDial(ctx context.Context, host string) ([]net.IP, error) {
+	var ips []net.IP
+	var err error
+	if crawlResolver != nil {
+		ips, err = crawlResolver.lookup(ctx, host)
+	} else {
+		ips, _, err = systemResolver{}.lookup(ctx, host)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return orderAddresses(host, ips)
+}
+
+// dialResolving looks hosts up with the crawl resolver, when there is one or
+// -ip-family orders addresses, and dials their addresses in turn until one answers
+func dialResolving(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		network = familyNetwork(network)
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil || (crawlResolver == nil && ipFamily == ipFamilyAny) || net.ParseIP(host) != nil {
+			return dial(ctx, network, addr)
+		}
+		ips, err := resolveForDial(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range ips {
+			var conn net.Conn
+			conn, err = dial(ctx, network, net.JoinHostPort(ip.String(), port))
+			if err == nil {
+				return conn, nil
+			}
+		}
+		return nil, err
+	}
+}
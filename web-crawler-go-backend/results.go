@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+)
+
+type (
+	// ResultStore keeps the nodes each crawl publishes, in the order they were
+	// found, followed by a marker once the crawl is done
+	ResultStore interface {
+		Append(crawlID string, nodes ...graphNode) error
+		// Range returns the nodes from index start to stop inclusive (-1 for the
+		// last one), and whether the done marker was reached
+		Range(crawlID string, start, stop int64) ([]graphNode, bool, error)
+		// Len counts the stored entries, the done marker included
+		Len(crawlID string) (int64, error)
+		MarkDone(crawlID string) error
+		Expire(crawlID string, ttl time.Duration) error
+		// StorePage keeps a page body of the crawl, replacing any earlier one of
+		// the same url. Page returns errPageNotStored if there is none
+		StorePage(crawlID string, page storedPage) error
+		Page(crawlID, url string) (*storedPage, error)
+		// Delete removes the crawl's nodes, done marker and pages
+		Delete(crawlID string) error
+	}
+	// redisResultStore keeps each crawl's results in the list go-crawler-results-<id>,
+	// one JSON node per entry and the finish sentinel last
+	redisResultStore struct {
+		rdb *redis.Client
+	}
+)
+
+// resultStore is where crawls publish their results, set up once in main
+var resultStore ResultStore
+
+func (store redisResultStore) Append(crawlID string, nodes ...graphNode) error {
+	if len(nodes) == 0 {
+		return nil
+	}
+	entries := make([]interface{}, 0, len(nodes))
+	for i := range nodes {
+		marshalled, err := json.Marshal(&nodes[i])
+		if err != nil {
+			return err
+		}
+		entries = append(entries, marshalled)
+	}
+	return store.rdb.RPush(ctx, resultsKey(crawlID), entries...).Err()
+}
+
+func (store redisResultStore) Range(crawlID string, start, stop int64) ([]graphNode, bool, error) {
+	rawResults, err := store.rdb.LRange(ctx, resultsKey(crawlID), start, stop).Result()
+	if err != nil {
+		return nil, false, err
+	}
+	nodes, done := parseResults(rawResults)
+	return nodes, done, nil
+}
+
+func (store redisResultStore) Len(crawlID string) (int64, error) {
+	return store.rdb.LLen(ctx, resultsKey(crawlID)).Result()
+}
+
+func (store redisResultStore) MarkDone(crawlID string) error {
+	marshalled, _ := json.Marshal(finishSentinel{DoneMessage: "true"})
+	return store.rdb.RPush(ctx, resultsKey(crawlID), marshalled).Err()
+}
+
+func (store redisResultStore) Expire(crawlID string, ttl time.Duration) error {
+	pipe := store.rdb.Pipeline()
+	pipe.Expire(ctx, resultsKey(crawlID), ttl)
+	pipe.Expire(ctx, pagesKey(crawlID), ttl)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (store redisResultStore) Delete(crawlID string) error {
+	return store.rdb.Del(ctx, resultsKey(crawlID), pagesKey(crawlID)).Err()
+}
+
+// crawlExists reports whether a crawl has results, or is still running and has a checkpoint
+func crawlExists(rdb *redis.Client, uniqueID string) (bool, error) {
+	length, err := resultStore.Len(uniqueID)
+	if err != nil || length > 0 {
+		return length > 0, err
+	}
+	checkpoints, err := rdb.Exists(ctx, checkpointKey(uniqueID)).Result()
+	return checkpoints > 0, err
+}
+
+// deleteCrawlData removes a crawl's results and everything attached to them,
+// in Redis, the cold store, the search index and the WARC archive
+func deleteCrawlData(rdb *redis.Client, uniqueID string) error {
+	if err := resultStore.Delete(uniqueID); err != nil {
+		return fmt.Errorf("could not delete results: %v", err)
+	}
+	keys := []string{annotationsKey(uniqueID), crawlErrorsKey(uniqueID), trackersKey(uniqueID), contentTypesKey(uniqueID), fetchStatsKey(uniqueID), seoPagesKey(uniqueID), securityHeadersKey(uniqueID), analysisKey(uniqueID), urlScoresKey(uniqueID), largestAssetsKey(uniqueID), timelineKey(uniqueID), crawlMetaKey(uniqueID), visitedKey(uniqueID), artifactsKey(uniqueID), checkpointKey(uniqueID), injectedURLsKey(uniqueID)}
+	artifactIDs, err := rdb.HKeys(ctx, artifactsKey(uniqueID)).Result()
+	if err != nil {
+		return fmt.Errorf("could not list artifacts: %v", err)
+	}
+	for _, artifactID := range artifactIDs {
+		keys = append(keys, artifactContentKey(uniqueID, artifactID))
+	}
+	if err := rdb.Del(ctx, keys...).Err(); err != nil {
+		return fmt.Errorf("could not delete crawl data: %v", err)
+	}
+	if pageSearch != nil {
+		if err := pageSearch.deleteCrawl(uniqueID); err != nil {
+			return fmt.Errorf("could not delete search documents: %v", err)
+		}
+	}
+	if coldStorage != nil {
+		if err := coldStorage.delete(coldArchiveName(uniqueID)); err != nil {
+			return fmt.Errorf("could not delete archived results: %v", err)
+		}
+		rdb.ZRem(ctx, retentionKey, uniqueID)
+	}
+	if pageArchive != nil {
+		if err := pageArchive.deleteCrawl(uniqueID); err != nil {
+			return fmt.Errorf("could not delete WARC files: %v", err)
+		}
+	}
+	return nil
+}
+
+// Delete results handler - DELETE /crawl/{crawl_ID}/results
+func deleteResultsHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
+	crawlID := mux.Vars(r)["crawl_ID"]
+
+	// A running crawl would carry on writing what we delete
+	checkpoint, err := loadCheckpoint(rdb, crawlID)
+	if err != nil && err != redis.Nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to get checkpoint")
+		return
+	}
+	if err == nil && time.Since(checkpoint.UpdatedAt) < 2*checkpointPeriodInSeconds*time.Second {
+		sendErrorResponse(w, http.StatusConflict, "Crawl is still running")
+		return
+	}
+
+	if err := deleteCrawlData(rdb, crawlID); err != nil {
+		withError(crawlLogger(crawlID).Error(), err).Msg("Failed to delete crawl")
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to delete crawl, try again")
+		return
+	}
+	crawlLogger(crawlID).Info().Msg("Deleted results of crawl")
+	w.WriteHeader(http.StatusNoContent)
+}
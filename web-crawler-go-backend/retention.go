@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// Crawl ID -> when its results stop being hot, as a unix time
+	retentionKey             = "go-crawler-retention"
+	retentionPeriodInSeconds = 10
+	// Results lists outlive their hot TTL by this much, so they can be archived before Redis drops them
+	retentionGrace = 10 * time.Minute
+	// Entries read from a results list at a time while archiving
+	retentionBatchSize = 1000
+)
+
+var errNotArchived = errors.New("crawl is not archived")
+
+type (
+	// coldStore keeps archived results lists, one compressed object per crawl.
+	// -screenshot-store keeps screenshots in one too
+	coldStore interface {
+		put(name string, data []byte) error
+		// get returns errNotArchived if there is no such object
+		get(name string) ([]byte, error)
+		delete(name string) error
+	}
+	// fileColdStore keeps archives as files in a directory
+	fileColdStore struct {
+		dir string
+	}
+	// s3ColdStore keeps archives as objects in an S3 (or MinIO) bucket
+	s3ColdStore struct {
+		client *s3.Client
+		bucket string
+		prefix string
+	}
+)
+
+// Set up in main when -cold-store is given, results simply expire otherwise
+var coldStorage coldStore
+
+// newColdStore picks a cold store from a file:///dir or s3://bucket/prefix url
+func newColdStore(rawURL string, awsConfig aws.Config, s3Endpoint string) (coldStore, error) {
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	switch parsedURL.Scheme {
+	case "file":
+		if err := os.MkdirAll(parsedURL.Path, 0700); err != nil {
+			return nil, err
+		}
+		return fileColdStore{dir: parsedURL.Path}, nil
+	case "s3":
+		client := s3.NewFromConfig(awsConfig, func(o *s3.Options) {
+			if s3Endpoint != "" {
+				o.EndpointResolver = s3.EndpointResolverFromURL(s3Endpoint)
+				o.UsePathStyle = true
+			}
+		})
+		return s3ColdStore{client: client, bucket: parsedURL.Host, prefix: strings.Trim(parsedURL.Path, "/")}, nil
+	}
+	return nil, fmt.Errorf("cold store must be a file:// or s3:// url, got %s", rawURL)
+}
+
+func (store fileColdStore) put(name string, data []byte) error {
+	// Written aside first, a crash never leaves half an archive under the real name
+	path := filepath.Join(store.dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path+".tmp", data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func (store fileColdStore) get(name string) ([]byte, error) {
+	data, err := ioutil.ReadFile(filepath.Join(store.dir, name))
+	if os.IsNotExist(err) {
+		return nil, errNotArchived
+	}
+	return data, err
+}
+
+func (store fileColdStore) delete(name string) error {
+	err := os.Remove(filepath.Join(store.dir, name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Helper function to build an archive's key within the bucket
+func (store s3ColdStore) key(name string) string {
+	if store.prefix == "" {
+		return name
+	}
+	return store.prefix + "/" + name
+}
+
+func (store s3ColdStore) put(name string, data []byte) error {
+	// Archives, or the screenshots of -screenshot-store
+	contentType := mime.TypeByExtension(filepath.Ext(name))
+	if contentType == "" {
+		contentType = "application/gzip"
+	}
+	_, err := store.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(store.bucket),
+		Key:         aws.String(store.key(name)),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String(contentType),
+	})
+	return err
+}
+
+func (store s3ColdStore) get(name string) ([]byte, error) {
+	resp, err := store.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(store.bucket),
+		Key:    aws.String(store.key(name)),
+	})
+	var notFound *types.NoSuchKey
+	if errors.As(err, &notFound) {
+		return nil, errNotArchived
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
+
+func (store s3ColdStore) delete(name string) error {
+	_, err := store.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(store.bucket),
+		Key:    aws.String(store.key(name)),
+	})
+	return err
+}
+
+// Helper function to name a crawl's archive in the cold store
+func coldArchiveName(uniqueID string) string {
+	return fmt.Sprintf("go-crawler-results-%s.jsonl.gz", uniqueID)
+}
+
+// scheduleArchival keeps a crawl's results past ttl, until the retention job has archived them
+func scheduleArchival(rdb *redis.Client, uniqueID string, ttl time.Duration) {
+	pipe := rdb.TxPipeline()
+	pipe.ZAdd(ctx, retentionKey, &redis.Z{Score: float64(time.Now().Add(ttl).Unix()), Member: uniqueID})
+	pipe.Expire(ctx, resultsKey(uniqueID), ttl+retentionGrace)
+	if _, err := pipe.Exec(ctx); err != nil {
+		withError(crawlLogger(uniqueID).Error(), err).Msg("Failed to schedule archival of crawl")
+	}
+}
+
+// runRetention archives the results of crawls whose hot TTL is up. Any number
+// of processes can run it, only the elected leader does the work
+func runRetention(rdb *redis.Client) {
+	election := newLeaderElection(rdb, leaderRoleRetention)
+	ticker := time.NewTicker(retentionPeriodInSeconds * time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		if !election.isLeader() {
+			continue
+		}
+		due, err := rdb.ZRangeByScore(ctx, retentionKey, &redis.ZRangeBy{Min: "-inf", Max: strconv.FormatInt(time.Now().Unix(), 10)}).Result()
+		if err != nil {
+			withError(logger.Error(), err).Msg("Failed to look for crawls to archive")
+			continue
+		}
+		for _, crawlID := range due {
+			if err := archiveResults(rdb, crawlID); err != nil {
+				// Tried again next time, the grace period leaves room for that
+				withError(crawlLogger(crawlID).Error(), err).Msg("Failed to archive crawl")
+				continue
+			}
+			rdb.ZRem(ctx, retentionKey, crawlID)
+		}
+	}
+}
+
+// archiveResults compresses a crawl's results list into the cold store, then deletes it from Redis
+func archiveResults(rdb *redis.Client, uniqueID string) error {
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	entries := int64(0)
+	for {
+		batch, err := rdb.LRange(ctx, resultsKey(uniqueID), entries, entries+retentionBatchSize-1).Result()
+		if err != nil {
+			return err
+		}
+		for _, entry := range batch {
+			gz.Write([]byte(entry))
+			gz.Write([]byte("\n"))
+		}
+		entries += int64(len(batch))
+		if len(batch) < retentionBatchSize {
+			break
+		}
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	if entries == 0 {
+		// Deleted or expired already, there is nothing to keep
+		return nil
+	}
+	if err := coldStorage.put(coldArchiveName(uniqueID), compressed.Bytes()); err != nil {
+		return err
+	}
+	crawlLogger(uniqueID).Info().Int64("entries", entries).Int("bytes", compressed.Len()).Msg("Archived results of crawl")
+	return rdb.Del(ctx, resultsKey(uniqueID)).Err()
+}
+
+// Rehydrate crawl handler - POST /crawl/{crawl_ID}/rehydrate
+func rehydrateCrawlHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
+	if coldStorage == nil {
+		sendErrorResponse(w, http.StatusServiceUnavailable, "Archival is not enabled, start the server with -cold-store")
+		return
+	}
+	crawlID := mux.Vars(r)["crawl_ID"]
+	ttl := currentPolicy().resultsTTL
+	if rawTTL := r.URL.Query().Get("ttlSeconds"); rawTTL != "" {
+		seconds, err := strconv.Atoi(rawTTL)
+		if err != nil || seconds < 1 || seconds > maxCrawlResultsTTL {
+			sendErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("ttlSeconds must be between 1 and %d", maxCrawlResultsTTL))
+			return
+		}
+		ttl = time.Duration(seconds) * time.Second
+	}
+
+	hot, err := rdb.Exists(ctx, resultsKey(crawlID)).Result()
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to look up results")
+		return
+	}
+	if hot > 0 {
+		sendErrorResponse(w, http.StatusConflict, "Results are not archived, they are still in Redis")
+		return
+	}
+	archive, err := coldStorage.get(coldArchiveName(crawlID))
+	if err == errNotArchived {
+		sendErrorResponse(w, http.StatusNotFound, "Crawl not archived")
+		return
+	}
+	if err != nil {
+		withError(crawlLogger(crawlID).Error(), err).Msg("Failed to read archive of crawl")
+		sendErrorResponse(w, http.StatusBadGateway, "Failed to read archive")
+		return
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Corrupt archive")
+		return
+	}
+	entries := []interface{}{}
+	scanner := bufio.NewScanner(gz)
+	scanner.Buffer(make([]byte, 64<<10), currentPolicy().maxPageBytes)
+	for scanner.Scan() {
+		entries = append(entries, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Corrupt archive")
+		return
+	}
+
+	pipe := rdb.TxPipeline()
+	for start := 0; start < len(entries); start += retentionBatchSize {
+		end := start + retentionBatchSize
+		if end > len(entries) {
+			end = len(entries)
+		}
+		pipe.RPush(ctx, resultsKey(crawlID), entries[start:end]...)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to restore results")
+		return
+	}
+	// Back to cold storage once the new TTL is up, the archive is simply rewritten
+	scheduleArchival(rdb, crawlID, ttl)
+	crawlLogger(crawlID).Info().Int("entries", len(entries)).Msg("Rehydrated results of crawl")
+	sendJSONResponse(w, http.StatusOK, InitializeCrawlResponse{CrawlID: crawlID, ResultsURL: buildResultsLink(apiScheme(), r.Host, crawlID, 0)})
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	schedulesKey = "go-crawler-schedules"
+	// Maps the crawl ID of every scheduled run still in progress to its scheduledRun
+	scheduleRunsKey = "go-crawler-schedule-runs"
+	// A run not done this many of its schedule's intervals after it started
+	// never will be, e.g. its results expired before it was seen done
+	scheduleRunDeadlineIntervals = 3
+	schedulerPeriodInSeconds     = 10
+	minScheduleInterval          = 60
+	reportDeliveryTimeout        = 10 * time.Second
+)
+
+type (
+	Schedule struct {
+		ID              string    `json:"id"`
+		URL             string    `json:"url"`
+		IntervalSeconds int       `json:"intervalSeconds"`
+		NextRun         time.Time `json:"nextRun"`
+		LastCrawlID     string    `json:"lastCrawlID,omitempty"`
+		// Set by the server from the creating tenant's policy, every run is held to them
+		Limits *CrawlLimits `json:"limits,omitempty"`
+		// Set by the server to the user whose token created the schedule, every run is attached to them
+		UserID string `json:"userID,omitempty"`
+		// Set by the server from the creating tenant's policy, every run is charged to the creator's quota
+		QuotaIdentity string `json:"quotaIdentity,omitempty"`
+		CrawlsPerHour int    `json:"crawlsPerHour,omitempty"`
+		// Optional, every run is checked for anomalies with the default thresholds otherwise
+		Anomalies *AnomalyThresholds `json:"anomalies,omitempty"`
+	}
+	SchedulesResponse struct {
+		Schedules []Schedule `json:"schedules"`
+	}
+	// scheduledRun is a run of a schedule whose reports aren't delivered yet
+	scheduledRun struct {
+		ScheduleID string    `json:"scheduleID"`
+		StartedAt  time.Time `json:"startedAt"`
+	}
+)
+
+func loadSchedules(rdb *redis.Client) ([]Schedule, error) {
+	raw, err := rdb.HGetAll(ctx, schedulesKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	schedules := make([]Schedule, 0, len(raw))
+	for _, rawSchedule := range raw {
+		var schedule Schedule
+		if err := json.Unmarshal([]byte(rawSchedule), &schedule); err != nil {
+			continue
+		}
+		schedules = append(schedules, schedule)
+	}
+	return schedules, nil
+}
+
+func saveSchedule(rdb *redis.Client, schedule *Schedule) error {
+	marshalled, _ := json.Marshal(schedule)
+	return rdb.HSet(ctx, schedulesKey, schedule.ID, marshalled).Err()
+}
+
+// runScheduler starts crawls for due schedules and delivers the subscribed
+// reports once those crawls finish. Any number of processes can run it,
+// only the elected leader does the work
+func runScheduler(rdb *redis.Client) {
+	election := newLeaderElection(rdb, leaderRoleScheduler)
+	ticker := time.NewTicker(schedulerPeriodInSeconds * time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		if !election.isLeader() {
+			continue
+		}
+		startDueSchedules(rdb)
+		deliverFinishedRuns(rdb)
+	}
+}
+
+func startDueSchedules(rdb *redis.Client) {
+	schedules, err := loadSchedules(rdb)
+	if err != nil {
+		withError(logger.Error(), err).Msg("Failed to load schedules")
+		return
+	}
+	for _, schedule := range schedules {
+		if time.Now().Before(schedule.NextRun) {
+			continue
+		}
+		if !takeScheduledRunQuota(rdb, &schedule) {
+			schedule.NextRun = time.Now().Add(time.Duration(schedule.IntervalSeconds) * time.Second)
+			saveSchedule(rdb, &schedule)
+			continue
+		}
+		// Each run gets a trace of its own, there is no request to continue
+		runCtx, span := tracer.Start(ctx, "scheduled run", trace.WithAttributes(label.String("schedule.id", schedule.ID)))
+		job := newCrawlJob(runCtx, schedule.URL)
+		job.Limits = schedule.Limits
+		job.UserID = schedule.UserID
+		crawlID, err := startCrawl(rdb, job)
+		if err != nil {
+			endSpan(span, err)
+			withError(logger.Error(), err).Str("schedule_id", schedule.ID).Msg("Failed to start scheduled crawl")
+			continue
+		}
+		span.SetAttributes(crawlIDLabel.String(crawlID))
+		span.End()
+		crawlLogger(crawlID).Info().Str("schedule_id", schedule.ID).Msg("Started scheduled crawl")
+		run, _ := json.Marshal(scheduledRun{ScheduleID: schedule.ID, StartedAt: time.Now()})
+		rdb.HSet(ctx, scheduleRunsKey, crawlID, run)
+		schedule.LastCrawlID = crawlID
+		schedule.NextRun = time.Now().Add(time.Duration(schedule.IntervalSeconds) * time.Second)
+		saveSchedule(rdb, &schedule)
+	}
+}
+
+// takeScheduledRunQuota charges a run to its creator's crawls per hour quota,
+// returning false if the run is to be skipped. The first run was charged when
+// the schedule was created
+func takeScheduledRunQuota(rdb *redis.Client, schedule *Schedule) bool {
+	if schedule.CrawlsPerHour <= 0 || schedule.LastCrawlID == "" {
+		return true
+	}
+	_, reset, err := countCrawl(rdb, schedule.QuotaIdentity, schedule.CrawlsPerHour)
+	if err == errCrawlQuotaExceeded {
+		logger.Warn().Str("schedule_id", schedule.ID).Time("reset", reset).Msg("Skipped scheduled crawl, its crawls per hour quota is used up")
+		return false
+	}
+	if err != nil {
+		// Best effort like the pages per day quota, runs go on if redis is unhappy
+		withError(logger.Warn(), err).Msg("Quota counter unavailable")
+	}
+	return true
+}
+
+func deliverFinishedRuns(rdb *redis.Client) {
+	runs, err := rdb.HGetAll(ctx, scheduleRunsKey).Result()
+	if err != nil {
+		withError(logger.Error(), err).Msg("Failed to load scheduled runs")
+		return
+	}
+	if len(runs) == 0 {
+		return
+	}
+	queries, err := loadSavedQueries(rdb)
+	if err != nil {
+		withError(logger.Error(), err).Msg("Failed to load saved queries")
+		return
+	}
+	schedules, err := loadSchedules(rdb)
+	if err != nil {
+		withError(logger.Error(), err).Msg("Failed to load schedules")
+		return
+	}
+
+	for crawlID, rawRun := range runs {
+		var run scheduledRun
+		if json.Unmarshal([]byte(rawRun), &run) != nil {
+			// Runs started before they were recorded with their start time
+			run.ScheduleID = rawRun
+		}
+		var schedule *Schedule
+		for i := range schedules {
+			if schedules[i].ID == run.ScheduleID {
+				schedule = &schedules[i]
+			}
+		}
+		// Only the last entry, the whole results are read once the run is done
+		_, done, err := resultStore.Range(crawlID, -1, -1)
+		if err != nil {
+			continue
+		}
+		if !done {
+			if reason := abandonedRunReason(rdb, crawlID, run, schedule); reason != "" {
+				crawlLogger(crawlID).Info().Str("schedule_id", run.ScheduleID).Str("reason", reason).Msg("Dropped scheduled run that won't finish")
+				rdb.HDel(ctx, scheduleRunsKey, crawlID)
+			}
+			continue
+		}
+		nodes, _, err := resultStore.Range(crawlID, 0, -1)
+		if err != nil {
+			continue
+		}
+		scheduleID := run.ScheduleID
+		if schedule != nil {
+			checkScheduledRun(rdb, *schedule, crawlID, nodes)
+		}
+		for _, query := range queries {
+			for _, subscription := range query.Subscriptions {
+				if subscription.ScheduleID != scheduleID {
+					continue
+				}
+				report, err := buildReport(rdb, query, crawlID)
+				if err != nil {
+					withError(logger.Error(), err).Str("query_id", query.ID).Msg("Failed to build report for query")
+					continue
+				}
+				report.ScheduleID = scheduleID
+				if err := deliverJSON(subscription.DeliverTo, report); err != nil {
+					withError(logger.Error(), err).Str("url", subscription.DeliverTo).Msg("Failed to deliver report")
+				}
+			}
+		}
+		rdb.HDel(ctx, scheduleRunsKey, crawlID)
+	}
+}
+
+// abandonedRunReason tells why a run that isn't done never will be, or
+// returns "" while it may still finish
+func abandonedRunReason(rdb *redis.Client, crawlID string, run scheduledRun, schedule *Schedule) string {
+	if crawlCanceled(rdb, crawlID) {
+		return "canceled"
+	}
+	// A crawl with a checkpoint is still running, or will be resumed
+	running := rdb.Exists(ctx, checkpointKey(crawlID)).Val() > 0
+	if !running && rdb.HExists(ctx, crawlErrorsKey(crawlID), "error").Val() {
+		return "failed"
+	}
+	if run.StartedAt.IsZero() {
+		// Recorded without its start, it's gone once it has neither results nor a checkpoint
+		if length, err := resultStore.Len(crawlID); !running && err == nil && length == 0 {
+			return "results expired"
+		}
+		return ""
+	}
+	interval := minScheduleInterval
+	if schedule != nil {
+		interval = schedule.IntervalSeconds
+	}
+	if time.Since(run.StartedAt) > scheduleRunDeadlineIntervals*time.Duration(interval)*time.Second {
+		return "past deadline"
+	}
+	return ""
+}
+
+// deliverJSON POSTs a report or notification to a subscriber
+func deliverJSON(deliverTo string, payload interface{}) error {
+	marshalled, _ := json.Marshal(payload)
+	resp, err := deliveryClient.Post(deliverTo, "application/json", bytes.NewReader(marshalled))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// Create schedule handler - POST /schedules
+func createScheduleHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
+	policy, ok := requireTenant(w, r)
+	if !ok {
+		return
+	}
+	var schedule Schedule
+	if err := json.NewDecoder(r.Body).Decode(&schedule); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	if err := validateSeedURL(schedule.URL); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if schedule.IntervalSeconds < minScheduleInterval {
+		sendErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("intervalSeconds must be at least %d", minScheduleInterval))
+		return
+	}
+
+	// Whatever the client sent, the limits come from its policy
+	schedule.Limits = nil
+	schedule.UserID = ""
+	schedule.QuotaIdentity, schedule.CrawlsPerHour = "", 0
+	if caller, ok := tokenClaimsFromContext(r.Context()); ok {
+		schedule.UserID = caller.user
+	}
+	if policy != nil {
+		limits := clampLimits(policy, 0, 0)
+		limits.applyQuota(r, policy)
+		if !limits.inScope(schedule.URL) {
+			sendErrorResponse(w, http.StatusForbidden, "URL is outside the allowed scopes")
+			return
+		}
+		schedule.Limits = &limits
+		if policy.CrawlsPerHour > 0 {
+			schedule.QuotaIdentity = quotaIdentity(r, policy)
+			schedule.CrawlsPerHour = policy.CrawlsPerHour
+		}
+	}
+	if currentPolicy().blocks(schedule.URL) {
+		sendErrorResponse(w, http.StatusForbidden, "URL is on a blocked domain")
+		return
+	}
+
+	if schedule.Anomalies != nil {
+		if err := validateAnomalyThresholds(schedule.Anomalies); err != nil {
+			sendErrorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	// The first run starts right away, it's charged like any other crawl
+	if !takeCrawlQuota(w, r, rdb, policy) {
+		return
+	}
+
+	schedule.ID = newNamespacedID(callerNamespace(r))
+	schedule.NextRun = time.Now()
+	schedule.LastCrawlID = ""
+	if err := saveSchedule(rdb, &schedule); err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to save schedule")
+		return
+	}
+	sendJSONResponse(w, http.StatusCreated, schedule)
+}
+
+// List schedules handler - GET /schedules
+func listSchedulesHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
+	schedules, err := loadSchedules(rdb)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to get schedules")
+		return
+	}
+	// Only the caller's own
+	own := make([]Schedule, 0, len(schedules))
+	for _, schedule := range schedules {
+		if inCallerNamespace(r, schedule.ID) {
+			own = append(own, schedule)
+		}
+	}
+	sendJSONResponse(w, http.StatusOK, SchedulesResponse{Schedules: own})
+}
+
+// Delete schedule handler - DELETE /schedules/{schedule_ID}
+func deleteScheduleHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
+	scheduleID := mux.Vars(r)["schedule_ID"]
+	deleted, err := rdb.HDel(ctx, schedulesKey, scheduleID).Result()
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to delete schedule")
+		return
+	}
+	if deleted == 0 {
+		sendErrorResponse(w, http.StatusNotFound, "Schedule not found")
+		return
+	}
+	rdb.Del(ctx, scheduleHistoryKey(scheduleID))
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,567 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"go/format"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type (
+	// endpointTypes documents the JSON an endpoint takes and returns. Only
+	// endpoints listed in endpointSchemas get a function in the generated SDKs
+	endpointTypes struct {
+		request  interface{}
+		response interface{}
+		query    []string
+		// Answered on success when it isn't 200, or 204 for DELETE
+		status int
+	}
+	// jsonSchema is the subset of JSON Schema needed to describe our types
+	jsonSchema struct {
+		Type                 string                 `json:"type,omitempty"`
+		Format               string                 `json:"format,omitempty"`
+		Ref                  string                 `json:"$ref,omitempty"`
+		Items                *jsonSchema            `json:"items,omitempty"`
+		Properties           map[string]*jsonSchema `json:"properties,omitempty"`
+		AdditionalProperties *jsonSchema            `json:"additionalProperties,omitempty"`
+		Required             []string               `json:"required,omitempty"`
+		// Property names in struct order, so generated code reads like the Go types
+		order []string
+	}
+	SchemaEndpoint struct {
+		Method      string   `json:"method"`
+		Path        string   `json:"path"`
+		PathParams  []string `json:"pathParams,omitempty"`
+		QueryParams []string `json:"queryParams,omitempty"`
+		Request     string   `json:"request,omitempty"`
+		Response    string   `json:"response,omitempty"`
+		Status      int      `json:"status,omitempty"`
+	}
+	// SchemaStream describes results that are pushed or polled rather than requested once
+	SchemaStream struct {
+		Name        string   `json:"name"`
+		Transport   string   `json:"transport"`
+		Channel     string   `json:"channel"`
+		Messages    []string `json:"messages"`
+		Description string   `json:"description"`
+	}
+	SchemaResponse struct {
+		// Changes whenever any type or endpoint does, so clients can tell they're stale
+		Version     string                 `json:"version"`
+		Definitions map[string]*jsonSchema `json:"definitions"`
+		Endpoints   []SchemaEndpoint       `json:"endpoints"`
+		Streams     []SchemaStream         `json:"streams"`
+	}
+)
+
+var endpointSchemas = map[string]endpointTypes{
+	"POST /v1/crawl":                                          {request: InitializeCrawlRequest{}, response: InitializeCrawlResponse{}, status: http.StatusAccepted},
+	"GET /v1/crawl/{crawl_ID}":                                {response: LookupCrawlResponse{}, query: []string{"startIndex", "domain", "minDepth", "maxDepth", "urlContains", "status"}},
+	"POST /v1/crawl/{crawl_ID}/resume":                        {response: InitializeCrawlResponse{}, status: http.StatusAccepted},
+	"POST /v1/crawl/{crawl_ID}/cancel":                        {response: CancelCrawlResponse{}},
+	"POST /v1/crawl/{crawl_ID}/urls":                          {request: InjectURLsRequest{}, response: InjectURLsResponse{}, status: http.StatusAccepted},
+	"POST /v1/crawl/{crawl_ID}/annotations":                   {request: Annotation{}, response: Annotation{}, status: http.StatusCreated},
+	"GET /v1/crawl/{crawl_ID}/annotations":                    {response: AnnotationsResponse{}},
+	"DELETE /v1/crawl/{crawl_ID}/annotations/{annotation_ID}": {},
+	"GET /v1/crawl/{crawl_ID}/artifacts":                      {response: ArtifactsResponse{}},
+	"DELETE /v1/crawl/{crawl_ID}/artifacts/{artifact_ID}":     {},
+	"POST /v1/graphs/merge":                                   {request: MergeGraphsRequest{}, response: MergeGraphsResponse{}, status: http.StatusCreated},
+	"GET /v1/graphs/{graph_ID}/asof":                          {response: AsOfResponse{}, query: []string{"date", "cumulative"}},
+	"POST /v1/queries":                                        {request: SavedQuery{}, response: SavedQuery{}, status: http.StatusCreated},
+	"GET /v1/queries":                                         {response: SavedQueriesResponse{}},
+	"DELETE /v1/queries/{query_ID}":                           {},
+	"GET /v1/queries/{query_ID}/report":                       {response: Report{}, query: []string{"crawlID"}},
+	"POST /v1/queries/{query_ID}/subscriptions":               {request: Subscription{}, response: SavedQuery{}, status: http.StatusCreated},
+	"POST /v1/schedules":                                      {request: Schedule{}, response: Schedule{}, status: http.StatusCreated},
+	"GET /v1/schedules":                                       {response: SchedulesResponse{}},
+	"DELETE /v1/schedules/{schedule_ID}":                      {},
+	"GET /v1/schedules/{schedule_ID}/runs":                    {response: ScheduleRunsResponse{}},
+	"GET /v1/crawl/{crawl_ID}/search":                         {response: SearchResponse{}, query: []string{"q", "size"}},
+	"GET /v1/crawl/{crawl_ID}/status":                         {response: CrawlStatusResponse{}},
+	"GET /v1/crawl/{crawl_ID}/trackers":                       {response: TrackerInventoryResponse{}},
+	"GET /v1/crawl/{crawl_ID}/assets":                         {response: AssetsReportResponse{}, query: []string{"top"}},
+	"GET /v1/crawl/{crawl_ID}/timeline":                       {response: TimelineResponse{}, query: []string{"step"}},
+	"GET /v1/crawl/{crawl_ID}/stats":                          {response: CrawlStatsResponse{}},
+	"GET /v1/crawl/{crawl_ID}/domains":                        {response: DomainsReportResponse{}},
+	"GET /v1/crawl/{crawl_ID}/seo":                            {response: SEOReportResponse{}, query: []string{"pages"}},
+	"GET /v1/crawl/{crawl_ID}/security-headers":               {response: SecurityHeadersReportResponse{}, query: []string{"all"}},
+	"POST /v1/crawl/{crawl_ID}/analyze":                       {response: AnalysisResponse{}, status: http.StatusAccepted},
+	"GET /v1/crawl/{crawl_ID}/analysis":                       {response: AnalysisResponse{}, query: []string{"top", "sort", "url"}},
+	"GET /v1/crawl/{crawl_ID}/path":                           {response: PathResponse{}, query: []string{"from", "to"}},
+	"GET /v1/crawl/{crawl_ID}/structure":                      {response: GraphStructureResponse{}, query: []string{"top"}},
+	"DELETE /v1/crawl/{crawl_ID}/results":                     {},
+	"POST /v1/crawl/{crawl_ID}/rehydrate":                     {response: InitializeCrawlResponse{}, query: []string{"ttlSeconds"}},
+	"GET /admin/workers":                                      {response: WorkersResponse{}},
+	"GET /admin/janitor":                                      {response: JanitorStats{}},
+	"GET /admin/log-level":                                    {response: LogLevel{}},
+	"PUT /admin/log-level":                                    {request: LogLevel{}, response: LogLevel{}},
+	"GET /admin/policy":                                       {response: PolicyResponse{}},
+	"POST /admin/policy/reload":                               {response: PolicyResponse{}},
+	"GET /admin/dead-letters":                                 {response: DeadLettersResponse{}},
+	"POST /admin/dead-letters/{letter_ID}/requeue":            {request: RequeueDeadLetterRequest{}, status: http.StatusAccepted},
+	"POST /admin/selftest":                                    {response: SelfTestResponse{}},
+	"GET /healthz":                                            {response: HealthResponse{}},
+	"GET /version":                                            {response: VersionResponse{}},
+	"GET /readyz":                                             {response: ReadinessResponse{}},
+}
+
+// Types that only travel over streams or in error bodies, not as an endpoint's request or response
+var streamSchemaTypes = []interface{}{graphNode{}, finishSentinel{}, EdgeEvent{}, ErrorResponse{}}
+
+var schemaStreams = []SchemaStream{
+	{Name: "results", Transport: "http-poll", Channel: "GET /v1/crawl/{crawl_ID}?startIndex=", Messages: []string{"LookupCrawlResponse"},
+		Description: "Follow _links.next until a response comes back without one, the crawl is then finished"},
+	{Name: "results list", Transport: "redis-list", Channel: "go-crawler-results-{crawl_ID}", Messages: []string{"GraphNode", "FinishSentinel"},
+		Description: "One node per entry, ending with the finish sentinel"},
+	{Name: "results exchange", Transport: "amqp", Channel: "go-crawler-results-{crawl_ID}", Messages: []string{"GraphNode", "FinishSentinel"},
+		Description: "Fanout exchange per crawl with -amqp-results, ending with the finish sentinel"},
+	{Name: "edges", Transport: "kafka", Channel: "-kafka-edges-topic", Messages: []string{"EdgeEvent"},
+		Description: "One message per edge keyed by crawl ID, ending with a message that has done set"},
+	{Name: "reports", Transport: "webhook", Channel: "Subscription.deliverTo", Messages: []string{"Report"},
+		Description: "POSTed after every scheduled crawl a saved query is subscribed to"},
+}
+
+var (
+	pathParamPattern     = regexp.MustCompile(`{([^}]+)}`)
+	nonIdentifierPattern = regexp.MustCompile(`[^A-Za-z0-9]+`)
+)
+
+// Helper function to name a type in the schema, exported so every SDK can use it as is
+func definitionName(t reflect.Type) string {
+	return strings.ToUpper(t.Name()[:1]) + t.Name()[1:]
+}
+
+// schemaOf describes t, adding the structs it uses to definitions and referring to them by name
+func schemaOf(t reflect.Type, definitions map[string]*jsonSchema) *jsonSchema {
+	switch t {
+	case reflect.TypeOf(time.Time{}):
+		return &jsonSchema{Type: "string", Format: "date-time"}
+	case reflect.TypeOf(time.Duration(0)):
+		return &jsonSchema{Type: "integer", Format: "nanoseconds"}
+	case reflect.TypeOf(json.RawMessage{}):
+		return &jsonSchema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schemaOf(t.Elem(), definitions)
+	case reflect.String:
+		return &jsonSchema{Type: "string"}
+	case reflect.Bool:
+		return &jsonSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &jsonSchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &jsonSchema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &jsonSchema{Type: "array", Items: schemaOf(t.Elem(), definitions)}
+	case reflect.Map:
+		return &jsonSchema{Type: "object", AdditionalProperties: schemaOf(t.Elem(), definitions)}
+	case reflect.Struct:
+		name := definitionName(t)
+		if _, ok := definitions[name]; !ok {
+			schema := &jsonSchema{Type: "object", Properties: map[string]*jsonSchema{}}
+			// Registered before the fields so recursive types terminate
+			definitions[name] = schema
+			for i := 0; i < t.NumField(); i++ {
+				field := t.Field(i)
+				if field.PkgPath != "" || field.Anonymous {
+					continue
+				}
+				tag := strings.Split(field.Tag.Get("json"), ",")
+				if tag[0] == "-" {
+					continue
+				}
+				jsonName := field.Name
+				if tag[0] != "" {
+					jsonName = tag[0]
+				}
+				schema.Properties[jsonName] = schemaOf(field.Type, definitions)
+				schema.order = append(schema.order, jsonName)
+				if len(tag) < 2 || tag[1] != "omitempty" {
+					schema.Required = append(schema.Required, jsonName)
+				}
+			}
+		}
+		return &jsonSchema{Ref: "#/definitions/" + name}
+	}
+	return &jsonSchema{}
+}
+
+// Helper function to name the definition a value refers to, registering it
+func schemaName(value interface{}, definitions map[string]*jsonSchema) string {
+	if value == nil {
+		return ""
+	}
+	return strings.TrimPrefix(schemaOf(reflect.TypeOf(value), definitions).Ref, "#/definitions/")
+}
+
+// buildSchema walks the router, so every registered route is listed even before it's typed
+func buildSchema(router *mux.Router) *SchemaResponse {
+	schema := &SchemaResponse{Definitions: map[string]*jsonSchema{}, Endpoints: []SchemaEndpoint{}, Streams: schemaStreams}
+	router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		for _, method := range methods {
+			endpoint := SchemaEndpoint{Method: method, Path: path}
+			for _, match := range pathParamPattern.FindAllStringSubmatch(path, -1) {
+				endpoint.PathParams = append(endpoint.PathParams, match[1])
+			}
+			if types, ok := endpointSchemas[method+" "+path]; ok {
+				endpoint.Request = schemaName(types.request, schema.Definitions)
+				endpoint.Response = schemaName(types.response, schema.Definitions)
+				endpoint.QueryParams = types.query
+				endpoint.Status = types.status
+			}
+			schema.Endpoints = append(schema.Endpoints, endpoint)
+		}
+		return nil
+	})
+	for _, value := range streamSchemaTypes {
+		schemaName(value, schema.Definitions)
+	}
+
+	marshalled, _ := json.Marshal(schema)
+	schema.Version = fmt.Sprintf("%x", sha256.Sum256(marshalled))[:12]
+	return schema
+}
+
+// Helper function to turn a path parameter like crawl_ID into crawlID
+func paramName(param string) string {
+	parts := strings.Split(param, "_")
+	return strings.ToLower(parts[0]) + strings.Join(parts[1:], "")
+}
+
+// Helper function to name an endpoint's SDK function, e.g. POST /v1/crawl/{crawl_ID}/urls is postCrawlUrls
+func functionName(endpoint SchemaEndpoint) string {
+	name := strings.ToLower(endpoint.Method)
+	for _, segment := range strings.Split(unversionedPath(endpoint.Path), "/") {
+		if segment == "" || strings.HasPrefix(segment, "{") {
+			continue
+		}
+		for _, word := range strings.Split(segment, "-") {
+			name += strings.ToUpper(word[:1]) + word[1:]
+		}
+	}
+	return name
+}
+
+// Helper function to list the endpoints that have an SDK function, in a stable order
+func sdkEndpoints(schema *SchemaResponse) []SchemaEndpoint {
+	endpoints := []SchemaEndpoint{}
+	for _, endpoint := range schema.Endpoints {
+		if _, ok := endpointSchemas[endpoint.Method+" "+endpoint.Path]; ok {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	sort.Slice(endpoints, func(i, j int) bool {
+		return functionName(endpoints[i]) < functionName(endpoints[j])
+	})
+	return endpoints
+}
+
+// Helper function to list definitions in a stable order
+func definitionNames(schema *SchemaResponse) []string {
+	names := make([]string, 0, len(schema.Definitions))
+	for name := range schema.Definitions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func typeScriptType(schema *jsonSchema) string {
+	switch {
+	case schema.Ref != "":
+		return strings.TrimPrefix(schema.Ref, "#/definitions/")
+	case schema.Type == "string":
+		return "string"
+	case schema.Type == "integer" || schema.Type == "number":
+		return "number"
+	case schema.Type == "boolean":
+		return "boolean"
+	case schema.Type == "array":
+		return typeScriptType(schema.Items) + "[]"
+	case schema.AdditionalProperties != nil:
+		return "Record<string, " + typeScriptType(schema.AdditionalProperties) + ">"
+	}
+	return "unknown"
+}
+
+func generateTypeScript(schema *SchemaResponse) string {
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// Generated by the crawler service (GET /sdk/typescript), schema version %s. Do not edit.\n\n", schema.Version)
+	fmt.Fprintf(&out, "export const schemaVersion = %q;\n", schema.Version)
+	for _, name := range definitionNames(schema) {
+		definition := schema.Definitions[name]
+		required := map[string]bool{}
+		for _, field := range definition.Required {
+			required[field] = true
+		}
+		fmt.Fprintf(&out, "\nexport interface %s {\n", name)
+		for _, field := range definition.order {
+			optional := "?"
+			if required[field] {
+				optional = ""
+			}
+			fmt.Fprintf(&out, "  %s%s: %s;\n", field, optional, typeScriptType(definition.Properties[field]))
+		}
+		fmt.Fprintf(&out, "}\n")
+	}
+
+	out.WriteString(`
+export class CrawlerClient {
+  constructor(private baseURL: string) {}
+
+  private async request<T>(method: string, path: string, query?: Record<string, string | undefined>, body?: unknown): Promise<T> {
+    const url = new URL(path, this.baseURL);
+    for (const [key, value] of Object.entries(query ?? {})) {
+      if (value !== undefined) url.searchParams.set(key, value);
+    }
+    const response = await fetch(url.toString(), {
+      method,
+      headers: body === undefined ? undefined : { "Content-Type": "application/json" },
+      body: body === undefined ? undefined : JSON.stringify(body),
+    });
+    if (!response.ok) {
+      const error: ErrorResponse = await response.json().catch(() => ({ message: response.statusText }));
+      throw new Error(error.message);
+    }
+    return (response.status === 204 || response.headers.get("Content-Length") === "0" ? undefined : response.json()) as Promise<T>;
+  }
+`)
+	for _, endpoint := range sdkEndpoints(schema) {
+		params := []string{}
+		path := endpoint.Path
+		for _, param := range endpoint.PathParams {
+			params = append(params, paramName(param)+": string")
+			path = strings.Replace(path, "{"+param+"}", "${encodeURIComponent("+paramName(param)+")}", 1)
+		}
+		if endpoint.Request != "" {
+			params = append(params, "body: "+endpoint.Request)
+		}
+		query := "undefined"
+		if len(endpoint.QueryParams) > 0 {
+			fields := []string{}
+			for _, param := range endpoint.QueryParams {
+				fields = append(fields, param+"?: string")
+			}
+			params = append(params, "query: { "+strings.Join(fields, "; ")+" } = {}")
+			query = "query"
+		}
+		body := "undefined"
+		if endpoint.Request != "" {
+			body = "body"
+		}
+		response := "void"
+		if endpoint.Response != "" {
+			response = endpoint.Response
+		}
+		fmt.Fprintf(&out, "\n  %s(%s): Promise<%s> {\n", functionName(endpoint), strings.Join(params, ", "), response)
+		fmt.Fprintf(&out, "    return this.request(%q, `%s`, %s, %s);\n  }\n", endpoint.Method, path, query, body)
+	}
+	out.WriteString("}\n")
+	return out.String()
+}
+
+func goType(schema *jsonSchema) string {
+	switch {
+	case schema.Ref != "":
+		return strings.TrimPrefix(schema.Ref, "#/definitions/")
+	case schema.Type == "string" && schema.Format == "date-time":
+		return "time.Time"
+	case schema.Type == "string":
+		return "string"
+	case schema.Type == "integer" && schema.Format == "nanoseconds":
+		return "time.Duration"
+	case schema.Type == "integer":
+		return "int64"
+	case schema.Type == "number":
+		return "float64"
+	case schema.Type == "boolean":
+		return "bool"
+	case schema.Type == "array":
+		return "[]" + goType(schema.Items)
+	case schema.AdditionalProperties != nil:
+		return "map[string]" + goType(schema.AdditionalProperties)
+	}
+	return "json.RawMessage"
+}
+
+// Helper function to turn a JSON property like _links into an exported Go field name
+func goFieldName(property string) string {
+	name := ""
+	for _, word := range nonIdentifierPattern.Split(property, -1) {
+		if word != "" {
+			name += strings.ToUpper(word[:1]) + word[1:]
+		}
+	}
+	return name
+}
+
+func generateGo(schema *SchemaResponse) string {
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// Code generated by the crawler service (GET /sdk/go), schema version %s. DO NOT EDIT.\n\n", schema.Version)
+	out.WriteString(`package crawlerclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+`)
+	fmt.Fprintf(&out, "const SchemaVersion = %q\n", schema.Version)
+	for _, name := range definitionNames(schema) {
+		definition := schema.Definitions[name]
+		required := map[string]bool{}
+		for _, field := range definition.Required {
+			required[field] = true
+		}
+		fmt.Fprintf(&out, "\ntype %s struct {\n", name)
+		for _, field := range definition.order {
+			tag := field
+			if !required[field] {
+				tag += ",omitempty"
+			}
+			fmt.Fprintf(&out, "%s %s `json:%q`\n", goFieldName(field), goType(definition.Properties[field]), tag)
+		}
+		out.WriteString("}\n")
+	}
+
+	out.WriteString(`
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+}
+
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	var marshalled []byte
+	if body != nil {
+		var err error
+		if marshalled, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	target := strings.TrimSuffix(c.BaseURL, "/") + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(marshalled))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		var errorResponse ErrorResponse
+		if json.NewDecoder(resp.Body).Decode(&errorResponse) == nil && errorResponse.Message != "" {
+			return errors.New(errorResponse.Message)
+		}
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent || resp.ContentLength == 0 {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+`)
+	for _, endpoint := range sdkEndpoints(schema) {
+		params := []string{"ctx context.Context"}
+		path := fmt.Sprintf("%q", endpoint.Path)
+		for _, param := range endpoint.PathParams {
+			params = append(params, paramName(param)+" string")
+			path = strings.Replace(path, "{"+param+"}", `"+url.PathEscape(`+paramName(param)+`)+"`, 1)
+		}
+		path = strings.TrimSuffix(strings.TrimPrefix(path, `""+`), `+""`)
+		body := "nil"
+		if endpoint.Request != "" {
+			params = append(params, "body "+endpoint.Request)
+			body = "body"
+		}
+		query := "nil"
+		if len(endpoint.QueryParams) > 0 {
+			params = append(params, "query url.Values")
+			query = "query"
+		}
+		name := functionName(endpoint)
+		name = strings.ToUpper(name[:1]) + name[1:]
+		if len(endpoint.QueryParams) > 0 {
+			fmt.Fprintf(&out, "\n// %s takes the query parameters %s\n", name, strings.Join(endpoint.QueryParams, ", "))
+		} else {
+			out.WriteString("\n")
+		}
+		if endpoint.Response == "" {
+			fmt.Fprintf(&out, "func (c *Client) %s(%s) error {\n", name, strings.Join(params, ", "))
+			fmt.Fprintf(&out, "return c.do(ctx, %q, %s, %s, %s, nil)\n}\n", endpoint.Method, path, query, body)
+			continue
+		}
+		fmt.Fprintf(&out, "func (c *Client) %s(%s) (*%s, error) {\n", name, strings.Join(params, ", "), endpoint.Response)
+		fmt.Fprintf(&out, "var out %s\n", endpoint.Response)
+		fmt.Fprintf(&out, "if err := c.do(ctx, %q, %s, %s, %s, &out); err != nil {\nreturn nil, err\n}\nreturn &out, nil\n}\n", endpoint.Method, path, query, body)
+	}
+
+	formatted, err := format.Source(out.Bytes())
+	if err != nil {
+		return out.String()
+	}
+	return string(formatted)
+}
+
+// Schema handler - GET /schema
+func schemaHandler(w http.ResponseWriter, r *http.Request, router *mux.Router) {
+	sendJSONResponse(w, http.StatusOK, buildSchema(router))
+}
+
+// SDK handler - GET /sdk/{language}
+func sdkHandler(w http.ResponseWriter, r *http.Request, router *mux.Router) {
+	schema := buildSchema(router)
+	var source, filename, contentType string
+	switch mux.Vars(r)["language"] {
+	case "go":
+		source, filename, contentType = generateGo(schema), "crawlerclient.go", "text/x-go"
+	case "typescript":
+		source, filename, contentType = generateTypeScript(schema), "crawler-client.ts", "application/typescript"
+	default:
+		sendErrorResponse(w, http.StatusNotFound, "Unknown language, use go or typescript")
+		return
+	}
+	w.Header().Set("Content-Type", contentType+"; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.Header().Set("X-Schema-Version", schema.Version)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(source))
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+)
+
+// Size of the viewport pages are captured at
+const (
+	screenshotWidth  = 1280
+	screenshotHeight = 800
+)
+
+// Set up in main when -screenshot-store is given, crawls can't ask for screenshots otherwise
+var screenshotStore coldStore
+
+// Helper function to name the screenshot of a crawl's page in the screenshot store
+func screenshotName(crawlID, pageURL string) string {
+	return fmt.Sprintf("%s/%x.png", crawlID, sha256.Sum256([]byte(pageURL)))
+}
+
+// Helper function to build the API path a page's screenshot is served at,
+// which its node links to
+func screenshotPath(crawlID, pageURL string) string {
+	return versionedPath("/crawl/"+url.PathEscape(crawlID)+"/screenshot") + "?url=" + url.QueryEscape(pageURL)
+}
+
+// Crawl screenshot handler - GET /crawl/{crawl_ID}/screenshot?url=
+func crawlScreenshotHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
+	if screenshotStore == nil {
+		sendErrorResponse(w, http.StatusServiceUnavailable, "Screenshots are not enabled, start the server with -screenshot-store")
+		return
+	}
+	crawlID := mux.Vars(r)["crawl_ID"]
+	pageURL := r.URL.Query().Get("url")
+	if pageURL == "" {
+		sendErrorResponse(w, http.StatusBadRequest, "Must specify a page with url")
+		return
+	}
+	png, err := screenshotStore.get(screenshotName(crawlID, pageURL))
+	if err == errNotArchived {
+		sendErrorResponse(w, http.StatusNotFound, "No screenshot of the page, the crawl must be started with screenshots")
+		return
+	}
+	if err != nil {
+		withError(crawlLogger(crawlID).Error(), err).Msg("Failed to read screenshot")
+		sendErrorResponse(w, http.StatusBadGateway, "Failed to read screenshot")
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+	w.Write(png)
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+	"golang.org/x/net/html"
+)
+
+const (
+	// Pages are indexed up to this size, and their text up to this length
+	maxIndexedPageBytes = 2 << 20
+	maxIndexedTextBytes = 100 << 10
+	// Documents are sent with the bulk API in batches of this many, or whatever built up every flush period
+	searchBatchSize   = 100
+	searchFlushPeriod = time.Second
+	// Documents waiting to be sent, fetches block once this many are queued
+	searchQueueSize    = 1000
+	defaultSearchHits  = 10
+	maxSearchHits      = 100
+	searchRetryDelay   = 5 * time.Second
+	searchMaxAttempts  = 3
+	searchRequestLimit = 30 * time.Second
+)
+
+// Only the fields the index needs to be searched by crawl are mapped up front
+const searchIndexMapping = `{
+	"mappings": {
+		"properties": {
+			"crawlID": {"type": "keyword"},
+			"url": {"type": "keyword"},
+			"title": {"type": "text"},
+			"body": {"type": "text"},
+			"fetchedAt": {"type": "date"}
+		}
+	}
+}`
+
+type (
+	// searchIndex indexes the text of every page fetched into Elasticsearch or
+	// OpenSearch. It only uses the REST API both of them share
+	searchIndex struct {
+		url    string
+		index  string
+		client *http.Client
+		docs   chan searchDocument
+	}
+	searchDocument struct {
+		CrawlID   string    `json:"crawlID"`
+		URL       string    `json:"url"`
+		Title     string    `json:"title"`
+		Body      string    `json:"body"`
+		FetchedAt time.Time `json:"fetchedAt"`
+	}
+	SearchHit struct {
+		URL   string  `json:"url"`
+		Title string  `json:"title"`
+		Score float64 `json:"score"`
+		// Snippets of the body around the matches
+		Highlights []string `json:"highlights,omitempty"`
+	}
+	SearchResponse struct {
+		Total int         `json:"total"`
+		Hits  []SearchHit `json:"hits"`
+	}
+)
+
+// Set up in main when -elasticsearch-url is given
+var pageSearch *searchIndex
+
+// newSearchIndex creates the index if it doesn't exist yet and starts sending documents in the background
+func newSearchIndex(url, index string) (*searchIndex, error) {
+	search := &searchIndex{
+		url:    strings.TrimSuffix(url, "/"),
+		index:  index,
+		client: &http.Client{Timeout: searchRequestLimit},
+		docs:   make(chan searchDocument, searchQueueSize),
+	}
+	resp, err := search.request(http.MethodHead, "/"+index, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		resp, err := search.request(http.MethodPut, "/"+index, "application/json", strings.NewReader(searchIndexMapping))
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			message, _ := ioutil.ReadAll(resp.Body)
+			return nil, fmt.Errorf("could not create index %s: %s", index, message)
+		}
+	}
+	go search.sendDocuments()
+	return search, nil
+}
+
+// Helper function to send a request to the search cluster
+func (search *searchIndex) request(method, path, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, search.url+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	return search.client.Do(req)
+}
+
+// capture queues a fetched page for indexing
+func (search *searchIndex) capture(crawlID, urlToFetch string, page []byte) {
+	if len(page) > maxIndexedPageBytes {
+		page = page[:maxIndexedPageBytes]
+	}
+	title, text := extractPageText(page)
+	search.captureText(crawlID, urlToFetch, title, text)
+}
+
+// captureText indexes text already extracted, e.g. from a document
+func (search *searchIndex) captureText(crawlID, urlToFetch, title, text string) {
+	search.docs <- searchDocument{CrawlID: crawlID, URL: urlToFetch, Title: title, Body: text, FetchedAt: time.Now()}
+}
+
+// extractPageText returns a page's title and its visible text, leaving out scripts and styles
+func extractPageText(page []byte) (string, string) {
+	z := html.NewTokenizer(bytes.NewReader(page))
+	title := ""
+	var text strings.Builder
+	skip := ""
+	inTitle := false
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return strings.TrimSpace(title), strings.TrimSpace(text.String())
+		case html.StartTagToken:
+			name, _ := z.TagName()
+			switch string(name) {
+			case "script", "style", "noscript":
+				skip = string(name)
+			case "title":
+				inTitle = true
+			}
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			if string(name) == skip {
+				skip = ""
+			}
+			if string(name) == "title" {
+				inTitle = false
+			}
+		case html.TextToken:
+			if skip != "" {
+				continue
+			}
+			words := strings.Join(strings.Fields(string(z.Text())), " ")
+			if words == "" {
+				continue
+			}
+			if inTitle {
+				title += words
+				continue
+			}
+			if text.Len() < maxIndexedTextBytes {
+				text.WriteString(words)
+				text.WriteString(" ")
+			}
+		}
+	}
+}
+
+// Helper function to name a page's document, so fetching it again in the same crawl replaces it
+func searchDocumentID(crawlID, url string) string {
+	return fmt.Sprintf("%s-%x", crawlID, sha1.Sum([]byte(url)))
+}
+
+func (search *searchIndex) sendDocuments() {
+	ticker := time.NewTicker(searchFlushPeriod)
+	defer ticker.Stop()
+	batch := make([]searchDocument, 0, searchBatchSize)
+	for {
+		select {
+		case doc := <-search.docs:
+			batch = append(batch, doc)
+			if len(batch) < searchBatchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		// Fetches block while we retry, but an outage of the search cluster mustn't stall every crawl
+		for attempt := 1; attempt <= searchMaxAttempts; attempt++ {
+			err := search.bulkIndex(batch)
+			if err == nil {
+				break
+			}
+			withError(logger.Warn(), err).Int("pages", len(batch)).Int("attempt", attempt).Msg("Failed to index pages")
+			if attempt < searchMaxAttempts {
+				time.Sleep(searchRetryDelay)
+			}
+		}
+		batch = batch[:0]
+	}
+}
+
+// Helper function to index documents with one bulk request
+func (search *searchIndex) bulkIndex(docs []searchDocument) error {
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	for _, doc := range docs {
+		action := map[string]interface{}{"index": map[string]string{"_index": search.index, "_id": searchDocumentID(doc.CrawlID, doc.URL)}}
+		if err := encoder.Encode(action); err != nil {
+			return err
+		}
+		if err := encoder.Encode(doc); err != nil {
+			return err
+		}
+	}
+	resp, err := search.request(http.MethodPost, "/_bulk", "application/x-ndjson", &body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var result struct {
+		Errors bool `json:"errors"`
+	}
+	if resp.StatusCode >= 300 {
+		message, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("bulk request failed with %s: %s", resp.Status, message)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+	if result.Errors {
+		// Individual documents were rejected, e.g. by a mapping conflict. Retrying won't help
+		logger.Error().Msg("Some pages could not be indexed")
+	}
+	return nil
+}
+
+// deleteCrawl removes every document of a crawl from the index
+func (search *searchIndex) deleteCrawl(crawlID string) error {
+	body, _ := json.Marshal(map[string]interface{}{
+		"query": map[string]interface{}{"term": map[string]string{"crawlID": crawlID}},
+	})
+	resp, err := search.request(http.MethodPost, "/"+search.index+"/_delete_by_query?conflicts=proceed", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		message, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("delete by query failed with %s: %s", resp.Status, message)
+	}
+	return nil
+}
+
+// search runs a full text query over one crawl's pages
+func (search *searchIndex) search(crawlID, query string, size int) (*SearchResponse, error) {
+	body, _ := json.Marshal(map[string]interface{}{
+		"size": size,
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"must":   map[string]interface{}{"simple_query_string": map[string]interface{}{"query": query, "fields": []string{"title^2", "body"}}},
+				"filter": map[string]interface{}{"term": map[string]string{"crawlID": crawlID}},
+			},
+		},
+		"highlight": map[string]interface{}{"fields": map[string]interface{}{"body": map[string]interface{}{}}},
+	})
+	resp, err := search.request(http.MethodPost, "/"+search.index+"/_search", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		message, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("search failed with %s: %s", resp.Status, message)
+	}
+	var result struct {
+		Hits struct {
+			Total struct {
+				Value int `json:"value"`
+			} `json:"total"`
+			Hits []struct {
+				Score     float64             `json:"_score"`
+				Source    searchDocument      `json:"_source"`
+				Highlight map[string][]string `json:"highlight"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	response := &SearchResponse{Total: result.Hits.Total.Value, Hits: []SearchHit{}}
+	for _, hit := range result.Hits.Hits {
+		response.Hits = append(response.Hits, SearchHit{
+			URL:        hit.Source.URL,
+			Title:      hit.Source.Title,
+			Score:      hit.Score,
+			Highlights: hit.Highlight["body"],
+		})
+	}
+	return response, nil
+}
+
+// Search crawl handler - GET /crawl/{crawl_ID}/search?q=
+func searchCrawlHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
+	if pageSearch == nil {
+		sendErrorResponse(w, http.StatusServiceUnavailable, "Search is not enabled, start the server with -elasticsearch-url")
+		return
+	}
+	crawlID := mux.Vars(r)["crawl_ID"]
+	query := r.URL.Query().Get("q")
+	if query == "" {
+		sendErrorResponse(w, http.StatusBadRequest, "Must specify a query with q")
+		return
+	}
+	size := defaultSearchHits
+	if rawSize := r.URL.Query().Get("size"); rawSize != "" {
+		parsed, err := strconv.Atoi(rawSize)
+		if err != nil || parsed < 1 || parsed > maxSearchHits {
+			sendErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("size must be between 1 and %d", maxSearchHits))
+			return
+		}
+		size = parsed
+	}
+
+	response, err := pageSearch.search(crawlID, query, size)
+	if err != nil {
+		withError(crawlLogger(crawlID).Error(), err).Msg("Failed to search crawl")
+		sendErrorResponse(w, http.StatusBadGateway, "Search failed")
+		return
+	}
+	sendJSONResponse(w, http.StatusOK, response)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+)
+
+// Field of the crawl's meta hash saying its security headers are audited
+const securityHeadersField = "securityHeaders"
+
+// Headers a security header audit looks for, and reports missing
+const (
+	headerCSP                = "Content-Security-Policy"
+	headerCSPReportOnly      = "Content-Security-Policy-Report-Only"
+	headerHSTS               = "Strict-Transport-Security"
+	headerFrameOptions       = "X-Frame-Options"
+	headerContentTypeOptions = "X-Content-Type-Options"
+)
+
+var (
+	auditedSecurityHeaders = []string{headerCSP, headerHSTS, headerFrameOptions, headerContentTypeOptions}
+	// Recorded too, for context, but never required
+	recordedSecurityHeaders = append([]string{headerCSPReportOnly}, auditedSecurityHeaders...)
+)
+
+type (
+	// PageSecurityHeaders is what a security header audit records of a page
+	PageSecurityHeaders struct {
+		URL    string `json:"url"`
+		Status int    `json:"status"`
+		// Headers of the audit the page lacks, or has without effect
+		Missing []string `json:"missing"`
+		// Values of the ones it has
+		Headers map[string]string `json:"headers,omitempty"`
+	}
+	SecurityHeadersReportResponse struct {
+		Done         bool `json:"done"`
+		PagesAudited int  `json:"pagesAudited"`
+		// Pages missing each header
+		Missing map[string]int `json:"missing"`
+		// Pages missing at least one header, or every page with ?all=true.
+		// Most missing first
+		Pages []PageSecurityHeaders `json:"pages"`
+	}
+)
+
+// Helper function to build the redis key holding the security headers of a crawl's pages
+func securityHeadersKey(uniqueID string) string {
+	return fmt.Sprintf("go-crawler-security-headers-%s", uniqueID)
+}
+
+// recordSecurityHeaders keeps the security headers a page answered with
+func recordSecurityHeaders(rdb *redis.Client, uniqueID, url string, resp *http.Response) {
+	page := PageSecurityHeaders{URL: url, Status: resp.StatusCode, Headers: make(map[string]string)}
+	for _, name := range recordedSecurityHeaders {
+		if values := resp.Header.Values(name); len(values) > 0 {
+			page.Headers[name] = strings.Join(values, ", ")
+		}
+	}
+	encoded, err := json.Marshal(page)
+	if err != nil {
+		return
+	}
+	pipe := rdb.TxPipeline()
+	pipe.HSet(ctx, securityHeadersKey(uniqueID), url, encoded)
+	expireLikeCheckpoint(pipe, securityHeadersKey(uniqueID))
+	if _, err := pipe.Exec(ctx); err != nil {
+		withError(crawlLogger(uniqueID).Error(), err).Str("url", url).Msg("Failed to record security headers")
+	}
+}
+
+// missingSecurityHeaders lists the audited headers a page lacks. A CSP with
+// frame-ancestors stands in for X-Frame-Options, X-Content-Type-Options only
+// counts as nosniff, and HSTS is only expected over https
+func missingSecurityHeaders(page PageSecurityHeaders) []string {
+	missing := []string{}
+	for _, name := range auditedSecurityHeaders {
+		value := strings.ToLower(page.Headers[name])
+		switch {
+		case name == headerHSTS && !strings.HasPrefix(page.URL, "https://"):
+		case name == headerFrameOptions && strings.Contains(strings.ToLower(page.Headers[headerCSP]), "frame-ancestors"):
+		case name == headerContentTypeOptions && strings.TrimSpace(value) != "nosniff":
+			missing = append(missing, name)
+		case value == "":
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+// Crawl security headers handler - GET /crawl/{crawl_ID}/security-headers
+func crawlSecurityHeadersHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
+	crawlID := mux.Vars(r)["crawl_ID"]
+
+	audited, err := rdb.HExists(ctx, crawlMetaKey(crawlID), securityHeadersField).Result()
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to look up crawl")
+		return
+	}
+	if !audited {
+		sendErrorResponse(w, http.StatusNotFound, "No security header audit, the crawl must be started with securityHeaders")
+		return
+	}
+	raw, err := rdb.HGetAll(ctx, securityHeadersKey(crawlID)).Result()
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to get security headers")
+		return
+	}
+	_, done, err := resultStore.Range(crawlID, -1, -1)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to get results")
+		return
+	}
+
+	all := r.URL.Query().Get("all") == "true"
+	response := SecurityHeadersReportResponse{Done: done, PagesAudited: len(raw), Missing: map[string]int{}, Pages: []PageSecurityHeaders{}}
+	for _, name := range auditedSecurityHeaders {
+		response.Missing[name] = 0
+	}
+	for _, encoded := range raw {
+		var page PageSecurityHeaders
+		if json.Unmarshal([]byte(encoded), &page) != nil {
+			continue
+		}
+		page.Missing = missingSecurityHeaders(page)
+		for _, name := range page.Missing {
+			response.Missing[name]++
+		}
+		if all || len(page.Missing) > 0 {
+			response.Pages = append(response.Pages, page)
+		}
+	}
+	sort.Slice(response.Pages, func(i, j int) bool {
+		a, b := response.Pages[i], response.Pages[j]
+		if len(a.Missing) != len(b.Missing) {
+			return len(a.Missing) > len(b.Missing)
+		}
+		return a.URL < b.URL
+	})
+	sendJSONResponse(w, http.StatusOK, response)
+}
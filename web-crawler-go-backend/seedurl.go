@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	// Longer urls aren't reliably served anywhere
+	maxSeedURLLength = 2048
+	// How long the -seed-preflight HEAD request may take
+	seedPreflightTimeout = 5 * time.Second
+)
+
+// validateSeedURL checks a url a crawl or schedule starts from is one the
+// workers can fetch
+func validateSeedURL(rawURL string) error {
+	if rawURL == "" {
+		return errors.New("URL is required")
+	}
+	if len(rawURL) > maxSeedURLLength {
+		return fmt.Errorf("URL must be at most %d characters", maxSeedURLLength)
+	}
+	// Commas would break the comma separated jobs workers still accept, and control characters have no place in a url
+	for _, char := range rawURL {
+		if char == ',' || char == ' ' || char < 0x20 || char == 0x7f {
+			return errors.New("URL must not contain commas, spaces or control characters, percent-encode them")
+		}
+	}
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return errors.New("URL doesn't parse")
+	}
+	if parsedURL.Scheme != "http" && parsedURL.Scheme != "https" {
+		return errors.New("URL must be http or https")
+	}
+	if parsedURL.Hostname() == "" || parsedURL.User != nil {
+		return errors.New("URL must have a host and no user info")
+	}
+	return nil
+}
+
+// preflightSeedURL sends a HEAD request to the seed, failing if the host can't
+// be reached or the page is gone. Other errors are left for the crawl, some
+// servers simply don't answer HEAD. It's sent with the headers and User-Agent
+// the crawl's requests will have, from crawlCtx, and the cookies of its jar if it has one
+func preflightSeedURL(crawlCtx context.Context, rawURL string, jar http.CookieJar) error {
+	client := &http.Client{
+		Jar:     jar,
+		Timeout: seedPreflightTimeout,
+		Transport: crawlHeaderTransport{base: proxyPoolTransport{base: &http.Transport{
+			Proxy:               crawlProxy,
+			DialContext:         dialLocalhostAware(dialResolving((&net.Dialer{Timeout: dialTimeout}).DialContext)),
+			TLSHandshakeTimeout: dialTimeout,
+		}}},
+	}
+	req, err := http.NewRequestWithContext(crawlCtx, http.MethodHead, rawURL, nil)
+	if err != nil {
+		return fmt.Errorf("URL can't be reached: %v", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("URL can't be reached: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+		return fmt.Errorf("URL answered %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	selfTestTimeoutInSeconds = 30
+	selfTestSlowPageDelay    = 500 * time.Millisecond
+	selfTestPollPeriod       = 250 * time.Millisecond
+)
+
+// The self-test site lives under *.localhost hosts so that every page
+// is on a different "domain" as far as the crawler is concerned, while
+// still being served by this process (see dialLocalhostAware)
+//
+//go:embed selftest/*.html
+var selfTestFiles embed.FS
+
+var selfTestPages = template.Must(template.New("selftest").Funcs(template.FuncMap{
+	"site": selfTestURL,
+}).ParseFS(selfTestFiles, "selftest/*.html"))
+
+// selfTestExpected maps every page the crawler should visit to the
+// children it should find there. Built on demand, the urls depend on -addr
+func selfTestExpected() map[string][]string {
+	return map[string][]string{
+		selfTestURL("seed", "index.html"): {
+			selfTestURL("alpha", "alpha.html"),
+			selfTestURL("beta", "beta.html"),
+			selfTestURL("gamma", "missing.html"),
+			selfTestURL("delta", "redirect"),
+			selfTestURL("slow", "slow"),
+		},
+		selfTestURL("alpha", "alpha.html"):   {selfTestURL("beta", "beta.html")},
+		selfTestURL("beta", "beta.html"):     {selfTestURL("seed", "index.html")},
+		selfTestURL("gamma", "missing.html"): {},
+		selfTestURL("delta", "redirect"):     {selfTestURL("alpha", "alpha.html")},
+		selfTestURL("slow", "slow"):          {selfTestURL("beta", "beta.html")},
+	}
+}
+
+type SelfTestResponse struct {
+	Passed   bool     `json:"passed"`
+	CrawlID  string   `json:"crawlID"`
+	Duration string   `json:"duration"`
+	Failures []string `json:"failures,omitempty"`
+}
+
+// Helper function to build the url of a page on the self-test site
+func selfTestURL(subdomain, page string) string {
+	// Whatever host the server listens on, it's reached through localhost
+	_, port, _ := net.SplitHostPort(serverAddr)
+	return fmt.Sprintf("http://%s.localhost:%s/selftest/%s", subdomain, port, page)
+}
+
+// Self-test site handler - GET /selftest/{page}
+func selfTestSiteHandler(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, "/selftest/synthetic/") {
+		page, err := strconv.Atoi(path.Base(r.URL.Path))
+		if err != nil || page < 0 || page >= syntheticSiteSize {
+			http.NotFound(w, r)
+			return
+		}
+		syntheticSiteHandler(w, r, page)
+		return
+	}
+
+	page := path.Base(r.URL.Path)
+	switch page {
+	case "redirect":
+		http.Redirect(w, r, selfTestURL("epsilon", "epsilon.html"), http.StatusFound)
+		return
+	case "slow":
+		time.Sleep(selfTestSlowPageDelay)
+		page = "slow.html"
+	}
+
+	if selfTestPages.Lookup(page) == nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	selfTestPages.ExecuteTemplate(w, page, nil)
+}
+
+// Self-test handler - POST /admin/selftest
+func selfTestHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
+	start := time.Now()
+	uniqueID, err := startCrawl(rdb, newCrawlJob(r.Context(), selfTestURL("seed", "index.html")))
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to queue command")
+		return
+	}
+
+	response := SelfTestResponse{CrawlID: uniqueID}
+	nodes, err := waitForCrawl(rdb, uniqueID, selfTestTimeoutInSeconds*time.Second)
+	if err != nil {
+		response.Failures = []string{err.Error()}
+	} else {
+		response.Failures = checkSelfTestResults(nodes)
+	}
+	response.Passed = len(response.Failures) == 0
+	response.Duration = time.Since(start).String()
+
+	if !response.Passed {
+		sendJSONResponse(w, http.StatusInternalServerError, response)
+		return
+	}
+	sendJSONResponse(w, http.StatusOK, response)
+}
+
+// waitForCrawl polls the results list until the finish sentinel shows up
+func waitForCrawl(rdb *redis.Client, uniqueID string, timeout time.Duration) ([]graphNode, error) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		nodes, done, err := resultStore.Range(uniqueID, 0, -1)
+		if err != nil {
+			return nil, err
+		}
+		if done {
+			return nodes, nil
+		}
+		time.Sleep(selfTestPollPeriod)
+	}
+	return nil, fmt.Errorf("crawl did not finish within %s", timeout)
+}
+
+func checkSelfTestResults(nodes []graphNode) []string {
+	failures := []string{}
+	found := make(map[string][]string, len(nodes))
+	for _, node := range nodes {
+		if _, seen := found[node.Parent]; seen {
+			failures = append(failures, fmt.Sprintf("%s was crawled more than once", node.Parent))
+		}
+		found[node.Parent] = node.Children
+	}
+
+	expected := selfTestExpected()
+	for parent, expectedChildren := range expected {
+		children, ok := found[parent]
+		if !ok {
+			failures = append(failures, fmt.Sprintf("%s was never crawled", parent))
+			continue
+		}
+		if !sameURLs(children, expectedChildren) {
+			failures = append(failures, fmt.Sprintf("%s: expected children %v, got %v", parent, expectedChildren, children))
+		}
+	}
+	for parent := range found {
+		if _, ok := expected[parent]; !ok {
+			failures = append(failures, fmt.Sprintf("%s was crawled but is not part of the self-test site", parent))
+		}
+	}
+	sort.Strings(failures)
+	return failures
+}
+
+func sameURLs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	sortedA := append([]string(nil), a...)
+	sortedB := append([]string(nil), b...)
+	sort.Strings(sortedA)
+	sort.Strings(sortedB)
+	return strings.Join(sortedA, " ") == strings.Join(sortedB, " ")
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+	"golang.org/x/net/html"
+)
+
+const (
+	// Full crawls that also audit every page they fetch, see GET /crawl/{id}/seo
+	crawlTypeSEO = "seo"
+	// Field of the crawl's meta hash saying what type of crawl it is, only set for audits
+	crawlTypeField = "type"
+	// Redirects in a row that make a chain worth reporting
+	minRedirectChain = 2
+)
+
+// Kinds of SEO findings, reported in this order
+const (
+	seoBrokenPage           = "broken_page"
+	seoRedirectChain        = "redirect_chain"
+	seoMissingTitle         = "missing_title"
+	seoDuplicateTitle       = "duplicate_title"
+	seoMissingDescription   = "missing_description"
+	seoDuplicateDescription = "duplicate_description"
+	seoMissingH1            = "missing_h1"
+	seoMultipleH1           = "multiple_h1"
+	seoNoindex              = "noindex"
+	seoCanonicalElsewhere   = "canonical_elsewhere"
+)
+
+var seoFindingKinds = []string{seoBrokenPage, seoRedirectChain, seoMissingTitle, seoDuplicateTitle, seoMissingDescription, seoDuplicateDescription, seoMissingH1, seoMultipleH1, seoNoindex, seoCanonicalElsewhere}
+
+type (
+	// SEOPage is what an SEO audit crawl records of every page it fetches
+	SEOPage struct {
+		URL    string `json:"url"`
+		Status int    `json:"status"`
+		// Every redirect on the way to the page, and where it ended up
+		Redirects []SEORedirect `json:"redirects,omitempty"`
+		FinalURL  string        `json:"finalURL,omitempty"`
+		// Only HTML pages have the rest
+		HTML        bool     `json:"html"`
+		Title       string   `json:"title,omitempty"`
+		Description string   `json:"description,omitempty"`
+		Canonical   string   `json:"canonical,omitempty"`
+		H1s         []string `json:"h1s,omitempty"`
+		// From <meta name="robots"> and the X-Robots-Tag header, lowercased
+		Robots []string `json:"robots,omitempty"`
+	}
+	SEORedirect struct {
+		URL    string `json:"url"`
+		Status int    `json:"status"`
+	}
+	SEOReportResponse struct {
+		Done         bool `json:"done"`
+		PagesAudited int  `json:"pagesAudited"`
+		// Findings of each kind
+		Summary  map[string]int `json:"summary"`
+		Findings []SEOFinding   `json:"findings"`
+		// Only with ?pages=true
+		Pages []SEOPage `json:"pages,omitempty"`
+	}
+	SEOFinding struct {
+		Kind string `json:"kind"`
+		// The page, or all the pages sharing a duplicate
+		URLs []string `json:"urls"`
+		// The duplicated text, the redirect chain or the canonical url
+		Detail string `json:"detail,omitempty"`
+	}
+)
+
+// Helper function to build the redis key holding what an SEO audit found on every page
+func seoPagesKey(uniqueID string) string {
+	return fmt.Sprintf("go-crawler-seo-%s", uniqueID)
+}
+
+// Helper function to collapse the whitespace of text found in a page
+func collapseSpace(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// auditPage gathers the SEO signals of a fetched page
+func auditPage(urlToFetch string, resp *http.Response, body []byte) SEOPage {
+	page := SEOPage{URL: urlToFetch, Status: resp.StatusCode, H1s: []string{}}
+	// Every request after a redirect links back to the response that caused it
+	for req := resp.Request; req != nil && req.Response != nil; req = req.Response.Request {
+		page.Redirects = append([]SEORedirect{{URL: req.Response.Request.URL.String(), Status: req.Response.StatusCode}}, page.Redirects...)
+	}
+	if len(page.Redirects) > 0 {
+		page.FinalURL = resp.Request.URL.String()
+	}
+	for _, directives := range resp.Header.Values("X-Robots-Tag") {
+		page.Robots = append(page.Robots, splitRobotsDirectives(directives)...)
+	}
+	contentType := responseContentType(resp.Header)
+	page.HTML = contentType == "text/html" || contentType == "application/xhtml+xml"
+	if !page.HTML {
+		return page
+	}
+
+	base := resp.Request.URL
+	var inTitle, inH1 bool
+	var h1 strings.Builder
+	z := html.NewTokenizer(bytes.NewReader(body))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return page
+		case html.TextToken:
+			if inTitle && page.Title == "" {
+				page.Title = collapseSpace(string(z.Text()))
+			}
+			if inH1 {
+				h1.Write(z.Text())
+			}
+		case html.EndTagToken:
+			switch tn, _ := z.TagName(); string(tn) {
+			case "title":
+				inTitle = false
+			case "h1":
+				if inH1 {
+					page.H1s = append(page.H1s, collapseSpace(h1.String()))
+					inH1 = false
+				}
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tn, hasAttrs := z.TagName()
+			attrs := make(map[string]string)
+			for hasAttrs {
+				var key, val []byte
+				key, val, hasAttrs = z.TagAttr()
+				attrs[string(key)] = string(val)
+			}
+			switch string(tn) {
+			case "title":
+				inTitle = true
+			case "h1":
+				inH1 = true
+				h1.Reset()
+			case "meta":
+				switch strings.ToLower(attrs["name"]) {
+				case "description":
+					if page.Description == "" {
+						page.Description = collapseSpace(attrs["content"])
+					}
+				case "robots":
+					page.Robots = append(page.Robots, splitRobotsDirectives(attrs["content"])...)
+				}
+			case "link":
+				if strings.EqualFold(attrs["rel"], "canonical") && page.Canonical == "" {
+					if canonical, err := base.Parse(strings.TrimSpace(attrs["href"])); err == nil {
+						page.Canonical = canonical.String()
+					}
+				}
+			}
+		}
+	}
+}
+
+// Helper function to split a robots directive list like "noindex, nofollow"
+func splitRobotsDirectives(directives string) []string {
+	split := []string{}
+	for _, directive := range strings.Split(directives, ",") {
+		if directive = strings.ToLower(strings.TrimSpace(directive)); directive != "" {
+			split = append(split, directive)
+		}
+	}
+	return split
+}
+
+// recordSEOPage keeps what an SEO audit found on a page
+func recordSEOPage(rdb *redis.Client, uniqueID string, page SEOPage) {
+	encoded, err := json.Marshal(page)
+	if err != nil {
+		return
+	}
+	pipe := rdb.TxPipeline()
+	pipe.HSet(ctx, seoPagesKey(uniqueID), page.URL, encoded)
+	expireLikeCheckpoint(pipe, seoPagesKey(uniqueID))
+	if _, err := pipe.Exec(ctx); err != nil {
+		withError(crawlLogger(uniqueID).Error(), err).Str("url", page.URL).Msg("Failed to record SEO audit")
+	}
+}
+
+// seoFindings turns the audited pages, sorted by url, into findings
+func seoFindings(pages []SEOPage) []SEOFinding {
+	byKind := make(map[string][]SEOFinding)
+	add := func(kind, detail string, urls ...string) {
+		byKind[kind] = append(byKind[kind], SEOFinding{Kind: kind, URLs: urls, Detail: detail})
+	}
+	titles := make(map[string][]string)
+	descriptions := make(map[string][]string)
+	for _, page := range pages {
+		if len(page.Redirects) >= minRedirectChain {
+			hops := make([]string, 0, len(page.Redirects)+1)
+			for _, redirect := range page.Redirects {
+				hops = append(hops, fmt.Sprintf("%s (%d)", redirect.URL, redirect.Status))
+			}
+			add(seoRedirectChain, strings.Join(append(hops, page.FinalURL), " -> "), page.URL)
+		}
+		if page.Status >= 400 {
+			add(seoBrokenPage, http.StatusText(page.Status), page.URL)
+			continue
+		}
+		for _, directive := range page.Robots {
+			if directive == "noindex" || directive == "none" {
+				add(seoNoindex, "", page.URL)
+				break
+			}
+		}
+		if !page.HTML {
+			continue
+		}
+		if page.Title == "" {
+			add(seoMissingTitle, "", page.URL)
+		} else {
+			titles[page.Title] = append(titles[page.Title], page.URL)
+		}
+		if page.Description == "" {
+			add(seoMissingDescription, "", page.URL)
+		} else {
+			descriptions[page.Description] = append(descriptions[page.Description], page.URL)
+		}
+		switch {
+		case len(page.H1s) == 0:
+			add(seoMissingH1, "", page.URL)
+		case len(page.H1s) > 1:
+			add(seoMultipleH1, strings.Join(page.H1s, " | "), page.URL)
+		}
+		landedOn := page.URL
+		if page.FinalURL != "" {
+			landedOn = page.FinalURL
+		}
+		if page.Canonical != "" && page.Canonical != landedOn {
+			add(seoCanonicalElsewhere, page.Canonical, page.URL)
+		}
+	}
+	for kind, duplicates := range map[string]map[string][]string{seoDuplicateTitle: titles, seoDuplicateDescription: descriptions} {
+		for text, urls := range duplicates {
+			if len(urls) > 1 {
+				add(kind, text, urls...)
+			}
+		}
+		// Pages are sorted, so the first url orders the groups
+		sort.Slice(byKind[kind], func(i, j int) bool {
+			return byKind[kind][i].URLs[0] < byKind[kind][j].URLs[0]
+		})
+	}
+
+	findings := []SEOFinding{}
+	for _, kind := range seoFindingKinds {
+		findings = append(findings, byKind[kind]...)
+	}
+	return findings
+}
+
+// Crawl SEO report handler - GET /crawl/{crawl_ID}/seo
+func crawlSEOReportHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
+	crawlID := mux.Vars(r)["crawl_ID"]
+
+	crawlType, err := rdb.HGet(ctx, crawlMetaKey(crawlID), crawlTypeField).Result()
+	if err != nil && err != redis.Nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to look up crawl")
+		return
+	}
+	if crawlType != crawlTypeSEO {
+		sendErrorResponse(w, http.StatusNotFound, "No SEO audit, the crawl must be started with type seo")
+		return
+	}
+	raw, err := rdb.HGetAll(ctx, seoPagesKey(crawlID)).Result()
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to get SEO audit")
+		return
+	}
+	_, done, err := resultStore.Range(crawlID, -1, -1)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to get results")
+		return
+	}
+	ignored, err := loadIgnoreSet(rdb, crawlID)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to get annotations")
+		return
+	}
+
+	pages := make([]SEOPage, 0, len(raw))
+	for _, encoded := range raw {
+		var page SEOPage
+		if json.Unmarshal([]byte(encoded), &page) == nil && !ignored.nodes[page.URL] {
+			pages = append(pages, page)
+		}
+	}
+	sort.Slice(pages, func(i, j int) bool {
+		return pages[i].URL < pages[j].URL
+	})
+	response := SEOReportResponse{Done: done, PagesAudited: len(pages), Summary: map[string]int{}, Findings: seoFindings(pages)}
+	for _, finding := range response.Findings {
+		response.Summary[finding.Kind]++
+	}
+	if r.URL.Query().Get("pages") == "true" {
+		response.Pages = pages
+	}
+	sendJSONResponse(w, http.StatusOK, response)
+}
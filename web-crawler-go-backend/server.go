@@ -5,30 +5,80 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
-	"time"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// Allowed origins for CORS. Update as needed.
-var allowedOrigins = []string{
-	"http://localhost:3000",
-	"http://localhost:5173",
-	"http://localhost:8080",
-	"https://localhost:3000",
-	"https://localhost:5173",
-	"https://localhost:8080",
-}
-
 // HTTP request/response types
 type InitializeCrawlRequest struct {
 	URL string `json:"url"`
+	// Optional ID of an earlier crawl, pages it visited are skipped
+	ExcludeVisitedFrom string `json:"excludeVisitedFrom,omitempty"`
+	// Optional, crawls the pages listed in the site's sitemap as if the url linked to them
+	Sitemap bool `json:"sitemap,omitempty"`
+	// Optional, also crawls the entries of the RSS and Atom feeds pages link to
+	FollowFeeds bool `json:"followFeeds,omitempty"`
+	// Optional, pages unchanged since an earlier crawl with httpCache aren't downloaded again
+	HTTPCache bool `json:"httpCache,omitempty"`
+	// Optional, "flag" marks pages whose text is nearly the same as a page the
+	// crawl already has, "prune" also doesn't crawl their links
+	NearDuplicates string `json:"nearDuplicates,omitempty"`
+	// Optional, the User-Agent every request of the crawl is sent with instead
+	// of the crawler's own, or several sent in turn, one per request
+	UserAgent  string   `json:"userAgent,omitempty"`
+	UserAgents []string `json:"userAgents,omitempty"`
+	// Optional, headers added to every request of the crawl, e.g. Accept-Language
+	Headers map[string]string `json:"headers,omitempty"`
+	// Optional, keeps the cookies sites set for the rest of the crawl. Cookies
+	// also turns it on, and starts the jar with them
+	CookieJar bool          `json:"cookieJar,omitempty"`
+	Cookies   []CrawlCookie `json:"cookies,omitempty"`
+	// Optional, HTTP Basic or bearer credentials for the crawl's site, or the hosts they list
+	Credentials []CrawlCredential `json:"credentials,omitempty"`
+	// Optional, every request of the crawl goes through this proxy instead of the server's
+	Proxy string `json:"proxy,omitempty"`
+	// Optional, round-robin or least-errors, spreads the requests of the crawl
+	// over the proxies of the server's -proxy-pool
+	ProxyPool string `json:"proxyPool,omitempty"`
+	// Optional, also crawls the links scripts add to pages, loading every HTML
+	// page in the server's headless browser
+	Render bool `json:"render,omitempty"`
+	// Optional, with render keeps a screenshot of every page rendered, see GET /crawl/{id}/screenshot
+	Screenshots bool `json:"screenshots,omitempty"`
+	// Optional, reads the text, metadata and links of the PDF and Word
+	// documents the crawl finds, see graphNode.Document
+	ExtractDocuments bool `json:"extractDocuments,omitempty"`
+	// Optional, a signed summary is POSTed here once the crawl finishes or fails
+	CallbackURL string `json:"callbackURL,omitempty"`
+	// Optional, Slack and email notifications of the crawl, on top of the server's
+	Notify *NotifySettings `json:"notify,omitempty"`
+	// "full" (the default), "seo", a full crawl that also audits every page, see
+	// GET /crawl/{id}/seo, or "status", which only checks the status of every
+	// url the crawl statusOf found, with HEAD requests
+	Type     string `json:"type,omitempty"`
+	StatusOf string `json:"statusOf,omitempty"`
+	// Optional, "raw", "text" or "article" stores the body of every page fetched, see
+	// GET /crawl/{id}/page and GET /crawl/{id}/article
+	StoreBodies string `json:"storeBodies,omitempty"`
+	// Optional, records the security headers of every page, see GET /crawl/{id}/security-headers
+	SecurityHeaders bool `json:"securityHeaders,omitempty"`
+	// Optional, how long the results are kept once the crawl is done. Defaults
+	// to -results-ttl and may be up to maxCrawlResultsTTL
+	TTLSeconds int `json:"ttlSeconds,omitempty"`
+	// Optional, lower than the defaults or what the tenant's policy allows
+	Depth   int     `json:"depth,omitempty"`
+	MaxRate float64 `json:"maxRate,omitempty"`
 }
 
 type InitializeCrawlResponse struct {
+	CrawlID    string `json:"crawlID"`
 	ResultsURL string `json:"resultsURL"`
+	// What the crawl ended up allowed, only when starting one
+	Limits *CrawlLimits `json:"limits,omitempty"`
 }
 
 type LookupCrawlResponse struct {
@@ -49,8 +99,45 @@ type ErrorResponse struct {
 }
 
 // Helper function to build results link
-func buildResultsLink(host, uniqueID string, startIndex int) string {
-	return fmt.Sprintf("http://%s/crawl/%s?startIndex=%d", host, uniqueID, startIndex)
+func buildResultsLink(scheme, host, uniqueID string, startIndex int) string {
+	return fmt.Sprintf("%s://%s%s?startIndex=%d", scheme, host, versionedPath("/crawl/"+uniqueID), startIndex)
+}
+
+// Helper function to build the redis key holding a crawl's results
+func resultsKey(uniqueID string) string {
+	return fmt.Sprintf("go-crawler-results-%s", uniqueID)
+}
+
+// Helper function to queue a new crawl, returning its ID
+func startCrawl(rdb *redis.Client, job crawlJob) (string, error) {
+	// Generate unique ID, in the tenant's namespace so no other tenant can read it
+	tenant := ""
+	if job.Limits != nil {
+		tenant = job.Limits.Tenant
+	}
+	job.CrawlID = newNamespacedID(tenant)
+	if err := queueJob(job); err != nil {
+		return "", err
+	}
+	return job.CrawlID, nil
+}
+
+// Helper function to decode raw results, reporting whether the finish sentinel was reached
+func parseResults(rawResults []string) ([]graphNode, bool) {
+	results := make([]graphNode, 0, len(rawResults))
+	for _, rawResult := range rawResults {
+		// Check if it's a finish sentinel
+		var sentinel finishSentinel
+		if err := json.Unmarshal([]byte(rawResult), &sentinel); err == nil && sentinel.DoneMessage != "" {
+			return results, true
+		}
+		var node graphNode
+		if err := json.Unmarshal([]byte(rawResult), &node); err != nil {
+			continue
+		}
+		results = append(results, node)
+	}
+	return results, false
 }
 
 // Helper function to send JSON response
@@ -72,32 +159,294 @@ func initializeCrawlHandler(w http.ResponseWriter, r *http.Request, rdb *redis.C
 		return
 	}
 
+	policy, ok := requireTenant(w, r)
+	if !ok {
+		return
+	}
+
 	var req InitializeCrawlRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		sendErrorResponse(w, http.StatusBadRequest, "Invalid JSON")
 		return
 	}
+	if req.Depth < 0 || req.MaxRate < 0 {
+		sendErrorResponse(w, http.StatusBadRequest, "depth and maxRate must not be negative")
+		return
+	}
+
+	switch req.Type {
+	case "", crawlTypeFull, crawlTypeSEO:
+	case crawlTypeStatus:
+		if req.StatusOf == "" {
+			sendErrorResponse(w, http.StatusBadRequest, "statusOf is required for status crawls")
+			return
+		}
+		if !inCallerNamespace(r, req.StatusOf) {
+			sendErrorResponse(w, http.StatusNotFound, "statusOf crawl not found")
+			return
+		}
+		// The earlier crawl's first page stands in as the url, for logs and checkpoints
+		nodes, _, err := resultStore.Range(req.StatusOf, 0, 0)
+		if err != nil {
+			sendErrorResponse(w, http.StatusInternalServerError, "Failed to look up statusOf crawl")
+			return
+		}
+		if len(nodes) == 0 {
+			sendErrorResponse(w, http.StatusNotFound, "statusOf crawl not found")
+			return
+		}
+		req.URL = nodes[0].Parent
+	default:
+		sendErrorResponse(w, http.StatusBadRequest, "type must be full, seo or status")
+		return
+	}
+
+	if err := validateSeedURL(req.URL); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	switch req.StoreBodies {
+	case "":
+	case storeBodiesRaw, storeBodiesText, storeBodiesArticle:
+		if req.Type == crawlTypeStatus {
+			sendErrorResponse(w, http.StatusBadRequest, "Status crawls don't download bodies to store")
+			return
+		}
+	default:
+		sendErrorResponse(w, http.StatusBadRequest, "storeBodies must be raw, text or article")
+		return
+	}
+	if (req.Sitemap || req.FollowFeeds) && req.Type == crawlTypeStatus {
+		sendErrorResponse(w, http.StatusBadRequest, "Status crawls only check the urls of statusOf, not a sitemap or feeds")
+		return
+	}
+	switch req.NearDuplicates {
+	case "":
+	case nearDuplicatesFlag, nearDuplicatesPrune:
+		if req.Type == crawlTypeStatus {
+			sendErrorResponse(w, http.StatusBadRequest, "Status crawls don't download the pages to compare")
+			return
+		}
+	default:
+		sendErrorResponse(w, http.StatusBadRequest, "nearDuplicates must be flag or prune")
+		return
+	}
+	if req.ExtractDocuments && req.Type == crawlTypeStatus {
+		sendErrorResponse(w, http.StatusBadRequest, "Status crawls don't download the documents to read")
+		return
+	}
+	if req.UserAgent != "" && len(req.UserAgents) > 0 {
+		sendErrorResponse(w, http.StatusBadRequest, "Set userAgent or userAgents, not both")
+		return
+	}
+	userAgents := req.UserAgents
+	if req.UserAgent != "" {
+		userAgents = []string{req.UserAgent}
+	}
+	if err := validateUserAgents(userAgents); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	headers, err := validateRequestHeaders(req.Headers)
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := validateCrawlCookies(req.Cookies); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	cookieJar := req.CookieJar || len(req.Cookies) > 0
+	if _, ok := headers["Cookie"]; ok && cookieJar {
+		sendErrorResponse(w, http.StatusBadRequest, "Set cookies in cookies, not in headers, when the crawl has a cookie jar")
+		return
+	}
+	credentials, err := validateCrawlCredentials(req.URL, req.Credentials)
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if _, ok := headers["Authorization"]; ok && len(credentials) > 0 {
+		sendErrorResponse(w, http.StatusBadRequest, "Set credentials or an Authorization header, not both")
+		return
+	}
+	if req.Proxy != "" {
+		proxy, err := parseProxyURL(req.Proxy)
+		if err != nil {
+			sendErrorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if !allowedCrawlProxy(proxy) {
+			sendErrorResponse(w, http.StatusForbidden, "proxy must be one of the server's -allowed-proxies, and crawls can't pick a proxy when the server has -proxy")
+			return
+		}
+	}
+	switch req.ProxyPool {
+	case "":
+	case proxyPoolRoundRobin, proxyPoolLeastErrors:
+		if len(proxyPoolAddrs) == 0 {
+			sendErrorResponse(w, http.StatusBadRequest, "The server has no -proxy-pool")
+			return
+		}
+		if req.Proxy != "" {
+			sendErrorResponse(w, http.StatusBadRequest, "Set proxy or proxyPool, not both")
+			return
+		}
+	default:
+		sendErrorResponse(w, http.StatusBadRequest, "proxyPool must be round-robin or least-errors")
+		return
+	}
+	if req.Render {
+		if policy != nil && !policy.AllowRender {
+			sendErrorResponse(w, http.StatusForbidden, "The tenant's policy doesn't allow render")
+			return
+		}
+		if renderWorkers == 0 {
+			sendErrorResponse(w, http.StatusBadRequest, "The server has no -render-workers")
+			return
+		}
+		if req.Type == crawlTypeStatus || req.HTTPCache {
+			sendErrorResponse(w, http.StatusBadRequest, "render needs every page downloaded, it can't be used with status crawls or httpCache")
+			return
+		}
+		if req.Proxy != "" || req.ProxyPool != "" {
+			sendErrorResponse(w, http.StatusBadRequest, "The browser downloads what pages need itself, render can't be used with proxy or proxyPool")
+			return
+		}
+	}
+	if req.Screenshots {
+		if !req.Render {
+			sendErrorResponse(w, http.StatusBadRequest, "Screenshots are taken of rendered pages, set render too")
+			return
+		}
+		if screenshotStore == nil {
+			sendErrorResponse(w, http.StatusBadRequest, "The server has no -screenshot-store")
+			return
+		}
+	}
+	if req.HTTPCache && (len(headers) > 0 || cookieJar || len(credentials) > 0) {
+		sendErrorResponse(w, http.StatusBadRequest, "httpCache revalidates pages cached by every crawl, it can't be used with headers, cookies or credentials")
+		return
+	}
+	if req.HTTPCache && (req.Type == crawlTypeStatus || req.Type == crawlTypeSEO || req.StoreBodies != "" || req.SecurityHeaders) {
+		sendErrorResponse(w, http.StatusBadRequest, "httpCache skips the bodies and headers of unchanged pages, it can't be used with status or seo crawls, storeBodies or securityHeaders")
+		return
+	}
 
-	if req.URL == "" {
-		sendErrorResponse(w, http.StatusBadRequest, "URL is required")
+	if req.CallbackURL != "" {
+		if webhookSecret == "" {
+			sendErrorResponse(w, http.StatusBadRequest, "callbackURL needs the server to be started with -webhook-secret-file")
+			return
+		}
+		if err := validateCallbackURL(req.CallbackURL); err != nil {
+			sendErrorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	if req.Notify != nil {
+		if err := req.Notify.validate(); err != nil {
+			sendErrorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		for _, address := range req.Notify.Email {
+			if !policy.allowsNotifyEmail(address) {
+				sendErrorResponse(w, http.StatusForbidden, fmt.Sprintf("The tenant's policy doesn't allow emailing %s", address))
+				return
+			}
+		}
+	}
+
+	if req.TTLSeconds < 0 || req.TTLSeconds > maxCrawlResultsTTL {
+		sendErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("ttlSeconds must be between 1 and %d", maxCrawlResultsTTL))
+		return
+	}
+
+	limits := clampLimits(policy, req.Depth, req.MaxRate)
+	limits.applyQuota(r, policy)
+	if !limits.inScope(req.URL) {
+		sendErrorResponse(w, http.StatusForbidden, "URL is outside the allowed scopes")
+		return
+	}
+	if currentPolicy().blocks(req.URL) {
+		sendErrorResponse(w, http.StatusForbidden, "URL is on a blocked domain")
 		return
 	}
 
-	// Generate unique ID
-	uniqueID := fmt.Sprintf("%d", time.Now().UnixNano())
+	if req.ExcludeVisitedFrom != "" {
+		if !inCallerNamespace(r, req.ExcludeVisitedFrom) {
+			sendErrorResponse(w, http.StatusNotFound, "excludeVisitedFrom crawl not found")
+			return
+		}
+		visited, err := rdb.Exists(ctx, visitedKey(req.ExcludeVisitedFrom)).Result()
+		if err != nil {
+			sendErrorResponse(w, http.StatusInternalServerError, "Failed to look up excludeVisitedFrom crawl")
+			return
+		}
+		length, err := resultStore.Len(req.ExcludeVisitedFrom)
+		if err != nil {
+			sendErrorResponse(w, http.StatusInternalServerError, "Failed to look up excludeVisitedFrom crawl")
+			return
+		}
+		if visited == 0 && length == 0 {
+			sendErrorResponse(w, http.StatusNotFound, "excludeVisitedFrom crawl not found")
+			return
+		}
+	}
+	if seedPreflight && req.Type != crawlTypeStatus {
+		var jar http.CookieJar
+		if cookieJar {
+			jar = newCrawlCookieJar(req.Cookies)
+		}
+		if err := preflightSeedURL(withProxyPool(withProxy(withCredentials(withRequestHeaders(withUserAgents(r.Context(), userAgents), headers), credentials), req.Proxy), req.ProxyPool), req.URL, jar); err != nil {
+			sendErrorResponse(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+	}
+	if !takeCrawlQuota(w, r, rdb, policy) {
+		return
+	}
 
-	// Publish command to Redis
-	command := fmt.Sprintf("%s,%s", req.URL, uniqueID)
-	if err := rdb.Publish(ctx, "go-crawler-commands", command).Err(); err != nil {
-		sendErrorResponse(w, http.StatusInternalServerError, "Failed to publish command")
+	// The worker's spans join this request's trace, and its logs carry the request's ID
+	job := newCrawlJob(r.Context(), req.URL)
+	if req.Type == crawlTypeStatus {
+		job.StatusOf = req.StatusOf
+	}
+	job.SEOAudit = req.Type == crawlTypeSEO
+	job.StoreBodies = req.StoreBodies
+	job.SecurityHeaders = req.SecurityHeaders
+	job.ResultsTTLSeconds = req.TTLSeconds
+	job.ExcludeVisitedFrom = req.ExcludeVisitedFrom
+	job.Sitemap = req.Sitemap
+	job.FollowFeeds = req.FollowFeeds
+	job.HTTPCache = req.HTTPCache
+	job.NearDuplicates = req.NearDuplicates
+	job.UserAgents = userAgents
+	job.Headers = headers
+	job.CookieJar, job.Cookies = cookieJar, req.Cookies
+	job.Credentials = credentials
+	job.Proxy, job.ProxyPool = req.Proxy, req.ProxyPool
+	job.Render, job.Screenshots = req.Render, req.Screenshots
+	job.ExtractDocuments = req.ExtractDocuments
+	if req.CallbackURL != "" {
+		job.CallbackURL, job.APIHost, job.APIScheme = req.CallbackURL, r.Host, apiScheme()
+	}
+	job.Notify = req.Notify
+	job.Limits = &limits
+	uniqueID, err := startCrawl(rdb, job)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to queue command")
 		return
 	}
+	trace.SpanFromContext(r.Context()).SetAttributes(crawlIDLabel.String(uniqueID))
+	crawlLogger(uniqueID).Info().Str("request_id", requestIDFromContext(r.Context())).Str("url", req.URL).Msg("Queued crawl")
 
 	// Build results URL
 	host := r.Host
-	resultsURL := buildResultsLink(host, uniqueID, 0)
+	resultsURL := buildResultsLink(apiScheme(), host, uniqueID, 0)
 
-	response := InitializeCrawlResponse{ResultsURL: resultsURL}
+	response := InitializeCrawlResponse{CrawlID: uniqueID, ResultsURL: resultsURL, Limits: &limits}
 	sendJSONResponse(w, http.StatusAccepted, response)
 }
 
@@ -128,25 +477,35 @@ func lookupCrawlHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Clien
 		return
 	}
 
-	// Get results from Redis
-	resultsListKey := fmt.Sprintf("go-crawler-results-%s", crawlID)
-	listLen, err := rdb.LLen(ctx, resultsListKey).Result()
+	// Optional filters, startIndex and the next link still count every result
+	filter, err := resultFilterFromQuery(r.URL.Query())
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	filterQuery := ""
+	if query := filter.query(); len(query) > 0 {
+		filterQuery = "&" + query.Encode()
+	}
+
+	// Get results from the store
+	listLen, err := resultStore.Len(crawlID)
 	if err != nil {
 		sendErrorResponse(w, http.StatusInternalServerError, "Failed to get results length")
 		return
 	}
 
 	// Get results from start index to end
-	rawResults, err := rdb.LRange(ctx, resultsListKey, int64(startIndex), listLen-1).Result()
+	results, done, err := resultStore.Range(crawlID, int64(startIndex), listLen-1)
 	if err != nil {
 		sendErrorResponse(w, http.StatusInternalServerError, "Failed to get results")
 		return
 	}
 
 	// No new results found
-	if len(rawResults) == 0 {
+	if len(results) == 0 && !done {
 		host := r.Host
-		nextLink := buildResultsLink(host, crawlID, startIndex)
+		nextLink := buildResultsLink(apiScheme(), host, crawlID, startIndex) + filterQuery
 		response := LookupCrawlResponse{
 			Edges: []graphNode{},
 			Links: &Links{
@@ -157,28 +516,9 @@ func lookupCrawlHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Clien
 		return
 	}
 
-	// Parse results
-	results := make([]graphNode, 0, len(rawResults))
-	for _, rawResult := range rawResults {
-		var node graphNode
-		if err := json.Unmarshal([]byte(rawResult), &node); err != nil {
-			// Check if it's a finish sentinel
-			var sentinel finishSentinel
-			if err := json.Unmarshal([]byte(rawResult), &sentinel); err == nil && sentinel.DoneMessage != "" {
-				// This is the finish sentinel, return results without it
-				break
-			}
-			continue
-		}
-		results = append(results, node)
-	}
-
-	// Check if the last result was a finish sentinel (crawl completed)
-	lastResult := rawResults[len(rawResults)-1]
-	var sentinel finishSentinel
-	if json.Unmarshal([]byte(lastResult), &sentinel) == nil && sentinel.DoneMessage != "" {
+	if done {
 		// Crawl is complete, return results without next link
-		response := LookupCrawlResponse{Edges: results}
+		response := LookupCrawlResponse{Edges: filter.apply(results)}
 		sendJSONResponse(w, http.StatusOK, response)
 		return
 	}
@@ -186,9 +526,9 @@ func lookupCrawlHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Clien
 	// Crawl is still in progress, return results with next link
 	host := r.Host
 	nextIndex := startIndex + len(results)
-	nextLink := buildResultsLink(host, crawlID, nextIndex)
+	nextLink := buildResultsLink(apiScheme(), host, crawlID, nextIndex) + filterQuery
 	response := LookupCrawlResponse{
-		Edges: results,
+		Edges: filter.apply(results),
 		Links: &Links{
 			Next: &NextLink{Href: nextLink},
 		},
@@ -202,31 +542,134 @@ func StartHTTPServer(rdb *redis.Client) {
 	router := mux.NewRouter()
 
 	// Create handlers that have access to the Redis client
-	initializeHandler := func(w http.ResponseWriter, r *http.Request) {
-		initializeCrawlHandler(w, r, rdb)
-	}
-	lookupHandler := func(w http.ResponseWriter, r *http.Request) {
-		lookupCrawlHandler(w, r, rdb)
+	withRedis := func(handler func(http.ResponseWriter, *http.Request, *redis.Client)) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			handler(w, r, rdb)
+		}
 	}
 
-	// Define routes
-	router.HandleFunc("/crawl", initializeHandler).Methods("POST")
-	router.HandleFunc("/crawl/{crawl_ID}", lookupHandler).Methods("GET")
-	// Explicit OPTIONS routes (useful for some proxies/CDNs)
-	router.HandleFunc("/crawl", func(w http.ResponseWriter, r *http.Request) {}).Methods("OPTIONS")
-	router.HandleFunc("/crawl/{crawl_ID}", func(w http.ResponseWriter, r *http.Request) {}).Methods("OPTIONS")
+	// The admin endpoints see every tenant's crawls and change the whole
+	// service, they only get a router of their own, served on -admin-addr
+	admin := mux.NewRouter()
+
+	// Define routes. The API is versioned so a breaking change can ship under a
+	// new prefix while clients of the current one keep working
+	api := router.PathPrefix("/" + currentAPIVersion).Subrouter()
+	api.HandleFunc("/crawl", withRedis(withIdempotency(initializeCrawlHandler))).Methods("POST")
+	api.HandleFunc("/crawl/{crawl_ID}", withRedis(lookupCrawlHandler)).Methods("GET")
+	api.HandleFunc("/crawl/{crawl_ID}/status", withRedis(crawlStatusHandler)).Methods("GET")
+	api.HandleFunc("/crawl/{crawl_ID}/search", withRedis(searchCrawlHandler)).Methods("GET")
+	api.HandleFunc("/crawl/{crawl_ID}/page", withRedis(crawlPageHandler)).Methods("GET")
+	api.HandleFunc("/crawl/{crawl_ID}/article", withRedis(crawlArticleHandler)).Methods("GET")
+	api.HandleFunc("/crawl/{crawl_ID}/screenshot", withRedis(crawlScreenshotHandler)).Methods("GET")
+	api.HandleFunc("/crawl/{crawl_ID}/results", withRedis(deleteResultsHandler)).Methods("DELETE")
+	api.HandleFunc("/crawl/{crawl_ID}/visited", withRedis(visitedURLsHandler)).Methods("GET")
+	api.HandleFunc("/crawl/{crawl_ID}/trackers", withRedis(crawlTrackersHandler)).Methods("GET")
+	api.HandleFunc("/crawl/{crawl_ID}/assets", withRedis(crawlAssetsHandler)).Methods("GET")
+	api.HandleFunc("/crawl/{crawl_ID}/timeline", withRedis(crawlTimelineHandler)).Methods("GET")
+	api.HandleFunc("/crawl/{crawl_ID}/stats", withRedis(crawlStatsHandler)).Methods("GET")
+	api.HandleFunc("/crawl/{crawl_ID}/domains", withRedis(crawlDomainsHandler)).Methods("GET")
+	api.HandleFunc("/crawl/{crawl_ID}/seo", withRedis(crawlSEOReportHandler)).Methods("GET")
+	api.HandleFunc("/crawl/{crawl_ID}/security-headers", withRedis(crawlSecurityHeadersHandler)).Methods("GET")
+	api.HandleFunc("/crawl/{crawl_ID}/analyze", withRedis(analyzeCrawlHandler)).Methods("POST")
+	api.HandleFunc("/crawl/{crawl_ID}/analysis", withRedis(crawlAnalysisHandler)).Methods("GET")
+	api.HandleFunc("/crawl/{crawl_ID}/path", withRedis(crawlPathHandler)).Methods("GET")
+	api.HandleFunc("/crawl/{crawl_ID}/structure", withRedis(crawlStructureHandler)).Methods("GET")
+	api.HandleFunc("/crawl/{crawl_ID}/rehydrate", withRedis(rehydrateCrawlHandler)).Methods("POST")
+	api.HandleFunc("/crawl/{crawl_ID}/resume", withRedis(resumeCrawlHandler)).Methods("POST")
+	api.HandleFunc("/crawl/{crawl_ID}/cancel", withRedis(cancelCrawlHandler)).Methods("POST")
+	api.HandleFunc("/crawl/{crawl_ID}/urls", withRedis(injectURLsHandler)).Methods("POST")
+	api.HandleFunc("/crawl/{crawl_ID}/annotations", withRedis(createAnnotationHandler)).Methods("POST")
+	api.HandleFunc("/crawl/{crawl_ID}/annotations", withRedis(listAnnotationsHandler)).Methods("GET")
+	api.HandleFunc("/crawl/{crawl_ID}/annotations/{annotation_ID}", withRedis(deleteAnnotationHandler)).Methods("DELETE")
+	api.HandleFunc("/crawl/{crawl_ID}/artifacts", withRedis(uploadArtifactHandler)).Methods("POST")
+	api.HandleFunc("/crawl/{crawl_ID}/artifacts", withRedis(listArtifactsHandler)).Methods("GET")
+	api.HandleFunc("/crawl/{crawl_ID}/artifacts/{artifact_ID}", withRedis(getArtifactHandler)).Methods("GET")
+	api.HandleFunc("/crawl/{crawl_ID}/artifacts/{artifact_ID}", withRedis(deleteArtifactHandler)).Methods("DELETE")
+	api.HandleFunc("/graphs/merge", withRedis(mergeGraphsHandler)).Methods("POST")
+	api.HandleFunc("/graphs/{graph_ID}/asof", withRedis(graphAsOfHandler)).Methods("GET")
+	api.HandleFunc("/queries", withRedis(createSavedQueryHandler)).Methods("POST")
+	api.HandleFunc("/queries", withRedis(listSavedQueriesHandler)).Methods("GET")
+	api.HandleFunc("/queries/{query_ID}", withRedis(deleteSavedQueryHandler)).Methods("DELETE")
+	api.HandleFunc("/queries/{query_ID}/report", withRedis(savedQueryReportHandler)).Methods("GET")
+	api.HandleFunc("/queries/{query_ID}/subscriptions", withRedis(subscribeSavedQueryHandler)).Methods("POST")
+	api.HandleFunc("/schedules", withRedis(createScheduleHandler)).Methods("POST")
+	api.HandleFunc("/schedules", withRedis(listSchedulesHandler)).Methods("GET")
+	api.HandleFunc("/schedules/{schedule_ID}", withRedis(deleteScheduleHandler)).Methods("DELETE")
+	api.HandleFunc("/schedules/{schedule_ID}/runs", withRedis(scheduleRunsHandler)).Methods("GET")
+	admin.HandleFunc("/admin/workers", withRedis(listWorkersHandler)).Methods("GET")
+	admin.HandleFunc("/admin/janitor", withRedis(janitorStatsHandler)).Methods("GET")
+	admin.HandleFunc("/admin/log-level", withRedis(getLogLevelHandler)).Methods("GET")
+	admin.HandleFunc("/admin/log-level", withRedis(setLogLevelHandler)).Methods("PUT")
+	admin.HandleFunc("/admin/policy", withRedis(getPolicyHandler)).Methods("GET")
+	admin.HandleFunc("/admin/policy/reload", withRedis(reloadPolicyHandler)).Methods("POST")
+	admin.HandleFunc("/admin/dead-letters", withRedis(listDeadLettersHandler)).Methods("GET")
+	admin.HandleFunc("/admin/dead-letters/{letter_ID}/requeue", withRedis(requeueDeadLetterHandler)).Methods("POST")
+	// Every self-test queues a crawl and waits for it, so it's not on the public API
+	admin.HandleFunc("/admin/selftest", withRedis(selfTestHandler)).Methods("POST")
+	admin.Handle("/metrics", promhttp.Handler()).Methods("GET")
+	router.HandleFunc("/healthz", withRedis(healthHandler)).Methods("GET")
+	router.HandleFunc("/version", withRedis(versionHandler)).Methods("GET")
+	router.HandleFunc("/readyz", withRedis(readinessHandler)).Methods("GET")
+	router.PathPrefix("/selftest/").HandlerFunc(selfTestSiteHandler).Methods("GET")
+	router.HandleFunc("/schema", func(w http.ResponseWriter, r *http.Request) {
+		schemaHandler(w, r, router)
+	}).Methods("GET")
+	router.HandleFunc("/sdk/{language}", func(w http.ResponseWriter, r *http.Request) {
+		sdkHandler(w, r, router)
+	}).Methods("GET")
+	router.HandleFunc("/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		openAPIHandler(w, r, router)
+	}).Methods("GET")
+	router.HandleFunc("/graphql", withRedis(graphQLHandler)).Methods("GET", "POST")
+	router.HandleFunc("/docs", swaggerUIHandler).Methods("GET")
+	routers := []*mux.Router{router}
+	if adminAddr != "" {
+		// Probes may be sent to either address
+		admin.HandleFunc("/healthz", withRedis(healthHandler)).Methods("GET")
+		admin.HandleFunc("/readyz", withRedis(readinessHandler)).Methods("GET")
+		admin.HandleFunc("/version", withRedis(versionHandler)).Methods("GET")
+		routers = append(routers, admin)
+	}
+	for _, served := range routers {
+		served.Use(tracingMiddleware)
+		served.Use(requestIDMiddleware)
+		served.Use(jwtMiddleware)
+		served.Use(metricsMiddleware)
+		// Explicit OPTIONS route for every path (useful for some proxies/CDNs)
+		served.Methods("OPTIONS").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	}
+	// IDs of other tenants' crawls are refused on the API, admin endpoints see them all
+	router.Use(namespaceMiddleware)
 
 	// Wrap with CORS middleware
 	cors := handlers.CORS(
 		handlers.AllowedOrigins(allowedOrigins),
-		handlers.AllowedMethods([]string{"GET", "POST", "OPTIONS"}),
-		handlers.AllowedHeaders([]string{"Content-Type", "Authorization"}),
+		handlers.AllowedMethods([]string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}),
+		handlers.AllowedHeaders([]string{"Content-Type", "Authorization", requestIDHeader, idempotencyHeader, apiVersionHeader}),
+		handlers.ExposedHeaders(append([]string{requestIDHeader, idempotentReplayHeader, apiVersionHeader, "Deprecation", "Link"}, quotaHeaders...)),
 		handlers.AllowCredentials(),
 	)
 
 	// Start HTTP server
-	fmt.Println("Starting HTTP server on :8080")
-	if err := http.ListenAndServe(":8080", cors(router)); err != nil {
-		fmt.Printf("HTTP server error: %v\n", err)
+	if adminAddr != "" {
+		go serveAdmin(cors(admin))
+	} else {
+		logger.Warn().Msg("No -admin-addr, serving no admin endpoints or metrics")
+	}
+	if grpcAddr != "" {
+		go serveGRPC(router)
+	}
+	if err := serveAPI(cors(negotiateAPIVersion(router))); err != nil {
+		withError(logger.Error(), err).Msg("HTTP server error")
+	}
+}
+
+// serveAdmin serves the admin endpoints on adminAddr. Always plaintext, it's
+// meant for a private network or localhost
+func serveAdmin(handler http.Handler) {
+	logger.Info().Str("addr", adminAddr).Msg("Starting admin HTTP server")
+	if err := http.ListenAndServe(adminAddr, handler); err != nil {
+		withError(logger.Error(), err).Msg("Admin HTTP server error")
 	}
 }
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// Urls a sitemap adds to a crawl at most, on top of the ones it finds itself
+	maxSitemapURLs = 1000
+	// Sitemap files read at most, following sitemap indexes
+	maxSitemapFiles = 20
+	// Largest sitemap file read, the protocol's own limit
+	maxSitemapBytes = 50 << 20
+)
+
+// sitemapDocument is a sitemap, which lists pages, or a sitemap index, which
+// lists other sitemaps. Both have the urls in <loc>
+type sitemapDocument struct {
+	XMLName  xml.Name
+	URLs     []string `xml:"url>loc"`
+	Sitemaps []string `xml:"sitemap>loc"`
+}
+
+// Helper function to fetch a file of the seed's site, unzipping gzipped sitemaps
+func fetchSitemapFile(fetchCtx context.Context, client *http.Client, fileURL string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(fetchCtx, http.MethodGet, fileURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s answered %d", fileURL, resp.StatusCode)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxSitemapBytes))
+	if err != nil {
+		return nil, err
+	}
+	// Gzip's magic number, whatever the file is called or served as
+	if bytes.HasPrefix(body, []byte{0x1f, 0x8b}) {
+		unzipped, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.ReadAll(io.LimitReader(unzipped, maxSitemapBytes))
+	}
+	return body, nil
+}
+
+// sitemapLocations lists the sitemaps robots.txt points to, or the usual
+// /sitemap.xml if it doesn't point to any
+func sitemapLocations(fetchCtx context.Context, client *http.Client, site *url.URL) []string {
+	locations := []string{}
+	robots, err := fetchSitemapFile(fetchCtx, client, site.ResolveReference(&url.URL{Path: "/robots.txt"}).String())
+	if err == nil {
+		scanner := bufio.NewScanner(bytes.NewReader(robots))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if len(line) > len("sitemap:") && strings.EqualFold(line[:len("sitemap:")], "sitemap:") {
+				if location, err := site.Parse(strings.TrimSpace(line[len("sitemap:"):])); err == nil {
+					locations = append(locations, location.String())
+				}
+			}
+		}
+	}
+	if len(locations) == 0 {
+		locations = append(locations, site.ResolveReference(&url.URL{Path: "/sitemap.xml"}).String())
+	}
+	return locations
+}
+
+// sitemapURLs reads the sitemaps of seedURL's site, following sitemap
+// indexes, and returns up to maxSitemapURLs of the pages on the seed's host
+// they list that keep is true for. Sitemaps that can't be read are skipped
+func sitemapURLs(fetchCtx context.Context, client *http.Client, uniqueID, seedURL string, keep func(string) bool) ([]string, error) {
+	site, err := url.Parse(seedURL)
+	if err != nil {
+		return nil, err
+	}
+	pending := sitemapLocations(fetchCtx, client, site)
+	seen := map[string]bool{seedURL: true}
+	urls := []string{}
+	for read := 0; len(pending) > 0 && read < maxSitemapFiles && len(urls) < maxSitemapURLs; read++ {
+		location := pending[0]
+		pending = pending[1:]
+		if seen[location] {
+			continue
+		}
+		seen[location] = true
+		body, err := fetchSitemapFile(fetchCtx, client, location)
+		if err != nil {
+			withError(crawlLogger(uniqueID).Info(), err).Str("sitemap", location).Msg("Skipping sitemap")
+			continue
+		}
+		var document sitemapDocument
+		if err := xml.Unmarshal(body, &document); err != nil {
+			withError(crawlLogger(uniqueID).Info(), err).Str("sitemap", location).Msg("Skipping malformed sitemap")
+			continue
+		}
+		for _, sitemap := range document.Sitemaps {
+			pending = append(pending, strings.TrimSpace(sitemap))
+		}
+		for _, page := range document.URLs {
+			page = strings.TrimSpace(page)
+			// Like the protocol, a sitemap only speaks for its own host
+			if seen[page] || urlHost(page) != urlHost(seedURL) || !keep(page) {
+				continue
+			}
+			seen[page] = true
+			urls = append(urls, page)
+			if len(urls) >= maxSitemapURLs {
+				break
+			}
+		}
+	}
+	return urls, nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+)
+
+// Prefixes the fields of fetchStatsKey counting fetch errors by class
+const fetchErrorStatsPrefix = "error|"
+
+type CrawlStatsResponse struct {
+	Done          bool  `json:"done"`
+	PagesFetched  int64 `json:"pagesFetched"`
+	UniqueDomains int   `json:"uniqueDomains"`
+	Edges         int64 `json:"edges"`
+	// Urls that couldn't be fetched by kind (dns, timeout, ...), and pages
+	// answering with an error by status class (4xx, 5xx)
+	Errors map[string]int64 `json:"errors"`
+	// Zero for merged graphs, whose fetches aren't timed
+	AverageFetchSeconds float64 `json:"averageFetchSeconds"`
+	// Levels below the starting url, 0 if only it was fetched
+	MaxDepthReached int `json:"maxDepthReached"`
+	// Links found on the pages of every level, the starting url's first
+	EdgesPerDepth []int64 `json:"edgesPerDepth"`
+	// Pages that answered 304 Not Modified to a crawl with httpCache
+	PagesUnchanged int64 `json:"pagesUnchanged"`
+	// Pages flagged by a crawl with nearDuplicates
+	PagesNearDuplicate int64 `json:"pagesNearDuplicate"`
+}
+
+// Helper function to build the redis key holding a crawl's fetch timings and error classes
+func fetchStatsKey(uniqueID string) string {
+	return fmt.Sprintf("go-crawler-fetch-stats-%s", uniqueID)
+}
+
+// recordFetchTime counts a fetch and how long it took towards its crawl's stats
+func recordFetchTime(rdb *redis.Client, uniqueID string, duration time.Duration) {
+	pipe := rdb.TxPipeline()
+	pipe.HIncrBy(ctx, fetchStatsKey(uniqueID), "fetches", 1)
+	pipe.HIncrBy(ctx, fetchStatsKey(uniqueID), "fetchNanos", duration.Nanoseconds())
+	expireLikeCheckpoint(pipe, fetchStatsKey(uniqueID))
+	if _, err := pipe.Exec(ctx); err != nil {
+		withError(crawlLogger(uniqueID).Error(), err).Msg("Failed to record fetch time")
+	}
+}
+
+// recordFetchErrorClass counts a url that couldn't be fetched towards its crawl's stats
+func recordFetchErrorClass(rdb *redis.Client, uniqueID string, fetchErr error) {
+	pipe := rdb.TxPipeline()
+	pipe.HIncrBy(ctx, fetchStatsKey(uniqueID), fetchErrorStatsPrefix+fetchErrorClass(fetchErr), 1)
+	expireLikeCheckpoint(pipe, fetchStatsKey(uniqueID))
+	if _, err := pipe.Exec(ctx); err != nil {
+		withError(crawlLogger(uniqueID).Error(), err).Msg("Failed to record fetch error class")
+	}
+}
+
+// Crawl stats handler - GET /crawl/{crawl_ID}/stats
+func crawlStatsHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
+	crawlID := mux.Vars(r)["crawl_ID"]
+
+	nodes, done, err := resultStore.Range(crawlID, 0, -1)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to get results")
+		return
+	}
+	ignored, err := loadIgnoreSet(rdb, crawlID)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to get annotations")
+		return
+	}
+	nodes = ignored.filter(nodes)
+	if len(nodes) == 0 {
+		exists, err := crawlExists(rdb, crawlID)
+		if err != nil {
+			sendErrorResponse(w, http.StatusInternalServerError, "Failed to look up crawl")
+			return
+		}
+		if !exists {
+			sendErrorResponse(w, http.StatusNotFound, "Crawl not found")
+			return
+		}
+	}
+	fields, err := rdb.HGetAll(ctx, fetchStatsKey(crawlID)).Result()
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to get fetch stats")
+		return
+	}
+
+	response := CrawlStatsResponse{Done: done, PagesFetched: int64(len(nodes)), Errors: map[string]int64{}, EdgesPerDepth: []int64{}}
+	for field, value := range fields {
+		if strings.HasPrefix(field, fetchErrorStatsPrefix) {
+			response.Errors[strings.TrimPrefix(field, fetchErrorStatsPrefix)], _ = strconv.ParseInt(value, 10, 64)
+		}
+	}
+	fetches, _ := strconv.ParseInt(fields["fetches"], 10, 64)
+	fetchNanos, _ := strconv.ParseInt(fields["fetchNanos"], 10, 64)
+	if fetches > 0 {
+		response.AverageFetchSeconds = time.Duration(fetchNanos / fetches).Seconds()
+	}
+
+	// Depth counts down from the starting url, which has the most left
+	seedDepth := 0
+	for _, node := range nodes {
+		if node.Depth > seedDepth {
+			seedDepth = node.Depth
+		}
+	}
+	domains := make(map[string]bool)
+	for _, node := range nodes {
+		if domain := urlHost(node.Parent); domain != "" {
+			domains[domain] = true
+		}
+		if node.Status >= 400 {
+			response.Errors[fmt.Sprintf("%dxx", node.Status/100)]++
+		}
+		if node.Unchanged {
+			response.PagesUnchanged++
+		}
+		if node.NearDuplicateOf != "" {
+			response.PagesNearDuplicate++
+		}
+		level := seedDepth - node.Depth
+		if level > response.MaxDepthReached {
+			response.MaxDepthReached = level
+		}
+		for len(response.EdgesPerDepth) <= level {
+			response.EdgesPerDepth = append(response.EdgesPerDepth, 0)
+		}
+		response.EdgesPerDepth[level] += int64(len(node.Children))
+		response.Edges += int64(len(node.Children))
+	}
+	response.UniqueDomains = len(domains)
+	sendJSONResponse(w, http.StatusOK, response)
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/semconv"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	crawlTypeFull   = "full"
+	crawlTypeStatus = "status"
+	// HEAD requests cost a site far less than page downloads, so status-only
+	// crawls may send this many times the requests, both per host and per crawl
+	statusRateMultiplier = 4
+)
+
+// Head reports the status of urlToFetch without downloading its body. Servers
+// that don't allow HEAD get a GET for a single byte, closed before it is read
+func (f realFetcher) Head(ctx context.Context, urlToFetch string) (int, error) {
+	spanCtx, span := tracer.Start(ctx, "head", trace.WithAttributes(crawlIDLabel.String(f.crawlID), semconv.HTTPURLKey.String(urlToFetch)))
+	status, err := f.head(spanCtx, urlToFetch)
+	if status != 0 {
+		span.SetAttributes(semconv.HTTPStatusCodeKey.Int(status))
+	}
+	endSpan(span, err)
+	return status, err
+}
+
+func (f realFetcher) head(ctx context.Context, urlToFetch string) (int, error) {
+	if !f.limits.inScope(urlToFetch) {
+		observeFetchError(crawlTypeStatus, errOutOfScope)
+		return 0, errOutOfScope
+	}
+	if currentPolicy().blocks(urlToFetch) {
+		observeFetchError(crawlTypeStatus, errBlockedDomain)
+		return 0, errBlockedDomain
+	}
+	if err := f.limits.takePage(f.rdb); err != nil {
+		observeFetchError(crawlTypeStatus, err)
+		return 0, err
+	}
+	f.waitForTurn(urlToFetch)
+	f.guard <- struct{}{}
+	defer func() {
+		<-f.guard
+	}()
+
+	start := time.Now()
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, urlToFetch, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		observeFetchError(crawlTypeStatus, err)
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed && resp.StatusCode != http.StatusNotImplemented {
+		f.observeHead(urlToFetch, resp, time.Since(start))
+		return resp.StatusCode, nil
+	}
+
+	req, err = http.NewRequestWithContext(ctx, http.MethodGet, urlToFetch, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Range", "bytes=0-0")
+	resp, err = f.client.Do(req)
+	if err != nil {
+		observeFetchError(crawlTypeStatus, err)
+		return 0, err
+	}
+	resp.Body.Close()
+	f.observeHead(urlToFetch, resp, time.Since(start))
+	return resp.StatusCode, nil
+}
+
+// observeHead records a status check that got an answer, in the metrics and the crawl's stats
+func (f realFetcher) observeHead(urlToFetch string, resp *http.Response, duration time.Duration) {
+	observeFetch(crawlTypeStatus, resp.StatusCode, duration)
+	if f.rdb != nil {
+		recordFetchTime(f.rdb, f.crawlID, duration)
+		if f.securityHeaders {
+			recordSecurityHeaders(f.rdb, f.crawlID, urlToFetch, resp)
+		}
+	}
+}
+
+// statusCrawlHelper checks the status of every url an earlier crawl found,
+// parents and children alike, publishing one node per url with the earlier
+// crawl's links so the results still form a map of the site
+func statusCrawlHelper(args helperOptions) error {
+	crawlLog := crawlLogger(args.uniqueID)
+	source, _, err := resultStore.Range(args.statusOf, 0, -1)
+	if err != nil {
+		return fmt.Errorf("could not load crawl %s: %v", args.statusOf, err)
+	}
+	if len(source) == 0 {
+		return fmt.Errorf("crawl %s has no results to check", args.statusOf)
+	}
+	urls := []string{}
+	children := make(map[string][]string)
+	depths := make(map[string]int)
+	for _, node := range source {
+		if _, ok := depths[node.Parent]; !ok {
+			urls = append(urls, node.Parent)
+		}
+		children[node.Parent] = node.Children
+		depths[node.Parent] = node.Depth
+		for _, child := range node.Children {
+			if _, ok := depths[child]; !ok {
+				urls = append(urls, child)
+				depths[child] = node.Depth - 1
+			}
+		}
+	}
+
+	// A resumed crawl doesn't check the urls it already published again
+	checked, _, err := resultStore.Range(args.uniqueID, 0, -1)
+	if err != nil {
+		return err
+	}
+	session := &crawlSession{
+		startTime:       time.Now(),
+		lastProgress:    time.Now(),
+		statusOf:        args.statusOf,
+		securityHeaders: args.securityHeaders,
+		userAgents:      args.userAgents,
+		headers:         args.headers,
+		cookieJar:       args.cookieJar,
+		cookies:         args.cookies,
+		credentials:     args.credentials,
+		proxy:           args.proxy,
+		proxyPool:       args.proxyPool,
+		resultsTTL:      args.resultsTTL,
+		limits:          args.limits,
+		urlMap:          &SafeMap{v: make(map[string]bool)},
+		frontier:        &frontier{pending: make(map[frontierItem]int)},
+	}
+	defer trackFrontier(session.frontier)()
+	for _, node := range checked {
+		session.urlMap.flip(node.Parent)
+	}
+	pending := make([]string, 0, len(urls))
+	for _, url := range urls {
+		if !session.urlMap.flip(url) {
+			pending = append(pending, url)
+			session.frontier.add(url, depths[url])
+		}
+	}
+
+	limiter := args.limiter
+	if limiter != nil {
+		limiter = &hostRateLimiter{rdb: limiter.rdb, rate: limiter.rate * statusRateMultiplier, burst: limiter.burst * statusRateMultiplier, class: crawlTypeStatus}
+	}
+	fetcher := realFetcher{client: args.client, guard: make(chan struct{}, args.concurrency*statusRateMultiplier), limiter: limiter, crawlID: args.uniqueID, securityHeaders: args.securityHeaders, limits: args.limits, rdb: args.rdb, rateLimited: new(int64)}
+	if args.limits.Rate > 0 {
+		fetcher.crawlLimiter = newCrawlRateLimiter(args.rdb, args.uniqueID, args.limits.Rate)
+	}
+	defer recordTimeline(args.rdb, args.uniqueID, fetcher, session.frontier)()
+
+	checkpoint := func(first bool) error {
+		err := saveCheckpoint(args.rdb, args.uniqueID, args.url, args.depth, session, first)
+		if err != nil && err != errCrawlAbandoned && err != errCrawlCanceled {
+			withError(crawlLog.Error(), err).Msg("Failed to checkpoint crawl")
+			return nil
+		}
+		return err
+	}
+	checkpoint(true)
+
+	resultsCh := make(chan graphNode)
+	// Closed when we return early, so no check is left waiting to send its result
+	stop := make(chan struct{})
+	defer close(stop)
+	// The first check to panic fails the crawl
+	panicked := make(chan error, 1)
+	go func() {
+		var wg sync.WaitGroup
+		// Very large sites have a lot of urls, only start a check once there's a fetch slot for it
+		slots := make(chan struct{}, cap(fetcher.guard))
+		for _, url := range pending {
+			select {
+			case slots <- struct{}{}:
+			case <-stop:
+				return
+			}
+			wg.Add(1)
+			go func(url string) {
+				var panicErr error
+				defer func() {
+					<-slots
+					wg.Done()
+				}()
+				defer func() {
+					if panicErr != nil {
+						select {
+						case panicked <- panicErr:
+						default:
+						}
+					}
+				}()
+				defer recoverCrawlPanic(&panicErr)
+				node := graphNode{Parent: url, Children: children[url], Depth: depths[url]}
+				status, err := fetcher.Head(args.spanCtx, url)
+				node.Status = status
+				if err != nil {
+					node.Error = err.Error()
+					recordFetchErrorClass(args.rdb, args.uniqueID, err)
+				}
+				node.TimeFound = time.Since(session.startTime)
+				select {
+				case resultsCh <- node:
+				case <-stop:
+				}
+			}(url)
+		}
+		wg.Wait()
+		close(resultsCh)
+	}()
+
+	ticker := time.NewTicker(checkpointPeriodInSeconds * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case node, ok := <-resultsCh:
+			if !ok {
+				if err := resultStore.MarkDone(args.uniqueID); err != nil {
+					return err
+				}
+				if args.sink != nil {
+					if err := args.sink.finish(args.uniqueID); err != nil {
+						withError(crawlLog.Error(), err).Msg("Failed to publish end of crawl")
+					}
+				}
+				if err := saveVisitedURLs(args.rdb, args.uniqueID, session.urlMap); err != nil {
+					withError(crawlLog.Error(), err).Msg("Failed to save visited urls of crawl")
+				}
+				expireCrawlData(args.rdb, args.uniqueID, args.resultsTTL)
+				args.rdb.Del(ctx, checkpointKey(args.uniqueID))
+				crawlLog.Info().Int("urls", len(urls)).Str("status_of", args.statusOf).Msg("Done checking the status of urls")
+				return nil
+			}
+			atomic.AddInt64(&pagesFetched, 1)
+			session.lastProgress = time.Now()
+			session.frontier.remove(node.Parent, node.Depth)
+			if err := resultStore.Append(args.uniqueID, node); err != nil {
+				withError(crawlLog.Error(), err).Str("url", node.Parent).Int("depth", node.Depth).Msg("Failed to store status")
+			}
+			if args.sink != nil {
+				if err := args.sink.publish(args.uniqueID, node); err != nil {
+					withError(crawlLog.Error(), err).Str("url", node.Parent).Int("depth", node.Depth).Msg("Failed to publish status")
+				}
+			}
+			crawlLog.Debug().Str("url", node.Parent).Int("depth", node.Depth).Int("status", node.Status).Msg("Checked status")
+		case err := <-panicked:
+			return err
+		case <-ticker.C:
+			if err := checkpoint(false); err != nil {
+				return err
+			}
+		}
+	}
+}
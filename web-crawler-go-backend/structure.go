@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// Most linked to urls reported by default, and at most
+	defaultStructureTop = 20
+	maxStructureTop     = 1000
+)
+
+type (
+	GraphStructureResponse struct {
+		// Fetched or only linked to
+		URLs  int `json:"urls"`
+		Links int `json:"links"`
+		// Urls by how many links lead to them and how many they have, fewest first
+		InDegrees  []DegreeCount `json:"inDegrees"`
+		OutDegrees []DegreeCount `json:"outDegrees"`
+		// Groups of urls linked to each other in either direction
+		Components       int `json:"components"`
+		LargestComponent int `json:"largestComponent"`
+		// Most links in first
+		MostLinkedTo []LinkedURL `json:"mostLinkedTo"`
+	}
+	DegreeCount struct {
+		Degree int `json:"degree"`
+		URLs   int `json:"urls"`
+	}
+	LinkedURL struct {
+		URL     string `json:"url"`
+		LinksIn int    `json:"linksIn"`
+	}
+)
+
+// components sorts the urls into weakly connected components, returning the size of each
+func (graph *linkGraph) components() []int {
+	parent := make([]int, len(graph.urls))
+	for i := range parent {
+		parent[i] = i
+	}
+	var root func(int) int
+	root = func(i int) int {
+		if parent[i] != i {
+			parent[i] = root(parent[i])
+		}
+		return parent[i]
+	}
+	for from, links := range graph.out {
+		for _, to := range links {
+			parent[root(from)] = root(to)
+		}
+	}
+	sizes := make(map[int]int)
+	for i := range parent {
+		sizes[root(i)]++
+	}
+	components := make([]int, 0, len(sizes))
+	for _, size := range sizes {
+		components = append(components, size)
+	}
+	return components
+}
+
+// Helper function to count the urls of every degree, fewest first
+func degreeDistribution(links [][]int) []DegreeCount {
+	counts := make(map[int]int)
+	for _, urlLinks := range links {
+		counts[len(urlLinks)]++
+	}
+	distribution := make([]DegreeCount, 0, len(counts))
+	for degree, urls := range counts {
+		distribution = append(distribution, DegreeCount{Degree: degree, URLs: urls})
+	}
+	sort.Slice(distribution, func(i, j int) bool {
+		return distribution[i].Degree < distribution[j].Degree
+	})
+	return distribution
+}
+
+// Crawl structure handler - GET /crawl/{crawl_ID}/structure
+func crawlStructureHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
+	crawlID := mux.Vars(r)["crawl_ID"]
+	top := defaultStructureTop
+	if rawTop := r.URL.Query().Get("top"); rawTop != "" {
+		var err error
+		top, err = strconv.Atoi(rawTop)
+		if err != nil || top < 1 || top > maxStructureTop {
+			sendErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("top must be between 1 and %d", maxStructureTop))
+			return
+		}
+	}
+
+	nodes, done, err := resultStore.Range(crawlID, 0, -1)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to get results")
+		return
+	}
+	ignored, err := loadIgnoreSet(rdb, crawlID)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to get annotations")
+		return
+	}
+	nodes = ignored.filter(nodes)
+	if !done {
+		exists, err := crawlExists(rdb, crawlID)
+		if err != nil {
+			sendErrorResponse(w, http.StatusInternalServerError, "Failed to look up crawl")
+			return
+		}
+		if !exists {
+			sendErrorResponse(w, http.StatusNotFound, "Crawl not found")
+			return
+		}
+		sendErrorResponse(w, http.StatusConflict, "Crawl is still running")
+		return
+	}
+
+	graph := newLinkGraph(nodes)
+	response := GraphStructureResponse{
+		URLs:         len(graph.urls),
+		InDegrees:    degreeDistribution(graph.in),
+		OutDegrees:   degreeDistribution(graph.out),
+		MostLinkedTo: []LinkedURL{},
+	}
+	for _, links := range graph.out {
+		response.Links += len(links)
+	}
+	components := graph.components()
+	response.Components = len(components)
+	for _, size := range components {
+		if size > response.LargestComponent {
+			response.LargestComponent = size
+		}
+	}
+
+	for i, links := range graph.in {
+		if len(links) > 0 {
+			response.MostLinkedTo = append(response.MostLinkedTo, LinkedURL{URL: graph.urls[i], LinksIn: len(links)})
+		}
+	}
+	sort.Slice(response.MostLinkedTo, func(i, j int) bool {
+		a, b := response.MostLinkedTo[i], response.MostLinkedTo[j]
+		if a.LinksIn != b.LinksIn {
+			return a.LinksIn > b.LinksIn
+		}
+		return a.URL < b.URL
+	})
+	if len(response.MostLinkedTo) > top {
+		response.MostLinkedTo = response.MostLinkedTo[:top]
+	}
+	sendJSONResponse(w, http.StatusOK, response)
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// How often a running crawl's concurrency is sampled
+	timelineInterval = time.Second
+	// Samples are written to redis in batches of this many
+	timelineFlushSamples = 10
+	// Samples kept per crawl, six hours of them. Longer crawls keep their latest ones
+	maxTimelineSamples = 6 * 60 * 60
+	// Longest bucket the timeline can be averaged into for plotting
+	maxTimelineStep = 3600
+)
+
+type (
+	TimelineResponse struct {
+		// Time of the first sample, T counts seconds from here. Unset until there is one
+		Start *time.Time `json:"start,omitempty"`
+		// Seconds each sample covers, the step asked for
+		Step    int              `json:"step"`
+		Samples []TimelineSample `json:"samples"`
+	}
+	// TimelineSample is the state of a crawl at one moment, or its average over a step
+	TimelineSample struct {
+		T int64 `json:"t"`
+		// Requests in flight, at most FetchSlots of them
+		ActiveFetches float64 `json:"activeFetches"`
+		FetchSlots    int     `json:"fetchSlots"`
+		// Urls scheduled but not yet fetched
+		Queued float64 `json:"queued"`
+		// Fetches held back by the crawl's or a host's rate limit
+		RateLimited float64 `json:"rateLimited"`
+	}
+)
+
+// Helper function to build the redis key holding a crawl's concurrency samples
+func timelineKey(uniqueID string) string {
+	return fmt.Sprintf("go-crawler-timeline-%s", uniqueID)
+}
+
+// waitForTurn blocks until the crawl's and the host's rate limits allow a
+// request to urlToFetch, counting the fetch as rate limited meanwhile
+func (f realFetcher) waitForTurn(urlToFetch string) {
+	if f.crawlLimiter == nil && f.limiter == nil {
+		return
+	}
+	if f.rateLimited != nil {
+		atomic.AddInt64(f.rateLimited, 1)
+		defer atomic.AddInt64(f.rateLimited, -1)
+	}
+	if f.crawlLimiter != nil {
+		f.crawlLimiter.wait()
+	}
+	if f.limiter != nil {
+		f.limiter.wait(urlToFetch)
+	}
+}
+
+// recordTimeline samples a crawl's fetches and frontier every timelineInterval
+// until the returned function is called. fetcher needs a rateLimited counter
+func recordTimeline(rdb *redis.Client, uniqueID string, fetcher realFetcher, f *frontier) func() {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(timelineInterval)
+		defer ticker.Stop()
+		samples := []interface{}{}
+		flush := func() {
+			if len(samples) == 0 {
+				return
+			}
+			pipe := rdb.TxPipeline()
+			pipe.RPush(ctx, timelineKey(uniqueID), samples...)
+			pipe.LTrim(ctx, timelineKey(uniqueID), -maxTimelineSamples, -1)
+			expireLikeCheckpoint(pipe, timelineKey(uniqueID))
+			if _, err := pipe.Exec(ctx); err != nil {
+				withError(crawlLogger(uniqueID).Error(), err).Msg("Failed to record timeline of crawl")
+			}
+			samples = samples[:0]
+		}
+		for {
+			select {
+			case now := <-ticker.C:
+				// unix seconds,active fetches,fetch slots,queued,rate limited
+				samples = append(samples, fmt.Sprintf("%d,%d,%d,%d,%d", now.Unix(), len(fetcher.guard), cap(fetcher.guard), f.size(), atomic.LoadInt64(fetcher.rateLimited)))
+				if len(samples) >= timelineFlushSamples {
+					flush()
+				}
+			case <-stop:
+				flush()
+				return
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+// Helper function to parse a sample as recorded by recordTimeline
+func parseTimelineSample(raw string) (int64, TimelineSample, bool) {
+	fields := strings.Split(raw, ",")
+	if len(fields) != 5 {
+		return 0, TimelineSample{}, false
+	}
+	values := make([]int64, len(fields))
+	for i, field := range fields {
+		value, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			return 0, TimelineSample{}, false
+		}
+		values[i] = value
+	}
+	return values[0], TimelineSample{ActiveFetches: float64(values[1]), FetchSlots: int(values[2]), Queued: float64(values[3]), RateLimited: float64(values[4])}, true
+}
+
+// Crawl timeline handler - GET /crawl/{crawl_ID}/timeline
+func crawlTimelineHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
+	crawlID := mux.Vars(r)["crawl_ID"]
+	step := 1
+	if rawStep := r.URL.Query().Get("step"); rawStep != "" {
+		var err error
+		step, err = strconv.Atoi(rawStep)
+		if err != nil || step < 1 || step > maxTimelineStep {
+			sendErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("step must be between 1 and %d seconds", maxTimelineStep))
+			return
+		}
+	}
+
+	raw, err := rdb.LRange(ctx, timelineKey(crawlID), 0, -1).Result()
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to get timeline")
+		return
+	}
+	if len(raw) == 0 {
+		// Not sampled yet, unless there is no such crawl at all
+		exists, err := crawlExists(rdb, crawlID)
+		if err != nil {
+			sendErrorResponse(w, http.StatusInternalServerError, "Failed to look up crawl")
+			return
+		}
+		if !exists {
+			sendErrorResponse(w, http.StatusNotFound, "Crawl not found")
+			return
+		}
+	}
+
+	response := TimelineResponse{Step: step, Samples: []TimelineSample{}}
+	var start int64
+	// Samples falling into the same step are averaged
+	var bucket *TimelineSample
+	count := 0
+	closeBucket := func() {
+		if bucket == nil {
+			return
+		}
+		bucket.ActiveFetches = math.Round(10*bucket.ActiveFetches/float64(count)) / 10
+		bucket.Queued = math.Round(10*bucket.Queued/float64(count)) / 10
+		bucket.RateLimited = math.Round(10*bucket.RateLimited/float64(count)) / 10
+		response.Samples = append(response.Samples, *bucket)
+	}
+	for _, rawSample := range raw {
+		at, sample, ok := parseTimelineSample(rawSample)
+		if !ok {
+			continue
+		}
+		if bucket == nil {
+			start = at
+			startTime := time.Unix(start, 0).UTC()
+			response.Start = &startTime
+		}
+		t := (at - start) / int64(step) * int64(step)
+		if bucket == nil || t != bucket.T {
+			closeBucket()
+			bucket = &TimelineSample{T: t}
+			count = 0
+		}
+		bucket.ActiveFetches += sample.ActiveFetches
+		bucket.Queued += sample.Queued
+		bucket.RateLimited += sample.RateLimited
+		if sample.FetchSlots > bucket.FetchSlots {
+			bucket.FetchSlots = sample.FetchSlots
+		}
+		count++
+	}
+	closeBucket()
+	sendJSONResponse(w, http.StatusOK, response)
+}
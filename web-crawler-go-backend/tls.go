@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net"
+	"net/http"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Helper function to tell whether the API is served over HTTPS
+func tlsEnabled() bool {
+	return tlsCertFile != "" || len(autocertDomains) > 0
+}
+
+// Helper function to get the scheme links back to the API are built with
+func apiScheme() string {
+	if tlsEnabled() {
+		return "https"
+	}
+	return "http"
+}
+
+// serveAPI serves handler on serverAddr, over TLS if it's configured, along
+// with the plaintext redirect if -http-redirect-addr is set
+func serveAPI(handler http.Handler) error {
+	server := &http.Server{Addr: serverAddr, Handler: handler}
+	if !tlsEnabled() {
+		logger.Info().Str("addr", serverAddr).Msg("Starting HTTP server")
+		return server.ListenAndServe()
+	}
+
+	var redirect http.Handler = http.HandlerFunc(redirectToHTTPS)
+	if len(autocertDomains) > 0 {
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(autocertDomains...),
+			Cache:      autocert.DirCache(autocertCacheDir),
+			Email:      autocertEmail,
+		}
+		// Certificates are fetched on the first handshake for a domain, and renewed before they expire
+		server.TLSConfig = manager.TLSConfig()
+		// HTTP-01 challenges are answered, everything else redirected
+		redirect = manager.HTTPHandler(redirect)
+	}
+	if httpRedirectAddr != "" {
+		go func() {
+			logger.Info().Str("addr", httpRedirectAddr).Msg("Starting HTTP to HTTPS redirect")
+			if err := http.ListenAndServe(httpRedirectAddr, redirect); err != nil {
+				withError(logger.Error(), err).Msg("HTTP redirect server error")
+			}
+		}()
+	}
+	logger.Info().Str("addr", serverAddr).Strs("autocert_domains", autocertDomains).Msg("Starting HTTPS server")
+	// Both empty with autocert, the certificates come from TLSConfig
+	return server.ListenAndServeTLS(tlsCertFile, tlsKeyFile)
+}
+
+// redirectToHTTPS sends plaintext requests to the same url on the API's HTTPS address.
+// 308 so API clients repeat POSTs and DELETEs there instead of turning them into GETs
+func redirectToHTTPS(w http.ResponseWriter, r *http.Request) {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+	if _, port, _ := net.SplitHostPort(serverAddr); port != "443" {
+		host = net.JoinHostPort(host, port)
+	}
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/semconv"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/credentials"
+)
+
+const (
+	// Header the traceparent travels in, both in HTTP and in traceCarrier
+	traceParentHeader = "traceparent"
+	// Name spans are reported under, in every process
+	tracingServiceName = "bishops-web-crawler"
+)
+
+// Every crawl's spans carry its ID under this attribute, to find them all at once
+var crawlIDLabel = label.Key("crawl.id")
+
+// Spans go nowhere until setupTracing installs an exporter
+var tracer = otel.Tracer(tracingServiceName)
+
+// traceCarrier holds the propagated trace context while it's copied in or out of a command
+type traceCarrier map[string]string
+
+func (carrier traceCarrier) Get(key string) string {
+	return carrier[key]
+}
+
+func (carrier traceCarrier) Set(key, value string) {
+	carrier[key] = value
+}
+
+// setupTracing exports spans to an OTLP collector over gRPC, in batches every few seconds
+func setupTracing(endpoint string, insecure bool, mode string) error {
+	options := []otlp.ExporterOption{otlp.WithAddress(endpoint)}
+	if insecure {
+		options = append(options, otlp.WithInsecure())
+	} else {
+		options = append(options, otlp.WithTLSCredentials(credentials.NewTLS(&tls.Config{})))
+	}
+	exporter, err := otlp.NewExporter(ctx, options...)
+	if err != nil {
+		return err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.ServiceNameKey.String(tracingServiceName),
+			semconv.ServiceInstanceIDKey.String(consumerName()),
+			label.String("crawler.mode", mode),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return nil
+}
+
+// traceParent returns the W3C traceparent that makes a queued crawl part of
+// ctx's trace, or "" if ctx isn't being traced
+func traceParent(traceCtx context.Context) string {
+	if !trace.SpanFromContext(traceCtx).SpanContext().IsValid() {
+		return ""
+	}
+	carrier := traceCarrier{}
+	otel.GetTextMapPropagator().Inject(traceCtx, carrier)
+	return carrier[traceParentHeader]
+}
+
+// startCrawlSpan starts the span a worker runs a crawl under, continuing the
+// trace of traceParent if the command carried one
+func startCrawlSpan(traceParent, uniqueID, url string) (context.Context, trace.Span) {
+	parent := otel.GetTextMapPropagator().Extract(context.Background(), traceCarrier{traceParentHeader: traceParent})
+	return tracer.Start(parent, "crawl", trace.WithAttributes(crawlIDLabel.String(uniqueID), label.String("crawl.url", url)))
+}
+
+// Helper function to end a span, marking it failed if err is set
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// tracingMiddleware traces every API request, continuing the caller's trace
+// if it sent a traceparent header
+func tracingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Named after the route's template, so every crawl's requests share a name
+		name := r.Method
+		attributes := []label.KeyValue{semconv.HTTPMethodKey.String(r.Method)}
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				name += " " + template
+				attributes = append(attributes, semconv.HTTPRouteKey.String(template))
+			}
+		}
+		parent := otel.GetTextMapPropagator().Extract(r.Context(), r.Header)
+		spanCtx, span := tracer.Start(parent, name, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(attributes...))
+		defer span.End()
+		if crawlID := mux.Vars(r)["crawl_ID"]; crawlID != "" {
+			span.SetAttributes(crawlIDLabel.String(crawlID))
+		}
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(spanCtx))
+		span.SetAttributes(semconv.HTTPStatusCodeKey.Int(recorder.status))
+		if recorder.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, fmt.Sprintf("status %d", recorder.status))
+		}
+	})
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+	"golang.org/x/net/html"
+)
+
+const (
+	// Script urls looked at per page, pages with more are rarely worth the time
+	maxScriptsScraped = 200
+	// Separates the domain, party and tracker name of the fields in trackersKey
+	trackerFieldSeparator = "|"
+	trackerFirstParty     = "first"
+	trackerThirdParty     = "third"
+)
+
+type (
+	// TrackerSignature recognizes the scripts of one analytics or tracking
+	// product. A script matches if its host is one of Hosts or a subdomain of
+	// one, or if its path ends with one of Paths, which catches self-hosted copies
+	TrackerSignature struct {
+		Name     string   `json:"name"`
+		Category string   `json:"category"`
+		Hosts    []string `json:"hosts,omitempty"`
+		Paths    []string `json:"paths,omitempty"`
+	}
+	TrackerInventoryResponse struct {
+		Domains []DomainTrackers `json:"domains"`
+	}
+	// DomainTrackers lists the trackers found on the pages of one domain
+	DomainTrackers struct {
+		Domain   string       `json:"domain"`
+		Trackers []TrackerUse `json:"trackers"`
+	}
+	TrackerUse struct {
+		Name     string `json:"name"`
+		Category string `json:"category,omitempty"`
+		// Whether the script was served from the page's own domain
+		FirstParty bool `json:"firstParty"`
+		// Pages of the domain that load the tracker
+		Pages int64 `json:"pages"`
+	}
+)
+
+// Replaced by -tracker-signatures
+var trackerSignatures = []TrackerSignature{
+	{Name: "Google Analytics", Category: "analytics", Hosts: []string{"google-analytics.com"}, Paths: []string{"/analytics.js", "/ga.js", "/gtag/js"}},
+	// gtag.js is served from googletagmanager.com too, but it loads Google Analytics
+	{Name: "Google Tag Manager", Category: "tag-manager", Paths: []string{"/gtm.js"}},
+	{Name: "DoubleClick", Category: "advertising", Hosts: []string{"doubleclick.net", "googlesyndication.com", "googleadservices.com"}},
+	{Name: "Facebook Pixel", Category: "advertising", Hosts: []string{"connect.facebook.net"}, Paths: []string{"/fbevents.js"}},
+	{Name: "LinkedIn Insight", Category: "advertising", Hosts: []string{"snap.licdn.com"}},
+	{Name: "Twitter Ads", Category: "advertising", Hosts: []string{"static.ads-twitter.com"}},
+	{Name: "TikTok Pixel", Category: "advertising", Hosts: []string{"analytics.tiktok.com"}},
+	{Name: "Microsoft Advertising", Category: "advertising", Hosts: []string{"bat.bing.com"}},
+	{Name: "Adobe Analytics", Category: "analytics", Hosts: []string{"omtrdc.net", "2o7.net", "demdex.net"}, Paths: []string{"/AppMeasurement.js"}},
+	{Name: "Matomo", Category: "analytics", Paths: []string{"/matomo.js", "/piwik.js"}},
+	{Name: "Plausible", Category: "analytics", Hosts: []string{"plausible.io"}, Paths: []string{"/plausible.js"}},
+	{Name: "Segment", Category: "analytics", Hosts: []string{"cdn.segment.com"}, Paths: []string{"/analytics.min.js"}},
+	{Name: "Mixpanel", Category: "analytics", Hosts: []string{"mixpanel.com", "mxpnl.com"}},
+	{Name: "Amplitude", Category: "analytics", Hosts: []string{"cdn.amplitude.com"}},
+	{Name: "Heap", Category: "analytics", Hosts: []string{"heapanalytics.com"}},
+	{Name: "Hotjar", Category: "session-replay", Hosts: []string{"hotjar.com"}},
+	{Name: "Microsoft Clarity", Category: "session-replay", Hosts: []string{"clarity.ms"}},
+	{Name: "FullStory", Category: "session-replay", Hosts: []string{"fullstory.com"}, Paths: []string{"/fs.js"}},
+	{Name: "New Relic", Category: "monitoring", Hosts: []string{"js-agent.newrelic.com", "nr-data.net"}},
+}
+
+// loadTrackerSignatures reads a JSON array of signatures, replacing the built in list
+func loadTrackerSignatures(path string) ([]TrackerSignature, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var signatures []TrackerSignature
+	if err := json.Unmarshal(raw, &signatures); err != nil {
+		return nil, err
+	}
+	for _, signature := range signatures {
+		if signature.Name == "" || strings.Contains(signature.Name, trackerFieldSeparator) {
+			return nil, errors.New("every tracker needs a name, without " + trackerFieldSeparator)
+		}
+		if len(signature.Hosts) == 0 && len(signature.Paths) == 0 {
+			return nil, fmt.Errorf("tracker %s needs hosts or paths to match", signature.Name)
+		}
+	}
+	return signatures, nil
+}
+
+// Helper function to build the redis key holding a crawl's tracker inventory
+func trackersKey(uniqueID string) string {
+	return fmt.Sprintf("go-crawler-trackers-%s", uniqueID)
+}
+
+// scrapeScripts returns the absolute urls of up to maxScriptsScraped external scripts in body
+func scrapeScripts(urlToFetch string, body []byte) []string {
+	base, err := url.Parse(urlToFetch)
+	if err != nil {
+		return nil
+	}
+	results := []string{}
+	z := html.NewTokenizer(bytes.NewReader(body))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return results
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tn, hasAttrs := z.TagName()
+			if string(tn) != "script" {
+				continue
+			}
+			for hasAttrs {
+				var key, val []byte
+				key, val, hasAttrs = z.TagAttr()
+				if string(key) != "src" {
+					continue
+				}
+				src, err := base.Parse(strings.TrimSpace(string(val)))
+				if err == nil && (src.Scheme == "http" || src.Scheme == "https") {
+					results = append(results, src.String())
+					if len(results) >= maxScriptsScraped {
+						return results
+					}
+				}
+				break
+			}
+		}
+	}
+}
+
+// matches reports whether a script url belongs to the signature's product
+func (signature TrackerSignature) matches(script *url.URL) bool {
+	host := strings.ToLower(script.Hostname())
+	for _, signatureHost := range signature.Hosts {
+		signatureHost = strings.ToLower(signatureHost)
+		if host == signatureHost || strings.HasSuffix(host, "."+signatureHost) {
+			return true
+		}
+	}
+	for _, path := range signature.Paths {
+		if strings.HasSuffix(script.Path, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// recordTrackers adds the trackers a page loads to its crawl's inventory.
+// A tracker is counted once per page, however many of its scripts it loads
+func recordTrackers(rdb *redis.Client, uniqueID, pageURL string, page []byte) {
+	parsedURL, err := url.Parse(pageURL)
+	if err != nil {
+		return
+	}
+	// Reported by host, but scripts anywhere on the same domain count as first party
+	host := strings.ToLower(parsedURL.Hostname())
+	domain, _ := getDomainFromURL(pageURL)
+	found := make(map[string]bool)
+	for _, rawScript := range scrapeScripts(pageURL, page) {
+		script, err := url.Parse(rawScript)
+		if err != nil {
+			continue
+		}
+		for _, signature := range trackerSignatures {
+			if !signature.matches(script) {
+				continue
+			}
+			party := trackerThirdParty
+			if scriptDomain, err := getDomainFromURL(rawScript); err == nil && domain != "" && scriptDomain == domain {
+				party = trackerFirstParty
+			}
+			found[strings.Join([]string{host, party, signature.Name}, trackerFieldSeparator)] = true
+		}
+	}
+	if len(found) == 0 {
+		return
+	}
+	pipe := rdb.TxPipeline()
+	for field := range found {
+		pipe.HIncrBy(ctx, trackersKey(uniqueID), field, 1)
+	}
+	expireLikeCheckpoint(pipe, trackersKey(uniqueID))
+	if _, err := pipe.Exec(ctx); err != nil {
+		withError(crawlLogger(uniqueID).Error(), err).Str("url", pageURL).Msg("Failed to record trackers")
+	}
+}
+
+// Crawl trackers handler - GET /crawl/{crawl_ID}/trackers
+func crawlTrackersHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
+	crawlID := mux.Vars(r)["crawl_ID"]
+	fields, err := rdb.HGetAll(ctx, trackersKey(crawlID)).Result()
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to get trackers")
+		return
+	}
+	if len(fields) == 0 {
+		// No trackers found yet, unless there is no such crawl at all
+		exists, err := crawlExists(rdb, crawlID)
+		if err != nil {
+			sendErrorResponse(w, http.StatusInternalServerError, "Failed to look up crawl")
+			return
+		}
+		if !exists {
+			sendErrorResponse(w, http.StatusNotFound, "Crawl not found")
+			return
+		}
+	}
+
+	categories := make(map[string]string)
+	for _, signature := range trackerSignatures {
+		categories[signature.Name] = signature.Category
+	}
+	byDomain := make(map[string]*DomainTrackers)
+	for field, count := range fields {
+		parts := strings.SplitN(field, trackerFieldSeparator, 3)
+		if len(parts) != 3 {
+			continue
+		}
+		pages, _ := strconv.ParseInt(count, 10, 64)
+		domain := byDomain[parts[0]]
+		if domain == nil {
+			domain = &DomainTrackers{Domain: parts[0]}
+			byDomain[parts[0]] = domain
+		}
+		domain.Trackers = append(domain.Trackers, TrackerUse{Name: parts[2], Category: categories[parts[2]], FirstParty: parts[1] == trackerFirstParty, Pages: pages})
+	}
+
+	response := TrackerInventoryResponse{Domains: []DomainTrackers{}}
+	for _, domain := range byDomain {
+		// Most widespread trackers first
+		sort.Slice(domain.Trackers, func(i, j int) bool {
+			if domain.Trackers[i].Pages != domain.Trackers[j].Pages {
+				return domain.Trackers[i].Pages > domain.Trackers[j].Pages
+			}
+			return domain.Trackers[i].Name < domain.Trackers[j].Name
+		})
+		response.Domains = append(response.Domains, *domain)
+	}
+	sort.Slice(response.Domains, func(i, j int) bool {
+		return response.Domains[i].Domain < response.Domains[j].Domain
+	})
+	sendJSONResponse(w, http.StatusOK, response)
+}
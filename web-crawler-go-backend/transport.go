@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+const (
+	// Idle connections kept to each host, so a crawl's next pages on a site reuse them
+	idleConnsPerHost = 16
+	// An HTTP/2 connection that has been quiet this long is pinged, and closed
+	// if the ping isn't answered within dialTimeout
+	http2ReadIdleTimeout = 30 * time.Second
+)
+
+// Set from flags before the transport is built
+var (
+	// Whether page fetches negotiate HTTP/2 with the servers that offer it
+	fetchHTTP2 = true
+	// Connections a worker opens to each host at most, 0 for no limit
+	maxConnsPerHost int
+)
+
+// newFetchTransport builds the transport crawls fetch pages with. A custom
+// dialer turns off Go's HTTP/2 support unless it's asked for, so it's set up
+// here: the streams a server allows on a connection are never exceeded by
+// opening more connections, and quiet connections are health checked
+func newFetchTransport() (*http.Transport, error) {
+	tr := &http.Transport{
+		DialContext: dialLocalhostAware(dialResolving((&net.Dialer{
+			Timeout:   dialTimeout,
+			KeepAlive: dialTimeout,
+			DualStack: true,
+		}).DialContext)),
+		Proxy:               crawlProxy,
+		IdleConnTimeout:     dialTimeout,
+		TLSHandshakeTimeout: dialTimeout,
+		MaxIdleConnsPerHost: idleConnsPerHost,
+		MaxConnsPerHost:     maxConnsPerHost,
+	}
+	if !fetchHTTP2 {
+		return tr, nil
+	}
+	h2, err := http2.ConfigureTransports(tr)
+	if err != nil {
+		return nil, err
+	}
+	h2.StrictMaxConcurrentStreams = true
+	h2.ReadIdleTimeout = http2ReadIdleTimeout
+	h2.PingTimeout = dialTimeout
+	return tr, nil
+}
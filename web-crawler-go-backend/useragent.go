@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	// Longest User-Agent a crawl may ask for, and most a rotation may list
+	maxUserAgentLength = 256
+	maxUserAgents      = 50
+)
+
+// Set from -user-agent and -user-agent-contact, sent by crawls that don't ask for their own
+var (
+	defaultUserAgent = "bishops-web-crawler"
+	userAgentContact string
+)
+
+type (
+	// userAgentRotation hands out a crawl's User-Agents in turn, one per request
+	userAgentRotation struct {
+		agents []string
+		next   uint64
+	}
+	userAgentKey struct{}
+)
+
+// Helper function to build the crawler's own User-Agent, naming where to
+// find out about it when a contact is set
+func crawlerUserAgent() string {
+	if userAgentContact == "" {
+		return defaultUserAgent
+	}
+	return fmt.Sprintf("%s (+%s)", defaultUserAgent, userAgentContact)
+}
+
+// Helper function to check the User-Agents a crawl asks for
+func validateUserAgents(userAgents []string) error {
+	if len(userAgents) > maxUserAgents {
+		return fmt.Errorf("userAgents may list at most %d User-Agents", maxUserAgents)
+	}
+	for _, userAgent := range userAgents {
+		if strings.TrimSpace(userAgent) == "" || len(userAgent) > maxUserAgentLength || strings.ContainsAny(userAgent, "\r\n") {
+			return fmt.Errorf("User-Agents must be one line of at most %d characters", maxUserAgentLength)
+		}
+	}
+	return nil
+}
+
+// withUserAgents returns a context whose requests are sent with the crawl's
+// User-Agents in turn, or the crawler's own if it has none
+func withUserAgents(crawlCtx context.Context, userAgents []string) context.Context {
+	if len(userAgents) == 0 {
+		return crawlCtx
+	}
+	return context.WithValue(crawlCtx, userAgentKey{}, &userAgentRotation{agents: userAgents})
+}
+
+func (rotation *userAgentRotation) pick() string {
+	next := atomic.AddUint64(&rotation.next, 1) - 1
+	return rotation.agents[next%uint64(len(rotation.agents))]
+}
+
+// Helper function to pick the User-Agent of the next request of the crawl of
+// crawlCtx, the crawler's own outside of crawls with User-Agents
+func requestUserAgent(crawlCtx context.Context) string {
+	if rotation, ok := crawlCtx.Value(userAgentKey{}).(*userAgentRotation); ok {
+		return rotation.pick()
+	}
+	return crawlerUserAgent()
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net/http"
+	"runtime"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Set at build time with
+// go build -ldflags "-X main.gitSHA=$(git rev-parse HEAD) -X main.buildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	gitSHA    = "unknown"
+	buildTime = "unknown"
+)
+
+type VersionResponse struct {
+	GitSHA    string `json:"gitSHA"`
+	BuildTime string `json:"buildTime"`
+	GoVersion string `json:"goVersion"`
+}
+
+// Helper function to describe the running build
+func currentVersion() VersionResponse {
+	return VersionResponse{GitSHA: gitSHA, BuildTime: buildTime, GoVersion: runtime.Version()}
+}
+
+// Version handler - GET /version
+func versionHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
+	sendJSONResponse(w, http.StatusOK, currentVersion())
+}
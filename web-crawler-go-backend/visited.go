@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+)
+
+type (
+	// visitedSet remembers which urls a crawl has already claimed
+	visitedSet interface {
+		// flip marks name as visited, reporting whether it already was
+		flip(name string) bool
+		keys() []string
+		// reset replaces the whole set, used when restoring a checkpoint
+		reset(names []string)
+	}
+	// redisVisitedSet keeps the visited set in Redis, so several workers
+	// cooperating on the same crawl never fetch a page twice
+	redisVisitedSet struct {
+		rdb *redis.Client
+		key string
+	}
+)
+
+const (
+	// Urls written to or read from Redis at a time when saving or exporting a visited set
+	visitedBatchSize = 1000
+)
+
+// Set by -shared-visited, keeps every crawl's visited set in Redis instead of in memory
+var sharedVisited bool
+
+// Helper function to build the redis key holding a crawl's visited set
+func visitedKey(uniqueID string) string {
+	return fmt.Sprintf("go-crawler-visited-%s", uniqueID)
+}
+
+// Helper function to create the visited set for a crawl
+func newVisitedSet(rdb *redis.Client, uniqueID string) visitedSet {
+	if sharedVisited {
+		return &redisVisitedSet{rdb: rdb, key: visitedKey(uniqueID)}
+	}
+	return &SafeMap{v: make(map[string]bool)}
+}
+
+// loadVisitedURLs collects every page a crawl fetched, from its shared visited
+// set if it had one and from its results, which also covers merged graphs
+func loadVisitedURLs(rdb *redis.Client, uniqueID string) ([]string, error) {
+	visited, err := rdb.SMembers(ctx, visitedKey(uniqueID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	nodes, _, err := resultStore.Range(uniqueID, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	for _, node := range nodes {
+		visited = append(visited, node.Parent)
+	}
+	return visited, nil
+}
+
+// saveVisitedURLs copies a visited set kept in memory to Redis once the crawl
+// is done, so it can still be exported or excluded like a shared one
+func saveVisitedURLs(rdb *redis.Client, uniqueID string, set visitedSet) error {
+	if _, shared := set.(*redisVisitedSet); shared {
+		return nil
+	}
+	urls := set.keys()
+	for start := 0; start < len(urls); start += visitedBatchSize {
+		end := start + visitedBatchSize
+		if end > len(urls) {
+			end = len(urls)
+		}
+		members := make([]interface{}, 0, end-start)
+		for _, url := range urls[start:end] {
+			members = append(members, url)
+		}
+		if err := rdb.SAdd(ctx, visitedKey(uniqueID), members...).Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Visited urls handler - GET /crawl/{crawl_ID}/visited
+func visitedURLsHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
+	crawlID := mux.Vars(r)["crawl_ID"]
+	saved, err := rdb.Exists(ctx, visitedKey(crawlID)).Result()
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to get visited urls")
+		return
+	}
+	if saved == 0 {
+		// A running crawl that keeps its visited set in memory, as of its last checkpoint
+		checkpoint, err := loadCheckpoint(rdb, crawlID)
+		if err == redis.Nil {
+			sendErrorResponse(w, http.StatusNotFound, "Crawl not found")
+			return
+		}
+		if err != nil {
+			sendErrorResponse(w, http.StatusInternalServerError, "Failed to get checkpoint")
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		for _, url := range checkpoint.Visited {
+			fmt.Fprintln(w, url)
+		}
+		return
+	}
+
+	// Sets can be huge, send them a batch at a time instead of building the whole response
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	cursor := uint64(0)
+	for {
+		urls, next, err := rdb.SScan(ctx, visitedKey(crawlID), cursor, "", visitedBatchSize).Result()
+		if err != nil {
+			// Too late for an error status, the client sees the response cut short
+			withError(crawlLogger(crawlID).Error(), err).Msg("Failed to export visited urls of crawl")
+			return
+		}
+		for _, url := range urls {
+			fmt.Fprintln(w, url)
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if next == 0 {
+			return
+		}
+		cursor = next
+	}
+}
+
+func (safeMap *SafeMap) keys() []string {
+	safeMap.Lock()
+	defer safeMap.Unlock()
+	keys := make([]string, 0, len(safeMap.v))
+	for key := range safeMap.v {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (safeMap *SafeMap) reset(names []string) {
+	safeMap.Lock()
+	defer safeMap.Unlock()
+	safeMap.v = make(map[string]bool, len(names))
+	for _, name := range names {
+		safeMap.v[name] = true
+	}
+}
+
+func (set *redisVisitedSet) flip(name string) bool {
+	// SADD is atomic, exactly one caller gets to add each url
+	pipe := set.rdb.Pipeline()
+	added := pipe.SAdd(ctx, set.key, name)
+	expireLikeCheckpoint(pipe, set.key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		// Fetching a page twice beats silently dropping part of the crawl
+		withError(logger.Error(), err).Str("key", set.key).Str("url", name).Msg("Failed to check visited set")
+		return false
+	}
+	return added.Val() == 0
+}
+
+func (set *redisVisitedSet) keys() []string {
+	return set.rdb.SMembers(ctx, set.key).Val()
+}
+
+func (set *redisVisitedSet) reset(names []string) {
+	pipe := set.rdb.TxPipeline()
+	pipe.Del(ctx, set.key)
+	if len(names) > 0 {
+		members := make([]interface{}, len(names))
+		for i, name := range names {
+			members[i] = name
+		}
+		pipe.SAdd(ctx, set.key, members...)
+		expireLikeCheckpoint(pipe, set.key)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		withError(logger.Error(), err).Str("key", set.key).Msg("Failed to reset visited set")
+	}
+}
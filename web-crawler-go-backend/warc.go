@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const (
+	// Each crawl's archive is uploaded in files of about this size, compressed.
+	// They are written to the crawl's workspace until then
+	warcSegmentBytes = 16 << 20
+	// Records waiting to be compressed, fetches block once this many are queued
+	warcQueueSize = 64
+	warcVersion   = "WARC/1.1"
+)
+
+type (
+	// warcArchiver writes the raw responses of every crawl to S3 (or MinIO) as gzipped WARC files
+	warcArchiver struct {
+		client *s3.Client
+		bucket string
+		prefix string
+	}
+	// warcWriter archives one crawl. Fetches queue records, and a goroutine
+	// of its own compresses them and uploads each file once it fills up
+	warcWriter struct {
+		sync.RWMutex
+		archiver  *warcArchiver
+		crawlID   string
+		workspace *crawlWorkspace
+		records   chan warcRecord
+		closed    bool
+		done      chan struct{}
+	}
+	// warcSegment is a file of the archive being written in the workspace
+	warcSegment struct {
+		name    string
+		file    *os.File
+		size    int64
+		records int
+	}
+	warcRecord struct {
+		url  string
+		date time.Time
+		// The response as it came in: status line, headers and body
+		block         []byte
+		payloadDigest string
+		truncated     bool
+	}
+)
+
+func newWARCArchiver(awsConfig aws.Config, endpoint, bucket, prefix string) *warcArchiver {
+	client := s3.NewFromConfig(awsConfig, func(o *s3.Options) {
+		// MinIO and other S3 compatible stores
+		if endpoint != "" {
+			o.EndpointResolver = s3.EndpointResolverFromURL(endpoint)
+			o.UsePathStyle = true
+		}
+	})
+	return &warcArchiver{client: client, bucket: bucket, prefix: prefix}
+}
+
+// Set up in main when -warc-bucket is given
+var pageArchive *warcArchiver
+
+// deleteCrawl removes every file of a crawl's archive
+func (archiver *warcArchiver) deleteCrawl(crawlID string) error {
+	prefix := fmt.Sprintf("%s/%s/", archiver.prefix, crawlID)
+	paginator := s3.NewListObjectsV2Paginator(archiver.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(archiver.bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		for _, object := range page.Contents {
+			_, err := archiver.client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: aws.String(archiver.bucket), Key: object.Key})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// open starts archiving a crawl into workspace, close the writer once the crawl is done
+func (archiver *warcArchiver) open(crawlID string, workspace *crawlWorkspace) *warcWriter {
+	writer := &warcWriter{
+		archiver:  archiver,
+		crawlID:   crawlID,
+		workspace: workspace,
+		records:   make(chan warcRecord, warcQueueSize),
+		done:      make(chan struct{}),
+	}
+	go writer.run()
+	return writer
+}
+
+// capture queues a response for the archive. Bodies the fetcher cut short are marked as truncated
+func (writer *warcWriter) capture(urlToFetch string, resp *http.Response, body []byte, truncated bool) {
+	var block bytes.Buffer
+	fmt.Fprintf(&block, "%s %s\r\n", resp.Proto, resp.Status)
+	resp.Header.Write(&block)
+	block.WriteString("\r\n")
+	block.Write(body)
+	digest := sha1.Sum(body)
+	record := warcRecord{
+		url:           urlToFetch,
+		date:          time.Now(),
+		block:         block.Bytes(),
+		payloadDigest: "sha1:" + base32.StdEncoding.EncodeToString(digest[:]),
+		truncated:     truncated,
+	}
+
+	writer.RLock()
+	defer writer.RUnlock()
+	// A crawl that was given up on can leave fetches running after its writer closed
+	if !writer.closed {
+		writer.records <- record
+	}
+}
+
+// close uploads whatever is still buffered, waiting until it's done
+func (writer *warcWriter) close() {
+	writer.Lock()
+	writer.closed = true
+	close(writer.records)
+	writer.Unlock()
+	<-writer.done
+}
+
+func (writer *warcWriter) run() {
+	defer close(writer.done)
+	var segment *warcSegment
+	for record := range writer.records {
+		headers := [][2]string{
+			{"WARC-Type", "response"},
+			{"WARC-Record-ID", newWARCRecordID()},
+			{"WARC-Date", record.date.UTC().Format(time.RFC3339)},
+			{"WARC-Target-URI", record.url},
+			{"Content-Type", "application/http; msgtype=response"},
+			{"WARC-Payload-Digest", record.payloadDigest},
+		}
+		if record.truncated {
+			headers = append(headers, [2]string{"WARC-Truncated", "length"})
+		}
+		var compressed bytes.Buffer
+		writeWARCRecord(&compressed, headers, record.block)
+
+		var err error
+		if segment == nil {
+			segment, err = writer.startSegment()
+		}
+		if err == nil {
+			err = writer.append(segment, compressed.Bytes())
+		}
+		if err == errWorkspaceFull && segment != nil && segment.records > 0 {
+			// Upload what we have early to make room, and carry on in a new file
+			writer.finishSegment(segment)
+			segment, err = writer.startSegment()
+			if err == nil {
+				err = writer.append(segment, compressed.Bytes())
+			}
+		}
+		if err != nil {
+			withError(crawlLogger(writer.crawlID).Error(), err).Str("url", record.url).Msg("Dropped WARC record")
+			continue
+		}
+		segment.records++
+		if segment.size >= warcSegmentBytes {
+			writer.finishSegment(segment)
+			segment = nil
+		}
+	}
+	if segment != nil {
+		writer.finishSegment(segment)
+	}
+}
+
+// Helper function to start a new file of the archive, with its warcinfo record
+func (writer *warcWriter) startSegment() (*warcSegment, error) {
+	name := fmt.Sprintf("%s-%d.warc.gz", writer.crawlID, time.Now().UnixNano())
+	file, err := writer.workspace.create(name + "-*")
+	if err != nil {
+		return nil, err
+	}
+	segment := &warcSegment{name: name, file: file}
+	var info bytes.Buffer
+	writeWARCRecord(&info, warcInfoHeaders(name), []byte("software: bishops-web-crawler\r\nformat: WARC File Format 1.1\r\n"))
+	if err := writer.append(segment, info.Bytes()); err != nil {
+		writer.removeSegment(segment)
+		return nil, err
+	}
+	return segment, nil
+}
+
+// Helper function to write to a file of the archive, within the workspace's quota
+func (writer *warcWriter) append(segment *warcSegment, data []byte) error {
+	if err := writer.workspace.reserve(int64(len(data))); err != nil {
+		return err
+	}
+	n, err := segment.file.Write(data)
+	segment.size += int64(n)
+	// Only what made it to disk takes up space
+	writer.workspace.release(int64(len(data) - n))
+	return err
+}
+
+// Helper function to upload a file of the archive, unless no record made it in, then remove it
+func (writer *warcWriter) finishSegment(segment *warcSegment) {
+	defer writer.removeSegment(segment)
+	if segment.records == 0 {
+		return
+	}
+	if _, err := segment.file.Seek(0, io.SeekStart); err != nil {
+		withError(crawlLogger(writer.crawlID).Error(), err).Str("file", segment.name).Msg("Failed to read WARC file")
+		return
+	}
+	writer.upload(segment.name, segment.file, segment.size)
+}
+
+// Helper function to delete a file of the archive and give its space back
+func (writer *warcWriter) removeSegment(segment *warcSegment) {
+	segment.file.Close()
+	os.Remove(segment.file.Name())
+	writer.workspace.release(segment.size)
+}
+
+// Helper function to upload one file of a crawl's archive, under <prefix>/<crawl id>/
+func (writer *warcWriter) upload(name string, contents io.Reader, size int64) {
+	key := fmt.Sprintf("%s/%s/%s", writer.archiver.prefix, writer.crawlID, name)
+	_, err := writer.archiver.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(writer.archiver.bucket),
+		Key:           aws.String(key),
+		Body:          contents,
+		ContentLength: size,
+		ContentType:   aws.String("application/warc"),
+	})
+	if err != nil {
+		withError(crawlLogger(writer.crawlID).Error(), err).Str("key", key).Msg("Failed to upload WARC file")
+		return
+	}
+	crawlLogger(writer.crawlID).Info().Int64("bytes", size).Str("key", key).Msg("Archived crawl to WARC")
+}
+
+// Helper function to build the headers of the warcinfo record every file starts with
+func warcInfoHeaders(name string) [][2]string {
+	return [][2]string{
+		{"WARC-Type", "warcinfo"},
+		{"WARC-Record-ID", newWARCRecordID()},
+		{"WARC-Date", time.Now().UTC().Format(time.RFC3339)},
+		{"WARC-Filename", name},
+		{"Content-Type", "application/warc-fields"},
+	}
+}
+
+// writeWARCRecord appends a record as a gzip member of its own, so tools can seek to any record
+func writeWARCRecord(w io.Writer, headers [][2]string, block []byte) {
+	gz := gzip.NewWriter(w)
+	fmt.Fprintf(gz, "%s\r\n", warcVersion)
+	for _, header := range headers {
+		fmt.Fprintf(gz, "%s: %s\r\n", header[0], header[1])
+	}
+	fmt.Fprintf(gz, "Content-Length: %d\r\n\r\n", len(block))
+	gz.Write(block)
+	gz.Write([]byte("\r\n\r\n"))
+	gz.Close()
+}
+
+// Helper function to make a random (version 4) UUID record ID
+func newWARCRecordID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("<urn:uuid:%x-%x-%x-%x-%x>", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
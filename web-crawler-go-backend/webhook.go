@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"golang.org/x/net/http/httpproxy"
+)
+
+// Fields of the crawl's meta hash with where to say it finished, and the API host and scheme it was started through
+const (
+	callbackURLField = "callbackURL"
+	apiHostField     = "apiHost"
+	apiSchemeField   = "apiScheme"
+)
+
+const (
+	// Header carrying "t=<unix time>,v1=<hex HMAC-SHA256 of "<unix time>.<body>">"
+	webhookSignatureHeader = "X-Crawler-Signature"
+	// Deliveries tried before giving up, waiting twice as long after every failure
+	webhookAttempts     = 5
+	webhookFirstBackoff = 2 * time.Second
+	// State of a crawl stopped by an error, only reported to callbacks
+	crawlStateFailed = "failed"
+)
+
+// Secret completion webhooks are signed with, from -webhook-secret-file. The
+// API only takes a callbackURL when it's set
+var webhookSecret string
+
+// Set from -allow-private-deliveries
+var allowPrivateDeliveries bool
+
+// The addresses of 100.64.0.0/10, shared by carrier-grade NATs, which net.IP doesn't call private
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// deliveryClient POSTs webhooks, notifications and reports to the urls
+// callers give, refusing to connect to addresses that aren't public unless
+// -allow-private-deliveries is set
+var deliveryClient = &http.Client{
+	Timeout: reportDeliveryTimeout,
+	Transport: &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		DialContext:         dialPublicOnly,
+		TLSHandshakeTimeout: reportDeliveryTimeout,
+	},
+}
+
+// CrawlWebhook is POSTed to a crawl's callbackURL once it's over
+type CrawlWebhook struct {
+	CrawlID string `json:"crawlID"`
+	URL     string `json:"url"`
+	// done, failed or canceled
+	State        string `json:"state"`
+	Error        string `json:"error,omitempty"`
+	PagesFetched int64  `json:"pagesFetched"`
+	FetchErrors  int64  `json:"fetchErrors"`
+	// Unset if the API host the crawl was started through isn't known
+	ResultsURL string    `json:"resultsURL,omitempty"`
+	FinishedAt time.Time `json:"finishedAt"`
+}
+
+// Helper function to check a callbackURL is somewhere a webhook can be POSTed
+func validateCallbackURL(rawURL string) error {
+	callbackURL, err := url.Parse(rawURL)
+	if err != nil || (callbackURL.Scheme != "http" && callbackURL.Scheme != "https") || callbackURL.Host == "" {
+		return fmt.Errorf("callbackURL must be an http(s) url")
+	}
+	return nil
+}
+
+// dialPublicOnly connects to addr once it's looked up, unless it's a loopback,
+// private, link-local or otherwise non-public address. The proxy of
+// HTTPS_PROXY and HTTP_PROXY is chosen by the operator, it may be anywhere
+func dialPublicOnly(dialCtx context.Context, network, addr string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	host, _, _ := net.SplitHostPort(addr)
+	if !allowPrivateDeliveries && !isEnvironmentProxy(host) {
+		dialer.Control = refuseNonPublicAddress
+	}
+	return dialer.DialContext(dialCtx, network, addr)
+}
+
+// Helper function to stop a dial to an address that isn't public
+func refuseNonPublicAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	ip := net.ParseIP(host)
+	if err != nil || ip == nil || !publicIP(ip) {
+		return fmt.Errorf("%s isn't a public address, nothing is delivered there", host)
+	}
+	return nil
+}
+
+// Helper function to tell whether an ip is reachable on the internet
+func publicIP(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() && !ip.IsMulticast() && !ip.IsUnspecified() && !sharedAddressSpace.Contains(ip)
+}
+
+// Helper function to tell whether host is the proxy HTTPS_PROXY or HTTP_PROXY name
+func isEnvironmentProxy(host string) bool {
+	environment := httpproxy.FromEnvironment()
+	for _, rawProxy := range []string{environment.HTTPSProxy, environment.HTTPProxy} {
+		if proxy, err := url.Parse(rawProxy); err == nil && rawProxy != "" && strings.EqualFold(proxy.Hostname(), host) {
+			return true
+		}
+	}
+	return false
+}
+
+// Helper function to sign a webhook body as sent at sentAt
+func signWebhook(body []byte, sentAt time.Time) string {
+	timestamp := strconv.FormatInt(sentAt.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(webhookSecret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return fmt.Sprintf("t=%s,v1=%s", timestamp, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// notifyCrawlOver POSTs a summary of the crawl to its callbackURL, if it was
+// started with one, retrying in the background, and sends its notifications.
+// crawlErr is what the crawl returned, nil if it finished
+func notifyCrawlOver(rdb *redis.Client, uniqueID, seedURL string, crawlErr error) {
+	meta, err := rdb.HGetAll(ctx, crawlMetaKey(uniqueID)).Result()
+	if err != nil {
+		withError(crawlLogger(uniqueID).Error(), err).Msg("Failed to look up crawl callback")
+		return
+	}
+	settings := crawlNotifySettings(meta)
+	if meta[callbackURLField] == "" && !settings.wants(notifyOnDone) && !settings.wants(notifyOnFailed) {
+		return
+	}
+
+	summary := CrawlWebhook{CrawlID: uniqueID, URL: seedURL, State: crawlStateDone, FinishedAt: time.Now()}
+	switch {
+	case crawlErr == errCrawlCanceled:
+		summary.State = crawlStateCanceled
+	case crawlErr != nil:
+		summary.State = crawlStateFailed
+		summary.Error = crawlErr.Error()
+	}
+	if nodes, _, err := resultStore.Range(uniqueID, 0, -1); err == nil {
+		summary.PagesFetched = int64(len(nodes))
+	}
+	fetchErrors, _ := rdb.HGet(ctx, crawlErrorsKey(uniqueID), "fetchErrors").Result()
+	summary.FetchErrors, _ = strconv.ParseInt(fetchErrors, 10, 64)
+	if meta[apiHostField] != "" {
+		// Crawls queued before the scheme was recorded were started over plain http
+		scheme := meta[apiSchemeField]
+		if scheme == "" {
+			scheme = "http"
+		}
+		summary.ResultsURL = buildResultsLink(scheme, meta[apiHostField], uniqueID, 0)
+	}
+	if meta[callbackURLField] != "" {
+		go deliverWebhook(uniqueID, meta[callbackURLField], summary)
+	}
+	notifyCrawlSummary(settings, summary)
+}
+
+// deliverWebhook tries to deliver a summary webhookAttempts times. Answers
+// other than 2xx, 429 and 5xx mean the receiver doesn't want it, and stop the retries
+func deliverWebhook(uniqueID, callbackURL string, summary CrawlWebhook) {
+	body, _ := json.Marshal(summary)
+	backoff := webhookFirstBackoff
+	for attempt := 1; ; attempt++ {
+		req, err := http.NewRequest(http.MethodPost, callbackURL, bytes.NewReader(body))
+		if err != nil {
+			withError(crawlLogger(uniqueID).Error(), err).Str("url", callbackURL).Msg("Failed to build webhook")
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(webhookSignatureHeader, signWebhook(body, time.Now()))
+		resp, err := deliveryClient.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode < 300 {
+				crawlLogger(uniqueID).Info().Str("url", callbackURL).Str("state", summary.State).Int("attempt", attempt).Msg("Delivered webhook")
+				return
+			}
+			err = fmt.Errorf("unexpected status %s", resp.Status)
+			if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+				withError(crawlLogger(uniqueID).Warn(), err).Str("url", callbackURL).Msg("Webhook refused")
+				return
+			}
+		}
+		if attempt == webhookAttempts {
+			withError(crawlLogger(uniqueID).Error(), err).Str("url", callbackURL).Int("attempts", attempt).Msg("Gave up delivering webhook")
+			return
+		}
+		withError(crawlLogger(uniqueID).Warn(), err).Str("url", callbackURL).Int("attempt", attempt).Msg("Failed to deliver webhook, retrying")
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
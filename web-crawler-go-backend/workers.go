@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// Set of every worker that has registered, stale members are pruned on read
+	workersKey               = "go-crawler-workers"
+	workerHeartbeatInSeconds = 5
+	// A worker that misses this many heartbeats in a row is considered gone
+	workerMissedHeartbeats = 3
+)
+
+type WorkerInfo struct {
+	Name           string    `json:"name"`
+	Hostname       string    `json:"hostname"`
+	PID            int       `json:"pid"`
+	QueueMode      string    `json:"queueMode"`
+	StartedAt      time.Time `json:"startedAt"`
+	LastHeartbeat  time.Time `json:"lastHeartbeat"`
+	ActiveCrawls   int64     `json:"activeCrawls"`
+	PagesFetched   int64     `json:"pagesFetched"`
+	PagesPerSecond float64   `json:"pagesPerSecond"`
+	// Build the worker runs, to tell which ones a deployment has reached
+	GitSHA string `json:"gitSHA"`
+}
+
+type WorkersResponse struct {
+	Workers []WorkerInfo `json:"workers"`
+	// Jobs waiting for a worker, if this keeps growing nobody is picking them up
+	QueuedJobs int64 `json:"queuedJobs"`
+	// Role (e.g. scheduler) -> name of the worker process leading it
+	Leaders map[string]string `json:"leaders"`
+}
+
+// Counters for this process, updated by the job loop and crawlHelper
+var (
+	activeCrawls int64
+	pagesFetched int64
+)
+
+// Helper function to build the redis key holding a worker's heartbeat
+func workerKey(name string) string {
+	return fmt.Sprintf("go-crawler-worker-%s", name)
+}
+
+// Helper function to name this worker, in the consumer group and in the fleet
+func consumerName() string {
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
+}
+
+// runHeartbeat registers this worker and keeps its record fresh until the process exits
+func runHeartbeat(rdb *redis.Client) {
+	hostname, _ := os.Hostname()
+	info := WorkerInfo{
+		Name:      consumerName(),
+		Hostname:  hostname,
+		PID:       os.Getpid(),
+		QueueMode: jobQueueMode,
+		StartedAt: time.Now(),
+		GitSHA:    gitSHA,
+	}
+	rdb.SAdd(ctx, workersKey, info.Name)
+
+	ticker := time.NewTicker(workerHeartbeatInSeconds * time.Second)
+	defer ticker.Stop()
+	lastPages, lastBeat := int64(0), time.Now()
+	for {
+		pages := atomic.LoadInt64(&pagesFetched)
+		info.LastHeartbeat = time.Now()
+		info.ActiveCrawls = atomic.LoadInt64(&activeCrawls)
+		info.PagesFetched = pages
+		info.PagesPerSecond = float64(pages-lastPages) / info.LastHeartbeat.Sub(lastBeat).Seconds()
+		lastPages, lastBeat = pages, info.LastHeartbeat
+
+		marshalled, _ := json.Marshal(info)
+		ttl := workerMissedHeartbeats * workerHeartbeatInSeconds * time.Second
+		if err := rdb.Set(ctx, workerKey(info.Name), marshalled, ttl).Err(); err != nil {
+			withError(logger.Error(), err).Msg("Failed to send heartbeat")
+		}
+		// Re-add in case we were pruned while Redis was unreachable
+		rdb.SAdd(ctx, workersKey, info.Name)
+		<-ticker.C
+	}
+}
+
+func loadWorkers(rdb *redis.Client) ([]WorkerInfo, error) {
+	names, err := rdb.SMembers(ctx, workersKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	workers := make([]WorkerInfo, 0, len(names))
+	for _, name := range names {
+		raw, err := rdb.Get(ctx, workerKey(name)).Bytes()
+		if err == redis.Nil {
+			// Heartbeat expired, the worker is gone
+			rdb.SRem(ctx, workersKey, name)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var worker WorkerInfo
+		if err := json.Unmarshal(raw, &worker); err != nil {
+			continue
+		}
+		workers = append(workers, worker)
+	}
+	sort.Slice(workers, func(i, j int) bool {
+		return workers[i].Name < workers[j].Name
+	})
+	return workers, nil
+}
+
+// List workers handler - GET /admin/workers
+func listWorkersHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
+	workers, err := loadWorkers(rdb)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to get workers")
+		return
+	}
+	queuedJobs, err := jobs.length()
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to get queue length")
+		return
+	}
+	leaders, err := loadLeaders(rdb)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to get leaders")
+		return
+	}
+	sendJSONResponse(w, http.StatusOK, WorkersResponse{Workers: workers, QueuedJobs: queuedJobs, Leaders: leaders})
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// Left behind by workers that died, swept this often
+	workspaceSweepPeriod = time.Minute
+)
+
+var errWorkspaceFull = errors.New("workspace quota exceeded")
+
+type (
+	// workspaceManager hands out a scratch directory to every crawl that needs
+	// disk, under <root>/<worker name>/<crawl id>. Each crawl may use up to
+	// crawlQuota bytes, and all of this worker's crawls together up to quota
+	workspaceManager struct {
+		sync.Mutex
+		root       string
+		quota      int64
+		crawlQuota int64
+		used       int64
+	}
+	crawlWorkspace struct {
+		manager *workspaceManager
+		dir     string
+		// Guarded by the manager's lock
+		used   int64
+		closed bool
+	}
+)
+
+func newWorkspaceManager(root string, quota, crawlQuota int64) *workspaceManager {
+	return &workspaceManager{root: root, quota: quota, crawlQuota: crawlQuota}
+}
+
+// Helper function to name the directory holding this worker's workspaces
+func (manager *workspaceManager) workerDir() string {
+	return filepath.Join(manager.root, consumerName())
+}
+
+// open creates a crawl's workspace, close it once the crawl stops for any reason
+func (manager *workspaceManager) open(crawlID string) (*crawlWorkspace, error) {
+	dir := filepath.Join(manager.workerDir(), crawlID)
+	// Whatever a previous attempt at the crawl in this process left is stale
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &crawlWorkspace{manager: manager, dir: dir}, nil
+}
+
+// create opens a new file in the workspace. Space for what is written to it must be reserved first
+func (workspace *crawlWorkspace) create(pattern string) (*os.File, error) {
+	return ioutil.TempFile(workspace.dir, pattern)
+}
+
+// reserve accounts for n more bytes on disk, or returns errWorkspaceFull if
+// that would go over the crawl's quota or the worker's
+func (workspace *crawlWorkspace) reserve(n int64) error {
+	manager := workspace.manager
+	manager.Lock()
+	defer manager.Unlock()
+	if workspace.closed || workspace.used+n > manager.crawlQuota || manager.used+n > manager.quota {
+		return errWorkspaceFull
+	}
+	workspace.used += n
+	manager.used += n
+	return nil
+}
+
+// release gives back the space of files that were removed or truncated
+func (workspace *crawlWorkspace) release(n int64) {
+	manager := workspace.manager
+	manager.Lock()
+	defer manager.Unlock()
+	if n > workspace.used {
+		n = workspace.used
+	}
+	workspace.used -= n
+	manager.used -= n
+}
+
+// close removes the workspace and everything in it
+func (workspace *crawlWorkspace) close() {
+	manager := workspace.manager
+	manager.Lock()
+	manager.used -= workspace.used
+	workspace.used = 0
+	workspace.closed = true
+	manager.Unlock()
+	if err := os.RemoveAll(workspace.dir); err != nil {
+		withError(logger.Error(), err).Str("dir", workspace.dir).Msg("Failed to remove workspace")
+	}
+}
+
+// runWorkspaceSweeper removes the workspaces of workers that died without cleaning up
+func runWorkspaceSweeper(rdb *redis.Client, manager *workspaceManager) {
+	ticker := time.NewTicker(workspaceSweepPeriod)
+	defer ticker.Stop()
+	for {
+		sweepWorkspaces(rdb, manager)
+		<-ticker.C
+	}
+}
+
+// sweepWorkspaces removes every worker directory under the root but ours,
+// unless that worker still has a heartbeat
+func sweepWorkspaces(rdb *redis.Client, manager *workspaceManager) {
+	entries, err := ioutil.ReadDir(manager.root)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			withError(logger.Error(), err).Msg("Failed to list workspaces")
+		}
+		return
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() || name == consumerName() {
+			continue
+		}
+		alive, err := rdb.Exists(ctx, workerKey(name)).Result()
+		if err != nil || alive > 0 {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(manager.root, name)); err != nil {
+			withError(logger.Error(), err).Str("worker", name).Msg("Failed to remove workspaces of worker")
+			continue
+		}
+		logger.Info().Str("worker", name).Msg("Removed workspaces left by worker")
+	}
+}